@@ -279,6 +279,30 @@ PN_EXTERN bool pn_delivery_current(pn_delivery_t *delivery);
  */
 PN_EXTERN void pn_delivery_abort(pn_delivery_t *delivery);
 
+/**
+ * Mark an outgoing delivery as resuming a delivery that was unsettled when a
+ * link was detached, see ::pn_link_unsettled_map. The first transfer frame for
+ * the delivery is sent with the resume flag set.
+ *
+ * Must be called before any data for the delivery is sent.
+ *
+ * @param[in] delivery a delivery object
+ * @param[in] resume true if the delivery is being resumed
+ */
+PN_EXTERN void pn_delivery_set_resume(pn_delivery_t *delivery, bool resume);
+
+/**
+ * Check if a delivery resumes an earlier delivery with the same tag.
+ *
+ * For an incoming delivery this is true if the first transfer frame had the
+ * resume flag set. For an outgoing delivery it is the value set by
+ * ::pn_delivery_set_resume.
+ *
+ * @param[in] delivery a delivery object
+ * @return true if the delivery is resumed, false otherwise
+ */
+PN_EXTERN bool pn_delivery_resumed(pn_delivery_t *delivery);
+
 /**
  * Settle a delivery.
  *
@@ -463,6 +463,19 @@ PN_EXTERN int pn_link_drained(pn_link_t *link);
  */
 PN_EXTERN int pn_link_available(pn_link_t *link);
 
+/**
+ * Get the delivery-count of a link.
+ *
+ * The delivery-count is the sender's count of deliveries sent on the
+ * link, starting from the sender's initial delivery-count. For a
+ * receiving link it is advanced by incoming transfers and updated
+ * from the sender's flow frames, for example when credit is drained.
+ *
+ * @param[in] link a link object
+ * @return the delivery-count
+ */
+PN_EXTERN pn_sequence_t pn_link_delivery_count(pn_link_t *link);
+
 /**
  * Describes the permitted/expected settlement behaviours of a sending
  * link.
@@ -709,6 +722,90 @@ PN_EXTERN pn_data_t *pn_link_properties(pn_link_t *link);
  */
 PN_EXTERN pn_data_t *pn_link_remote_properties(pn_link_t *link);
 
+/**
+ * Access/modify the AMQP offered capabilities data for a link object.
+ *
+ * This operation will return a pointer to a ::pn_data_t object that
+ * is valid until the link object is freed. Any data contained by the
+ * ::pn_data_t object will be sent as the offered capabilities for the
+ * link object when the link is opened by calling ::pn_link_open.
+ * This MUST take the form of a symbol or an array of symbols.
+ *
+ * @param[in] link the link object
+ * @return a pointer to a pn_data_t representing the offered capabilities
+ */
+PN_EXTERN pn_data_t *pn_link_offered_capabilities(pn_link_t *link);
+
+/**
+ * Access/modify the AMQP desired capabilities data for a link object.
+ *
+ * This operation will return a pointer to a ::pn_data_t object that
+ * is valid until the link object is freed. Any data contained by the
+ * ::pn_data_t object will be sent as the desired capabilities for the
+ * link object when the link is opened by calling ::pn_link_open.
+ * This MUST take the form of a symbol or an array of symbols.
+ *
+ * @param[in] link the link object
+ * @return a pointer to a pn_data_t representing the desired capabilities
+ */
+PN_EXTERN pn_data_t *pn_link_desired_capabilities(pn_link_t *link);
+
+/**
+ * Access the AMQP offered capabilities supplied by the remote link
+ * endpoint.
+ *
+ * This operation will return a pointer to a ::pn_data_t object that
+ * is valid until the link object is freed. This data object will be
+ * empty until the remote link is opened as indicated by the
+ * ::PN_REMOTE_ACTIVE flag.
+ *
+ * @param[in] link the link object
+ * @return the remote offered capabilities
+ */
+PN_EXTERN pn_data_t *pn_link_remote_offered_capabilities(pn_link_t *link);
+
+/**
+ * Access the AMQP desired capabilities supplied by the remote link
+ * endpoint.
+ *
+ * This operation will return a pointer to a ::pn_data_t object that
+ * is valid until the link object is freed. This data object will be
+ * empty until the remote link is opened as indicated by the
+ * ::PN_REMOTE_ACTIVE flag.
+ *
+ * @param[in] link the link object
+ * @return the remote desired capabilities
+ */
+PN_EXTERN pn_data_t *pn_link_remote_desired_capabilities(pn_link_t *link);
+
+/**
+ * Access/modify the AMQP unsettled map for a link object.
+ *
+ * The unsettled map is sent when the link is attached, so that a link
+ * re-attached with the same name can resume deliveries that were unsettled
+ * when it was detached. It MUST take the form of a map from delivery tags
+ * (binary) to delivery states, with a null value for a delivery that has
+ * no state. It is sent as null if the ::pn_data_t object is empty.
+ *
+ * The ::pn_data_t pointer returned is valid until the link object is freed.
+ *
+ * @param[in] link the link object
+ * @return a pointer to a pn_data_t representing the unsettled map
+ */
+PN_EXTERN pn_data_t *pn_link_unsettled_map(pn_link_t *link);
+
+/**
+ * Access the AMQP unsettled map supplied by the remote link endpoint.
+ *
+ * This data object will be empty until the remote link is opened as
+ * indicated by the ::PN_REMOTE_ACTIVE flag, and remains empty if the
+ * remote endpoint did not send an unsettled map.
+ *
+ * @param[in] link the link object
+ * @return the remote unsettled map
+ */
+PN_EXTERN pn_data_t *pn_link_remote_unsettled_map(pn_link_t *link);
+
 /**
  * @}
  */
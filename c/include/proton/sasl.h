@@ -180,6 +180,22 @@ PN_EXTERN void pn_sasl_set_allow_insecure_mechs(pn_sasl_t *sasl, bool insecure);
  */
 PN_EXTERN bool pn_sasl_get_allow_insecure_mechs(pn_sasl_t *sasl);
 
+/**
+ * Set the external security layer for the SASL EXTERNAL mechanism
+ *
+ * Use this when the connection is secured by a layer outside of proton, for
+ * example TLS provided by the application. A server will offer the EXTERNAL
+ * mechanism and authenticate the client as authid. A non-zero ssf means the
+ * connection is encrypted, which permits clear text mechanisms such as PLAIN.
+ *
+ * Values set by the proton SSL layer, if it is used, take precedence.
+ *
+ * @param[in] sasl the SASL layer
+ * @param[in] ssf the security strength factor of the external layer, 0 if not encrypted
+ * @param[in] authid the identity established by the external layer, or NULL
+ */
+PN_EXTERN void pn_sasl_set_external_security(pn_sasl_t *sasl, int ssf, const char *authid);
+
 /**
  * Set the sasl configuration name
  *
@@ -256,6 +256,26 @@ PN_EXTERN size_t pn_session_outgoing_bytes(pn_session_t *session);
  */
 PN_EXTERN size_t pn_session_incoming_bytes(pn_session_t *session);
 
+/**
+ * Get the incoming window last sent by the remote peer, in frames.
+ *
+ * This is the number of transfer frames the peer is currently willing to
+ * accept on the session. It is set by the peer's begin frame and updated
+ * by each flow frame, and decreases as transfers are sent.
+ *
+ * @param[in] session the session object
+ * @return the remote incoming window, 0 if the peer has not begun the session
+ */
+PN_EXTERN size_t pn_session_remote_incoming_window(pn_session_t *session);
+
+/**
+ * Get the outgoing window last sent by the remote peer, in frames.
+ *
+ * @param[in] session the session object
+ * @return the remote outgoing window, 0 if the peer has not begun the session
+ */
+PN_EXTERN size_t pn_session_remote_outgoing_window(pn_session_t *session);
+
 /**
  * Retrieve the first session from a given connection that matches the
  * specified state mask.
@@ -286,6 +286,21 @@ PN_EXTERN pn_data_t *pn_terminus_capabilities(pn_terminus_t *terminus);
  */
 PN_EXTERN pn_data_t *pn_terminus_outcomes(pn_terminus_t *terminus);
 
+/**
+ * Access/modify the AMQP default outcome for a terminus object.
+ *
+ * This operation will return a pointer to a ::pn_data_t object that
+ * is valid until the terminus object is freed due to its parent link
+ * being freed. Any data contained by the ::pn_data_t object will be
+ * sent as the AMQP default outcome for the parent terminus object.
+ * Note that this MUST take the form of a described outcome, e.g.
+ * amqp:released:list, to be valid. It is only sent for a source.
+ *
+ * @param[in] terminus a source terminus object
+ * @return a pointer to a pn_data_t representing the terminus default outcome
+ */
+PN_EXTERN pn_data_t *pn_terminus_default_outcome(pn_terminus_t *terminus);
+
 /**
  * Access/modify the AMQP filter set for a terminus object.
  *
@@ -91,6 +91,7 @@ typedef struct {
   pn_sequence_t remote_incoming_window;
   pn_sequence_t outgoing_transfer_count;
   pn_sequence_t outgoing_window;
+  pn_sequence_t remote_outgoing_window;
   pn_sequence_t disp_first;
   pn_sequence_t disp_last;
   // XXX: stop using negative numbers
@@ -273,6 +274,7 @@ struct pn_terminus_t {
   pn_data_t *properties;
   pn_data_t *capabilities;
   pn_data_t *outcomes;
+  pn_data_t *default_outcome;
   pn_data_t *filter;
   pn_seconds_t timeout;
   uint8_t durability;
@@ -298,6 +300,12 @@ struct pn_link_t {
   pn_record_t *context;
   pn_data_t *properties;
   pn_data_t *remote_properties;
+  pn_data_t *offered_capabilities;
+  pn_data_t *desired_capabilities;
+  pn_data_t *remote_offered_capabilities;
+  pn_data_t *remote_desired_capabilities;
+  pn_data_t *unsettled;
+  pn_data_t *remote_unsettled;
   size_t unsettled_count;
   uint64_t max_message_size;
   uint64_t remote_max_message_size;
@@ -347,6 +355,7 @@ struct pn_delivery_t {
   bool done;
   bool referenced;
   bool aborted;
+  bool resume;
 };
 
 #define PN_SET_LOCAL(OLD, NEW)                                          \
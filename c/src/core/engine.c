@@ -348,6 +348,7 @@ static void pni_terminus_free(pn_terminus_t *terminus)
   pn_free(terminus->properties);
   pn_free(terminus->capabilities);
   pn_free(terminus->outcomes);
+  pn_free(terminus->default_outcome);
   pn_free(terminus->filter);
 }
 
@@ -1067,6 +1068,18 @@ size_t pn_session_incoming_bytes(pn_session_t *ssn)
   return ssn->incoming_bytes;
 }
 
+size_t pn_session_remote_incoming_window(pn_session_t *ssn)
+{
+  assert(ssn);
+  return ssn->state.remote_incoming_window;
+}
+
+size_t pn_session_remote_outgoing_window(pn_session_t *ssn)
+{
+  assert(ssn);
+  return ssn->state.remote_outgoing_window;
+}
+
 pn_state_t pn_session_state(pn_session_t *session)
 {
   return session->endpoint.state;
@@ -1085,6 +1098,7 @@ static void pni_terminus_init(pn_terminus_t *terminus, pn_terminus_type_t type)
   terminus->properties = pn_data(0);
   terminus->capabilities = pn_data(0);
   terminus->outcomes = pn_data(0);
+  terminus->default_outcome = pn_data(0);
   terminus->filter = pn_data(0);
 }
 
@@ -1129,6 +1143,12 @@ static void pn_link_finalize(void *object)
   }
   pn_free(link->properties);
   pn_free(link->remote_properties);
+  pn_free(link->offered_capabilities);
+  pn_free(link->desired_capabilities);
+  pn_free(link->remote_offered_capabilities);
+  pn_free(link->remote_desired_capabilities);
+  pn_free(link->unsettled);
+  pn_free(link->remote_unsettled);
 }
 
 #define pn_link_refcount pn_object_refcount
@@ -1174,6 +1194,12 @@ pn_link_t *pn_link_new(int type, pn_session_t *session, const char *name)
   link->detached = false;
   link->properties = 0;
   link->remote_properties = 0;
+  link->offered_capabilities = 0;
+  link->desired_capabilities = 0;
+  link->remote_offered_capabilities = pn_data(0);
+  link->remote_desired_capabilities = pn_data(0);
+  link->unsettled = 0;
+  link->remote_unsettled = pn_data(0);
 
   // begin transport state
   link->state.local_handle = -1;
@@ -1316,6 +1342,11 @@ pn_data_t *pn_terminus_outcomes(pn_terminus_t *terminus)
   return terminus ? terminus->outcomes : NULL;
 }
 
+pn_data_t *pn_terminus_default_outcome(pn_terminus_t *terminus)
+{
+  return terminus ? terminus->default_outcome : NULL;
+}
+
 pn_data_t *pn_terminus_filter(pn_terminus_t *terminus)
 {
   return terminus ? terminus->filter : NULL;
@@ -1354,6 +1385,8 @@ int pn_terminus_copy(pn_terminus_t *terminus, pn_terminus_t *src)
   if (err) return err;
   err = pn_data_copy(terminus->outcomes, src->outcomes);
   if (err) return err;
+  err = pn_data_copy(terminus->default_outcome, src->default_outcome);
+  if (err) return err;
   err = pn_data_copy(terminus->filter, src->filter);
   if (err) return err;
   return 0;
@@ -1554,6 +1587,7 @@ pn_delivery_t *pn_delivery(pn_link_t *link, pn_delivery_tag_t tag)
   pn_buffer_clear(delivery->bytes);
   delivery->done = false;
   delivery->aborted = false;
+  delivery->resume = false;
   pn_record_clear(delivery->context);
 
   // begin delivery state
@@ -1801,6 +1835,11 @@ int pn_link_available(pn_link_t *link)
   return link ? link->available : 0;
 }
 
+pn_sequence_t pn_link_delivery_count(pn_link_t *link)
+{
+  return link ? link->state.delivery_count : 0;
+}
+
 int pn_link_queued(pn_link_t *link)
 {
   return link ? link->queued : 0;
@@ -1992,6 +2031,48 @@ pn_data_t *pn_link_remote_properties(pn_link_t *link)
   return link->remote_properties;
 }
 
+pn_data_t *pn_link_offered_capabilities(pn_link_t *link)
+{
+  assert(link);
+  if (!link->offered_capabilities)
+      link->offered_capabilities = pn_data(0);
+  return link->offered_capabilities;
+}
+
+pn_data_t *pn_link_desired_capabilities(pn_link_t *link)
+{
+  assert(link);
+  if (!link->desired_capabilities)
+      link->desired_capabilities = pn_data(0);
+  return link->desired_capabilities;
+}
+
+pn_data_t *pn_link_remote_offered_capabilities(pn_link_t *link)
+{
+  assert(link);
+  return link->remote_offered_capabilities;
+}
+
+pn_data_t *pn_link_remote_desired_capabilities(pn_link_t *link)
+{
+  assert(link);
+  return link->remote_desired_capabilities;
+}
+
+pn_data_t *pn_link_unsettled_map(pn_link_t *link)
+{
+  assert(link);
+  if (!link->unsettled)
+      link->unsettled = pn_data(0);
+  return link->unsettled;
+}
+
+pn_data_t *pn_link_remote_unsettled_map(pn_link_t *link)
+{
+  assert(link);
+  return link->remote_unsettled;
+}
+
 
 pn_link_t *pn_delivery_link(pn_delivery_t *delivery)
 {
@@ -2092,6 +2173,14 @@ bool pn_delivery_aborted(pn_delivery_t *delivery) {
   return delivery->aborted;
 }
 
+void pn_delivery_set_resume(pn_delivery_t *delivery, bool resume) {
+  delivery->resume = resume;
+}
+
+bool pn_delivery_resumed(pn_delivery_t *delivery) {
+  return delivery->resume;
+}
+
 pn_condition_t *pn_connection_condition(pn_connection_t *connection)
 {
   assert(connection);
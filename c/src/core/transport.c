@@ -315,7 +315,9 @@ ssize_t pn_io_layer_input_autodetect(pn_transport_t *transport, unsigned int lay
     transport->io_layers[layer] = &sasl_write_header_layer;
     transport->io_layers[layer+1] = &pni_autodetect_layer;
     PN_LOG(&transport->logger, PN_SUBSYSTEM_SASL, PN_LEVEL_FRAME, "  <- %s", "SASL");
-    pni_sasl_set_external_security(transport, pn_ssl_get_ssf((pn_ssl_t*)transport), pn_ssl_get_remote_subject((pn_ssl_t*)transport));
+    if (transport->ssl) {
+      pni_sasl_set_external_security(transport, pn_ssl_get_ssf((pn_ssl_t*)transport), pn_ssl_get_remote_subject((pn_ssl_t*)transport));
+    }
     return 8;
   case PNI_PROTOCOL_AMQP1:
     if (!(transport->allowed_layers & LAYER_AMQP1)) {
@@ -1217,7 +1219,8 @@ int pn_do_begin(pn_transport_t *transport, uint8_t frame_type, uint16_t channel,
   bool reply;
   uint16_t remote_channel;
   pn_sequence_t next;
-  int err = pn_data_scan(args, "D.[?HI]", &reply, &remote_channel, &next);
+  uint32_t iwin, owin;
+  int err = pn_data_scan(args, "D.[?HIII]", &reply, &remote_channel, &next, &iwin, &owin);
   if (err) return err;
 
   // AMQP 1.0 section 2.7.1 - if the peer doesn't honor our channel_max --
@@ -1247,6 +1250,8 @@ int pn_do_begin(pn_transport_t *transport, uint8_t frame_type, uint16_t channel,
     ssn = pn_session(transport->connection);
   }
   ssn->state.incoming_transfer_count = next;
+  ssn->state.remote_incoming_window = iwin;
+  ssn->state.remote_outgoing_window = owin;
   pni_map_remote_channel(ssn, channel);
   PN_SET_REMOTE(ssn->endpoint.state, PN_REMOTE_ACTIVE);
   pn_collector_put(transport->connection->collector, PN_OBJECT, ssn, PN_SESSION_REMOTE_OPEN);
@@ -1386,6 +1391,15 @@ int pn_do_attach(pn_transport_t *transport, uint8_t frame_type, uint16_t channel
     pn_free(rem_props);
   }
 
+  pn_data_clear(link->remote_unsettled);
+  pn_data_clear(link->remote_offered_capabilities);
+  pn_data_clear(link->remote_desired_capabilities);
+  err = pn_data_scan(args, "D.[.......C...CC]",
+                     link->remote_unsettled,
+                     link->remote_offered_capabilities,
+                     link->remote_desired_capabilities);
+  if (err) return err;
+
   pni_map_remote_handle(link, handle);
   PN_SET_REMOTE(link->endpoint.state, PN_REMOTE_ACTIVE);
   pn_terminus_t *rsrc = &link->remote_source;
@@ -1429,11 +1443,13 @@ int pn_do_attach(pn_transport_t *transport, uint8_t frame_type, uint16_t channel
   pn_data_clear(link->remote_source.properties);
   pn_data_clear(link->remote_source.filter);
   pn_data_clear(link->remote_source.outcomes);
+  pn_data_clear(link->remote_source.default_outcome);
   pn_data_clear(link->remote_source.capabilities);
 
-  err = pn_data_scan(args, "D.[.....D.[.....C.C.CC]",
+  err = pn_data_scan(args, "D.[.....D.[.....C.CCCC]",
                      link->remote_source.properties,
                      link->remote_source.filter,
+                     link->remote_source.default_outcome,
                      link->remote_source.outcomes,
                      link->remote_source.capabilities);
 
@@ -1441,6 +1457,7 @@ int pn_do_attach(pn_transport_t *transport, uint8_t frame_type, uint16_t channel
 
   pn_data_rewind(link->remote_source.properties);
   pn_data_rewind(link->remote_source.filter);
+  pn_data_rewind(link->remote_source.default_outcome);
   pn_data_rewind(link->remote_source.outcomes);
   pn_data_rewind(link->remote_source.capabilities);
 
@@ -1541,6 +1558,7 @@ int pn_do_transfer(pn_transport_t *transport, uint8_t frame_type, uint16_t chann
       delivery->remote.type = type;
       pn_data_copy(delivery->remote.data, transport->disp_data);
     }
+    delivery->resume = resume;
 
     link->state.delivery_count++;
     link->state.link_credit--;
@@ -1579,12 +1597,13 @@ int pn_do_transfer(pn_transport_t *transport, uint8_t frame_type, uint16_t chann
 int pn_do_flow(pn_transport_t *transport, uint8_t frame_type, uint16_t channel, pn_data_t *args, const pn_bytes_t *payload)
 {
   pn_sequence_t onext, inext, delivery_count;
-  uint32_t iwin, owin, link_credit;
+  uint32_t iwin, owin, link_credit, available;
   uint32_t handle;
-  bool inext_init, handle_init, dcount_init, drain;
-  int err = pn_data_scan(args, "D.[?IIII?I?II.o]", &inext_init, &inext, &iwin,
+  bool inext_init, handle_init, dcount_init, available_init, drain;
+  int err = pn_data_scan(args, "D.[?IIII?I?II?Io]", &inext_init, &inext, &iwin,
                          &onext, &owin, &handle_init, &handle, &dcount_init,
-                         &delivery_count, &link_credit, &drain);
+                         &delivery_count, &link_credit, &available_init, &available,
+                         &drain);
   if (err) return err;
 
   pn_session_t *ssn = pni_channel_state(transport, channel);
@@ -1597,6 +1616,7 @@ int pn_do_flow(pn_transport_t *transport, uint8_t frame_type, uint16_t channel,
   } else {
     ssn->state.remote_incoming_window = iwin;
   }
+  ssn->state.remote_outgoing_window = owin;
 
   if (handle_init) {
     pn_link_t *link = pni_handle_state(ssn, handle);
@@ -1618,6 +1638,7 @@ int pn_do_flow(pn_transport_t *transport, uint8_t frame_type, uint16_t channel,
       pn_delivery_t *delivery = pn_link_current(link);
       if (delivery) pn_work_update(transport->connection, delivery);
     } else {
+      if (available_init) link->available = available;
       pn_sequence_t delta = delivery_count - link->state.delivery_count;
       if (delta > 0) {
         link->state.delivery_count += delta;
@@ -2069,7 +2090,7 @@ static int pni_process_link_setup(pn_transport_t *transport, pn_endpoint_t *endp
       const pn_distribution_mode_t dist_mode = (pn_distribution_mode_t) link->source.distribution_mode;
       if (link->target.type == PN_COORDINATOR) {
         int err = pn_post_frame(transport, AMQP_FRAME_TYPE, ssn_state->local_channel,
-                                "DL[SIoBB?DL[SIsIoC?sCnCC]DL[C]nnI]", ATTACH,
+                                "DL[SIoBB?DL[SIsIoC?sCCCC]DL[C]nnI]", ATTACH,
                                 pn_string_get(link->name),
                                 state->local_handle,
                                 endpoint->type == RECEIVER,
@@ -2084,6 +2105,7 @@ static int pni_process_link_setup(pn_transport_t *transport, pn_endpoint_t *endp
                                 link->source.properties,
                                 (dist_mode != PN_DIST_MODE_UNSPECIFIED), dist_mode2symbol(dist_mode),
                                 link->source.filter,
+                                link->source.default_outcome,
                                 link->source.outcomes,
                                 link->source.capabilities,
                                 COORDINATOR, link->target.capabilities,
@@ -2091,7 +2113,7 @@ static int pni_process_link_setup(pn_transport_t *transport, pn_endpoint_t *endp
         if (err) return err;
       } else {
         int err = pn_post_frame(transport, AMQP_FRAME_TYPE, ssn_state->local_channel,
-                                "DL[SIoBB?DL[SIsIoC?sCnMM]?DL[SIsIoCM]nnILnnC]", ATTACH,
+                                "DL[SIoBB?DL[SIsIoC?sCCMM]?DL[SIsIoCM]CnILMMC]", ATTACH,
                                 pn_string_get(link->name),
                                 state->local_handle,
                                 endpoint->type == RECEIVER,
@@ -2107,6 +2129,7 @@ static int pni_process_link_setup(pn_transport_t *transport, pn_endpoint_t *endp
                                 link->source.properties,
                                 (dist_mode != PN_DIST_MODE_UNSPECIFIED), dist_mode2symbol(dist_mode),
                                 link->source.filter,
+                                link->source.default_outcome,
                                 link->source.outcomes,
                                 link->source.capabilities,
 
@@ -2119,8 +2142,11 @@ static int pni_process_link_setup(pn_transport_t *transport, pn_endpoint_t *endp
                                 link->target.properties,
                                 link->target.capabilities,
 
+                                link->unsettled,
                                 0,
                                 link->max_message_size,
+                                link->offered_capabilities,
+                                link->desired_capabilities,
                                 link->properties);
         if (err) return err;
       }
@@ -2276,7 +2302,7 @@ static int pni_process_tpwork_sender(pn_transport_t *transport, pn_delivery_t *d
                                                ssn_state->remote_incoming_window,
                                                delivery->local.type,
                                                transport->disp_data,
-                                               false, /* Resume */
+                                               delivery->resume,
                                                delivery->aborted,
                                                false /* Batchable */
       );
@@ -556,7 +556,9 @@ static ssize_t pn_input_read_sasl_header(pn_transport_t* transport, unsigned int
         transport->io_layers[layer] = &sasl_write_header_layer;
     }
     PN_LOG(&transport->logger, PN_SUBSYSTEM_SASL, PN_LEVEL_FRAME, "  <- %s", "SASL");
-    pni_sasl_set_external_security(transport, pn_ssl_get_ssf((pn_ssl_t*)transport), pn_ssl_get_remote_subject((pn_ssl_t*)transport));
+    if (transport->ssl) {
+      pni_sasl_set_external_security(transport, pn_ssl_get_ssf((pn_ssl_t*)transport), pn_ssl_get_remote_subject((pn_ssl_t*)transport));
+    }
     return SASL_HEADER_LEN;
   case PNI_PROTOCOL_INSUFFICIENT:
     if (!eos) return 0;
@@ -824,6 +826,11 @@ void pn_sasl_set_allow_insecure_mechs(pn_sasl_t *sasl0, bool insecure)
     sasl->allow_insecure_mechs = insecure;
 }
 
+void pn_sasl_set_external_security(pn_sasl_t *sasl0, int ssf, const char *authid)
+{
+    pni_sasl_set_external_security((pn_transport_t *)sasl0, ssf, authid);
+}
+
 bool pn_sasl_get_allow_insecure_mechs(pn_sasl_t *sasl0)
 {
     pni_sasl_t *sasl = get_sasl_internal(sasl0);
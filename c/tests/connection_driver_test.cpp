@@ -19,6 +19,8 @@
 
 #include "./pn_test.hpp"
 
+#include "core/engine-internal.h"
+
 #include <proton/codec.h>
 #include <proton/connection.h>
 #include <proton/connection_driver.h>
@@ -566,3 +568,112 @@ TEST_CASE("driver_settle_incomplete_receiver", "[!hide][!shouldfail]") {
              cond_empty());
   CHECK_THAT(*pn_connection_condition(d.server.connection), cond_empty());
 }
+
+/* Delivery-ids are serial numbers that wrap to 0 after 2^32 transfers. Make
+   sure a disposition range that spans the wrap settles every delivery in it.
+*/
+TEST_CASE("driver_delivery_id_rollover") {
+  open_handler client, server;
+  pn_test::driver_pair d(client, server);
+
+  pn_connection_open(d.client.connection);
+  pn_session_t *ssn = pn_session(d.client.connection);
+  /* Start the session's delivery-ids just before the wrap */
+  ssn->state.outgoing.next = ssn->state.outgoing_transfer_count = UINT32_MAX - 1;
+  pn_session_open(ssn);
+  pn_link_t *snd = pn_sender(ssn, "x");
+  pn_link_open(snd);
+  d.run();
+  pn_link_t *rcv = server.link;
+  REQUIRE(rcv);
+  pn_link_flow(rcv, 5);
+  d.run();
+
+  pn_delivery_t *sd[5];
+  for (int i = 0; i < 5; ++i) {
+    sd[i] = pn_delivery(snd, pn_bytes(std::string(1, '0' + i)));
+    CHECK(1 == pn_link_send(snd, "x", 1));
+    pn_link_advance(snd);
+  }
+  d.run();
+
+  /* Accept and settle together, the disposition range is 0xfffffffe-0x2 */
+  for (int i = 0; i < 5; ++i) {
+    pn_delivery_t *rd = pn_link_current(rcv);
+    REQUIRE(rd);
+    CHECK((pn_sequence_t)(UINT32_MAX - 1 + i) == rd->state.id);
+    pn_link_advance(rcv);
+    pn_delivery_update(rd, PN_ACCEPTED);
+    pn_delivery_settle(rd);
+  }
+  d.run();
+
+  for (int i = 0; i < 5; ++i) {
+    CHECK(PN_ACCEPTED == pn_delivery_remote_state(sd[i]));
+    CHECK(pn_delivery_settled(sd[i]));
+  }
+  CHECK_THAT(*pn_connection_remote_condition(d.client.connection),
+             cond_empty());
+  CHECK_THAT(*pn_connection_condition(d.server.connection), cond_empty());
+}
+
+/* The unsettled map is sent with the attach, and resumed deliveries carry the
+   resume flag */
+TEST_CASE("driver_link_resume") {
+  open_handler client;
+  delivery_handler server;
+  pn_test::driver_pair d(client, server);
+
+  pn_connection_open(d.client.connection);
+  pn_session_t *ssn = pn_session(d.client.connection);
+  pn_session_open(ssn);
+  pn_link_t *snd = pn_sender(ssn, "x");
+  pn_data_t *unsettled = pn_link_unsettled_map(snd);
+  pn_data_put_map(unsettled);
+  pn_data_enter(unsettled);
+  pn_data_put_binary(unsettled, pn_bytes("1"));
+  pn_data_put_null(unsettled);
+  pn_data_exit(unsettled);
+  pn_data_put_symbol(pn_link_offered_capabilities(snd), pn_bytes("cap"));
+  pn_link_open(snd);
+  d.run();
+
+  pn_link_t *rcv = server.link;
+  REQUIRE(rcv);
+  pn_data_t *remote = pn_link_remote_unsettled_map(rcv);
+  pn_data_rewind(remote);
+  REQUIRE(pn_data_next(remote));
+  CHECK(PN_MAP == pn_data_type(remote));
+  CHECK(2 == pn_data_get_map(remote));
+  pn_data_enter(remote);
+  REQUIRE(pn_data_next(remote));
+  CHECK_THAT("1", Equals(std::string(pn_data_get_binary(remote).start,
+                                     pn_data_get_binary(remote).size)));
+  REQUIRE(pn_data_next(remote));
+  CHECK(PN_NULL == pn_data_type(remote));
+  /* Fields after the unsettled map are unaffected */
+  pn_data_t *caps = pn_link_remote_offered_capabilities(rcv);
+  pn_data_rewind(caps);
+  REQUIRE(pn_data_next(caps));
+  CHECK_THAT("cap", Equals(std::string(pn_data_get_symbol(caps).start,
+                                       pn_data_get_symbol(caps).size)));
+  /* The server sent no unsettled map */
+  CHECK(0 == pn_data_size(pn_link_remote_unsettled_map(snd)));
+
+  pn_link_flow(rcv, 2);
+  d.run();
+  pn_delivery_t *sd = pn_delivery(snd, pn_bytes("1"));
+  pn_delivery_set_resume(sd, true);
+  CHECK(1 == pn_link_send(snd, "x", 1));
+  pn_link_advance(snd);
+  d.run();
+  CHECK(pn_delivery_resumed(server.delivery));
+  CHECK_THAT("1", Equals(std::string(pn_delivery_tag(server.delivery).start,
+                                     pn_delivery_tag(server.delivery).size)));
+
+  pn_delivery(snd, pn_bytes("2"));
+  CHECK(1 == pn_link_send(snd, "x", 1));
+  pn_link_advance(snd);
+  d.run();
+  CHECK(!pn_delivery_resumed(server.delivery));
+}
@@ -366,3 +366,130 @@ TEST_CASE("link_properties)") {
   pn_transport_free(t2);
   pn_connection_free(c2);
 }
+
+TEST_CASE("link_capabilities") {
+  pn_connection_t *c1 = pn_connection();
+  pn_transport_t *t1 = pn_transport();
+  pn_transport_bind(t1, c1);
+
+  pn_connection_t *c2 = pn_connection();
+  pn_transport_t *t2 = pn_transport();
+  pn_transport_set_server(t2);
+  pn_transport_bind(t2, c2);
+
+  pn_connection_open(c1);
+  pn_connection_open(c2);
+
+  pn_session_t *s1 = pn_session(c1);
+  pn_session_open(s1);
+
+  pn_link_t *rx = pn_receiver(s1, "caps");
+  pn_data_fill(pn_link_offered_capabilities(rx), "s", "one");
+  pn_data_fill(pn_link_desired_capabilities(rx), "@T[ss]", PN_SYMBOL, "two", "three");
+  pn_link_open(rx);
+
+  while (pump(t1, t2)) {
+    process_endpoints(c1);
+    process_endpoints(c2);
+  }
+
+  REQUIRE(pn_link_state(rx) == (PN_LOCAL_ACTIVE | PN_REMOTE_ACTIVE));
+  CHECK(pn_data_size(pn_link_remote_offered_capabilities(rx)) == 0);
+  CHECK(pn_data_size(pn_link_remote_desired_capabilities(rx)) == 0);
+
+  pn_link_t *tx = pn_link_head(c2, (PN_LOCAL_ACTIVE | PN_REMOTE_ACTIVE));
+  CHECK(":one" == pn_test::inspect(pn_link_remote_offered_capabilities(tx)));
+  CHECK("@PN_SYMBOL[:two, :three]" == pn_test::inspect(pn_link_remote_desired_capabilities(tx)));
+
+  pn_transport_unbind(t1);
+  pn_transport_free(t1);
+  pn_connection_free(c1);
+
+  pn_transport_unbind(t2);
+  pn_transport_free(t2);
+  pn_connection_free(c2);
+}
+
+TEST_CASE("session_remote_windows") {
+  pn_connection_t *c1 = pn_connection();
+  pn_transport_t *t1 = pn_transport();
+  pn_transport_set_max_frame(t1, 1024);
+  pn_transport_bind(t1, c1);
+
+  pn_connection_t *c2 = pn_connection();
+  pn_transport_t *t2 = pn_transport();
+  pn_transport_set_server(t2);
+  pn_transport_bind(t2, c2);
+
+  pn_connection_open(c1);
+  pn_connection_open(c2);
+
+  pn_session_t *s1 = pn_session(c1);
+  pn_session_set_incoming_capacity(s1, 10 * 1024);
+  pn_session_set_outgoing_window(s1, 7);
+  CHECK(pn_session_remote_incoming_window(s1) == 0);
+  pn_session_open(s1);
+
+  while (pump(t1, t2)) {
+    process_endpoints(c1);
+    process_endpoints(c2);
+  }
+
+  REQUIRE(pn_session_state(s1) == (PN_LOCAL_ACTIVE | PN_REMOTE_ACTIVE));
+  pn_session_t *s2 = pn_session_head(c2, (PN_LOCAL_ACTIVE | PN_REMOTE_ACTIVE));
+  REQUIRE(s2);
+  CHECK(pn_session_remote_incoming_window(s2) == 10);
+  CHECK(pn_session_remote_outgoing_window(s2) == 7);
+  // c2 has no max frame size so session flow control is not enabled
+  CHECK(pn_session_remote_incoming_window(s1) == 2147483647);
+  CHECK(pn_session_remote_outgoing_window(s1) == 2147483647);
+
+  pn_transport_unbind(t1);
+  pn_transport_free(t1);
+  pn_connection_free(c1);
+
+  pn_transport_unbind(t2);
+  pn_transport_free(t2);
+  pn_connection_free(c2);
+}
+
+TEST_CASE("terminus_default_outcome") {
+  pn_connection_t *c1 = pn_connection();
+  pn_transport_t *t1 = pn_transport();
+  pn_transport_bind(t1, c1);
+
+  pn_connection_t *c2 = pn_connection();
+  pn_transport_t *t2 = pn_transport();
+  pn_transport_set_server(t2);
+  pn_transport_bind(t2, c2);
+
+  pn_connection_open(c1);
+  pn_connection_open(c2);
+
+  pn_session_t *s1 = pn_session(c1);
+  pn_session_open(s1);
+
+  pn_link_t *rx = pn_receiver(s1, "outcomes");
+  pn_data_fill(pn_terminus_default_outcome(pn_link_source(rx)), "DL[]", (uint64_t)0x26);
+  pn_data_fill(pn_terminus_outcomes(pn_link_source(rx)), "@T[ss]", PN_SYMBOL, "amqp:accepted:list", "amqp:released:list");
+  pn_link_open(rx);
+
+  while (pump(t1, t2)) {
+    process_endpoints(c1);
+    process_endpoints(c2);
+  }
+
+  REQUIRE(pn_link_state(rx) == (PN_LOCAL_ACTIVE | PN_REMOTE_ACTIVE));
+  pn_link_t *tx = pn_link_head(c2, (PN_LOCAL_ACTIVE | PN_REMOTE_ACTIVE));
+  CHECK("@released(38) []" == pn_test::inspect(pn_terminus_default_outcome(pn_link_remote_source(tx))));
+  CHECK("@PN_SYMBOL[:\"amqp:accepted:list\", :\"amqp:released:list\"]" == pn_test::inspect(pn_terminus_outcomes(pn_link_remote_source(tx))));
+  CHECK(pn_data_size(pn_terminus_default_outcome(pn_link_remote_source(rx))) == 0);
+
+  pn_transport_unbind(t1);
+  pn_transport_free(t1);
+  pn_connection_free(c1);
+
+  pn_transport_unbind(t2);
+  pn_transport_free(t2);
+  pn_connection_free(c2);
+}
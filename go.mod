@@ -1,3 +1,5 @@
 module github.com/apache/qpid-proton
 
 go 1.11
+
+require google.golang.org/protobuf v1.30.0
@@ -0,0 +1,353 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Command amqp-gen generates AMQP encoding methods for Go struct types that
+// represent AMQP described types.
+//
+// Usage:
+//
+//	amqp-gen [-output file] [file.go ...]
+//
+// With no file arguments amqp-gen reads $GOFILE, so it can be run from a
+// directive in the source file itself:
+//
+//	//go:generate amqp-gen
+//
+// A struct type is selected by a line in its doc comment giving the numeric
+// descriptor, optionally followed by the symbolic descriptor:
+//
+//	// amqp:described 0x70 amqp:header:list
+//	type Header struct { ... }
+//
+// For each selected type T amqp-gen writes a MarshalAMQP method for T and an
+// UnmarshalAMQP method for *T, implementing amqp.Marshaler and
+// amqp.Unmarshaler. T is encoded as a described list of its fields in
+// declaration order, as for the composite types in the AMQP specification.
+//
+// Fields of pointer, slice, map or interface type are nil-able: nil encodes as
+// AMQP null. A field of comparable type tagged `amqp:",omitempty"` also
+// encodes as null when it has its zero value. Trailing null fields are left
+// out of the list. A field tagged `amqp:"-"` is not encoded.
+//
+// When decoding, null or missing fields are set to their zero value and extra
+// fields are ignored. Either the numeric or the symbolic descriptor is accepted.
+//
+// Nil-ability is decided from the field's type expression, so a named slice or
+// map type such as amqp.Binary or amqp.Map is treated as a plain value.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const amqpImport = "github.com/apache/qpid-proton/go/pkg/amqp"
+
+var output = flag.String("output", "", "output file name, default is <file>_amqp.go for the first input file")
+
+var describedRe = regexp.MustCompile(`^amqp:described\s+(0[xX][0-9a-fA-F]+|[0-9]+)(?:\s+(\S+))?\s*$`)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: amqp-gen [-output file] [file.go ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("amqp-gen: ")
+	flag.Usage = usage
+	flag.Parse()
+	files := flag.Args()
+	if len(files) == 0 {
+		if gofile := os.Getenv("GOFILE"); gofile != "" {
+			files = []string{gofile}
+		} else {
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+	out := *output
+	if out == "" {
+		out = strings.TrimSuffix(files[0], ".go") + "_amqp.go"
+	}
+	src, err := generate(files)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// field is a struct field to be encoded as a list entry.
+type field struct {
+	Name      string
+	Type      string // Type expression as written in the source
+	Pointer   bool
+	Nilable   bool
+	OmitEmpty bool
+}
+
+// describedType is a struct type annotated with amqp:described.
+type describedType struct {
+	Name   string
+	Code   string // Numeric descriptor as written in the annotation
+	Symbol string // Symbolic descriptor, may be empty
+	Fields []field
+}
+
+// genFile is the data for the generated source file.
+type genFile struct {
+	Header  string // Comment preceding the package clause, e.g. a licence
+	Package string
+	Q       string // Qualifier for names from the amqp package
+	Imports []string
+	Types   []describedType
+}
+
+// generate returns the formatted source generated from the annotated types in files.
+func generate(files []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	g := &genFile{}
+	imports := make(map[string]bool)
+	for _, name := range files {
+		f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if g.Package == "" {
+			g.Package = f.Name.Name
+			g.Header = header(fset, f)
+		} else if g.Package != f.Name.Name {
+			return nil, fmt.Errorf("%s: package %s, expected %s", name, f.Name.Name, g.Package)
+		}
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				doc := ts.Doc
+				if doc == nil && len(gd.Specs) == 1 {
+					doc = gd.Doc
+				}
+				code, symbol, ok := annotation(doc)
+				if !ok {
+					continue
+				}
+				t, err := newDescribedType(fset, f, ts, code, symbol, imports)
+				if err != nil {
+					return nil, err
+				}
+				g.Types = append(g.Types, t)
+			}
+		}
+	}
+	if len(g.Types) == 0 {
+		return nil, fmt.Errorf("no amqp:described types in %s", strings.Join(files, ", "))
+	}
+	if g.Package != "amqp" {
+		g.Q = "amqp."
+		imports[strconv.Quote(amqpImport)] = true
+	}
+	for spec := range imports {
+		g.Imports = append(g.Imports, spec)
+	}
+	sort.Strings(g.Imports)
+	var buf bytes.Buffer
+	if err := fileTemplate.Execute(&buf, g); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("invalid generated code: %v\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+// header returns the text of a comment preceding the package clause that is
+// not the package doc comment, such as a licence.
+func header(fset *token.FileSet, f *ast.File) string {
+	if len(f.Comments) == 0 || f.Comments[0] == f.Doc || f.Comments[0].Pos() > f.Package {
+		return ""
+	}
+	var buf bytes.Buffer
+	for _, c := range f.Comments[0].List {
+		fmt.Fprintln(&buf, c.Text)
+	}
+	return buf.String()
+}
+
+// annotation finds the amqp:described line in a doc comment.
+func annotation(doc *ast.CommentGroup) (code, symbol string, ok bool) {
+	if doc == nil {
+		return "", "", false
+	}
+	for _, c := range doc.List {
+		line := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+		if m := describedRe.FindStringSubmatch(line); m != nil {
+			return m[1], m[2], true
+		}
+	}
+	return "", "", false
+}
+
+func newDescribedType(fset *token.FileSet, f *ast.File, ts *ast.TypeSpec, code, symbol string, imports map[string]bool) (describedType, error) {
+	t := describedType{Name: ts.Name.Name, Code: code, Symbol: symbol}
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return t, fmt.Errorf("%s: amqp:described type %s is not a struct", fset.Position(ts.Pos()), t.Name)
+	}
+	for _, af := range st.Fields.List {
+		if len(af.Names) == 0 {
+			return t, fmt.Errorf("%s: embedded field in %s is not supported", fset.Position(af.Pos()), t.Name)
+		}
+		var tag string
+		if af.Tag != nil {
+			s, _ := strconv.Unquote(af.Tag.Value)
+			tag = reflect.StructTag(s).Get("amqp")
+		}
+		if tag == "-" {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, af.Type); err != nil {
+			return t, err
+		}
+		fd := field{Type: buf.String(), OmitEmpty: strings.HasSuffix(tag, ",omitempty")}
+		switch x := af.Type.(type) {
+		case *ast.StarExpr:
+			fd.Pointer, fd.Nilable = true, true
+		case *ast.ArrayType:
+			fd.Nilable = x.Len == nil
+		case *ast.MapType, *ast.InterfaceType:
+			fd.Nilable = true
+		}
+		if fd.OmitEmpty && !fd.Nilable {
+			// The zero value comparison names the field type, so it needs the type's imports.
+			for _, spec := range typeImports(f, af.Type) {
+				imports[spec] = true
+			}
+		}
+		for _, n := range af.Names {
+			fd.Name = n.Name
+			t.Fields = append(t.Fields, fd)
+		}
+	}
+	return t, nil
+}
+
+// typeImports returns the import specs from f used by the type expression.
+func typeImports(f *ast.File, expr ast.Expr) (specs []string) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if id, ok := sel.X.(*ast.Ident); ok {
+			for _, is := range f.Imports {
+				p, _ := strconv.Unquote(is.Path.Value)
+				if is.Name != nil && is.Name.Name == id.Name {
+					specs = append(specs, is.Name.Name+" "+is.Path.Value)
+				} else if is.Name == nil && path.Base(p) == id.Name {
+					specs = append(specs, is.Path.Value)
+				}
+			}
+		}
+		return false
+	})
+	return specs
+}
+
+var fileTemplate = template.Must(template.New("file").Parse(`{{.Header}}
+// Code generated by amqp-gen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"fmt"
+{{range .Imports}}	{{.}}
+{{end}})
+{{range .Types}}
+// MarshalAMQP encodes t as a list described by {{.Code}}.
+func (t {{.Name}}) MarshalAMQP() (interface{}, error) {
+	l := make({{$.Q}}List, {{len .Fields}})
+{{range $i, $f := .Fields}}{{if .Pointer}}	if t.{{.Name}} != nil {
+		l[{{$i}}] = *t.{{.Name}}
+	}
+{{else if .Nilable}}	if t.{{.Name}} != nil {
+		l[{{$i}}] = t.{{.Name}}
+	}
+{{else if .OmitEmpty}}	if t.{{.Name}} != *new({{.Type}}) {
+		l[{{$i}}] = t.{{.Name}}
+	}
+{{else}}	l[{{$i}}] = t.{{.Name}}
+{{end}}{{end}}	for len(l) > 0 && l[len(l)-1] == nil {
+		l = l[:len(l)-1]
+	}
+	return {{$.Q}}Described{Descriptor: uint64({{.Code}}), Value: l}, nil
+}
+
+// UnmarshalAMQP decodes t from a list described by {{.Code}}{{if .Symbol}} or {{.Symbol}}{{end}}.
+func (t *{{.Name}}) UnmarshalAMQP(v interface{}) error {
+	*t = {{.Name}}{}
+	if v == nil {
+		return nil
+	}
+	d, ok := v.({{$.Q}}Described)
+	if !ok || !(d.Descriptor == uint64({{.Code}}){{if .Symbol}} || d.Descriptor == {{$.Q}}Symbol({{printf "%q" .Symbol}}){{end}}) {
+		return fmt.Errorf("cannot unmarshal %v as {{.Name}}, expected descriptor {{.Code}}", v)
+	}
+	l, ok := d.Value.({{$.Q}}List)
+	if !ok && d.Value != nil {
+		return fmt.Errorf("cannot unmarshal %v as {{.Name}}, expected a list", d.Value)
+	}
+	for i, x := range l {
+		if x == nil {
+			continue
+		}
+		var err error
+		switch i {
+{{range $i, $f := .Fields}}		case {{$i}}:
+			err = {{$.Q}}Convert(x, &t.{{.Name}})
+{{end}}		}
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal field %d of {{.Name}}: %v", i, err)
+		}
+	}
+	return nil
+}
+{{end}}`))
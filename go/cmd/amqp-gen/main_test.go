@@ -0,0 +1,131 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSource(t *testing.T, src string) string {
+	dir, err := ioutil.TempDir("", "amqp-gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "types.go")
+	if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestGenerate(t *testing.T) {
+	name := writeSource(t, `// Licence
+
+package foo
+
+import (
+	"time"
+	other "example.com/x/y"
+)
+
+// Bar is not annotated
+type Bar struct{ A int }
+
+// Foo is described
+//
+// amqp:described 0x1234 example:foo:list
+type Foo struct {
+	A    string
+	B, C *int
+	D    []byte
+	E    time.Time    `+"`amqp:\",omitempty\"`"+`
+	F    other.Thing  `+"`amqp:\"-\"`"+`
+	g    interface{}
+}
+`)
+	defer os.RemoveAll(filepath.Dir(name))
+	src, err := generate([]string{name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "", src, 0); err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	for _, want := range []string{
+		"// Licence\n",
+		"// Code generated by amqp-gen. DO NOT EDIT.\n",
+		"\"github.com/apache/qpid-proton/go/pkg/amqp\"",
+		"\"time\"",
+		"func (t Foo) MarshalAMQP() (interface{}, error)",
+		"func (t *Foo) UnmarshalAMQP(v interface{}) error",
+		"make(amqp.List, 6)",
+		"l[0] = t.A\n",
+		"l[2] = *t.C",
+		"if t.E != *new(time.Time)",
+		"l[5] = t.g",
+		"uint64(0x1234)",
+		"amqp.Symbol(\"example:foo:list\")",
+		"err = amqp.Convert(x, &t.g)",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("missing %q in:\n%s", want, src)
+		}
+	}
+	for _, unwanted := range []string{"Bar", "t.F", "example.com/x/y"} {
+		if strings.Contains(string(src), unwanted) {
+			t.Errorf("unexpected %q in:\n%s", unwanted, src)
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, src := range []string{
+		"package foo\ntype Foo struct{}",
+		"package foo\n// amqp:described 0x1\ntype Foo int",
+		"package foo\n// amqp:described 0x1\ntype Foo struct{ Bar }",
+	} {
+		name := writeSource(t, src)
+		defer os.RemoveAll(filepath.Dir(name))
+		if _, err := generate([]string{name}); err == nil {
+			t.Errorf("expected error for %q", src)
+		}
+	}
+}
+
+// The generated code checked in to the amqp package must be up to date.
+func TestGeneratedSections(t *testing.T) {
+	src, err := generate([]string{"../../pkg/amqp/sections.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := ioutil.ReadFile("../../pkg/amqp/sections_amqp.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != string(old) {
+		t.Error("sections_amqp.go is out of date, run go generate in pkg/amqp")
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+)
+
+// Content types for the Message body helpers
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// contentBody returns the binary body of m if its content type is
+// contentType, ignoring any media type parameters.
+func contentBody(m Message, contentType string) ([]byte, error) {
+	if t, _, err := mime.ParseMediaType(m.ContentType()); err != nil || t != contentType {
+		return nil, fmt.Errorf("content type is %q, not %q", m.ContentType(), contentType)
+	}
+	switch b := m.Body().(type) {
+	case Binary:
+		return []byte(b), nil
+	case []byte:
+		return b, nil
+	default:
+		return nil, fmt.Errorf("%s body is %T, not binary", contentType, m.Body())
+	}
+}
+
+// setContentBody sets b as the body of m, to be sent as a DATA section.
+func setContentBody(m Message, contentType string, b []byte) {
+	m.SetContentType(contentType)
+	m.SetInferred(true)
+	m.SetBody(Binary(b))
+}
+
+// BodyAsJSON decodes a JSON body of m into v using json.Unmarshal. The
+// ContentType() must be "application/json" and the body binary, as set by
+// SetJSONBody().
+func BodyAsJSON(m Message, v interface{}) error {
+	b, err := contentBody(m, ContentTypeJSON)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// SetJSONBody encodes v using json.Marshal as the binary body of m, sent as an
+// AMQP DATA section, and sets ContentType() to "application/json".
+func SetJSONBody(m Message, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	setContentBody(m, ContentTypeJSON, b)
+	return nil
+}
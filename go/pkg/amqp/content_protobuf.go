@@ -0,0 +1,52 @@
+//go:build protobuf
+// +build protobuf
+
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+// Protocol buffer body helpers, built with the "protobuf" build tag so that
+// other builds do not need the google.golang.org/protobuf module.
+
+// BodyAsProtobuf decodes a protocol buffer body of m into pm using
+// proto.Unmarshal. The ContentType() must be "application/x-protobuf" and the
+// body binary, as set by SetProtobufBody().
+func BodyAsProtobuf(m Message, pm proto.Message) error {
+	b, err := contentBody(m, ContentTypeProtobuf)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, pm)
+}
+
+// SetProtobufBody encodes pm using proto.Marshal as the binary body of m, sent
+// as an AMQP DATA section, and sets ContentType() to "application/x-protobuf".
+func SetProtobufBody(m Message, pm proto.Message) error {
+	b, err := proto.Marshal(pm)
+	if err != nil {
+		return err
+	}
+	setContentBody(m, ContentTypeProtobuf, b)
+	return nil
+}
@@ -23,36 +23,130 @@ package amqp
 import "C"
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"sync"
 )
 
-// Error is an AMQP error condition. It has a name and a description.
-// It implements the Go error interface so can be returned as an error value.
+// Error is an AMQP error condition. It has a name, a description and optional
+// info. It implements the Go error interface so can be returned as an error value.
 //
-// You can pass amqp.Error to methods that send an error to a remote endpoint,
-// this gives you full control over what the remote endpoint will see.
+// You can pass amqp.Error or *amqp.Error to methods that send an error to a
+// remote endpoint, such as Close, this gives you full control over what the
+// remote endpoint will see.
 //
 // You can also pass any Go error to such functions, the remote peer
 // will see the equivalent of MakeError(error)
 //
-type Error struct{ Name, Description string }
+type Error struct {
+	Name, Description string
+
+	// Info is extra information about the condition, nil if there is none. It
+	// is a pointer so that Error values can be compared with ==, errors with
+	// Info are equal only if they share the same map.
+	Info *map[Symbol]interface{}
+}
 
 // Error implements the Go error interface for AMQP error errors.
 func (c Error) Error() string { return fmt.Sprintf("%s: %s", c.Name, c.Description) }
 
+// Is reports whether c matches target, for errors.Is(). target matches if it
+// is an Error or *Error and either:
+//
+// - target.Name is a namespace ending in ":" that contains c.Name
+// - target.Name equals c.Name and target.Description is empty
+//
+// See ConnectionErrors, SessionErrors and LinkErrors for namespaces.
+func (c Error) Is(target error) bool {
+	var name, desc string
+	switch t := target.(type) {
+	case Error:
+		name, desc = t.Name, t.Description
+	case *Error:
+		if t == nil {
+			return false
+		}
+		name, desc = t.Name, t.Description
+	default:
+		return false
+	}
+	if strings.HasSuffix(name, ":") {
+		return strings.HasPrefix(c.Name, name)
+	}
+	return desc == "" && name == c.Name
+}
+
 // Errorf makes a Error with name and formatted description as per fmt.Sprintf
 func Errorf(name, format string, arg ...interface{}) Error {
-	return Error{name, fmt.Sprintf(format, arg...)}
+	return Error{Name: name, Description: fmt.Sprintf(format, arg...)}
 }
 
 // MakeError makes an AMQP error from a go error: {Name: InternalError, Description: err.Error()}
-// If err is already an amqp.Error it is returned unchanged.
+// If err is already an amqp.Error or *amqp.Error it is returned unchanged. If
+// err wraps one, see Unwrap(), the wrapped Error is returned.
 func MakeError(err error) Error {
-	if amqpErr, ok := err.(Error); ok {
-		return amqpErr
-	} else {
-		return Error{InternalError, err.Error()}
+	if e, ok := findError(err); ok {
+		return e
+	}
+	return Error{Name: InternalError, Description: err.Error()}
+}
+
+// ConditionMapper converts an application error into an AMQP error condition.
+// It returns ok == false if it does not recognize err.
+type ConditionMapper func(err error) (cond Error, ok bool)
+
+var conditionMappers struct {
+	lock    sync.RWMutex
+	mappers []ConditionMapper
+}
+
+// RegisterConditionMapper adds an application-defined mapping from Go errors to
+// AMQP error conditions, used by MakeCondition. Mappers are tried in the order
+// they were registered, before the built-in rules.
+//
+// Safe to call concurrently, but normally called once during initialization.
+func RegisterConditionMapper(m ConditionMapper) {
+	conditionMappers.lock.Lock()
+	defer conditionMappers.lock.Unlock()
+	conditionMappers.mappers = append(conditionMappers.mappers, m)
+}
+
+// MakeCondition makes an AMQP error condition from a Go error, for example to
+// send to the remote peer when rejecting a delivery. The rules are:
+//
+// - an amqp.Error or *amqp.Error is returned unchanged
+// - mappers added with RegisterConditionMapper are tried in order
+// - context.DeadlineExceeded maps to ResourceLimitExceeded
+// - *MarshalError and *UnmarshalError map to DecodeError
+// - anything else maps to InternalError
+//
+// The Description is always err.Error(). MakeCondition(nil) returns the zero Error.
+func MakeCondition(err error) Error {
+	switch e := err.(type) {
+	case nil:
+		return Error{}
+	case Error:
+		return e
+	case *Error:
+		return *e
+	}
+	conditionMappers.lock.RLock()
+	mappers := conditionMappers.mappers
+	conditionMappers.lock.RUnlock()
+	for _, m := range mappers {
+		if cond, ok := m(err); ok {
+			return cond
+		}
+	}
+	switch err.(type) {
+	case MarshalError, *MarshalError, UnmarshalError, *UnmarshalError:
+		return Error{Name: DecodeError, Description: err.Error()}
+	}
+	if err == context.DeadlineExceeded {
+		return Error{Name: ResourceLimitExceeded, Description: err.Error()}
 	}
+	return Error{Name: InternalError, Description: err.Error()}
 }
 
 var (
@@ -69,30 +163,145 @@ var (
 	ResourceDeleted       = "amqp:resource-deleted"
 	IllegalState          = "amqp:illegal-state"
 	FrameSizeTooSmall     = "amqp:frame-size-too-small"
+
+	// Connection errors
+	ConnectionForced   = "amqp:connection:forced"
+	FramingError       = "amqp:connection:framing-error"
+	ConnectionRedirect = "amqp:connection:redirect"
+
+	// Session errors
+	WindowViolation  = "amqp:session:window-violation"
+	ErrantLink       = "amqp:session:errant-link"
+	HandleInUse      = "amqp:session:handle-in-use"
+	UnattachedHandle = "amqp:session:unattached-handle"
+
+	// Link errors
+	DetachForced          = "amqp:link:detach-forced"
+	TransferLimitExceeded = "amqp:link:transfer-limit-exceeded"
+	MessageSizeExceeded   = "amqp:link:message-size-exceeded"
+	LinkRedirect          = "amqp:link:redirect"
+	Stolen                = "amqp:link:stolen"
+
+	// Transaction errors
+	TransactionUnknownId = "amqp:transaction:unknown-id"
+	TransactionRollback  = "amqp:transaction:rollback"
+	TransactionTimeout   = "amqp:transaction:timeout"
 )
 
+// Error values that match every condition in a namespace with Error.Is(), for
+// example errors.Is(err, amqp.LinkErrors).
+var (
+	ConnectionErrors = Error{Name: "amqp:connection:"}
+	SessionErrors    = Error{Name: "amqp:session:"}
+	LinkErrors       = Error{Name: "amqp:link:"}
+)
+
+// IsConnectionError is true if err is or wraps an Error with a condition in the
+// "amqp:connection:" namespace, such as ConnectionForced or FramingError.
+func IsConnectionError(err error) bool { return inNamespace(err, ConnectionErrors) }
+
+// IsSessionError is true if err is or wraps an Error with a condition in the
+// "amqp:session:" namespace, such as WindowViolation or ErrantLink.
+func IsSessionError(err error) bool { return inNamespace(err, SessionErrors) }
+
+// IsLinkError is true if err is or wraps an Error with a condition in the
+// "amqp:link:" namespace, such as DetachForced or Stolen.
+func IsLinkError(err error) bool { return inNamespace(err, LinkErrors) }
+
+// inNamespace looks for an Error in the chain of errors returned by Unwrap()
+// methods, starting with err, and checks if it is in namespace ns.
+func inNamespace(err error, ns Error) bool {
+	e, ok := findError(err)
+	return ok && e.Is(ns)
+}
+
+// findError returns the first Error in the chain of errors returned by
+// Unwrap() methods, starting with err.
+func findError(err error) (Error, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case Error:
+			return e, true
+		case *Error:
+			if e == nil {
+				return Error{}, false
+			}
+			return *e, true
+		}
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		err = u.Unwrap()
+	}
+	return Error{}, false
+}
+
+// PnErrorCode is an error code returned by the proton C library.
 type PnErrorCode int
 
+// Proton C library error codes.
+const (
+	PnEOS         PnErrorCode = C.PN_EOS           // End of stream
+	PnErr         PnErrorCode = C.PN_ERR           // General error
+	PnOverflow    PnErrorCode = C.PN_OVERFLOW      // Overflow error
+	PnUnderflow   PnErrorCode = C.PN_UNDERFLOW     // Underflow error
+	PnStateErr    PnErrorCode = C.PN_STATE_ERR     // State error
+	PnArgErr      PnErrorCode = C.PN_ARG_ERR       // Argument error
+	PnTimeout     PnErrorCode = C.PN_TIMEOUT       // Timeout
+	PnIntr        PnErrorCode = C.PN_INTR          // Interrupt
+	PnInProgress  PnErrorCode = C.PN_INPROGRESS    // In-progress
+	PnOutOfMemory PnErrorCode = C.PN_OUT_OF_MEMORY // Out-of-memory error
+	PnAborted     PnErrorCode = C.PN_ABORTED       // Delivery aborted error
+)
+
+var pnErrorNames = map[PnErrorCode]string{
+	PnEOS:         "PN_EOS",
+	PnErr:         "PN_ERR",
+	PnOverflow:    "PN_OVERFLOW",
+	PnUnderflow:   "PN_UNDERFLOW",
+	PnStateErr:    "PN_STATE_ERR",
+	PnArgErr:      "PN_ARG_ERR",
+	PnTimeout:     "PN_TIMEOUT",
+	PnIntr:        "PN_INTR",
+	PnInProgress:  "PN_INPROGRESS",
+	PnOutOfMemory: "PN_OUT_OF_MEMORY",
+	PnAborted:     "PN_ABORTED",
+}
+
+// PnErrorString returns the name of a proton C error code, for example
+// "PN_OVERFLOW", or "PN_ERROR(n)" for an unknown code.
+func PnErrorString(code int) string {
+	if s, ok := pnErrorNames[PnErrorCode(code)]; ok {
+		return s
+	}
+	return fmt.Sprintf("PN_ERROR(%d)", code)
+}
+
 func (e PnErrorCode) String() string {
 	switch e {
-	case C.PN_EOS:
+	case PnEOS:
 		return "end-of-data"
-	case C.PN_ERR:
+	case PnErr:
 		return "error"
-	case C.PN_OVERFLOW:
+	case PnOverflow:
 		return "overflow"
-	case C.PN_UNDERFLOW:
+	case PnUnderflow:
 		return "underflow"
-	case C.PN_STATE_ERR:
+	case PnStateErr:
 		return "bad-state"
-	case C.PN_ARG_ERR:
+	case PnArgErr:
 		return "invalid-argument"
-	case C.PN_TIMEOUT:
+	case PnTimeout:
 		return "timeout"
-	case C.PN_INTR:
+	case PnIntr:
 		return "interrupted"
-	case C.PN_INPROGRESS:
+	case PnInProgress:
 		return "in-progress"
+	case PnOutOfMemory:
+		return "out-of-memory"
+	case PnAborted:
+		return "aborted"
 	default:
 		return fmt.Sprintf("unknown-error(%d)", e)
 	}
@@ -0,0 +1,144 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+type appError struct{ code int }
+
+func (e appError) Error() string { return fmt.Sprintf("app error %d", e.code) }
+
+func TestMakeCondition(t *testing.T) {
+	RegisterConditionMapper(func(err error) (Error, bool) {
+		if e, ok := err.(appError); ok {
+			return Errorf("app:error", "code %d", e.code), true
+		}
+		return Error{}, false
+	})
+	amqpErr := Error{Name: NotFound, Description: "no such queue"}
+	for _, x := range []struct {
+		err  error
+		want Error
+	}{
+		{nil, Error{}},
+		{amqpErr, amqpErr},
+		{&amqpErr, amqpErr},
+		{context.DeadlineExceeded, Error{Name: ResourceLimitExceeded, Description: context.DeadlineExceeded.Error()}},
+		{newMarshalError(1i, "no conversion"), Error{Name: DecodeError, Description: "cannot marshal complex128: no conversion"}},
+		{EndOfData, Error{Name: DecodeError, Description: EndOfData.Error()}},
+		{appError{42}, Error{Name: "app:error", Description: "code 42"}},
+		{fmt.Errorf("oops"), Error{Name: InternalError, Description: "oops"}},
+	} {
+		test.ErrorIf(t, test.Differ(x.want, MakeCondition(x.err)), "%v", x.err)
+	}
+}
+
+type wrapError struct{ err error }
+
+func (e wrapError) Error() string { return "wrapped: " + e.err.Error() }
+func (e wrapError) Unwrap() error { return e.err }
+
+func TestErrorNamespaces(t *testing.T) {
+	for _, x := range []struct {
+		err                       error
+		connection, session, link bool
+	}{
+		{Errorf(ConnectionForced, "x"), true, false, false},
+		{Errorf(FramingError, "x"), true, false, false},
+		{Errorf(WindowViolation, "x"), false, true, false},
+		{&Error{Name: ErrantLink}, false, true, false},
+		{Errorf(DetachForced, "x"), false, false, true},
+		{Errorf(Stolen, "x"), false, false, true},
+		{Errorf("amqp:link:vendor-specific", "x"), false, false, true},
+		{wrapError{Errorf(DetachForced, "x")}, false, false, true},
+		{wrapError{wrapError{&Error{Name: FramingError}}}, true, false, false},
+		{Errorf(InternalError, "x"), false, false, false},
+		{Errorf(TransactionRollback, "x"), false, false, false},
+		{Errorf("amqp:linked", "x"), false, false, false},
+		{(*Error)(nil), false, false, false},
+		{fmt.Errorf("amqp:link:stolen"), false, false, false},
+		{nil, false, false, false},
+	} {
+		test.ErrorIf(t, test.Differ(x.connection, IsConnectionError(x.err)), "%#v", x.err)
+		test.ErrorIf(t, test.Differ(x.session, IsSessionError(x.err)), "%#v", x.err)
+		test.ErrorIf(t, test.Differ(x.link, IsLinkError(x.err)), "%#v", x.err)
+	}
+
+	err := Errorf(DetachForced, "gone")
+	for _, x := range []struct {
+		target error
+		want   bool
+	}{
+		{LinkErrors, true},
+		{&LinkErrors, true},
+		{SessionErrors, false},
+		{Error{Name: DetachForced}, true},
+		{Error{Name: DetachForced, Description: "other"}, false},
+		{Error{Name: Stolen}, false},
+		{(*Error)(nil), false},
+		{fmt.Errorf("gone"), false},
+	} {
+		test.ErrorIf(t, test.Differ(x.want, err.Is(x.target)), "%#v", x.target)
+	}
+}
+
+func TestMakeError(t *testing.T) {
+	cond := Errorf(ResourceLimitExceeded, "x")
+	for _, x := range []struct {
+		err  error
+		want Error
+	}{
+		{cond, cond},
+		{&cond, cond},
+		{wrapError{cond}, cond},
+		{wrapError{wrapError{&cond}}, cond},
+		{fmt.Errorf("x"), Error{Name: InternalError, Description: "x"}},
+		{wrapError{fmt.Errorf("x")}, Error{Name: InternalError, Description: "wrapped: x"}},
+	} {
+		test.ErrorIf(t, test.Differ(x.want, MakeError(x.err)), "%#v", x.err)
+	}
+}
+
+func TestPnErrorString(t *testing.T) {
+	codes := []PnErrorCode{PnEOS, PnErr, PnOverflow, PnUnderflow, PnStateErr, PnArgErr,
+		PnTimeout, PnIntr, PnInProgress, PnOutOfMemory, PnAborted}
+	names, strs := map[string]bool{}, map[string]bool{}
+	for _, c := range codes {
+		name := PnErrorString(int(c))
+		if !strings.HasPrefix(name, "PN_") || names[name] {
+			t.Errorf("%d: bad or duplicate name %q", c, name)
+		}
+		names[name] = true
+		if s := c.String(); strings.HasPrefix(s, "unknown") || strs[s] {
+			t.Errorf("%d: bad or duplicate string %q", c, s)
+		}
+		strs[c.String()] = true
+	}
+	test.ErrorIf(t, test.Differ("PN_OVERFLOW", PnErrorString(-3)))
+	test.ErrorIf(t, test.Differ("PN_ERROR(-99)", PnErrorString(-99)))
+	test.ErrorIf(t, test.Differ("unknown-error(-99)", PnErrorCode(-99).String()))
+}
@@ -0,0 +1,144 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// FormatIndented renders an AMQP value as a human-readable string, with each
+// element of a map, list or array on a new line, like json.MarshalIndent.
+// Each new line begins with prefix followed by one copy of indent per level
+// of nesting. The first line is not prefixed.
+//
+// v is marshaled and unmarshaled first, so it can be any type accepted by
+// Marshal, and the output shows the AMQP type of the encoded value: a string
+// is quoted, a Symbol is not, a Binary is shown as b"...", a described value
+// is shown as @descriptor followed by the value. Map keys are sorted.
+func FormatIndented(v interface{}, prefix, indent string) (string, error) {
+	b, err := Marshal(v, nil)
+	if err != nil {
+		return "", err
+	}
+	var decoded interface{}
+	if _, err := Unmarshal(b, &decoded); err != nil {
+		return "", err
+	}
+	f := formatter{prefix: prefix, indent: indent}
+	f.value(decoded, 0)
+	return f.out.String(), nil
+}
+
+type formatter struct {
+	out            bytes.Buffer
+	prefix, indent string
+}
+
+func (f *formatter) newline(depth int) {
+	f.out.WriteByte('\n')
+	f.out.WriteString(f.prefix)
+	for i := 0; i < depth; i++ {
+		f.out.WriteString(f.indent)
+	}
+}
+
+// kv is a map entry with the formatted key, for sorting.
+type kv struct {
+	key   string
+	value interface{}
+}
+
+func (f *formatter) value(v interface{}, depth int) {
+	switch v := v.(type) {
+	case nil:
+		f.out.WriteString("null")
+	case string:
+		f.out.WriteString(strconv.Quote(v))
+	case Symbol:
+		f.out.WriteString(string(v))
+	case Binary:
+		f.out.WriteString("b" + strconv.Quote(string(v)))
+	case Described:
+		f.out.WriteString("@")
+		if code, ok := v.Descriptor.(uint64); ok {
+			fmt.Fprintf(&f.out, "%#x", code)
+		} else {
+			f.value(v.Descriptor, depth)
+		}
+		f.out.WriteString(" ")
+		f.value(v.Value, depth)
+	case Map:
+		entries := make([]kv, 0, len(v))
+		for k, x := range v {
+			entries = append(entries, kv{f.key(k), x})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+		f.entries(entries, depth)
+	case AnyMap:
+		entries := make([]kv, 0, len(v))
+		for _, e := range v {
+			entries = append(entries, kv{f.key(e.Key), e.Value})
+		}
+		f.entries(entries, depth)
+	default:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice { // List, Array or []T
+			f.out.WriteString("[")
+			for i := 0; i < rv.Len(); i++ {
+				if i > 0 {
+					f.out.WriteString(",")
+				}
+				f.newline(depth + 1)
+				f.value(rv.Index(i).Interface(), depth+1)
+			}
+			if rv.Len() > 0 {
+				f.newline(depth)
+			}
+			f.out.WriteString("]")
+		} else {
+			fmt.Fprint(&f.out, v)
+		}
+	}
+}
+
+func (f *formatter) key(k interface{}) string {
+	kf := formatter{prefix: f.prefix, indent: f.indent}
+	kf.value(k, 0)
+	return kf.out.String()
+}
+
+func (f *formatter) entries(entries []kv, depth int) {
+	f.out.WriteString("{")
+	for i, e := range entries {
+		if i > 0 {
+			f.out.WriteString(",")
+		}
+		f.newline(depth + 1)
+		f.out.WriteString(e.key + ": ")
+		f.value(e.value, depth+1)
+	}
+	if len(entries) > 0 {
+		f.newline(depth)
+	}
+	f.out.WriteString("}")
+}
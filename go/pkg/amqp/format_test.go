@@ -0,0 +1,54 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+import (
+	"testing"
+
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+func TestFormatIndented(t *testing.T) {
+	for _, x := range []struct {
+		v    interface{}
+		want string
+	}{
+		{Map{"a": List{1, 2}}, "{\n  \"a\": [\n    1,\n    2\n  ]\n}"},
+		{Map{Symbol("s"): "x", "b": Binary("y"), int32(1): nil}, "{\n  \"b\": b\"y\",\n  1: null,\n  s: \"x\"\n}"},
+		{List{}, "[]"},
+		{Map{}, "{}"},
+		{[]Symbol{"a", "b"}, "[\n  a,\n  b\n]"},
+		{Described{uint64(0x13), List{true}}, "@0x13 [\n  true\n]"},
+		{Described{Symbol("x:y"), "z"}, "@x:y \"z\""},
+		{"plain", "\"plain\""},
+		{map[string]interface{}{"m": map[string]int32{"n": 3}}, "{\n  \"m\": {\n    \"n\": 3\n  }\n}"},
+	} {
+		s, err := FormatIndented(x.v, "", "  ")
+		test.ErrorIf(t, err)
+		test.ErrorIf(t, test.Differ(x.want, s))
+	}
+	s, err := FormatIndented(List{Map{"k": 1}}, "> ", "\t")
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ("[\n> \t{\n> \t\t\"k\": 1\n> \t}\n> ]", s))
+
+	if _, err := FormatIndented(make(chan int), "", " "); err == nil {
+		t.Error("expected error for a channel")
+	}
+}
@@ -316,6 +316,93 @@ func TestEncodeDecode(t *testing.T) {
 	}
 }
 
+func TestDecoderMarkReset(t *testing.T) {
+	buf := bytes.Buffer{}
+	e := NewEncoder(&buf)
+	for _, v := range []interface{}{"foo", int64(42), "bar"} {
+		if err := e.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := NewDecoder(&buf)
+	if err := d.Reset(0); err == nil {
+		t.Error("expected error resetting without a mark")
+	}
+
+	// Speculatively decode, then back up and decode as the right type.
+	mark := d.Mark()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v.(int64); ok {
+		t.Errorf("expected a string, got %#v", v)
+	}
+	if err := d.Reset(mark); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := d.Decode(&s); err != nil {
+		t.Error(err)
+	}
+	if err := test.Differ("foo", s); err != nil {
+		t.Error(err)
+	}
+
+	// Reset over several values.
+	mark = d.Mark()
+	var i int64
+	if err := d.Decode(&i); err != nil {
+		t.Error(err)
+	}
+	if err := d.Decode(&s); err != nil {
+		t.Error(err)
+	}
+	if err := d.Reset(mark - 1); err == nil {
+		t.Error("expected error resetting before the mark")
+	}
+	if err := d.Reset(mark); err != nil {
+		t.Fatal(err)
+	}
+	var all []interface{}
+	for {
+		if err := d.Decode(&v); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, v)
+	}
+	if err := test.Differ([]interface{}{int64(42), "bar"}, all); err != nil {
+		t.Error(err)
+	}
+}
+
+// Decoded data is kept from Mark until Unmark.
+func TestDecoderUnmark(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, v := range []string{"a", "b", "c"} {
+		test.FatalIf(t, e.Encode(v))
+	}
+	d := NewDecoder(&buf)
+	mark := d.Mark()
+	var s string
+	test.FatalIf(t, d.Decode(&s))
+	test.FatalIf(t, d.Decode(&s))
+	if d.buffer.Len() == len(d.unread()) {
+		t.Error("expected decoded data to be kept after Mark")
+	}
+	d.Unmark()
+	test.ErrorIf(t, test.Differ(len(d.unread()), d.buffer.Len()))
+	if d.Reset(mark) == nil {
+		t.Error("expected error resetting after Unmark")
+	}
+	test.FatalIf(t, d.Decode(&s))
+	test.ErrorIf(t, test.Differ("c", s))
+	test.ErrorIf(t, test.Differ(0, d.buffer.Len()))
+}
+
 func TestMap(t *testing.T) {
 	d := NewDecoder(getReader(t, "maps"))
 
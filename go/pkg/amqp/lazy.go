@@ -0,0 +1,141 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+// #include <proton/codec.h>
+import "C"
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// LazyBinary holds an AMQP binary, string or symbol value decoded by
+// Unmarshal or Decoder.Decode without copying it into Go memory.  The bytes are
+// copied on the first call to Bytes() or Materialize().
+//
+// This is useful for applications that decode many values but only look at
+// the contents of a few of them.
+//
+// Lifetime: the decoded data is normally freed at the end of the Unmarshal or
+// Decode call. If any LazyBinary values were decoded, the data is kept until
+// every one of them has been materialized, released or garbage collected. Call
+// Materialize() as soon as you know you need a value, or Release() if you know
+// you don't, to avoid holding on to the memory of the entire decoded value.
+//
+// Values unmarshaled with UnmarshalUnsafe or Message.Unmarshal are materialized
+// immediately as the decoded data is not owned by this package.
+//
+// A LazyBinary is not safe for concurrent use.
+type LazyBinary struct {
+	src   *lazySource // Keeps the C data alive, nil if not decoded or materialized.
+	start *C.char
+	size  C.size_t
+	bytes []byte
+}
+
+// lazySource owns a pn_data_t that is referenced by LazyBinary values.
+// Copies of a LazyBinary share the same lazySource, the data is freed by a
+// finalizer when none of them refer to it.
+type lazySource struct {
+	data *C.pn_data_t
+	used bool
+}
+
+func freeLazySource(s *lazySource) { C.pn_data_free(s.data) }
+
+// NewLazyBinary returns a LazyBinary that is already materialized with b.
+func NewLazyBinary(b []byte) *LazyBinary { return &LazyBinary{bytes: b} }
+
+// Bytes returns the value, copying it into Go memory on the first call.
+func (b *LazyBinary) Bytes() []byte {
+	b.Materialize()
+	return b.bytes
+}
+
+// Binary returns the value as a Binary, see Bytes()
+func (b *LazyBinary) Binary() Binary { return Binary(b.Bytes()) }
+
+// Len returns the length of the value in bytes without copying it.
+func (b *LazyBinary) Len() int {
+	if b.src != nil {
+		return int(b.size)
+	}
+	return len(b.bytes)
+}
+
+// Materialized is true if the value has been copied into Go memory.
+func (b *LazyBinary) Materialized() bool { return b.src == nil }
+
+// Materialize copies the value into Go memory if it has not been copied
+// already, and releases this value's reference to the decoded data.
+func (b *LazyBinary) Materialize() {
+	if b.src != nil {
+		b.bytes = C.GoBytes(unsafe.Pointer(b.start), C.int(b.size))
+		b.release()
+	}
+}
+
+// Release discards the value without copying it, and releases this value's
+// reference to the decoded data. Bytes() returns nil after Release()
+// unless the value was already materialized.
+func (b *LazyBinary) Release() {
+	if b.src != nil {
+		b.release()
+	}
+}
+
+func (b *LazyBinary) release() {
+	b.src, b.start, b.size = nil, nil, 0
+}
+
+func (b *LazyBinary) String() string { return string(b.Bytes()) }
+
+// newData creates a pn_data_t for an unmarshal call, owned by the returned
+// lazySource. LazyBinary values unmarshaled from it refer to it without copying.
+func newData(capacity int) *lazySource {
+	return &lazySource{data: C.pn_data(C.size_t(capacity))}
+}
+
+// free frees the data at the end of the unmarshal call, unless it is
+// referenced by LazyBinary values in which case it will be freed when they no
+// longer refer to it.
+func (s *lazySource) free() {
+	if s.used {
+		runtime.SetFinalizer(s, freeLazySource)
+	} else {
+		C.pn_data_free(s.data)
+	}
+}
+
+// Called by unmarshal with the pn_bytes_t of the current value.
+// If src is nil, the value is materialized immediately.
+func (b *LazyBinary) set(src *lazySource, bytes C.pn_bytes_t) {
+	b.Release()
+	switch {
+	case bytes.size == 0:
+		b.bytes = []byte{}
+	case src == nil:
+		b.bytes = goBytes(bytes)
+	default:
+		src.used = true
+		b.src, b.start, b.size, b.bytes = src, bytes.start, bytes.size, nil
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -72,7 +73,7 @@ Go types are encoded as follows
  +-------------------------------------+--------------------------------------------+
  |string                               |string                                      |
  +-------------------------------------+--------------------------------------------+
- |[]byte, Binary                       |binary                                      |
+ |[]byte, Binary, *LazyBinary          |binary                                      |
  +-------------------------------------+--------------------------------------------+
  |Symbol                               |symbol                                      |
  +-------------------------------------+--------------------------------------------+
@@ -82,6 +83,10 @@ Go types are encoded as follows
  +-------------------------------------+--------------------------------------------+
  |nil                                  |null                                        |
  +-------------------------------------+--------------------------------------------+
+ |nil pointer, slice, map, chan or func |null                                        |
+ +-------------------------------------+--------------------------------------------+
+ |AMQPAbsent                           |null                                        |
+ +-------------------------------------+--------------------------------------------+
  |map[K]T                              |map with K and T converted as above         |
  +-------------------------------------+--------------------------------------------+
  |Map                                  |map, may have mixed types for keys, values  |
@@ -94,12 +99,24 @@ Go types are encoded as follows
  +-------------------------------------+--------------------------------------------+
  |Described                            |described type                              |
  +-------------------------------------+--------------------------------------------+
+ |[]Described                          |described array if all elements share the   |
+ |                                     |descriptor and value type, otherwise list   |
+ +-------------------------------------+--------------------------------------------+
  |time.Time                            |timestamp                                   |
  +-------------------------------------+--------------------------------------------+
  |UUID                                 |uuid                                        |
  +-------------------------------------+--------------------------------------------+
+ |TerminusDurability                   |uint                                        |
+ +-------------------------------------+--------------------------------------------+
+ |TerminusExpiryPolicy                 |symbol                                      |
+ +-------------------------------------+--------------------------------------------+
+ |SenderSettleMode, ReceiverSettleMode |ubyte                                       |
+ +-------------------------------------+--------------------------------------------+
+ |Marshaler                            |the value returned by MarshalAMQP           |
+ +-------------------------------------+--------------------------------------------+
 
-The following Go types cannot be marshaled: uintptr, function, channel, struct, complex64/128
+The following Go types cannot be marshaled: uintptr, function, channel, struct, complex64/128,
+except that nil functions and channels are marshaled as null.
 
 AMQP types not yet supported: decimal32/64/128
 */
@@ -129,6 +146,15 @@ func MarshalUnsafe(v interface{}, pnData unsafe.Pointer) (err error) {
 	return recoverMarshal(v, (*C.pn_data_t)(pnData))
 }
 
+// Marshaler is implemented by types that convert themselves to AMQP data,
+// typically described types with code generated by amqp-gen.
+//
+// MarshalAMQP returns a value to be marshaled in place of the Marshaler, for
+// example a Described with a List value.
+type Marshaler interface {
+	MarshalAMQP() (interface{}, error)
+}
+
 func recoverMarshal(v interface{}, data *C.pn_data_t) (err error) {
 	defer func() { // Convert panic to error return
 		if r := recover(); r != nil {
@@ -166,10 +192,53 @@ func encodeGrow(buffer []byte, encode encodeFn) ([]byte, error) {
 	return buffer, err
 }
 
+// encodedData is AMQP data that encodes itself to an io.Writer, see WriteTo.
+// v is the Go value marshaled into pn, for errors.
+type encodedData struct {
+	pn *C.pn_data_t
+	v  interface{}
+}
+
+// encodeBuffers holds buffers for encodedData.WriteTo
+var encodeBuffers = sync.Pool{New: func() interface{} { b := make([]byte, minEncode); return &b }}
+
+// maxPooledEncode is the largest buffer returned to encodeBuffers, so one huge
+// value does not keep a huge buffer alive.
+const maxPooledEncode = 64 * 1024
+
+// WriteTo encodes d into a buffer borrowed from encodeBuffers, growing it as
+// encodeGrow does if it is too small, and writes the encoded bytes to w.
+func (d *encodedData) WriteTo(w io.Writer) (n int64, err error) {
+	bp := encodeBuffers.Get().(*[]byte)
+	defer func() {
+		if cap(*bp) <= maxPooledEncode {
+			encodeBuffers.Put(bp)
+		}
+	}()
+	encode := func(buf []byte) ([]byte, error) {
+		n := int(C.pn_data_encode(d.pn, cPtr(buf), cLen(buf)))
+		switch {
+		case n == int(C.PN_OVERFLOW):
+			return buf, overflow
+		case n < 0:
+			return buf, dataMarshalError(d.v, d.pn)
+		default:
+			return buf[:n], nil
+		}
+	}
+	buf, err := encodeGrow((*bp)[:cap(*bp)], encode)
+	*bp = buf[:cap(buf)] // Keep the grown buffer
+	if err != nil {
+		return 0, err
+	}
+	m, err := w.Write(buf)
+	return int64(m), err
+}
+
 // Marshal v to data
 func marshal(i interface{}, data *C.pn_data_t) {
 	switch v := i.(type) {
-	case nil:
+	case nil, AMQPAbsent:
 		C.pn_data_put_null(data)
 	case bool:
 		C.pn_data_put_bool(data, C.bool(v))
@@ -216,11 +285,17 @@ func marshal(i interface{}, data *C.pn_data_t) {
 	case string:
 		C.pn_data_put_string(data, pnBytes([]byte(v)))
 	case []byte:
-		C.pn_data_put_binary(data, pnBytes(v))
+		if v == nil {
+			C.pn_data_put_null(data)
+		} else {
+			C.pn_data_put_binary(data, pnBytes(v))
+		}
 	case Binary:
 		C.pn_data_put_binary(data, pnBytes([]byte(v)))
 	case Symbol:
 		C.pn_data_put_symbol(data, pnBytes([]byte(v)))
+	case *LazyBinary:
+		C.pn_data_put_binary(data, pnBytes(v.Bytes()))
 
 		// Other simple types
 	case time.Time:
@@ -229,6 +304,14 @@ func marshal(i interface{}, data *C.pn_data_t) {
 		C.pn_data_put_uuid(data, *(*C.pn_uuid_t)(unsafe.Pointer(&v[0])))
 	case Char:
 		C.pn_data_put_char(data, (C.pn_char_t)(v))
+	case TerminusDurability:
+		C.pn_data_put_uint(data, C.uint32_t(v))
+	case TerminusExpiryPolicy:
+		C.pn_data_put_symbol(data, pnBytes([]byte(v)))
+	case SenderSettleMode:
+		C.pn_data_put_ubyte(data, C.uint8_t(v))
+	case ReceiverSettleMode:
+		C.pn_data_put_ubyte(data, C.uint8_t(v))
 
 		// Described types
 	case Described:
@@ -238,8 +321,29 @@ func marshal(i interface{}, data *C.pn_data_t) {
 		marshal(v.Value, data)
 		C.pn_data_exit(data)
 
+	case []Described:
+		if pnType, ok := describedArrayType(v); ok {
+			C.pn_data_put_array(data, true, pnType)
+			C.pn_data_enter(data)
+			marshal(v[0].Descriptor, data)
+			for _, d := range v {
+				marshal(d.Value, data)
+			}
+			C.pn_data_exit(data)
+		} else {
+			C.pn_data_put_list(data)
+			C.pn_data_enter(data)
+			for _, d := range v {
+				marshal(d, data)
+			}
+			C.pn_data_exit(data)
+		}
+
 		// Restricted type annotation-key, marshals as contained value
 	case AnnotationKey:
+		if err := v.Validate(); err != nil {
+			panic(newMarshalError(v, err.Error()))
+		}
 		marshal(v.Get(), data)
 
 		// Special type to represent AMQP maps with keys that are illegal in Go
@@ -252,12 +356,35 @@ func marshal(i interface{}, data *C.pn_data_t) {
 			marshal(kv.Value, data)
 		}
 
+		// Types that convert themselves
+	case Marshaler:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			C.pn_data_put_null(data)
+			break
+		}
+		x, err := v.MarshalAMQP()
+		if err != nil {
+			panic(newMarshalError(i, err.Error()))
+		}
+		marshal(x, data)
+
 	default:
 		// Examine complex types (Go map, slice, array) by reflected structure
 		switch reflect.TypeOf(i).Kind() {
 
+		case reflect.Ptr, reflect.Chan, reflect.Func:
+			if reflect.ValueOf(v).IsNil() {
+				C.pn_data_put_null(data)
+			} else {
+				panic(newMarshalError(v, "no conversion"))
+			}
+
 		case reflect.Map:
 			m := reflect.ValueOf(v)
+			if m.IsNil() {
+				C.pn_data_put_null(data)
+				break
+			}
 			C.pn_data_put_map(data)
 			if C.pn_data_enter(data) {
 				defer C.pn_data_exit(data)
@@ -274,6 +401,10 @@ func marshal(i interface{}, data *C.pn_data_t) {
 			// if element type is an interface, map to AMQP list (mixed type)
 			// if element type is a non-interface type map to AMQP array (single type)
 			s := reflect.ValueOf(v)
+			if s.Kind() == reflect.Slice && s.IsNil() {
+				C.pn_data_put_null(data)
+				break
+			}
 			if pnType, ok := arrayTypeMap[s.Type().Elem()]; ok {
 				C.pn_data_put_array(data, false, pnType)
 			} else {
@@ -294,6 +425,27 @@ func marshal(i interface{}, data *C.pn_data_t) {
 	}
 }
 
+// describedArrayType returns the AMQP array type for a described array holding
+// ds, false if ds cannot be an AMQP described array. An AMQP described array
+// has a single descriptor for all elements, so ds must be non-empty and all
+// elements must have the same descriptor and the same simple value type.
+func describedArrayType(ds []Described) (C.pn_type_t, bool) {
+	if len(ds) == 0 || ds[0].Descriptor == nil || !reflect.TypeOf(ds[0].Descriptor).Comparable() {
+		return 0, false
+	}
+	vt := reflect.TypeOf(ds[0].Value)
+	pnType, ok := arrayTypeMap[vt]
+	if !ok || vt == nil {
+		return 0, false
+	}
+	for _, d := range ds[1:] {
+		if d.Descriptor != ds[0].Descriptor || reflect.TypeOf(d.Value) != vt {
+			return 0, false
+		}
+	}
+	return pnType, true
+}
+
 // Mapping froo Go element type to AMQP array type for types that can go in an AMQP array
 // NOTE: this must be kept consistent with marshal() which does the actual marshalling.
 var arrayTypeMap = map[reflect.Type]C.pn_type_t{
@@ -342,18 +494,18 @@ func clearMarshal(v interface{}, data *C.pn_data_t) {
 // Encoder encodes AMQP values to an io.Writer
 type Encoder struct {
 	writer io.Writer
-	buffer []byte
 }
 
 // New encoder returns a new encoder that writes to w.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w, make([]byte, minEncode)}
+	return &Encoder{w}
 }
 
 func (e *Encoder) Encode(v interface{}) (err error) {
-	e.buffer, err = Marshal(v, e.buffer)
-	if err == nil {
-		_, err = e.writer.Write(e.buffer)
+	data := C.pn_data(0)
+	defer C.pn_data_free(data)
+	if err = recoverMarshal(v, data); err == nil {
+		_, err = (&encodedData{data, v}).WriteTo(e.writer)
 	}
 	return err
 }
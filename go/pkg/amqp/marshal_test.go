@@ -20,6 +20,9 @@ under the License.
 package amqp
 
 import (
+	"bytes"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -39,6 +42,34 @@ func TestSymbolKey(t *testing.T) {
 	test.ErrorIf(t, test.Differ("foo", sym.String()))
 }
 
+func TestAnnotationKeyValidate(t *testing.T) {
+	for _, k := range []AnnotationKey{SymbolAnnotationKey("foo"), UlongAnnotationKey(42), AnnotationKeyString("foo")} {
+		test.ErrorIf(t, k.Validate())
+		_, err := Marshal(k, nil)
+		test.ErrorIf(t, err)
+	}
+	for _, k := range []AnnotationKey{{"foo"}, {int64(42)}, {}} {
+		if k.Validate() == nil {
+			t.Errorf("%#v: expected validation error", k)
+		}
+		if _, err := Marshal(k, nil); err == nil {
+			t.Errorf("%#v: expected marshal error", k)
+		}
+		if _, err := Marshal(Annotations{k: "x"}, nil); err == nil {
+			t.Errorf("%#v: expected marshal error in map", k)
+		}
+	}
+
+	// A string key on the wire unmarshals as a symbol
+	bytes, err := Marshal("foo", nil)
+	test.FatalIf(t, err)
+	var k AnnotationKey
+	_, err = Unmarshal(bytes, &k)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, k.Validate())
+	test.ErrorIf(t, test.Differ(SymbolAnnotationKey("foo"), k))
+}
+
 func TestStringKey(t *testing.T) {
 	bytes, err := Marshal(AnnotationKeyString("foo"), nil)
 	test.FatalIf(t, err)
@@ -186,3 +217,195 @@ func TestBadMap(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestMaxCollectionSize(t *testing.T) {
+	test.ErrorIf(t, test.Differ(DefaultMaxCollectionSize, MaxCollectionSize()))
+	tooLarge := func(err error) {
+		if _, ok := err.(*UnmarshalError); !ok || !strings.Contains(err.Error(), "exceeds the maximum collection size") {
+			t.Errorf("want UnmarshalError, got %#v", err)
+		}
+	}
+
+	// A map claiming a huge count fails without allocating it.
+	var v interface{}
+	huge := []byte{0xd1, 0, 0, 0, 6, 0x7f, 0xff, 0xff, 0xfe, 0x40, 0x40}
+	_, err := Unmarshal(huge, &v)
+	if err == nil {
+		t.Error("expected error")
+	}
+
+	old := SetMaxCollectionSize(2)
+	defer SetMaxCollectionSize(old)
+	test.ErrorIf(t, test.Differ(DefaultMaxCollectionSize, old))
+	for _, x := range []interface{}{
+		List{1, 2, 3},
+		[]int32{1, 2, 3},
+		Map{"a": 1, "b": 2, "c": 3},
+	} {
+		bytes, err := Marshal(x, nil)
+		test.FatalIf(t, err)
+		_, err = Unmarshal(bytes, &v)
+		tooLarge(err)
+		target := reflect.New(reflect.TypeOf(x))
+		_, err = Unmarshal(bytes, target.Interface())
+		tooLarge(err)
+	}
+	bytes, err := Marshal(Map{"a": 1, "b": 2, "c": 3}, nil)
+	test.FatalIf(t, err)
+	var am AnyMap
+	_, err = Unmarshal(bytes, &am)
+	tooLarge(err)
+
+	// Collections within the limit are not affected.
+	bytes, err = Marshal(List{1, 2}, nil)
+	test.FatalIf(t, err)
+	_, err = Unmarshal(bytes, &v)
+	test.ErrorIf(t, err)
+}
+
+// Encoder must grow its buffer for large values and reuse it for later ones.
+func TestEncoderGrow(t *testing.T) {
+	var buf, want bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, v := range []interface{}{"x", strings.Repeat("y", 10*minEncode), []string{"a", "b"}, 42} {
+		test.FatalIf(t, e.Encode(v))
+		b, err := Marshal(v, nil)
+		test.FatalIf(t, err)
+		want.Write(b)
+	}
+	test.ErrorIf(t, test.Differ(want.Bytes(), buf.Bytes()))
+}
+
+// Buffers grown for huge values are not kept in encodeBuffers.
+func TestEncoderHugeBuffer(t *testing.T) {
+	var buf bytes.Buffer
+	test.FatalIf(t, NewEncoder(&buf).Encode(strings.Repeat("x", 2*maxPooledEncode)))
+	bp := encodeBuffers.Get().(*[]byte)
+	defer encodeBuffers.Put(bp)
+	if cap(*bp) > maxPooledEncode {
+		t.Errorf("pooled buffer too big: %d", cap(*bp))
+	}
+}
+
+func TestLazyBinary(t *testing.T) {
+	bytes, err := Marshal(Binary("hello"), nil)
+	test.FatalIf(t, err)
+	var lb LazyBinary
+	_, err = Unmarshal(bytes, &lb)
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(false, lb.Materialized()))
+	test.ErrorIf(t, test.Differ(5, lb.Len()))
+	runtime.GC() // Decoded data must stay alive while lb is not materialized.
+	test.ErrorIf(t, test.Differ("hello", string(lb.Bytes())))
+	test.ErrorIf(t, test.Differ(true, lb.Materialized()))
+
+	// Re-marshal as binary
+	bytes2, err := Marshal(&lb, nil)
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(bytes, bytes2))
+
+	// Sequence of lazy values sharing the same data
+	bytes, err = Marshal(List{Binary("one"), Symbol("two"), ""}, nil)
+	test.FatalIf(t, err)
+	var lbs []LazyBinary
+	_, err = Unmarshal(bytes, &lbs)
+	test.FatalIf(t, err)
+	lbs[1].Release()
+	runtime.GC()
+	test.ErrorIf(t, test.Differ(Binary("one"), lbs[0].Binary()))
+	test.ErrorIf(t, test.Differ([]byte(nil), lbs[1].Bytes()))
+	test.ErrorIf(t, test.Differ([]byte{}, lbs[2].Bytes()))
+
+	// Not a binary type
+	bytes, err = Marshal(int32(1), nil)
+	test.FatalIf(t, err)
+	_, err = Unmarshal(bytes, &lb)
+	if _, ok := err.(*UnmarshalError); !ok {
+		t.Errorf("expected UnmarshalError, got %v", err)
+	}
+}
+
+func TestMarshalNil(t *testing.T) {
+	null, err := Marshal(nil, nil)
+	test.FatalIf(t, err)
+	for _, x := range []interface{}{
+		(*string)(nil),
+		([]byte)(nil),
+		(map[string]int)(nil),
+		([]string)(nil),
+		List(nil),
+		Map(nil),
+		(chan int)(nil),
+		(func())(nil),
+	} {
+		bytes, err := Marshal(x, nil)
+		if err != nil {
+			t.Errorf("%T: %v", x, err)
+			continue
+		}
+		test.ErrorIf(t, test.Differ(null, bytes))
+		var v interface{} = "not nil"
+		_, err = Unmarshal(bytes, &v)
+		test.ErrorIf(t, err)
+		test.ErrorIf(t, test.Differ(nil, v))
+	}
+	// Nil values inside containers
+	bytes, err := Marshal(List{(*int)(nil), []byte(nil)}, nil)
+	test.FatalIf(t, err)
+	var l List
+	_, err = Unmarshal(bytes, &l)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ(List{nil, nil}, l))
+
+	// Empty but non-nil values are not null
+	bytes, err = Marshal([]byte{}, nil)
+	test.FatalIf(t, err)
+	var v interface{}
+	_, err = Unmarshal(bytes, &v)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ(Binary(""), v))
+
+	// Non-nil pointers still can't be marshaled
+	s := "x"
+	if _, err = Marshal(&s, nil); err == nil || !strings.Contains(err.Error(), "no conversion") {
+		t.Errorf("want no conversion error, got %v", err)
+	}
+}
+
+func TestGoArray(t *testing.T) {
+	bytes, err := Marshal([]int32{1, 2, 3}, nil)
+	test.FatalIf(t, err)
+	a := [3]int32{9, 9, 9}
+	_, err = Unmarshal(bytes, &a)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ([3]int32{1, 2, 3}, a))
+
+	// Go arrays marshal like slices
+	bytes2, err := Marshal(a, nil)
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(bytes, bytes2))
+
+	// Wrong length, the array is not modified
+	var b [2]int32
+	_, err = Unmarshal(bytes, &b)
+	if _, ok := err.(*UnmarshalError); !ok || !strings.Contains(err.Error(), "3 elements for array of length 2") {
+		t.Errorf("want UnmarshalError, got %#v", err)
+	}
+	test.ErrorIf(t, test.Differ([2]int32{}, b))
+
+	// Lists and described arrays
+	bytes, err = Marshal(List{"a", int64(1)}, nil)
+	test.FatalIf(t, err)
+	var l [2]interface{}
+	_, err = Unmarshal(bytes, &l)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ([2]interface{}{"a", int64(1)}, l))
+
+	ds := []Described{{Symbol("D"), "a"}, {Symbol("D"), "b"}}
+	bytes, err = Marshal(ds, nil)
+	test.FatalIf(t, err)
+	var da [2]Described
+	_, err = Unmarshal(bytes, &da)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ([2]Described{{Symbol("D"), "a"}, {Symbol("D"), "b"}}, da))
+}
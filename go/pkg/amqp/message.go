@@ -73,7 +73,7 @@ type Message interface {
 
 	// MessageId provides a unique identifier for a message.
 	// it can be an a string, an unsigned long, a uuid or a
-	// binary value.
+	// binary value. SetMessageId also accepts a MessageID.
 	MessageId() interface{}
 	SetMessageId(interface{})
 
@@ -122,16 +122,25 @@ type Message interface {
 	ApplicationProperties() map[string]interface{}
 	SetApplicationProperties(map[string]interface{})
 
+	// Typed access to ApplicationProperties(). The Get methods return the zero
+	// value and false if key is missing or its value has a different type.
+	GetStringProp(key string) (string, bool)
+	GetInt64Prop(key string) (int64, bool)
+	GetBoolProp(key string) (bool, bool)
+	SetStringProp(key string, value string)
+	SetInt64Prop(key string, value int64)
+	SetBoolProp(key string, value bool)
+
 	// Per-delivery annotations to provide delivery instructions.
 	// May be added or removed by intermediaries during delivery.
 	// See ApplicationProperties() for properties set by the application.
-	DeliveryAnnotations() map[AnnotationKey]interface{}
-	SetDeliveryAnnotations(map[AnnotationKey]interface{})
+	DeliveryAnnotations() Annotations
+	SetDeliveryAnnotations(Annotations)
 
 	// Message annotations added as part of the bare message at creation, usually
 	// by an AMQP library. See ApplicationProperties() for properties set by the application.
-	MessageAnnotations() map[AnnotationKey]interface{}
-	SetMessageAnnotations(map[AnnotationKey]interface{})
+	MessageAnnotations() Annotations
+	SetMessageAnnotations(Annotations)
 
 	// Inferred indicates how the message content
 	// is encoded into AMQP sections. If inferred is true then binary and
@@ -145,13 +154,14 @@ type Message interface {
 	// Get the message body, using the amqp.Unmarshal() rules for interface{}
 	Body() interface{}
 
-	// Set the body using amqp.Marshal()
+	// Set the body using amqp.Marshal(). SetBody(nil) sets a null body section.
 	SetBody(interface{})
 
 	// Marshal a Go value into the message body, synonym for SetBody()
 	Marshal(interface{})
 
-	// Unmarshal the message body, using amqp.Unmarshal()
+	// Unmarshal the message body, using amqp.Unmarshal(). If the message has no
+	// body section an interface{} is set to AMQPAbsent, a null body sets it to nil.
 	Unmarshal(interface{})
 
 	// Encode encodes the message as AMQP data. If buffer is non-nil and is large enough
@@ -168,6 +178,17 @@ type Message interface {
 	// Copy the contents of another message to this one.
 	Copy(m Message) error
 
+	// Clone returns a copy of the message that can be modified without
+	// affecting the original. The application properties, annotations,
+	// message and correlation IDs and body are deep-copied, including []byte,
+	// Map and List values, see Map.DeepClone().
+	//
+	// A Message is not safe for concurrent use. To keep or share a message
+	// after handing it on, for example a received message after Accept(),
+	// Clone() it first and give the clone to other goroutines. The original
+	// must not be modified while Clone() is running.
+	Clone() Message
+
 	// Deprecated: use DeliveryAnnotations() for a more type-safe interface
 	Instructions() map[string]interface{}
 	SetInstructions(v map[string]interface{})
@@ -218,6 +239,17 @@ func (m *message) Copy(x Message) error {
 	return err
 }
 
+// Clone makes a copy of m without encoding, deep-copying its maps and body.
+func (m *message) Clone() Message {
+	c := *m
+	c.applicationProperties, _ = deepCopy(m.applicationProperties).(map[string]interface{})
+	c.deliveryAnnotations, _ = deepCopy(m.deliveryAnnotations).(Annotations)
+	c.messageAnnotations, _ = deepCopy(m.messageAnnotations).(Annotations)
+	c.messageId, c.correlationId = deepCopy(m.messageId), deepCopy(m.correlationId)
+	c.body = deepCopy(m.body)
+	return &c
+}
+
 type message struct {
 	address               string
 	applicationProperties map[string]interface{}
@@ -225,7 +257,7 @@ type message struct {
 	contentType           string
 	correlationId         interface{}
 	creationTime          time.Time
-	deliveryAnnotations   map[AnnotationKey]interface{}
+	deliveryAnnotations   Annotations
 	deliveryCount         uint32
 	durable               bool
 	expiryTime            time.Time
@@ -233,7 +265,7 @@ type message struct {
 	groupId               string
 	groupSequence         int32
 	inferred              bool
-	messageAnnotations    map[AnnotationKey]interface{}
+	messageAnnotations    Annotations
 	messageId             interface{}
 	priority              uint8
 	replyTo               string
@@ -242,6 +274,7 @@ type message struct {
 	ttl                   time.Duration
 	userId                string
 	body                  interface{}
+	nullBody              bool // Decoded body section is null, body is nil
 	// Keep the original data to support Unmarshal to a non-interface{} type
 	// Waste of memory, consider deprecating or making it optional.
 	pnBody *C.pn_data_t
@@ -269,15 +302,15 @@ func (m *message) GroupId() string            { return m.groupId }
 func (m *message) GroupSequence() int32       { return m.groupSequence }
 func (m *message) ReplyToGroupId() string     { return m.replyToGroupId }
 
-func (m *message) DeliveryAnnotations() map[AnnotationKey]interface{} {
+func (m *message) DeliveryAnnotations() Annotations {
 	if m.deliveryAnnotations == nil {
-		m.deliveryAnnotations = make(map[AnnotationKey]interface{})
+		m.deliveryAnnotations = make(Annotations)
 	}
 	return m.deliveryAnnotations
 }
-func (m *message) MessageAnnotations() map[AnnotationKey]interface{} {
+func (m *message) MessageAnnotations() Annotations {
 	if m.messageAnnotations == nil {
-		m.messageAnnotations = make(map[AnnotationKey]interface{})
+		m.messageAnnotations = make(Annotations)
 	}
 	return m.messageAnnotations
 }
@@ -290,7 +323,7 @@ func (m *message) ApplicationProperties() map[string]interface{} {
 
 // ==== message set methods
 
-func (m *message) SetBody(v interface{})          { m.body = v }
+func (m *message) SetBody(v interface{})          { m.body, m.nullBody = v, v == nil }
 func (m *message) SetInferred(x bool)             { m.inferred = x }
 func (m *message) SetDurable(x bool)              { m.durable = x }
 func (m *message) SetPriority(x uint8)            { m.priority = x }
@@ -311,24 +344,45 @@ func (m *message) SetGroupId(x string)            { m.groupId = x }
 func (m *message) SetGroupSequence(x int32)       { m.groupSequence = x }
 func (m *message) SetReplyToGroupId(x string)     { m.replyToGroupId = x }
 
-func (m *message) SetDeliveryAnnotations(x map[AnnotationKey]interface{}) {
+func (m *message) SetDeliveryAnnotations(x Annotations) {
 	m.deliveryAnnotations = x
 }
-func (m *message) SetMessageAnnotations(x map[AnnotationKey]interface{}) {
+func (m *message) SetMessageAnnotations(x Annotations) {
 	m.messageAnnotations = x
 }
 func (m *message) SetApplicationProperties(x map[string]interface{}) {
 	m.applicationProperties = x
 }
 
+func (m *message) GetStringProp(key string) (v string, ok bool) {
+	v, ok = m.applicationProperties[key].(string)
+	return
+}
+func (m *message) GetInt64Prop(key string) (v int64, ok bool) {
+	v, ok = m.applicationProperties[key].(int64)
+	return
+}
+func (m *message) GetBoolProp(key string) (v bool, ok bool) {
+	v, ok = m.applicationProperties[key].(bool)
+	return
+}
+
+func (m *message) SetStringProp(key string, v string) { m.ApplicationProperties()[key] = v }
+func (m *message) SetInt64Prop(key string, v int64)   { m.ApplicationProperties()[key] = v }
+func (m *message) SetBoolProp(key string, v bool)     { m.ApplicationProperties()[key] = v }
+
 // Marshal body from v, same as SetBody(v). See amqp.Marshal.
-func (m *message) Marshal(v interface{}) { m.body = v }
+func (m *message) Marshal(v interface{}) { m.SetBody(v) }
 
 func (m *message) Unmarshal(v interface{}) {
+	if vp, ok := v.(*interface{}); ok && m.body == nil && !m.nullBody {
+		*vp = AMQPAbsent{} // No body section
+		return
+	}
 	pnData := C.pn_data(2)
 	defer C.pn_data_free(pnData)
 	marshal(m.body, pnData)
-	unmarshal(v, pnData)
+	unmarshal(v, pnData, nil)
 }
 
 // Internal use only
@@ -461,7 +515,7 @@ func getData(v interface{}, data *C.pn_data_t) {
 	if data != nil && C.pn_data_size(data) > 0 {
 		C.pn_data_rewind(data)
 		C.pn_data_next(data)
-		unmarshal(v, data)
+		unmarshal(v, data, nil)
 	}
 	return
 }
@@ -498,6 +552,7 @@ func (m *message) get(pn *C.pn_message_t) {
 	getData(&m.messageAnnotations, C.pn_message_annotations(pn))
 	getData(&m.applicationProperties, C.pn_message_properties(pn))
 	getData(&m.body, C.pn_message_body(pn))
+	m.nullBody = m.body == nil && C.pn_data_size(C.pn_message_body(pn)) > 0
 }
 
 // ==== put message to pn_message_t
@@ -558,11 +613,14 @@ func (m *message) put(pn *C.pn_message_t) {
 		putData(m.applicationProperties, C.pn_message_properties(pn))
 	}
 	putData(m.body, C.pn_message_body(pn))
+	if m.nullBody && m.body == nil {
+		C.pn_data_put_null(C.pn_message_body(pn))
+	}
 }
 
 // ==== Deprecated functions
 
-func oldAnnotations(in map[AnnotationKey]interface{}) (out map[string]interface{}) {
+func oldAnnotations(in Annotations) (out map[string]interface{}) {
 	if len(in) == 0 {
 		return nil
 	}
@@ -584,11 +642,11 @@ func (m *message) Properties() map[string]interface{} {
 }
 
 // Convert old string-keyed annotations to an AnnotationKey map
-func newAnnotations(in map[string]interface{}) (out map[AnnotationKey]interface{}) {
+func newAnnotations(in map[string]interface{}) (out Annotations) {
 	if len(in) == 0 {
 		return nil
 	}
-	out = make(map[AnnotationKey]interface{})
+	out = make(Annotations)
 	for k, v := range in {
 		out[AnnotationKeyString(k)] = v
 	}
@@ -65,8 +65,8 @@ func TestDefaultMessage(t *testing.T) {
 		{"ReplyToGroupId", ""},
 		{"MessageId", nil},
 		{"CorrelationId", nil},
-		{"DeliveryAnnotations", map[AnnotationKey]interface{}{}},
-		{"MessageAnnotations", map[AnnotationKey]interface{}{}},
+		{"DeliveryAnnotations", Annotations{}},
+		{"MessageAnnotations", Annotations{}},
 		{"ApplicationProperties", map[string]interface{}{}},
 
 		// Deprecated
@@ -158,8 +158,8 @@ func TestMessageRoundTrip(t *testing.T) {
 		{m.MessageId(), "id"},
 		{m.CorrelationId(), "correlation"},
 
-		{m.DeliveryAnnotations(), map[AnnotationKey]interface{}{AnnotationKeySymbol("instructions"): "foo"}},
-		{m.MessageAnnotations(), map[AnnotationKey]interface{}{AnnotationKeySymbol("annotations"): "bar"}},
+		{m.DeliveryAnnotations(), Annotations{AnnotationKeySymbol("instructions"): "foo"}},
+		{m.MessageAnnotations(), Annotations{AnnotationKeySymbol("annotations"): "bar"}},
 		{m.ApplicationProperties(), map[string]interface{}{"int": int32(32), "bool": true}},
 		{m.Body(), "hello"},
 
@@ -184,8 +184,8 @@ func TestDeprecated(t *testing.T) {
 	m.SetProperties(map[string]interface{}{"int": int32(32), "bool": true})
 
 	for _, data := range [][]interface{}{
-		{m.DeliveryAnnotations(), map[AnnotationKey]interface{}{AnnotationKeySymbol("instructions"): "foo"}},
-		{m.MessageAnnotations(), map[AnnotationKey]interface{}{AnnotationKeySymbol("annotations"): "bar"}},
+		{m.DeliveryAnnotations(), Annotations{AnnotationKeySymbol("instructions"): "foo"}},
+		{m.MessageAnnotations(), Annotations{AnnotationKeySymbol("annotations"): "bar"}},
 		{m.ApplicationProperties(), map[string]interface{}{"int": int32(32), "bool": true}},
 
 		{m.Instructions(), map[string]interface{}{"instructions": "foo"}},
@@ -245,6 +245,209 @@ func TestMessageBodyTypes(t *testing.T) {
 	// TODO aconway 2015-09-08: array etc.
 }
 
+func TestMessageAbsentBody(t *testing.T) {
+	var body interface{}
+	m := NewMessage()
+	m.Unmarshal(&body)
+	if !IsAbsent(body) {
+		t.Errorf("want absent body, got %#v", body)
+	}
+	buffer, err := m.Encode(nil)
+	test.FatalIf(t, err)
+	m, err = DecodeMessage(buffer)
+	test.FatalIf(t, err)
+	m.Unmarshal(&body)
+	if !IsAbsent(body) {
+		t.Errorf("want absent body, got %#v", body)
+	}
+
+	// AMQPAbsent marshals as null, so this message has a null body section.
+	m = NewMessageWith(AMQPAbsent{})
+	buffer, err = m.Encode(nil)
+	test.FatalIf(t, err)
+	m, err = DecodeMessage(buffer)
+	test.FatalIf(t, err)
+	body = "not nil"
+	m.Unmarshal(&body)
+	if body != nil {
+		t.Errorf("want nil body, got %#v", body)
+	}
+	test.ErrorIf(t, test.Differ(nil, m.Body()))
+	test.ErrorIf(t, roundTrip(m)) // The null body is preserved
+
+	// SetBody(nil) also sets a null body section.
+	m = NewMessage()
+	m.SetBody(nil)
+	test.ErrorIf(t, roundTrip(m))
+	buffer, err = m.Encode(nil)
+	test.FatalIf(t, err)
+	m, err = DecodeMessage(buffer)
+	test.FatalIf(t, err)
+	body = "not nil"
+	m.Unmarshal(&body)
+	if body != nil {
+		t.Errorf("want nil body, got %#v", body)
+	}
+	test.ErrorIf(t, roundTrip(m))
+
+	if IsAbsent(nil) || !IsAbsent(AMQPAbsent{}) {
+		t.Error("IsAbsent")
+	}
+}
+
+func TestMessageJSONBody(t *testing.T) {
+	type point struct {
+		X, Y int
+		Name string
+	}
+	want := point{1, 2, "p"}
+	m := NewMessage()
+	if err := SetJSONBody(m, want); err != nil {
+		t.Fatal(err)
+	}
+	if err := test.Differ(ContentTypeJSON, m.ContentType()); err != nil {
+		t.Error(err)
+	}
+	buffer, err := m.Encode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = DecodeMessage(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := test.Differ(Binary(`{"X":1,"Y":2,"Name":"p"}`), m.Body()); err != nil {
+		t.Error(err) // Sent as a data section
+	}
+	var got point
+	if err := BodyAsJSON(m, &got); err != nil {
+		t.Error(err)
+	}
+	if err := test.Differ(want, got); err != nil {
+		t.Error(err)
+	}
+
+	m.SetContentType("application/json; charset=utf-8")
+	if err := BodyAsJSON(m, &got); err != nil {
+		t.Error(err)
+	}
+	m.SetContentType("text/plain")
+	if err := BodyAsJSON(m, &got); err == nil {
+		t.Error("expected content type error")
+	}
+	m = NewMessageWith("{}")
+	m.SetContentType(ContentTypeJSON)
+	if err := BodyAsJSON(m, &got); err == nil {
+		t.Error("expected non-binary body error")
+	}
+}
+
+func TestMessageClone(t *testing.T) {
+	m := NewMessageWith(Map{"k": List{"v"}})
+	m.SetApplicationProperties(map[string]interface{}{"p": Map{"x": 1}})
+	m.SetMessageAnnotations(Annotations{AnnotationKeySymbol("x-opt-a"): []byte("a")})
+	m.SetMessageId(Binary("id"))
+
+	c := m.Clone()
+	test.ErrorIf(t, test.Differ(m.String(), c.String()))
+	c.ApplicationProperties()["p"].(Map)["x"] = 2
+	c.ApplicationProperties()["q"] = 3
+	c.MessageAnnotations()[AnnotationKeySymbol("x-opt-a")].([]byte)[0] = 'b'
+	c.Body().(Map)["k"].(List)[0] = "w"
+	c.SetSubject("changed")
+
+	test.ErrorIf(t, test.Differ(map[string]interface{}{"p": Map{"x": 1}}, m.ApplicationProperties()))
+	test.ErrorIf(t, test.Differ([]byte("a"), m.MessageAnnotations()[AnnotationKeySymbol("x-opt-a")]))
+	test.ErrorIf(t, test.Differ(Map{"k": List{"v"}}, m.Body()))
+	test.ErrorIf(t, test.Differ("", m.Subject()))
+}
+
+func TestMessageCloneBody(t *testing.T) {
+	for _, body := range []interface{}{
+		[]byte("bytes"),
+		Binary("binary"),
+		List{[]byte("a"), Map{"k": []byte("v")}},
+		Map{"k": Map{"n": List{1, 2}}},
+	} {
+		m := NewMessageWith(deepCopy(body))
+		m.SetCorrelationId([]byte("cid"))
+		c := m.Clone()
+		test.ErrorIf(t, test.Differ(m.String(), c.String()))
+
+		switch b := c.Body().(type) {
+		case []byte:
+			b[0] = 'X'
+		case Binary:
+			c.SetBody(Binary("changed"))
+		case List:
+			b[0].([]byte)[0] = 'X'
+			b[1].(Map)["k"].([]byte)[0] = 'X'
+		case Map:
+			b["k"].(Map)["n"].(List)[0] = 99
+			b["new"] = true
+		}
+		c.CorrelationId().([]byte)[0] = 'X'
+		test.ErrorIf(t, test.Differ(body, m.Body()))
+		test.ErrorIf(t, test.Differ([]byte("cid"), m.CorrelationId()))
+	}
+
+	// A decoded message clones like any other.
+	m := NewMessageWith(Map{"k": List{"v"}})
+	bytes, err := m.Encode(nil)
+	test.FatalIf(t, err)
+	m = NewMessage()
+	test.FatalIf(t, m.Decode(bytes))
+	c := m.Clone()
+	c.Body().(Map)["k"].(List)[0] = "w"
+	test.ErrorIf(t, test.Differ(Map{"k": List{"v"}}, m.Body()))
+	test.ErrorIf(t, test.Differ(Map{"k": List{"w"}}, c.Body()))
+}
+
+func TestMessageTypedProps(t *testing.T) {
+	m := NewMessage()
+	if v, ok := m.GetStringProp("s"); ok || v != "" {
+		t.Errorf("missing key: got %q, %v", v, ok)
+	}
+	if v, ok := m.GetInt64Prop("i"); ok || v != 0 {
+		t.Errorf("missing key: got %v, %v", v, ok)
+	}
+	if v, ok := m.GetBoolProp("b"); ok || v {
+		t.Errorf("missing key: got %v, %v", v, ok)
+	}
+
+	m.SetStringProp("s", "str")
+	m.SetInt64Prop("i", -42)
+	m.SetBoolProp("b", true)
+	m.ApplicationProperties()["i32"] = int32(7)
+	test.ErrorIf(t, test.Differ(map[string]interface{}{"s": "str", "i": int64(-42), "b": true, "i32": int32(7)}, m.ApplicationProperties()))
+
+	// Round trip through encoding keeps the types.
+	bytes, err := m.Encode(nil)
+	test.FatalIf(t, err)
+	m = NewMessage()
+	test.FatalIf(t, m.Decode(bytes))
+	if v, ok := m.GetStringProp("s"); !ok || v != "str" {
+		t.Errorf("got %q, %v", v, ok)
+	}
+	if v, ok := m.GetInt64Prop("i"); !ok || v != -42 {
+		t.Errorf("got %v, %v", v, ok)
+	}
+	if v, ok := m.GetBoolProp("b"); !ok || !v {
+		t.Errorf("got %v, %v", v, ok)
+	}
+
+	// Wrong types
+	if v, ok := m.GetStringProp("i"); ok || v != "" {
+		t.Errorf("wrong type: got %q, %v", v, ok)
+	}
+	if v, ok := m.GetInt64Prop("i32"); ok || v != 0 {
+		t.Errorf("wrong type: got %v, %v", v, ok)
+	}
+	if v, ok := m.GetBoolProp("s"); ok || v {
+		t.Errorf("wrong type: got %v, %v", v, ok)
+	}
+}
+
 // Benchmarks assign to package-scope variables to prevent being optimized out.
 var bmM Message
 var bmBuf []byte
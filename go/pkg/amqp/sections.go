@@ -0,0 +1,58 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+import "time"
+
+//go:generate go run ../../cmd/amqp-gen sections.go
+
+// Header is the header section of an AMQP message, carrying delivery details.
+// The zero value encodes as an empty header list.
+//
+// amqp:described 0x70 amqp:header:list
+type Header struct {
+	Durable bool `amqp:",omitempty"`
+	// Priority is nil for the default priority 4.
+	Priority *uint8
+	// TTL is the time to live in milliseconds, 0 means no limit.
+	TTL           uint32 `amqp:",omitempty"`
+	FirstAcquirer bool   `amqp:",omitempty"`
+	DeliveryCount uint32 `amqp:",omitempty"`
+}
+
+// Properties is the properties section of an AMQP message, carrying
+// immutable message properties. Empty fields are not encoded.
+//
+// amqp:described 0x73 amqp:properties:list
+type Properties struct {
+	MessageId          interface{}
+	UserId             []byte
+	To                 string `amqp:",omitempty"`
+	Subject            string `amqp:",omitempty"`
+	ReplyTo            string `amqp:",omitempty"`
+	CorrelationId      interface{}
+	ContentType        Symbol    `amqp:",omitempty"`
+	ContentEncoding    Symbol    `amqp:",omitempty"`
+	AbsoluteExpiryTime time.Time `amqp:",omitempty"`
+	CreationTime       time.Time `amqp:",omitempty"`
+	GroupId            string    `amqp:",omitempty"`
+	GroupSequence      uint32    `amqp:",omitempty"`
+	ReplyToGroupId     string    `amqp:",omitempty"`
+}
@@ -0,0 +1,191 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Code generated by amqp-gen. DO NOT EDIT.
+
+package amqp
+
+import (
+	"fmt"
+	"time"
+)
+
+// MarshalAMQP encodes t as a list described by 0x70.
+func (t Header) MarshalAMQP() (interface{}, error) {
+	l := make(List, 5)
+	if t.Durable != *new(bool) {
+		l[0] = t.Durable
+	}
+	if t.Priority != nil {
+		l[1] = *t.Priority
+	}
+	if t.TTL != *new(uint32) {
+		l[2] = t.TTL
+	}
+	if t.FirstAcquirer != *new(bool) {
+		l[3] = t.FirstAcquirer
+	}
+	if t.DeliveryCount != *new(uint32) {
+		l[4] = t.DeliveryCount
+	}
+	for len(l) > 0 && l[len(l)-1] == nil {
+		l = l[:len(l)-1]
+	}
+	return Described{Descriptor: uint64(0x70), Value: l}, nil
+}
+
+// UnmarshalAMQP decodes t from a list described by 0x70 or amqp:header:list.
+func (t *Header) UnmarshalAMQP(v interface{}) error {
+	*t = Header{}
+	if v == nil {
+		return nil
+	}
+	d, ok := v.(Described)
+	if !ok || !(d.Descriptor == uint64(0x70) || d.Descriptor == Symbol("amqp:header:list")) {
+		return fmt.Errorf("cannot unmarshal %v as Header, expected descriptor 0x70", v)
+	}
+	l, ok := d.Value.(List)
+	if !ok && d.Value != nil {
+		return fmt.Errorf("cannot unmarshal %v as Header, expected a list", d.Value)
+	}
+	for i, x := range l {
+		if x == nil {
+			continue
+		}
+		var err error
+		switch i {
+		case 0:
+			err = Convert(x, &t.Durable)
+		case 1:
+			err = Convert(x, &t.Priority)
+		case 2:
+			err = Convert(x, &t.TTL)
+		case 3:
+			err = Convert(x, &t.FirstAcquirer)
+		case 4:
+			err = Convert(x, &t.DeliveryCount)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal field %d of Header: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// MarshalAMQP encodes t as a list described by 0x73.
+func (t Properties) MarshalAMQP() (interface{}, error) {
+	l := make(List, 13)
+	if t.MessageId != nil {
+		l[0] = t.MessageId
+	}
+	if t.UserId != nil {
+		l[1] = t.UserId
+	}
+	if t.To != *new(string) {
+		l[2] = t.To
+	}
+	if t.Subject != *new(string) {
+		l[3] = t.Subject
+	}
+	if t.ReplyTo != *new(string) {
+		l[4] = t.ReplyTo
+	}
+	if t.CorrelationId != nil {
+		l[5] = t.CorrelationId
+	}
+	if t.ContentType != *new(Symbol) {
+		l[6] = t.ContentType
+	}
+	if t.ContentEncoding != *new(Symbol) {
+		l[7] = t.ContentEncoding
+	}
+	if t.AbsoluteExpiryTime != *new(time.Time) {
+		l[8] = t.AbsoluteExpiryTime
+	}
+	if t.CreationTime != *new(time.Time) {
+		l[9] = t.CreationTime
+	}
+	if t.GroupId != *new(string) {
+		l[10] = t.GroupId
+	}
+	if t.GroupSequence != *new(uint32) {
+		l[11] = t.GroupSequence
+	}
+	if t.ReplyToGroupId != *new(string) {
+		l[12] = t.ReplyToGroupId
+	}
+	for len(l) > 0 && l[len(l)-1] == nil {
+		l = l[:len(l)-1]
+	}
+	return Described{Descriptor: uint64(0x73), Value: l}, nil
+}
+
+// UnmarshalAMQP decodes t from a list described by 0x73 or amqp:properties:list.
+func (t *Properties) UnmarshalAMQP(v interface{}) error {
+	*t = Properties{}
+	if v == nil {
+		return nil
+	}
+	d, ok := v.(Described)
+	if !ok || !(d.Descriptor == uint64(0x73) || d.Descriptor == Symbol("amqp:properties:list")) {
+		return fmt.Errorf("cannot unmarshal %v as Properties, expected descriptor 0x73", v)
+	}
+	l, ok := d.Value.(List)
+	if !ok && d.Value != nil {
+		return fmt.Errorf("cannot unmarshal %v as Properties, expected a list", d.Value)
+	}
+	for i, x := range l {
+		if x == nil {
+			continue
+		}
+		var err error
+		switch i {
+		case 0:
+			err = Convert(x, &t.MessageId)
+		case 1:
+			err = Convert(x, &t.UserId)
+		case 2:
+			err = Convert(x, &t.To)
+		case 3:
+			err = Convert(x, &t.Subject)
+		case 4:
+			err = Convert(x, &t.ReplyTo)
+		case 5:
+			err = Convert(x, &t.CorrelationId)
+		case 6:
+			err = Convert(x, &t.ContentType)
+		case 7:
+			err = Convert(x, &t.ContentEncoding)
+		case 8:
+			err = Convert(x, &t.AbsoluteExpiryTime)
+		case 9:
+			err = Convert(x, &t.CreationTime)
+		case 10:
+			err = Convert(x, &t.GroupId)
+		case 11:
+			err = Convert(x, &t.GroupSequence)
+		case 12:
+			err = Convert(x, &t.ReplyToGroupId)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal field %d of Properties: %v", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	p := uint8(9)
+	for _, h := range []Header{
+		{},
+		{Durable: true},
+		{Priority: &p, DeliveryCount: 3},
+		{Durable: true, Priority: &p, TTL: 1000, FirstAcquirer: true, DeliveryCount: 1},
+	} {
+		bytes, err := Marshal(h, nil)
+		test.FatalIf(t, err)
+		var h2 Header
+		_, err = Unmarshal(bytes, &h2)
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ(h, h2))
+	}
+}
+
+func TestHeaderEncoding(t *testing.T) {
+	// Trailing empty fields are left out, earlier ones are null.
+	p := uint8(9)
+	bytes, err := Marshal(Header{Priority: &p}, nil)
+	test.FatalIf(t, err)
+	var d Described
+	_, err = Unmarshal(bytes, &d)
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(Described{uint64(0x70), List{nil, uint8(9)}}, d))
+
+	// Symbolic descriptor, missing and extra fields
+	bytes, err = Marshal(Described{Symbol("amqp:header:list"), List{true, nil, uint32(10), nil, nil, "extra"}}, nil)
+	test.FatalIf(t, err)
+	var h Header
+	_, err = Unmarshal(bytes, &h)
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(Header{Durable: true, TTL: 10}, h))
+
+	// A nil pointer marshals as null
+	var hp *Header
+	bytes, err = Marshal(hp, nil)
+	test.FatalIf(t, err)
+	var x interface{} = "not nil"
+	_, err = Unmarshal(bytes, &x)
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(nil, x))
+	_, err = Unmarshal(bytes, &hp)
+	test.FatalIf(t, err)
+	if hp != nil {
+		t.Errorf("expected nil, got %#v", hp)
+	}
+}
+
+func TestHeaderErrors(t *testing.T) {
+	bytes, err := Marshal(Described{uint64(0x73), List{}}, nil)
+	test.FatalIf(t, err)
+	var h Header
+	_, err = Unmarshal(bytes, &h)
+	if _, ok := err.(*UnmarshalError); !ok {
+		t.Errorf("expected UnmarshalError, got %#v", err)
+	}
+	bytes, err = Marshal(Described{uint64(0x70), List{"not a bool"}}, nil)
+	test.FatalIf(t, err)
+	_, err = Unmarshal(bytes, &h)
+	if _, ok := err.(*UnmarshalError); !ok {
+		t.Errorf("expected UnmarshalError, got %#v", err)
+	}
+}
+
+func TestPropertiesRoundTrip(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	for _, p := range []Properties{
+		{},
+		{MessageId: "id", To: "queue", ContentType: "text/plain"},
+		{
+			MessageId:          uint64(42),
+			UserId:             []byte("user"),
+			To:                 "to",
+			Subject:            "subject",
+			ReplyTo:            "reply",
+			CorrelationId:      Binary("correlation"),
+			ContentType:        "application/json",
+			ContentEncoding:    "gzip",
+			AbsoluteExpiryTime: now.Add(time.Hour),
+			CreationTime:       now,
+			GroupId:            "group",
+			GroupSequence:      7,
+			ReplyToGroupId:     "reply-group",
+		},
+	} {
+		bytes, err := Marshal(p, nil)
+		test.FatalIf(t, err)
+		var p2 Properties
+		_, err = Unmarshal(bytes, &p2)
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ(p, p2))
+	}
+}
+
+func TestConvert(t *testing.T) {
+	var n int64
+	test.ErrorIf(t, Convert(int8(-3), &n))
+	test.ErrorIf(t, test.Differ(int64(-3), n))
+	var s *string
+	test.ErrorIf(t, Convert(Symbol("x"), &s))
+	if s == nil || *s != "x" {
+		t.Errorf("expected x, got %v", s)
+	}
+	test.ErrorIf(t, Convert(nil, &s))
+	if s != nil {
+		t.Errorf("expected nil, got %v", *s)
+	}
+	if err := Convert("x", &n); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,597 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SelectorError is returned by EvalSelector if the selector expression cannot be parsed.
+type SelectorError struct {
+	// Expr is the selector expression
+	Expr string
+	// Pos is the byte offset in Expr where the error was detected.
+	Pos int
+	s   string
+}
+
+func (e *SelectorError) Error() string {
+	return fmt.Sprintf("invalid selector %q at %d: %s", e.Expr, e.Pos, e.s)
+}
+
+/*
+EvalSelector evaluates a message selector expression against a set of message
+properties. It is intended for applications that receive unfiltered messages
+and need to filter them locally using the same selector syntax as a broker.
+
+The syntax is a subset of the SQL-92 conditional expression syntax used by JMS
+and AMQP brokers:
+
+  - identifiers name a property in props, a missing property has the value NULL.
+  - literals: 'string' (two single quotes for a literal quote), integer and decimal numbers, TRUE and FALSE
+  - comparison: =, <>, <, >, <=, >=
+  - a [NOT] IN ('x', 'y', ...)
+  - a [NOT] LIKE 'pattern' [ESCAPE 'c'], where % matches any sequence and _ any character
+  - a IS [NOT] NULL
+  - logical: AND, OR, NOT and parentheses
+
+Keywords are case insensitive. Comparisons involving NULL or values of
+incompatible types are "unknown" as in SQL, and a selector that evaluates to
+unknown does not match.
+
+Property values can be any Go numeric type, string, Symbol, Binary or bool.
+
+Returns an error of type *SelectorError if expr is not a valid selector.
+*/
+func EvalSelector(expr string, props map[string]interface{}) (bool, error) {
+	sel, err := parseSelector(expr)
+	if err != nil {
+		return false, err
+	}
+	return sel.eval(props) == true, nil
+}
+
+// Token types
+type selToken int
+
+const (
+	selEnd selToken = iota
+	selIdent
+	selString
+	selNumber
+	selOp     // Comparison operator
+	selLParen // (
+	selRParen // )
+	selComma  // ,
+	selKeyword
+)
+
+var selKeywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "IN": true, "LIKE": true, "ESCAPE": true,
+	"IS": true, "NULL": true, "TRUE": true, "FALSE": true,
+}
+
+type selLexer struct {
+	expr  string
+	pos   int      // Position of next unread byte
+	start int      // Start of current token
+	tok   selToken // Current token type
+	text  string   // Current token text, keywords are upper-cased
+}
+
+func (l *selLexer) fail(format string, arg ...interface{}) {
+	panic(&SelectorError{Expr: l.expr, Pos: l.start, s: fmt.Sprintf(format, arg...)})
+}
+
+// next reads the next token into l.tok, l.text
+func (l *selLexer) next() {
+	for l.pos < len(l.expr) && unicode.IsSpace(rune(l.expr[l.pos])) {
+		l.pos++
+	}
+	l.start = l.pos
+	if l.pos >= len(l.expr) {
+		l.tok, l.text = selEnd, ""
+		return
+	}
+	c := l.expr[l.pos]
+	switch {
+	case c == '(':
+		l.single(selLParen)
+	case c == ')':
+		l.single(selRParen)
+	case c == ',':
+		l.single(selComma)
+	case c == '=':
+		l.single(selOp)
+	case c == '<' || c == '>':
+		l.pos++
+		if l.pos < len(l.expr) && (l.expr[l.pos] == '=' || (c == '<' && l.expr[l.pos] == '>')) {
+			l.pos++
+		}
+		l.tok, l.text = selOp, l.expr[l.start:l.pos]
+	case c == '\'':
+		l.quoted()
+	case isDigit(c) || c == '.' || ((c == '-' || c == '+') && l.pos+1 < len(l.expr) && (isDigit(l.expr[l.pos+1]) || l.expr[l.pos+1] == '.')):
+		l.pos++
+		for l.pos < len(l.expr) && (isDigit(l.expr[l.pos]) || strings.IndexByte(".eE", l.expr[l.pos]) >= 0 ||
+			((l.expr[l.pos] == '-' || l.expr[l.pos] == '+') && strings.IndexByte("eE", l.expr[l.pos-1]) >= 0)) {
+			l.pos++
+		}
+		l.tok, l.text = selNumber, l.expr[l.start:l.pos]
+	default:
+		for l.pos < len(l.expr) && isIdentChar(rune(l.expr[l.pos])) {
+			l.pos++
+		}
+		if l.pos == l.start {
+			l.fail("unexpected character %q", c)
+		}
+		l.text = l.expr[l.start:l.pos]
+		if upper := strings.ToUpper(l.text); selKeywords[upper] {
+			l.tok, l.text = selKeyword, upper
+		} else {
+			l.tok = selIdent
+		}
+	}
+}
+
+func (l *selLexer) single(t selToken) {
+	l.pos++
+	l.tok, l.text = t, l.expr[l.start:l.pos]
+}
+
+// quoted reads a string literal, a doubled single quote is an escaped quote.
+func (l *selLexer) quoted() {
+	var b strings.Builder
+	for l.pos++; l.pos < len(l.expr); l.pos++ {
+		if c := l.expr[l.pos]; c != '\'' {
+			b.WriteByte(c)
+		} else if l.pos+1 < len(l.expr) && l.expr[l.pos+1] == '\'' {
+			b.WriteByte(c)
+			l.pos++
+		} else {
+			l.pos++
+			l.tok, l.text = selString, b.String()
+			return
+		}
+	}
+	l.fail("unterminated string")
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentChar(r rune) bool {
+	return r == '_' || r == '$' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// selExpr is a node in a parsed selector expression.
+//
+// eval returns the value of the node: a bool for conditions, a string, int64 or
+// float64 for values, or nil for NULL/unknown.
+type selExpr interface {
+	eval(props map[string]interface{}) interface{}
+}
+
+type (
+	selLiteral    struct{ v interface{} }
+	selIdentifier struct{ name string }
+	selNot        struct{ x selExpr }
+	selAnd        struct{ x, y selExpr }
+	selOr         struct{ x, y selExpr }
+	selCompare    struct {
+		op   string
+		x, y selExpr
+	}
+	selIn struct {
+		x    selExpr
+		list []interface{}
+		not  bool
+	}
+	selLike struct {
+		x   selExpr
+		re  *regexp.Regexp
+		not bool
+	}
+	selIsNull struct {
+		x   selExpr
+		not bool
+	}
+)
+
+func (e selLiteral) eval(map[string]interface{}) interface{} { return e.v }
+
+func (e selIdentifier) eval(props map[string]interface{}) interface{} {
+	return selValue(props[e.name])
+}
+
+func (e selNot) eval(props map[string]interface{}) interface{} {
+	if b, ok := e.x.eval(props).(bool); ok {
+		return !b
+	}
+	return nil
+}
+
+func (e selAnd) eval(props map[string]interface{}) interface{} {
+	x, xok := e.x.eval(props).(bool)
+	if xok && !x {
+		return false
+	}
+	y, yok := e.y.eval(props).(bool)
+	switch {
+	case yok && !y:
+		return false
+	case xok && yok:
+		return true
+	default:
+		return nil
+	}
+}
+
+func (e selOr) eval(props map[string]interface{}) interface{} {
+	x, xok := e.x.eval(props).(bool)
+	if xok && x {
+		return true
+	}
+	y, yok := e.y.eval(props).(bool)
+	switch {
+	case yok && y:
+		return true
+	case xok && yok:
+		return false
+	default:
+		return nil
+	}
+}
+
+func (e selCompare) eval(props map[string]interface{}) interface{} {
+	c, ok := selCmp(e.x.eval(props), e.y.eval(props))
+	if !ok {
+		return nil
+	}
+	switch e.op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case ">":
+		return c > 0
+	case "<=":
+		return c <= 0
+	default: // ">="
+		return c >= 0
+	}
+}
+
+func (e selIn) eval(props map[string]interface{}) interface{} {
+	x := e.x.eval(props)
+	if x == nil {
+		return nil
+	}
+	for _, v := range e.list {
+		if c, ok := selCmp(x, v); ok && c == 0 {
+			return !e.not
+		}
+	}
+	return e.not
+}
+
+func (e selLike) eval(props map[string]interface{}) interface{} {
+	if s, ok := e.x.eval(props).(string); ok {
+		return e.re.MatchString(s) != e.not
+	}
+	return nil
+}
+
+func (e selIsNull) eval(props map[string]interface{}) interface{} {
+	return (e.x.eval(props) == nil) != e.not
+}
+
+// selValue converts a property value to a selector value: bool, string,
+// int64, float64 or nil if the value has a type that can't be used in a selector.
+func selValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case bool, string, int64, float64:
+		return v
+	case Symbol:
+		return string(v)
+	case Binary:
+		return string(v)
+	case Char:
+		return string(v)
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return selUint(uint64(v))
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return selUint(v)
+	case float32:
+		return float64(v)
+	default:
+		return nil
+	}
+}
+
+func selUint(u uint64) interface{} {
+	if u > math.MaxInt64 {
+		return float64(u)
+	}
+	return int64(u)
+}
+
+// selCmp compares two selector values, returns ok == false if they can't be compared.
+func selCmp(x, y interface{}) (c int, ok bool) {
+	switch x := x.(type) {
+	case string:
+		if y, ok := y.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := y.(bool); ok {
+			if x == y {
+				return 0, true
+			}
+			return 1, true // Only = and <> are meaningful for bool
+		}
+	case int64:
+		switch y := y.(type) {
+		case int64:
+			return cmpInt64(x, y), true
+		case float64:
+			return cmpFloat64(float64(x), y), true
+		}
+	case float64:
+		switch y := y.(type) {
+		case int64:
+			return cmpFloat64(x, float64(y)), true
+		case float64:
+			return cmpFloat64(x, y), true
+		}
+	}
+	return 0, false
+}
+
+func cmpInt64(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func cmpFloat64(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Recursive descent parser for selectors.
+//
+//	or         := and { OR and }
+//	and        := not { AND not }
+//	not        := NOT not | predicate
+//	predicate  := operand [ op operand | [NOT] IN list | [NOT] LIKE string [ESCAPE string] | IS [NOT] NULL ]
+//	operand    := identifier | literal | ( or )
+type selParser struct{ selLexer }
+
+func parseSelector(expr string) (sel selExpr, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if serr, ok := r.(*SelectorError); ok {
+				err = serr
+			} else {
+				panic(r)
+			}
+		}
+	}()
+	p := &selParser{selLexer{expr: expr}}
+	p.next()
+	sel = p.or()
+	if p.tok != selEnd {
+		p.fail("unexpected %q", p.text)
+	}
+	return sel, nil
+}
+
+func (p *selParser) isKeyword(kw string) bool { return p.tok == selKeyword && p.text == kw }
+
+func (p *selParser) expect(t selToken, what string) string {
+	if p.tok != t {
+		p.fail("expected %s", what)
+	}
+	text := p.text
+	p.next()
+	return text
+}
+
+func (p *selParser) or() selExpr {
+	x := p.and()
+	for p.isKeyword("OR") {
+		p.next()
+		x = selOr{x, p.and()}
+	}
+	return x
+}
+
+func (p *selParser) and() selExpr {
+	x := p.not()
+	for p.isKeyword("AND") {
+		p.next()
+		x = selAnd{x, p.not()}
+	}
+	return x
+}
+
+func (p *selParser) not() selExpr {
+	if p.isKeyword("NOT") {
+		p.next()
+		return selNot{p.not()}
+	}
+	return p.predicate()
+}
+
+func (p *selParser) predicate() selExpr {
+	x := p.operand()
+	switch {
+	case p.tok == selOp:
+		op := p.text
+		p.next()
+		return selCompare{op, x, p.operand()}
+	case p.isKeyword("IS"):
+		p.next()
+		not := p.isKeyword("NOT")
+		if not {
+			p.next()
+		}
+		if !p.isKeyword("NULL") {
+			p.fail("expected NULL")
+		}
+		p.next()
+		return selIsNull{x, not}
+	case p.isKeyword("NOT"):
+		p.next()
+		if !p.isKeyword("IN") && !p.isKeyword("LIKE") {
+			p.fail("expected IN or LIKE after NOT")
+		}
+		return p.inOrLike(x, true)
+	case p.isKeyword("IN"), p.isKeyword("LIKE"):
+		return p.inOrLike(x, false)
+	}
+	return x
+}
+
+func (p *selParser) inOrLike(x selExpr, not bool) selExpr {
+	if p.isKeyword("IN") {
+		p.next()
+		p.expect(selLParen, "(")
+		in := selIn{x: x, not: not}
+		for {
+			in.list = append(in.list, p.literal())
+			if p.tok != selComma {
+				break
+			}
+			p.next()
+		}
+		p.expect(selRParen, ")")
+		return in
+	}
+	p.next() // LIKE
+	pattern := p.expect(selString, "string pattern after LIKE")
+	escape := ""
+	if p.isKeyword("ESCAPE") {
+		p.next()
+		if escape = p.expect(selString, "string after ESCAPE"); len([]rune(escape)) != 1 {
+			p.fail("ESCAPE must be a single character")
+		}
+	}
+	return selLike{x, likeRegexp(pattern, escape), not}
+}
+
+// likeRegexp converts a LIKE pattern to an anchored regular expression.
+func likeRegexp(pattern, escape string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case escape != "" && string(r) == escape:
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func (p *selParser) operand() selExpr {
+	switch {
+	case p.tok == selIdent:
+		name := p.text
+		p.next()
+		return selIdentifier{name}
+	case p.tok == selLParen:
+		p.next()
+		x := p.or()
+		p.expect(selRParen, ")")
+		return x
+	default:
+		return selLiteral{p.literal()}
+	}
+}
+
+func (p *selParser) literal() (v interface{}) {
+	switch {
+	case p.tok == selString:
+		v = p.text
+	case p.tok == selNumber:
+		if i, err := strconv.ParseInt(p.text, 10, 64); err == nil {
+			v = i
+		} else if f, err := strconv.ParseFloat(p.text, 64); err == nil {
+			v = f
+		} else {
+			p.fail("invalid number %q", p.text)
+		}
+	case p.isKeyword("TRUE"):
+		v = true
+	case p.isKeyword("FALSE"):
+		v = false
+	case p.isKeyword("NULL"):
+		v = nil
+	case p.tok == selEnd:
+		p.fail("unexpected end of selector")
+	default:
+		p.fail("unexpected %q", p.text)
+	}
+	p.next()
+	return v
+}
@@ -0,0 +1,103 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+import (
+	"testing"
+)
+
+func TestEvalSelector(t *testing.T) {
+	props := map[string]interface{}{
+		"color":  "red",
+		"size":   int32(10),
+		"weight": 2.5,
+		"count":  uint64(3),
+		"sym":    Symbol("abc"),
+		"ok":     true,
+		"name":   "50%_off",
+	}
+	for _, x := range []struct {
+		expr string
+		want bool
+	}{
+		{"color = 'red'", true},
+		{"color <> 'red'", false},
+		{"COLOR = 'red'", false}, // Identifiers are case sensitive
+		{"color = 'red' AND size > 5", true},
+		{"color = 'blue' OR size >= 10", true},
+		{"NOT color = 'red'", false},
+		{"size < 10.5 and weight <= 2.5", true},
+		{"count = 3", true},
+		{"weight > 2", true},
+		{"sym = 'abc'", true},
+		{"ok = TRUE", true},
+		{"ok", true},
+		{"size = -10", false},
+		{"color IN ('blue', 'red')", true},
+		{"color NOT IN ('blue', 'red')", false},
+		{"size IN (1, 10)", true},
+		{"color LIKE 'r%'", true},
+		{"color LIKE 'r_d'", true},
+		{"color LIKE 'r_'", false},
+		{"color NOT LIKE '%e%'", false},
+		{`name LIKE '50\%\_off' ESCAPE '\'`, true},
+		{`name LIKE '50\%x' ESCAPE '\'`, false},
+		{"missing IS NULL", true},
+		{"color IS NOT NULL", true},
+		{"color IS NULL", false},
+		// Unknown: comparisons with NULL or mismatched types never match
+		{"missing = 1", false},
+		{"NOT missing = 1", false},
+		{"missing = 1 OR color = 'red'", true},
+		{"missing = 1 AND color = 'red'", false},
+		{"color = 1", false},
+		{"NOT (color = 1)", false},
+		{"(color = 'red' OR size = 1) AND (weight = 2.5)", true},
+		{"color = 'it''s'", false},
+	} {
+		got, err := EvalSelector(x.expr, props)
+		if err != nil {
+			t.Errorf("%q: %v", x.expr, err)
+		} else if got != x.want {
+			t.Errorf("%q: want %v got %v", x.expr, x.want, got)
+		}
+	}
+}
+
+func TestEvalSelectorErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"color =",
+		"color = 'red",
+		"(color = 'red'",
+		"color IN 'red'",
+		"color LIKE 5",
+		"color IS 5",
+		"color = 'red' size",
+		"color # 1",
+		"x LIKE 'a' ESCAPE 'ab'",
+	} {
+		if _, err := EvalSelector(expr, nil); err == nil {
+			t.Errorf("%q: expected error", expr)
+		} else if _, ok := err.(*SelectorError); !ok {
+			t.Errorf("%q: expected *SelectorError, got %T", expr, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+import "fmt"
+
+// SenderSettleMode is the sender-settle-mode of a link, sent in the attach
+// performative: when the sender settles deliveries.
+type SenderSettleMode uint8
+
+const (
+	// The sender sends all deliveries unsettled.
+	SenderSettleModeUnsettled SenderSettleMode = 0
+	// The sender sends all deliveries settled.
+	SenderSettleModeSettled SenderSettleMode = 1
+	// The sender may send a mixture of settled and unsettled deliveries. This
+	// is the default.
+	SenderSettleModeMixed SenderSettleMode = 2
+)
+
+func (m SenderSettleMode) String() string {
+	switch m {
+	case SenderSettleModeUnsettled:
+		return "unsettled"
+	case SenderSettleModeSettled:
+		return "settled"
+	case SenderSettleModeMixed:
+		return "mixed"
+	default:
+		return fmt.Sprintf("invalid(%d)", uint8(m))
+	}
+}
+
+// ReceiverSettleMode is the receiver-settle-mode of a link, sent in the attach
+// performative: when the receiver settles deliveries.
+type ReceiverSettleMode uint8
+
+const (
+	// The receiver settles spontaneously, without waiting for the sender. This
+	// is the default.
+	ReceiverSettleModeFirst ReceiverSettleMode = 0
+	// The receiver settles only after the sender has settled.
+	ReceiverSettleModeSecond ReceiverSettleMode = 1
+)
+
+func (m ReceiverSettleMode) String() string {
+	switch m {
+	case ReceiverSettleModeFirst:
+		return "first"
+	case ReceiverSettleModeSecond:
+		return "second"
+	default:
+		return fmt.Sprintf("invalid(%d)", uint8(m))
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+import "fmt"
+
+// TerminusDurability is the terminus-durability of a link source or target:
+// what state of the terminus is retained durably by the node.
+type TerminusDurability uint32
+
+const (
+	// No terminus state is retained durably.
+	DurabilityNone TerminusDurability = 0
+	// Only the existence and configuration of the terminus is retained durably.
+	DurabilityConfiguration TerminusDurability = 1
+	// In addition to configuration, unsettled state is retained durably.
+	DurabilityUnsettledState TerminusDurability = 2
+)
+
+func (d TerminusDurability) String() string {
+	switch d {
+	case DurabilityNone:
+		return "none"
+	case DurabilityConfiguration:
+		return "configuration"
+	case DurabilityUnsettledState:
+		return "unsettled-state"
+	default:
+		return fmt.Sprintf("invalid(%d)", uint32(d))
+	}
+}
+
+// TerminusExpiryPolicy is the terminus-expiry-policy of a link source or
+// target: the event that starts the terminus expiry timer.
+type TerminusExpiryPolicy Symbol
+
+const (
+	// The expiry timer starts when the terminus is detached.
+	ExpiryPolicyLinkDetach TerminusExpiryPolicy = "link-detach"
+	// The expiry timer starts when the session ends.
+	ExpiryPolicySessionEnd TerminusExpiryPolicy = "session-end"
+	// The expiry timer starts when the connection closes.
+	ExpiryPolicyConnectionClose TerminusExpiryPolicy = "connection-close"
+	// The terminus never expires.
+	ExpiryPolicyNever TerminusExpiryPolicy = "never"
+)
+
+func (e TerminusExpiryPolicy) String() string { return string(e) }
@@ -0,0 +1,186 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package testing provides TestBroker, an in-memory AMQP 1.0 broker for
+// writing integration tests without an external broker.
+package testing
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/electron"
+)
+
+// queueSize is the number of messages a queue can hold before senders block.
+const queueSize = 1000
+
+// credit is the credit window for messages sent to the broker.
+const credit = 100
+
+// TestBroker is a minimal AMQP 1.0 broker that listens on a local TCP port.
+//
+// Queues are created automatically by sender or receiver addresses. Messages
+// are delivered at-least-once: a message that is not accepted by the
+// receiving client is put back on its queue. Transactions and management are
+// not supported.
+type TestBroker struct {
+	listener  net.Listener
+	container electron.Container
+	done      chan struct{}
+
+	lock        sync.Mutex
+	queues      map[string]queue
+	connections map[electron.Connection]bool
+}
+
+// NewTestBroker starts a broker listening on a local port, see Addr().
+// It panics if it cannot listen.
+func NewTestBroker() *TestBroker {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Errorf("TestBroker cannot listen: %v", err))
+	}
+	b := &TestBroker{
+		listener:    l,
+		container:   electron.NewContainer(fmt.Sprintf("test-broker[%v]", l.Addr())),
+		done:        make(chan struct{}),
+		queues:      make(map[string]queue),
+		connections: make(map[electron.Connection]bool),
+	}
+	go b.run()
+	return b
+}
+
+// Addr is the "host:port" address of the broker, for electron.Dial("tcp", b.Addr())
+func (b *TestBroker) Addr() string { return b.listener.Addr().String() }
+
+// Close stops listening and closes all connections. Queued messages are discarded.
+func (b *TestBroker) Close() {
+	_ = b.listener.Close()
+	<-b.done
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for c := range b.connections {
+		c.Close(nil)
+	}
+}
+
+func (b *TestBroker) run() {
+	defer close(b.done)
+	for {
+		c, err := b.container.Accept(b.listener)
+		if err != nil {
+			return
+		}
+		b.lock.Lock()
+		b.connections[c] = true
+		b.lock.Unlock()
+		go b.connection(c)
+	}
+}
+
+// Get a queue by name, create it if not found.
+func (b *TestBroker) queue(name string) queue {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	q := b.queues[name]
+	if q == nil {
+		q = make(queue, queueSize)
+		b.queues[name] = q
+	}
+	return q
+}
+
+// accept remotely-opened endpoints on a connection and start goroutines to service them.
+func (b *TestBroker) connection(c electron.Connection) {
+	for in := range c.Incoming() {
+		switch in := in.(type) {
+		case *electron.IncomingSender:
+			go b.sender(in.Accept().(electron.Sender))
+		case *electron.IncomingReceiver:
+			in.SetPrefetch(true)
+			in.SetCapacity(credit)
+			go b.receiver(in.Accept().(electron.Receiver))
+		default:
+			in.Accept()
+		}
+	}
+	b.lock.Lock()
+	delete(b.connections, c)
+	b.lock.Unlock()
+}
+
+// receiver puts received messages on a queue, then accepts them.
+func (b *TestBroker) receiver(r electron.Receiver) {
+	q := b.queue(r.Target())
+	for {
+		rm, err := r.Receive()
+		if err != nil {
+			return
+		}
+		q <- rm.Message
+		_ = rm.Accept()
+	}
+}
+
+// sender sends messages from a queue, messages that are not accepted are put back.
+func (b *TestBroker) sender(s electron.Sender) {
+	q := b.queue(s.Source())
+	acks := make(chan electron.Outcome, credit)
+	total := make(chan int, 1)
+	go func() { // Handle outcomes till all sent messages are accounted for.
+		n, want := 0, -1
+		for n != want {
+			select {
+			case o := <-acks:
+				n++
+				if o.Status != electron.Accepted {
+					q.putBack(o.Value.(amqp.Message))
+				}
+			case want = <-total:
+			}
+		}
+	}()
+	n := 0
+	defer func() { total <- n }()
+	for {
+		select {
+		case m := <-q:
+			s.SendAsync(m, acks, m)
+			n++
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+// Use a buffered channel as a simple queue.
+type queue chan amqp.Message
+
+// Put a message back on the queue, does not block.
+func (q queue) putBack(m amqp.Message) {
+	select {
+	case q <- m:
+	default:
+		go func() { q <- m }()
+	}
+}
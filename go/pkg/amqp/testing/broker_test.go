@@ -0,0 +1,79 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/electron"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+func TestBrokerRoundTrip(t *testing.T) {
+	b := NewTestBroker()
+	defer b.Close()
+	c, err := electron.Dial("tcp", b.Addr())
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+
+	s, err := c.Sender(electron.Target("q"))
+	test.FatalIf(t, err)
+	const n = 10
+	for i := 0; i < n; i++ {
+		out := s.SendSync(amqp.NewMessageWith(fmt.Sprintf("m%v", i)))
+		test.FatalIf(t, out.Error)
+		test.ErrorIf(t, test.Differ(electron.Accepted, out.Status))
+	}
+
+	// Receiver without prefetch issues credit one message at a time.
+	r, err := c.Receiver(electron.Source("q"))
+	test.FatalIf(t, err)
+	for i := 0; i < n; i++ {
+		rm, err := r.Receive()
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ(fmt.Sprintf("m%v", i), rm.Message.Body()))
+		test.ErrorIf(t, rm.Accept())
+	}
+}
+
+func TestBrokerRedeliver(t *testing.T) {
+	b := NewTestBroker()
+	defer b.Close()
+	c, err := electron.Dial("tcp", b.Addr())
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+
+	s, err := c.Sender(electron.Target("q"))
+	test.FatalIf(t, err)
+	test.FatalIf(t, s.SendSync(amqp.NewMessageWith("hello")).Error)
+
+	// A released message is put back on the queue and delivered again.
+	r, err := c.Receiver(electron.Source("q"), electron.Prefetch(true), electron.Capacity(10))
+	test.FatalIf(t, err)
+	rm, err := r.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, rm.Release())
+	rm, err = r.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ("hello", rm.Message.Body()))
+	test.ErrorIf(t, rm.Accept())
+}
@@ -24,7 +24,13 @@ import "C"
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 	"unsafe"
 )
@@ -121,16 +127,55 @@ type Array []interface{}
 // Symbol is a string that is encoded as an AMQP symbol
 type Symbol string
 
-func (s Symbol) String() string   { return string(s) }
-func (s Symbol) GoString() string { return fmt.Sprintf("s\"%s\"", s) }
+// String returns s as a plain string, so a Symbol is a fmt.Stringer and
+// formats as text with %v and %s.
+func (s Symbol) String() string { return string(s) }
+
+// GoString returns s as a Go expression, for example amqp.Symbol("x"), for %#v.
+func (s Symbol) GoString() string { return "amqp.Symbol(" + strconv.Quote(string(s)) + ")" }
 
 // Binary is a string that is encoded as an AMQP binary.
 // It is a string rather than a byte[] because byte[] is not hashable and can't be used as
 // a map key, AMQP frequently uses binary types as map keys. It can convert to and from []byte
 type Binary string
 
-func (b Binary) String() string   { return string(b) }
-func (b Binary) GoString() string { return fmt.Sprintf("b\"%s\"", b) }
+// maxBinaryString is the longest Binary, in bytes, that String() shows in full.
+const maxBinaryString = 32
+
+// String returns b in hexadecimal, or "<N bytes>" if it is longer than 32
+// bytes, so binary values are readable in logs. Use string(b) for the bytes.
+func (b Binary) String() string {
+	if len(b) > maxBinaryString {
+		return fmt.Sprintf("<%d bytes>", len(b))
+	}
+	return b.Hex()
+}
+
+func (b Binary) GoString() string { return fmt.Sprintf("b\"%s\"", string(b)) }
+
+// Hex returns b encoded in hexadecimal.
+func (b Binary) Hex() string { return hex.EncodeToString([]byte(b)) }
+
+// Base64 returns b encoded in standard base64.
+func (b Binary) Base64() string { return base64.StdEncoding.EncodeToString([]byte(b)) }
+
+// BinaryFromHex decodes a hexadecimal string, see Binary.Hex().
+func BinaryFromHex(s string) (Binary, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return Binary(b), nil
+}
+
+// BinaryFromBase64 decodes a standard base64 string, see Binary.Base64().
+func BinaryFromBase64(s string) (Binary, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return Binary(b), nil
+}
 
 // GoString for Map prints values with their types, useful for debugging.
 func (m Map) GoString() string {
@@ -148,6 +193,140 @@ func (m Map) GoString() string {
 	return out.String()
 }
 
+// MergePolicy says how Map.Merge resolves a key that is in both maps with
+// different values.
+type MergePolicy int
+
+const (
+	// MergePolicyOverwrite uses the value from the other map.
+	MergePolicyOverwrite MergePolicy = iota
+	// MergePolicyKeepFirst keeps the value from the original map.
+	MergePolicyKeepFirst
+	// MergePolicyError fails the merge with an error listing the conflicting keys.
+	MergePolicyError
+)
+
+// Merge returns a new Map with the entries of m and other, m is not modified.
+// A key that is in both maps with different values is resolved by policy, a
+// key with equal values is not a conflict. Merge returns an error only for
+// MergePolicyError, or an unknown policy.
+func (m Map) Merge(other Map, policy MergePolicy) (Map, error) {
+	if policy < MergePolicyOverwrite || policy > MergePolicyError {
+		return nil, fmt.Errorf("unknown merge policy %d", int(policy))
+	}
+	merged := make(Map, len(m)+len(other))
+	for k, v := range m {
+		merged[k] = v
+	}
+	var conflicts []string
+	for k, v := range other {
+		if old, ok := merged[k]; ok && !reflect.DeepEqual(old, v) {
+			switch policy {
+			case MergePolicyKeepFirst:
+				continue
+			case MergePolicyError:
+				conflicts = append(conflicts, fmt.Sprintf("%#v", k))
+				continue
+			}
+		}
+		merged[k] = v
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("merge conflict for keys: %s", strings.Join(conflicts, ", "))
+	}
+	return merged, nil
+}
+
+// Clone returns a shallow copy of m: a new map with the same keys and values.
+// Returns nil if m is nil.
+func (m Map) Clone() Map {
+	if m == nil {
+		return nil
+	}
+	out := make(Map, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// DeepClone returns a copy of m that shares no mutable values with m: nested
+// Map, List, Array, AnyMap, Described, Annotations, map[string]interface{} and
+// []byte values are copied recursively. Binary, Symbol and other string types
+// are immutable so they are shared. Returns nil if m is nil.
+func (m Map) DeepClone() Map { return deepCopy(m).(Map) }
+
+// deepCopy returns a copy of v with mutable AMQP container values copied
+// recursively, other values are returned unchanged.
+func deepCopy(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Map:
+		if v == nil {
+			return v
+		}
+		out := make(Map, len(v))
+		for k, x := range v {
+			out[k] = deepCopy(x)
+		}
+		return out
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			out[k] = deepCopy(x)
+		}
+		return out
+	case Annotations:
+		if v == nil {
+			return v
+		}
+		out := make(Annotations, len(v))
+		for k, x := range v {
+			out[k] = deepCopy(x)
+		}
+		return out
+	case List:
+		if v == nil {
+			return v
+		}
+		out := make(List, len(v))
+		for i, x := range v {
+			out[i] = deepCopy(x)
+		}
+		return out
+	case Array:
+		if v == nil {
+			return v
+		}
+		out := make(Array, len(v))
+		for i, x := range v {
+			out[i] = deepCopy(x)
+		}
+		return out
+	case AnyMap:
+		if v == nil {
+			return v
+		}
+		out := make(AnyMap, len(v))
+		for i, kv := range v {
+			out[i] = KeyValue{deepCopy(kv.Key), deepCopy(kv.Value)}
+		}
+		return out
+	case Described:
+		return Described{deepCopy(v.Descriptor), deepCopy(v.Value)}
+	case []byte:
+		if v == nil {
+			return v
+		}
+		return append([]byte{}, v...)
+	default:
+		return v
+	}
+}
+
 // GoString for List prints values with their types, useful for debugging.
 func (l List) GoString() string {
 	out := &bytes.Buffer{}
@@ -162,6 +341,37 @@ func (l List) GoString() string {
 	return out.String()
 }
 
+// Filter returns a new List with the elements of l for which pred returns
+// true, in the same order. Nil elements are passed to pred like any other.
+func (l List) Filter(pred func(interface{}) bool) List {
+	out := make(List, 0, len(l))
+	for _, v := range l {
+		if pred(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Map returns a new List with f applied to each element of l.
+func (l List) Map(f func(interface{}) interface{}) List {
+	out := make(List, len(l))
+	for i, v := range l {
+		out[i] = f(v)
+	}
+	return out
+}
+
+// Reduce combines the elements of l in order, starting with init, by calling
+// acc = f(acc, v) for each element v. Returns init if l is empty.
+func (l List) Reduce(init interface{}, f func(acc, val interface{}) interface{}) interface{} {
+	acc := init
+	for _, v := range l {
+		acc = f(acc, v)
+	}
+	return acc
+}
+
 // pnTime converts Go time.Time to Proton millisecond Unix time.
 // Take care to convert zero values to zero values.
 func pnTime(t time.Time) C.pn_timestamp_t {
@@ -232,11 +442,90 @@ func AnnotationKeySymbol(v Symbol) AnnotationKey { return AnnotationKey{v} }
 func AnnotationKeyUint64(v uint64) AnnotationKey { return AnnotationKey{v} }
 func AnnotationKeyString(v string) AnnotationKey { return AnnotationKey{Symbol(v)} }
 
+// SymbolAnnotationKey returns a symbol annotation key, same as AnnotationKeySymbol.
+func SymbolAnnotationKey(s Symbol) AnnotationKey { return AnnotationKey{s} }
+
+// UlongAnnotationKey returns a ulong annotation key, same as AnnotationKeyUint64.
+func UlongAnnotationKey(v uint64) AnnotationKey { return AnnotationKey{v} }
+
 // Returns the value which must be Symbol, uint64 or nil
 func (k AnnotationKey) Get() interface{} { return k.value }
 
+// Validate returns an error unless the key is a Symbol or uint64, the only key
+// types allowed in an annotation map. A zero AnnotationKey is not valid.
+func (k AnnotationKey) Validate() error {
+	switch k.value.(type) {
+	case Symbol, uint64:
+		return nil
+	default:
+		return fmt.Errorf("invalid annotation key %T, must be symbol or ulong", k.value)
+	}
+}
+
 func (k AnnotationKey) String() string { return fmt.Sprintf("%v", k.Get()) }
 
+// Annotations is an AMQP annotation map, used for message annotations and
+// delivery annotations. Keys are symbols or ulongs, see AnnotationKey.
+//
+// A plain map[AnnotationKey]interface{} can be used anywhere Annotations is expected.
+type Annotations map[AnnotationKey]interface{}
+
+// Clone returns a shallow copy of a. Values are not copied.
+// Returns nil if a is nil.
+func (a Annotations) Clone() Annotations {
+	if a == nil {
+		return nil
+	}
+	out := make(Annotations, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	return out
+}
+
+// Merge adds the entries of other to a. If overwrite is true, values from
+// other replace values in a that have the same key, otherwise existing values
+// in a are kept.
+//
+// Like append(), Merge returns the updated map. If a is nil a new map is
+// allocated, otherwise a is modified in place.
+func (a Annotations) Merge(other Annotations, overwrite bool) Annotations {
+	if a == nil && len(other) > 0 {
+		a = make(Annotations, len(other))
+	}
+	for k, v := range other {
+		if _, exists := a[k]; overwrite || !exists {
+			a[k] = v
+		}
+	}
+	return a
+}
+
+// FilterPrefix returns a new Annotations containing only the entries of a with a
+// symbol key that starts with prefix, e.g. FilterPrefix("x-opt-")
+func (a Annotations) FilterPrefix(prefix string) Annotations {
+	out := make(Annotations)
+	for k, v := range a {
+		if s, ok := k.Get().(Symbol); ok && strings.HasPrefix(string(s), prefix) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Without returns a new Annotations containing the entries of a except those
+// with the given keys.
+func (a Annotations) Without(keys ...AnnotationKey) Annotations {
+	out := a.Clone()
+	if out == nil {
+		out = make(Annotations)
+	}
+	for _, k := range keys {
+		delete(out, k)
+	}
+	return out
+}
+
 // Described represents an AMQP described type, which is really
 // just a pair of AMQP values - the first is treated as a "descriptor",
 // and is normally a string or ulong providing information about the type.
@@ -246,6 +535,17 @@ type Described struct {
 	Value      interface{}
 }
 
+// AMQPAbsent is the value of an interface{} unmarshalled from data with no
+// value at all, for example an optional field or section that was omitted,
+// as opposed to nil for a value that was present and set to null.
+type AMQPAbsent struct{}
+
+// IsAbsent is true if v is AMQPAbsent: the value was not present, not even as null.
+func IsAbsent(v interface{}) bool {
+	_, ok := v.(AMQPAbsent)
+	return ok
+}
+
 // UUID is an AMQP 128-bit Universally Unique Identifier, as defined by RFC-4122 section 4.1.2
 type UUID [16]byte
 
@@ -253,6 +553,48 @@ func (u UUID) String() string {
 	return fmt.Sprintf("UUID(%x-%x-%x-%x-%x)", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
 }
 
+// MessageID is an AMQP message-id or correlation-id, which must be a string,
+// ulong, uuid or binary value.
+//
+// The zero MessageID has no value and marshals as null.
+type MessageID struct {
+	v interface{}
+}
+
+func MessageIDString(s string) MessageID { return MessageID{s} }
+func MessageIDUlong(v uint64) MessageID  { return MessageID{v} }
+func MessageIDUUID(u UUID) MessageID     { return MessageID{u} }
+func MessageIDBinary(b Binary) MessageID { return MessageID{b} }
+
+// ParseMessageID returns a MessageID for v, which must be a string, uint64,
+// UUID or Binary. A nil or absent v returns the zero MessageID.
+func ParseMessageID(v interface{}) (MessageID, error) {
+	switch v := v.(type) {
+	case string, uint64, UUID, Binary:
+		return MessageID{v}, nil
+	case MessageID:
+		return v, nil
+	case nil, AMQPAbsent:
+		return MessageID{}, nil
+	default:
+		return MessageID{}, fmt.Errorf("invalid message-id %T, must be string, ulong, uuid or binary", v)
+	}
+}
+
+// Returns the value which must be string, uint64, UUID, Binary or nil
+func (id MessageID) Get() interface{} { return id.v }
+
+// MarshalAMQP encodes the value of id directly, not as a described type.
+func (id MessageID) MarshalAMQP() (interface{}, error) { return id.v, nil }
+
+// UnmarshalAMQP sets id from a string, ulong, uuid or binary value.
+func (id *MessageID) UnmarshalAMQP(v interface{}) (err error) {
+	*id, err = ParseMessageID(v)
+	return err
+}
+
+func (id MessageID) String() string { return fmt.Sprintf("%v", id.v) }
+
 // Char is an AMQP unicode character, equivalent to a Go rune.
 // It is defined as a distinct type so it can be distinguished from an AMQP int
 type Char rune
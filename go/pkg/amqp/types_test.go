@@ -22,6 +22,8 @@ package amqp
 import (
 	"fmt"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -93,7 +95,7 @@ var vstrings = []string{
 	"-8", "-16", "-32", "-64",
 	"8", "16", "32", "64",
 	"0.32", "0.64",
-	"string", "Binary", "symbol",
+	"string", "42696e617279", "symbol",
 	"<nil>",
 	"{D V}",
 	fmt.Sprintf("%v", timeValue),
@@ -161,6 +163,38 @@ func TestTypesPrint(t *testing.T) {
 	}
 }
 
+func TestSymbolFormat(t *testing.T) {
+	s := Symbol("amqp:accepted:list")
+	var _ fmt.Stringer = s
+	test.ErrorIf(t, test.Differ("amqp:accepted:list", fmt.Sprintf("%v", s)))
+	test.ErrorIf(t, test.Differ("amqp:accepted:list", fmt.Sprintf("%s", s)))
+	test.ErrorIf(t, test.Differ(`amqp.Symbol("amqp:accepted:list")`, fmt.Sprintf("%#v", s)))
+	test.ErrorIf(t, test.Differ(`amqp.Symbol("a\"b")`, fmt.Sprintf("%#v", Symbol(`a"b`))))
+	test.ErrorIf(t, test.Differ("[x y]", fmt.Sprintf("%v", []Symbol{"x", "y"})))
+}
+
+func TestBinaryEncodings(t *testing.T) {
+	for _, b := range []Binary{"", "AB", "\x00\xff binary", Binary(strings.Repeat("x", 100))} {
+		got, err := BinaryFromHex(b.Hex())
+		test.ErrorIf(t, err)
+		test.ErrorIf(t, test.Differ(b, got))
+		got, err = BinaryFromBase64(b.Base64())
+		test.ErrorIf(t, err)
+		test.ErrorIf(t, test.Differ(b, got))
+	}
+	test.ErrorIf(t, test.Differ("4142", Binary("AB").Hex()))
+	test.ErrorIf(t, test.Differ("QUI=", Binary("AB").Base64()))
+	test.ErrorIf(t, test.Differ("4142", Binary("AB").String()))
+	test.ErrorIf(t, test.Differ("<33 bytes>", fmt.Sprint(Binary(strings.Repeat("x", 33)))))
+	test.ErrorIf(t, test.Differ(`b"AB"`, fmt.Sprintf("%#v", Binary("AB"))))
+	if _, err := BinaryFromHex("xyz"); err == nil {
+		t.Error("expected hex error")
+	}
+	if _, err := BinaryFromBase64("!"); err == nil {
+		t.Error("expected base64 error")
+	}
+}
+
 func TestDescribed(t *testing.T) {
 	want := Described{"D", "V"}
 	marshaled, _ := Marshal(want, nil)
@@ -196,6 +230,207 @@ func TestDescribed(t *testing.T) {
 	}
 }
 
+func TestDescribedArray(t *testing.T) {
+	want := []Described{{Symbol("D"), "a"}, {Symbol("D"), "b"}}
+	marshaled, err := Marshal(want, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marshaled[0] != 0xe0 && marshaled[0] != 0xf0 { // array8 or array32
+		t.Errorf("want array encoding, got % x", marshaled)
+	}
+
+	var ds []Described
+	if err := checkUnmarshal(marshaled, &ds); err != nil {
+		t.Error(err)
+	}
+	if err := test.Differ(want, ds); err != nil {
+		t.Error(err)
+	}
+
+	var i interface{}
+	if err := checkUnmarshal(marshaled, &i); err != nil {
+		t.Error(err)
+	}
+	if err := test.Differ(want, i); err != nil {
+		t.Error(err)
+	}
+
+	// Unmarshal values only (drop descriptor)
+	var ss []string
+	if err := checkUnmarshal(marshaled, &ss); err != nil {
+		t.Error(err)
+	}
+	if err := test.Differ([]string{"a", "b"}, ss); err != nil {
+		t.Error(err)
+	}
+
+	// Mixed descriptors or value types can't be an array, use a list.
+	for _, mixed := range [][]Described{
+		{{Symbol("D"), "a"}, {Symbol("E"), "b"}},
+		{{Symbol("D"), "a"}, {Symbol("D"), int32(1)}},
+		{{Symbol("D"), List{"a"}}},
+		{},
+	} {
+		marshaled, err := Marshal(mixed, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var l interface{}
+		if err := checkUnmarshal(marshaled, &l); err != nil {
+			t.Error(err)
+		}
+		if _, ok := l.(List); !ok {
+			t.Errorf("%v: want List, got %T(%v)", mixed, l, l)
+		}
+		var ds []Described
+		if err := checkUnmarshal(marshaled, &ds); err != nil {
+			t.Error(err)
+		}
+		if err := test.Differ(mixed, ds); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMapMerge(t *testing.T) {
+	m := Map{"a": 1, Symbol("b"): "x", "c": List{1}}
+	other := Map{Symbol("b"): "y", "c": List{1}, "d": nil}
+
+	got, err := m.Merge(other, MergePolicyOverwrite)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ(Map{"a": 1, Symbol("b"): "y", "c": List{1}, "d": nil}, got))
+
+	got, err = m.Merge(other, MergePolicyKeepFirst)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ(Map{"a": 1, Symbol("b"): "x", "c": List{1}, "d": nil}, got))
+
+	// Equal values are not a conflict
+	got, err = m.Merge(other, MergePolicyError)
+	if err == nil || !strings.Contains(err.Error(), `amqp.Symbol("b")`) || strings.Contains(err.Error(), `"c"`) {
+		t.Errorf("want conflict for b only, got %v", err)
+	}
+	test.ErrorIf(t, test.Differ(Map(nil), got))
+
+	// Non-overlapping maps merge the same way for every policy
+	for _, policy := range []MergePolicy{MergePolicyOverwrite, MergePolicyKeepFirst, MergePolicyError} {
+		got, err := m.Merge(Map{"e": 2}, policy)
+		test.ErrorIf(t, err)
+		test.ErrorIf(t, test.Differ(Map{"a": 1, Symbol("b"): "x", "c": List{1}, "e": 2}, got))
+	}
+	got, err = Map(nil).Merge(nil, MergePolicyError)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ(Map{}, got))
+
+	// m is not modified
+	test.ErrorIf(t, test.Differ(Map{"a": 1, Symbol("b"): "x", "c": List{1}}, m))
+
+	if _, err := m.Merge(other, MergePolicy(99)); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestMapClone(t *testing.T) {
+	m := Map{"a": 1, "m": Map{"x": List{[]byte("b")}}, "d": Described{"d", Map{"y": 2}}}
+
+	shallow := m.Clone()
+	shallow["a"] = 2
+	test.ErrorIf(t, test.Differ(1, m["a"]))
+	shallow["m"].(Map)["x"] = nil // Nested values are shared
+	test.ErrorIf(t, test.Differ(nil, m["m"].(Map)["x"]))
+	m["m"].(Map)["x"] = List{[]byte("b")}
+
+	deep := m.DeepClone()
+	test.ErrorIf(t, test.Differ(m, deep))
+	deep["m"].(Map)["x"].(List)[0].([]byte)[0] = 'c'
+	deep["m"].(Map)["z"] = 3
+	deep["d"].(Described).Value.(Map)["y"] = 3
+	test.ErrorIf(t, test.Differ(Map{"a": 1, "m": Map{"x": List{[]byte("b")}}, "d": Described{"d", Map{"y": 2}}}, m))
+
+	test.ErrorIf(t, test.Differ(Map(nil), Map(nil).Clone()))
+	test.ErrorIf(t, test.Differ(Map(nil), Map(nil).DeepClone()))
+}
+
+func TestListFunctions(t *testing.T) {
+	ints := List{1, 2, 3, 4, nil, 6}
+	even := func(v interface{}) bool { i, ok := v.(int); return ok && i%2 == 0 }
+	test.ErrorIf(t, test.Differ(List{2, 4, 6}, ints.Filter(even)))
+	test.ErrorIf(t, test.Differ(List{nil}, ints.Filter(func(v interface{}) bool { return v == nil })))
+	test.ErrorIf(t, test.Differ(List{}, List(nil).Filter(even)))
+
+	upper := func(v interface{}) interface{} {
+		if s, ok := v.(string); ok {
+			return strings.ToUpper(s)
+		}
+		return v
+	}
+	strs := List{"a", "b", nil, "c"}
+	test.ErrorIf(t, test.Differ(List{"A", "B", nil, "C"}, strs.Map(upper)))
+	test.ErrorIf(t, test.Differ(List{"a", "b", nil, "c"}, strs)) // Not modified
+
+	sum := func(acc, v interface{}) interface{} {
+		if i, ok := v.(int); ok {
+			return acc.(int) + i
+		}
+		return acc
+	}
+	test.ErrorIf(t, test.Differ(16, ints.Reduce(0, sum)))
+	test.ErrorIf(t, test.Differ("init", List{}.Reduce("init", sum)))
+}
+
+func TestMessageID(t *testing.T) {
+	for _, id := range []MessageID{
+		MessageIDString("foo"),
+		MessageIDUlong(42),
+		MessageIDUUID(UUID{1, 2, 3}),
+		MessageIDBinary(Binary("\x00bar")),
+	} {
+		bytes, err := Marshal(id, nil)
+		test.FatalIf(t, err)
+		var v interface{}
+		_, err = Unmarshal(bytes, &v)
+		test.ErrorIf(t, err)
+		test.ErrorIf(t, test.Differ(id.Get(), v)) // Encoded as the inner value
+		var id2 MessageID
+		_, err = Unmarshal(bytes, &id2)
+		test.ErrorIf(t, err)
+		test.ErrorIf(t, test.Differ(id, id2))
+		id3, err := ParseMessageID(v)
+		test.ErrorIf(t, err)
+		test.ErrorIf(t, test.Differ(id, id3))
+
+		m := NewMessage()
+		m.SetMessageId(id)
+		buffer, err := m.Encode(nil)
+		test.FatalIf(t, err)
+		m2, err := DecodeMessage(buffer)
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ(id.Get(), m2.MessageId()))
+	}
+
+	var zero MessageID
+	bytes, err := Marshal(zero, nil)
+	test.FatalIf(t, err)
+	var v interface{}
+	_, err = Unmarshal(bytes, &v)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ(nil, v))
+	id, err := ParseMessageID(nil)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ(zero, id))
+
+	for _, bad := range []interface{}{int32(1), Symbol("foo"), []byte("foo"), List{}} {
+		if _, err := ParseMessageID(bad); err == nil || !strings.Contains(err.Error(), "invalid message-id") {
+			t.Errorf("ParseMessageID(%#v): want error, got %v", bad, err)
+		}
+	}
+	bytes, err = Marshal(int32(1), nil)
+	test.FatalIf(t, err)
+	if _, err = Unmarshal(bytes, &id); err == nil {
+		t.Error("want error unmarshaling int into MessageID")
+	}
+}
+
 func TestTimeConversion(t *testing.T) {
 	pt := pnTime(timeValue)
 	if err := test.Differ(timeValue, goTime(pt)); err != nil {
@@ -221,3 +456,110 @@ func TestTimeConversion(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestAnnotations(t *testing.T) {
+	sym, str, num := AnnotationKeySymbol("x-opt-a"), AnnotationKeyString("x-opt-a"), AnnotationKeyUint64(1)
+	a := Annotations{sym: "a", AnnotationKeySymbol("b"): "b", num: "1"}
+	b := map[AnnotationKey]interface{}{str: "A", AnnotationKeyString("x-opt-c"): "c"}
+
+	for _, x := range []struct {
+		name      string
+		got, want Annotations
+	}{
+		{"equivalent keys", Annotations{str: 1}, Annotations{sym: 1}},
+		{"clone", a.Clone(), a},
+		{"clone nil", Annotations(nil).Clone(), nil},
+		{"merge", a.Clone().Merge(b, false),
+			Annotations{sym: "a", AnnotationKeySymbol("b"): "b", num: "1", AnnotationKeySymbol("x-opt-c"): "c"}},
+		{"merge overwrite", a.Clone().Merge(b, true),
+			Annotations{sym: "A", AnnotationKeySymbol("b"): "b", num: "1", AnnotationKeySymbol("x-opt-c"): "c"}},
+		{"merge nil", Annotations(nil).Merge(b, true), Annotations(b)},
+		{"filter prefix", a.FilterPrefix("x-opt-"), Annotations{sym: "a"}},
+		{"filter prefix none", a.FilterPrefix("x-none-"), Annotations{}},
+		{"without", a.Without(str, num), Annotations{AnnotationKeySymbol("b"): "b"}},
+		{"without nil", Annotations(nil).Without(num), Annotations{}},
+	} {
+		test.ErrorIf(t, test.Differ(x.want, x.got), x.name)
+	}
+	// Clone and Without must not modify the original
+	a.Clone()[num] = "changed"
+	a.Without(sym)
+	test.ErrorIf(t, test.Differ(Annotations{sym: "a", AnnotationKeySymbol("b"): "b", num: "1"}, a))
+}
+
+func testMarshal(t *testing.T, v interface{}) []byte {
+	marshalled, err := Marshal(v, nil)
+	test.FatalIfN(1, t, err)
+	return marshalled
+}
+
+func TestTerminusTypes(t *testing.T) {
+	for _, x := range []struct {
+		got  TerminusDurability
+		want uint32
+		str  string
+	}{
+		{DurabilityNone, 0, "none"},
+		{DurabilityConfiguration, 1, "configuration"},
+		{DurabilityUnsettledState, 2, "unsettled-state"},
+	} {
+		test.ErrorIf(t, test.Differ(x.want, uint32(x.got)))
+		test.ErrorIf(t, test.Differ(x.str, x.got.String()))
+		var d TerminusDurability
+		test.ErrorIf(t, checkUnmarshal(testMarshal(t, x.got), &d))
+		test.ErrorIf(t, test.Differ(x.got, d))
+		var v interface{}
+		test.ErrorIf(t, checkUnmarshal(testMarshal(t, x.got), &v))
+		test.ErrorIf(t, test.Differ(x.want, v)) // Encoded as AMQP uint
+	}
+	for _, x := range []struct {
+		got  TerminusExpiryPolicy
+		want Symbol
+	}{
+		{ExpiryPolicyLinkDetach, "link-detach"},
+		{ExpiryPolicySessionEnd, "session-end"},
+		{ExpiryPolicyConnectionClose, "connection-close"},
+		{ExpiryPolicyNever, "never"},
+	} {
+		test.ErrorIf(t, test.Differ(x.want, Symbol(x.got)))
+		var e TerminusExpiryPolicy
+		test.ErrorIf(t, checkUnmarshal(testMarshal(t, x.got), &e))
+		test.ErrorIf(t, test.Differ(x.got, e))
+		var v interface{}
+		test.ErrorIf(t, checkUnmarshal(testMarshal(t, x.got), &v))
+		test.ErrorIf(t, test.Differ(x.want, v)) // Encoded as AMQP symbol
+	}
+}
+
+func TestSettleModeTypes(t *testing.T) {
+	test.ErrorIf(t, test.Differ("unsettled settled mixed invalid(3)", fmt.Sprintf("%v %v %v %v",
+		SenderSettleModeUnsettled, SenderSettleModeSettled, SenderSettleModeMixed, SenderSettleMode(3))))
+	test.ErrorIf(t, test.Differ("first second invalid(2)", fmt.Sprintf("%v %v %v",
+		ReceiverSettleModeFirst, ReceiverSettleModeSecond, ReceiverSettleMode(2))))
+
+	for _, snd := range []SenderSettleMode{SenderSettleModeUnsettled, SenderSettleModeSettled, SenderSettleModeMixed} {
+		for _, rcv := range []ReceiverSettleMode{ReceiverSettleModeFirst, ReceiverSettleModeSecond} {
+			// Settle modes as they appear in the attach performative fields
+			var l List
+			test.ErrorIf(t, checkUnmarshal(testMarshal(t, List{snd, rcv}), &l))
+			test.ErrorIf(t, test.Differ(List{uint8(snd), uint8(rcv)}, l)) // Encoded as AMQP ubyte
+
+			var s SenderSettleMode
+			test.ErrorIf(t, checkUnmarshal(testMarshal(t, snd), &s))
+			test.ErrorIf(t, test.Differ(snd, s))
+			var r ReceiverSettleMode
+			test.ErrorIf(t, checkUnmarshal(testMarshal(t, rcv), &r))
+			test.ErrorIf(t, test.Differ(rcv, r))
+		}
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	v := GetVersionString()
+	if !strings.Contains(v, "proton") || !strings.HasPrefix(v, "qpid-proton-go/"+Version+" proton-c/") {
+		t.Errorf("bad version string %q", v)
+	}
+	if ok, _ := regexp.MatchString(`^[0-9]+\.[0-9]+\.[0-9]+$`, protonCVersion()); !ok {
+		t.Errorf("bad proton-C version %q", protonCVersion())
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -80,6 +81,29 @@ func panicIfBadData(data *C.pn_data_t, v interface{}) {
 	}
 }
 
+// DefaultMaxCollectionSize is the initial value of MaxCollectionSize().
+const DefaultMaxCollectionSize = 1 << 20
+
+var maxCollectionSize int64 = DefaultMaxCollectionSize
+
+// MaxCollectionSize returns the largest number of elements in a list, array or
+// map that will be unmarshaled. Larger collections fail with an
+// UnmarshalError rather than allocating memory for corrupt or hostile data.
+//
+func MaxCollectionSize() int { return int(atomic.LoadInt64(&maxCollectionSize)) }
+
+// SetMaxCollectionSize sets MaxCollectionSize() for all unmarshaling and
+// returns the previous value.
+//
+func SetMaxCollectionSize(n int) int { return int(atomic.SwapInt64(&maxCollectionSize, int64(n))) }
+
+// panicIfTooLarge panics if count elements is more than MaxCollectionSize().
+func panicIfTooLarge(count int, data *C.pn_data_t, v interface{}) {
+	if max := MaxCollectionSize(); count > max {
+		doPanicMsg(data, v, fmt.Sprintf("%d elements exceeds the maximum collection size %d", count, max))
+	}
+}
+
 func panicUnless(ok bool, data *C.pn_data_t, v interface{}) {
 	if !ok {
 		doPanic(data, v)
@@ -104,6 +128,9 @@ func checkOp(ok bool, v interface{}) {
 type Decoder struct {
 	reader io.Reader
 	buffer bytes.Buffer
+	base   int  // Stream position of the start of buffer
+	pos    int  // Stream position of the next value, buffer holds data from base
+	marked bool // Data from base is kept for Reset
 }
 
 // NewDecoder returns a new decoder that reads from r.
@@ -113,14 +140,53 @@ type Decoder struct {
 // buffer.
 //
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r, bytes.Buffer{}}
+	return &Decoder{reader: r}
 }
 
 // Buffered returns a reader of the data remaining in the Decoder's buffer. The
 // reader is valid until the next call to Decode.
 //
 func (d *Decoder) Buffered() io.Reader {
-	return bytes.NewReader(d.buffer.Bytes())
+	return bytes.NewReader(d.unread())
+}
+
+// Mark returns the current position in the stream, to be passed to Reset to
+// decode the same values again, for example as a different type. Data read
+// after the most recent Mark is kept until the next Mark or Unmark.
+//
+func (d *Decoder) Mark() int {
+	d.discard()
+	d.marked = true
+	return d.pos
+}
+
+// Unmark releases the most recent Mark, so data that has been decoded is no
+// longer kept. Reset returns an error until the next Mark.
+//
+func (d *Decoder) Unmark() {
+	d.discard()
+	d.marked = false
+}
+
+// Reset returns to pos, normally the position returned by the most recent
+// Mark, so the values decoded since then are decoded again. It is an error if
+// pos is before the most recent Mark or after the current position.
+//
+func (d *Decoder) Reset(pos int) error {
+	if !d.marked || pos < d.base || pos > d.pos {
+		return fmt.Errorf("cannot reset decoder to position %d, marked at %d", pos, d.base)
+	}
+	d.pos = pos
+	return nil
+}
+
+// unread returns the buffered data that has not been decoded.
+func (d *Decoder) unread() []byte { return d.buffer.Bytes()[d.pos-d.base:] }
+
+// discard the buffered data that has been decoded.
+func (d *Decoder) discard() {
+	d.buffer.Next(d.pos - d.base)
+	d.base = d.pos
 }
 
 // Decode reads the next AMQP value from the Reader and stores it in the value pointed to by v.
@@ -128,18 +194,22 @@ func (d *Decoder) Buffered() io.Reader {
 // See the documentation for Unmarshal for details about the conversion of AMQP into a Go value.
 //
 func (d *Decoder) Decode(v interface{}) (err error) {
-	data := C.pn_data(0)
-	defer C.pn_data_free(data)
+	src := newData(0)
+	defer src.free()
+	data := src.data
 	var n int
-	for n, err = decode(data, d.buffer.Bytes()); err == EndOfData; {
+	for n, err = decode(data, d.unread()); err == EndOfData; {
 		err = d.more()
 		if err == nil {
-			n, err = decode(data, d.buffer.Bytes())
+			n, err = decode(data, d.unread())
 		}
 	}
 	if err == nil {
-		if err = recoverUnmarshal(v, data); err == nil {
-			d.buffer.Next(n)
+		if err = recoverUnmarshal(v, data, src); err == nil {
+			d.pos += n
+			if !d.marked {
+				d.discard()
+			}
 		}
 	}
 	return
@@ -166,6 +236,8 @@ type as follows:
  +----------------------------+--------------------------------------------------+
  |string, []byte              |string, symbol or binary                          |
  +----------------------------+--------------------------------------------------+
+ |LazyBinary                  |string, symbol or binary, see LazyBinary          |
+ +----------------------------+--------------------------------------------------+
  |Symbol                      |symbol                                            |
  +----------------------------+--------------------------------------------------+
  |Char                        |char                                              |
@@ -185,8 +257,14 @@ type as follows:
  +----------------------------+--------------------------------------------------+
  |[]T                         |list or array if elements can unmarshal as T      |
  +----------------------------+------------------n-------------------------------+
+ |[N]T                        |list or array of exactly N elements, as for []T   |
+ +----------------------------+--------------------------------------------------+
  |interface{}                 |any AMQP type[2]                                  |
  +----------------------------+--------------------------------------------------+
+ |*T                          |null as a nil pointer, otherwise as for T         |
+ +----------------------------+--------------------------------------------------+
+ |Unmarshaler                 |any AMQP type, see Unmarshaler                    |
+ +----------------------------+--------------------------------------------------+
 
 [1] An AMQP described value can also unmarshal to a plain value, discarding the
 descriptor. Unmarshalling into the special amqp.Described type preserves the
@@ -216,6 +294,8 @@ determined by the AMQP type as follows:
  +----------------------------+--------------------------------------------------+
  |null                        |nil                                               |
  +----------------------------+--------------------------------------------------+
+ |no value (empty data)       |AMQPAbsent                                        |
+ +----------------------------+--------------------------------------------------+
  |described type              |Described                                         |
  +----------------------------+--------------------------------------------------+
  |timestamp                   |time.Time                                         |
@@ -228,6 +308,8 @@ determined by the AMQP type as follows:
  +----------------------------+--------------------------------------------------+
  |array                       |[]T for simple types, T is chosen as above [3]    |
  +----------------------------+--------------------------------------------------+
+ |described array             |[]Described                                       |
+ +----------------------------+--------------------------------------------------+
 
 [3] An AMQP array of simple types unmarshalls as a slice of the corresponding Go type.
 An AMQP array containing complex types (lists, maps or nested arrays) unmarshals
@@ -238,23 +320,50 @@ unless it contains key values that are illegal as Go map types, in which case
 it unmarshals as type AnyMap.
 
 The following Go types cannot be unmarshaled: uintptr, function, interface,
-channel, array (use slice), struct
+channel, struct
 
 AMQP types not yet supported: decimal32/64/128
 */
 func Unmarshal(bytes []byte, v interface{}) (n int, err error) {
-	data := C.pn_data(0)
-	defer C.pn_data_free(data)
+	src := newData(0)
+	defer src.free()
+	data := src.data
 	n, err = decode(data, bytes)
 	if err == nil {
-		err = recoverUnmarshal(v, data)
+		err = recoverUnmarshal(v, data, src)
 	}
 	return
 }
 
 // Internal
 func UnmarshalUnsafe(pnData unsafe.Pointer, v interface{}) (err error) {
-	return recoverUnmarshal(v, (*C.pn_data_t)(pnData))
+	return recoverUnmarshal(v, (*C.pn_data_t)(pnData), nil)
+}
+
+// Unmarshaler is implemented by types that convert themselves from AMQP data,
+// typically described types with code generated by amqp-gen.
+//
+// UnmarshalAMQP is called with the value as it would be unmarshaled into an
+// interface{}, so a described type arrives as a Described.
+type Unmarshaler interface {
+	UnmarshalAMQP(interface{}) error
+}
+
+// Convert stores the Go value v in the value pointed to by dst, applying the
+// same conversions as Unmarshal would to the AMQP encoding of v.
+//
+// It is mainly useful for implementing Unmarshaler, to convert values
+// unmarshaled as interface{} into more specific Go types.
+func Convert(v interface{}, dst interface{}) error {
+	src := newData(0)
+	defer src.free()
+	data := src.data
+	if err := recoverMarshal(v, data); err != nil {
+		return err
+	}
+	C.pn_data_rewind(data)
+	C.pn_data_next(data)
+	return recoverUnmarshal(dst, data, src)
 }
 
 // more reads more data when we can't parse a complete AMQP type
@@ -272,7 +381,7 @@ func (d *Decoder) more() error {
 }
 
 // Call unmarshal(), convert panic to error value
-func recoverUnmarshal(v interface{}, data *C.pn_data_t) (err error) {
+func recoverUnmarshal(v interface{}, data *C.pn_data_t, src *lazySource) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			if uerr, ok := r.(*UnmarshalError); ok {
@@ -282,21 +391,45 @@ func recoverUnmarshal(v interface{}, data *C.pn_data_t) (err error) {
 			}
 		}
 	}()
-	unmarshal(v, data)
+	unmarshal(v, data, src)
 	return nil
 }
 
 // Unmarshal from data into value pointed at by v. Returns v.
+// LazyBinary values refer to src without copying, if src is nil they are
+// materialized immediately.
 // NOTE: If you update this you also need to update getInterface()
-func unmarshal(v interface{}, data *C.pn_data_t) {
+func unmarshal(v interface{}, data *C.pn_data_t, src *lazySource) {
 	rt := reflect.TypeOf(v)
 	rv := reflect.ValueOf(v)
 	panicUnless(v != nil && rt.Kind() == reflect.Ptr && !rv.IsNil(), data, v)
 
+	// Types that decode themselves get the value as it would unmarshal to an interface{}.
+	if u, ok := v.(Unmarshaler); ok {
+		var x interface{}
+		getInterface(data, &x, src)
+		if err := u.UnmarshalAMQP(x); err != nil {
+			doPanicMsg(data, v, err.Error())
+		}
+		return
+	}
+
+	// Pointer targets: null is a nil pointer, anything else unmarshals into a new value.
+	if rt.Elem().Kind() == reflect.Ptr {
+		if C.pn_data_type(data) == C.PN_NULL {
+			rv.Elem().Set(reflect.Zero(rt.Elem()))
+		} else {
+			p := reflect.New(rt.Elem().Elem())
+			unmarshal(p.Interface(), data, src)
+			rv.Elem().Set(p)
+		}
+		return
+	}
+
 	// Check for PN_DESCRIBED first, as described types can unmarshal into any of the Go types.
 	// An interface{} target is handled in the switch below, even for described types.
 	if _, isInterface := v.(*interface{}); !isInterface && bool(C.pn_data_is_described(data)) {
-		getDescribed(data, v)
+		getDescribed(data, v, src)
 		return
 	}
 
@@ -481,10 +614,38 @@ func unmarshal(v interface{}, data *C.pn_data_t) {
 		panicUnless(pnType == C.PN_BINARY, data, v)
 		*v = Binary(goBytes(C.pn_data_get_binary(data)))
 
+	case *LazyBinary:
+		switch pnType {
+		case C.PN_STRING:
+			v.set(src, C.pn_data_get_string(data))
+		case C.PN_SYMBOL:
+			v.set(src, C.pn_data_get_symbol(data))
+		case C.PN_BINARY:
+			v.set(src, C.pn_data_get_binary(data))
+		default:
+			doPanic(data, v)
+		}
+
 	case *Symbol:
 		panicUnless(pnType == C.PN_SYMBOL, data, v)
 		*v = Symbol(goBytes(C.pn_data_get_symbol(data)))
 
+	case *TerminusDurability:
+		panicUnless(pnType == C.PN_UINT, data, v)
+		*v = TerminusDurability(C.pn_data_get_uint(data))
+
+	case *TerminusExpiryPolicy:
+		panicUnless(pnType == C.PN_SYMBOL, data, v)
+		*v = TerminusExpiryPolicy(goBytes(C.pn_data_get_symbol(data)))
+
+	case *SenderSettleMode:
+		panicUnless(pnType == C.PN_UBYTE, data, v)
+		*v = SenderSettleMode(C.pn_data_get_ubyte(data))
+
+	case *ReceiverSettleMode:
+		panicUnless(pnType == C.PN_UBYTE, data, v)
+		*v = ReceiverSettleMode(C.pn_data_get_ubyte(data))
+
 	case *time.Time:
 		panicUnless(pnType == C.PN_TIMESTAMP, data, v)
 		*v = goTime(C.pn_data_get_timestamp(data))
@@ -496,11 +657,15 @@ func unmarshal(v interface{}, data *C.pn_data_t) {
 
 	case *AnnotationKey:
 		panicUnless(pnType == C.PN_ULONG || pnType == C.PN_SYMBOL || pnType == C.PN_STRING, data, v)
-		unmarshal(&v.value, data)
+		unmarshal(&v.value, data, src)
+		if s, ok := v.value.(string); ok { // Tolerate string keys, but as symbols
+			v.value = Symbol(s)
+		}
 
 	case *AnyMap:
 		panicUnless(C.pn_data_type(data) == C.PN_MAP, data, v)
 		n := int(C.pn_data_get_map(data)) / 2
+		panicIfTooLarge(n, data, v)
 		if cap(*v) < n {
 			*v = make(AnyMap, n)
 		}
@@ -509,21 +674,21 @@ func unmarshal(v interface{}, data *C.pn_data_t) {
 		defer data.exit(*v)
 		for i := 0; i < n; i++ {
 			data.next(*v)
-			unmarshal(&(*v)[i].Key, data)
+			unmarshal(&(*v)[i].Key, data, src)
 			data.next(*v)
-			unmarshal(&(*v)[i].Value, data)
+			unmarshal(&(*v)[i].Value, data, src)
 		}
 
 	case *interface{}:
-		getInterface(data, v)
+		getInterface(data, v, src)
 
 	default: // This is not one of the fixed well-known types, reflect for map and slice types
 
 		switch rt.Elem().Kind() {
 		case reflect.Map:
-			getMap(data, v)
-		case reflect.Slice:
-			getSequence(data, v)
+			getMap(data, v, src)
+		case reflect.Slice, reflect.Array:
+			getSequence(data, v, src)
 		default:
 			doPanic(data, v)
 		}
@@ -532,7 +697,7 @@ func unmarshal(v interface{}, data *C.pn_data_t) {
 
 // Unmarshalling into an interface{} the type is determined by the AMQP source type,
 // since the interface{} target can hold any Go type.
-func getInterface(data *C.pn_data_t, vp *interface{}) {
+func getInterface(data *C.pn_data_t, vp *interface{}, src *lazySource) {
 	pnType := C.pn_data_type(data)
 	switch pnType {
 	case C.PN_BOOL:
@@ -569,36 +734,37 @@ func getInterface(data *C.pn_data_t, vp *interface{}) {
 		*vp = goTime(C.pn_data_get_timestamp(data))
 	case C.PN_UUID:
 		var u UUID
-		unmarshal(&u, data)
+		unmarshal(&u, data, src)
 		*vp = u
 	case C.PN_MAP:
+		panicIfTooLarge(int(C.pn_data_get_map(data))/2, data, vp)
 		// We will try to unmarshal as a Map first, if that fails try AnyMap
 		m := make(Map, int(C.pn_data_get_map(data))/2)
-		if err := recoverUnmarshal(&m, data); err == nil {
+		if err := recoverUnmarshal(&m, data, src); err == nil {
 			*vp = m
 		} else {
 			am := make(AnyMap, int(C.pn_data_get_map(data))/2)
-			unmarshal(&am, data)
+			unmarshal(&am, data, src)
 			*vp = am
 		}
 	case C.PN_LIST:
 		l := List{}
-		unmarshal(&l, data)
+		unmarshal(&l, data, src)
 		*vp = l
 	case C.PN_ARRAY:
 		sp := getArrayStore(data) // interface{} containing T* for suitable T
-		unmarshal(sp, data)
+		unmarshal(sp, data, src)
 		*vp = reflect.ValueOf(sp).Elem().Interface()
 	case C.PN_DESCRIBED:
 		d := Described{}
-		unmarshal(&d, data)
+		unmarshal(&d, data, src)
 		*vp = d
 	case C.PN_NULL:
 		*vp = nil
 	case C.PN_INVALID:
-		// Allow decoding from an empty data object to an interface, treat it like NULL.
-		// This happens when optional values or properties are omitted from a message.
-		*vp = nil
+		// Decoding from an empty data object to an interface. This happens when
+		// optional values or properties are omitted, distinguish it from NULL.
+		*vp = AMQPAbsent{}
 	default: // Don't know how to handle this
 		panic(newUnmarshalError(pnType, vp))
 	}
@@ -606,7 +772,9 @@ func getInterface(data *C.pn_data_t, vp *interface{}) {
 
 // Return an interface{} containing a pointer to an appropriate slice or Array
 func getArrayStore(data *C.pn_data_t) interface{} {
-	// TODO aconway 2017-11-10: described arrays.
+	if C.pn_data_is_array_described(data) {
+		return new([]Described)
+	}
 	switch C.pn_data_get_array_type(data) {
 	case C.PN_BOOL:
 		return new([]bool)
@@ -649,9 +817,10 @@ func getArrayStore(data *C.pn_data_t) interface{} {
 var typeOfInterface = reflect.TypeOf(interface{}(nil))
 
 // get into map pointed at by v
-func getMap(data *C.pn_data_t, v interface{}) {
+func getMap(data *C.pn_data_t, v interface{}, src *lazySource) {
 	panicUnless(C.pn_data_type(data) == C.PN_MAP, data, v)
 	n := int(C.pn_data_get_map(data)) / 2
+	panicIfTooLarge(n, data, v)
 	mapValue := reflect.ValueOf(v).Elem()
 	mapValue.Set(reflect.MakeMap(mapValue.Type())) // Clear the map
 	data.enter(v)
@@ -662,17 +831,17 @@ func getMap(data *C.pn_data_t, v interface{}) {
 	valPtr := reflect.New(mapValue.Type().Elem())
 	for i := 0; i < n; i++ {
 		data.next(v)
-		unmarshal(keyPtr.Interface(), data)
+		unmarshal(keyPtr.Interface(), data, src)
 		if keyType.Kind() == reflect.Interface && !keyPtr.Elem().Elem().Type().Comparable() {
 			doPanicMsg(data, v, fmt.Sprintf("key %#v is not comparable", keyPtr.Elem().Interface()))
 		}
 		data.next(v)
-		unmarshal(valPtr.Interface(), data)
+		unmarshal(valPtr.Interface(), data, src)
 		mapValue.SetMapIndex(keyPtr.Elem(), valPtr.Elem())
 	}
 }
 
-func getSequence(data *C.pn_data_t, vp interface{}) {
+func getSequence(data *C.pn_data_t, vp interface{}, src *lazySource) {
 	var count int
 	pnType := C.pn_data_type(data)
 	switch pnType {
@@ -683,30 +852,55 @@ func getSequence(data *C.pn_data_t, vp interface{}) {
 	default:
 		doPanic(data, vp)
 	}
-	listValue := reflect.MakeSlice(reflect.TypeOf(vp).Elem(), count, count)
+	panicIfTooLarge(count, data, vp)
+	described := pnType == C.PN_ARRAY && bool(C.pn_data_is_array_described(data))
+	// Fill a Go array in place, or make a new slice.
+	listValue := reflect.ValueOf(vp).Elem()
+	if listValue.Kind() == reflect.Array {
+		if count != listValue.Len() {
+			doPanicMsg(data, vp, fmt.Sprintf("%d elements for array of length %d", count, listValue.Len()))
+		}
+	} else {
+		listValue = reflect.MakeSlice(listValue.Type(), count, count)
+	}
 	data.enter(vp)
 	defer data.exit(vp)
+	// A described array has a single descriptor ahead of the elements.
+	var descriptor interface{}
+	if described {
+		data.next(vp)
+		unmarshal(&descriptor, data, src)
+	}
+	isDescribed := listValue.Type().Elem() == reflect.TypeOf(Described{})
 	for i := 0; i < count; i++ {
 		data.next(vp)
+		if described && isDescribed {
+			d := Described{Descriptor: descriptor}
+			unmarshal(&d.Value, data, src)
+			listValue.Index(i).Set(reflect.ValueOf(d))
+			continue
+		}
 		val := reflect.New(listValue.Type().Elem())
-		unmarshal(val.Interface(), data)
+		unmarshal(val.Interface(), data, src)
 		listValue.Index(i).Set(val.Elem())
 	}
-	reflect.ValueOf(vp).Elem().Set(listValue)
+	if listValue.Kind() == reflect.Slice {
+		reflect.ValueOf(vp).Elem().Set(listValue)
+	}
 }
 
-func getDescribed(data *C.pn_data_t, vp interface{}) {
+func getDescribed(data *C.pn_data_t, vp interface{}, src *lazySource) {
 	d, isDescribed := vp.(*Described)
 	data.enter(vp)
 	defer data.exit(vp)
 	data.next(vp)
 	if isDescribed {
-		unmarshal(&d.Descriptor, data)
+		unmarshal(&d.Descriptor, data, src)
 		data.next(vp)
-		unmarshal(&d.Value, data)
+		unmarshal(&d.Value, data, src)
 	} else {
-		data.next(vp)       // Skip descriptor
-		unmarshal(vp, data) // Unmarshal plain value
+		data.next(vp)            // Skip descriptor
+		unmarshal(vp, data, src) // Unmarshal plain value
 	}
 }
 
@@ -27,11 +27,20 @@ package amqp
 // Version check for compatible proton-c library.
 //
 // NOTE: the required version should NOT be increased unless the Go
-// library is modified to require some new proton-c API. That hasn't
-// happened for a long time.
+// library is modified to require some new proton-c API. The Go packages
+// use these functions that are new in 0.33:
+//
+//   pn_sasl_set_external_security
+//   pn_link_offered_capabilities, pn_link_desired_capabilities
+//   pn_link_remote_offered_capabilities, pn_link_remote_desired_capabilities
+//   pn_session_remote_incoming_window, pn_session_remote_outgoing_window
+//   pn_terminus_default_outcome
+//   pn_link_delivery_count
+//   pn_link_unsettled_map, pn_link_remote_unsettled_map
+//   pn_delivery_set_resume, pn_delivery_resumed
 
 // #include <proton/version.h>
-// #if PN_VERSION_MAJOR == 0 && PN_VERSION_MINOR < 10
-// #error packages qpid.apache.org/... require Proton-C library version 0.10 or greater
+// #if PN_VERSION_MAJOR == 0 && PN_VERSION_MINOR < 33
+// #error packages qpid.apache.org/... require Proton-C library version 0.33 or greater
 // #endif
 import "C"
@@ -0,0 +1,45 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package amqp
+
+// #include <proton/version.h>
+import "C"
+
+import "fmt"
+
+// Version is the version of the Go packages in this module.
+const Version = "1.0.0-alpha.1"
+
+// GetVersionString returns the versions of the Go packages and of the proton-C
+// library they were built with, for logging and bug reports. For example:
+//
+//	qpid-proton-go/1.0.0-alpha.1 proton-c/0.33.0
+func GetVersionString() string {
+	return "qpid-proton-go/" + Version + " proton-c/" + protonCVersion()
+}
+
+// protonCVersion is the version from the proton-C headers used to build.
+//
+// The proton-C library has no function to report its version at run time, so
+// a mismatch with the installed library can't be detected here. The only check
+// is the compile-time minimum version in version.go.
+func protonCVersion() string {
+	return fmt.Sprintf("%d.%d.%d", C.PN_VERSION_MAJOR, C.PN_VERSION_MINOR, C.PN_VERSION_POINT)
+}
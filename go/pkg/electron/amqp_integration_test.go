@@ -0,0 +1,200 @@
+//go:build integration
+// +build integration
+
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+// Integration tests against a real broker, run with:
+//
+//	AMQP_TEST_BROKER=amqp://localhost:5672 go test -tags integration -run Integration ./go/pkg/electron
+//
+// See go/docker-compose.yml to start a broker. The tests are skipped if
+// AMQP_TEST_BROKER is not set.
+package electron_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/electron"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// How long to wait for a message that should not arrive.
+const integrationQuiet = time.Second
+
+// Dial the broker in AMQP_TEST_BROKER, skip the test if it is not set.
+func integrationDial(t *testing.T) electron.Connection {
+	url := os.Getenv("AMQP_TEST_BROKER")
+	if url == "" {
+		t.Skip("AMQP_TEST_BROKER is not set")
+	}
+	c, err := electron.DialURL(url, electron.SASLAllowInsecure(true))
+	test.FatalIfN(1, t, err)
+	test.FatalIfN(1, t, c.Sync())
+	return c
+}
+
+// Make a queue name that is not used by any other test run.
+func integrationQueue(t *testing.T) string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	test.FatalIfN(1, t, err)
+	name := strings.Replace(t.Name(), "/", "-", -1)
+	return fmt.Sprintf("electron-%s-%s", name, hex.EncodeToString(b))
+}
+
+// Receive a message and check its body is want.
+func integrationReceive(t *testing.T, r electron.Receiver, want interface{}) {
+	rm, err := r.ReceiveTimeout(10 * time.Second)
+	test.FatalIfN(1, t, err)
+	test.ErrorIfN(1, t, test.Differ(want, rm.Message.Body()))
+	test.ErrorIfN(1, t, rm.Accept())
+}
+
+// Check no message arrives on r.
+func integrationNothing(t *testing.T, r electron.Receiver) {
+	if rm, err := r.ReceiveTimeout(integrationQuiet); err != electron.Timeout {
+		t.Errorf("want timeout got %v, %v", rm.Message, err)
+	}
+}
+
+func TestIntegration(t *testing.T) {
+	c := integrationDial(t)
+	defer func() { c.Close(nil) }()
+
+	t.Run("SendReceive", func(t *testing.T) {
+		q := integrationQueue(t)
+		s, err := c.Sender(electron.Target(q))
+		test.FatalIf(t, err)
+		for i := 0; i < 100; i++ {
+			out := s.SendSync(amqp.NewMessageWith(fmt.Sprintf("message-%d", i)))
+			test.FatalIf(t, out.Error)
+			test.ErrorIf(t, test.Differ(electron.Accepted, out.Status))
+		}
+		r, err := c.Receiver(electron.Source(q), electron.Capacity(10), electron.Prefetch(true))
+		test.FatalIf(t, err)
+		for i := 0; i < 100; i++ {
+			integrationReceive(t, r, fmt.Sprintf("message-%d", i))
+		}
+	})
+
+	t.Run("Durable", func(t *testing.T) {
+		q := integrationQueue(t)
+		s, err := c.Sender(electron.Target(q))
+		test.FatalIf(t, err)
+		m := amqp.NewMessageWith("durable")
+		m.SetDurable(true)
+		test.FatalIf(t, s.SendSync(m).Error)
+
+		// The message survives the connection that sent it.
+		c2 := integrationDial(t)
+		c.Close(nil)
+		r, err := c2.Receiver(electron.Source(q))
+		test.FatalIf(t, err)
+		integrationReceive(t, r, "durable")
+		c = c2
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		q := integrationQueue(t)
+		s, err := c.Sender(electron.Target(q))
+		test.FatalIf(t, err)
+		m := amqp.NewMessageWith("expired")
+		m.SetTTL(100 * time.Millisecond)
+		test.FatalIf(t, s.SendSync(m).Error)
+		test.FatalIf(t, s.SendSync(amqp.NewMessageWith("live")).Error)
+		time.Sleep(500 * time.Millisecond)
+
+		r, err := c.Receiver(electron.Source(q))
+		test.FatalIf(t, err)
+		integrationReceive(t, r, "live")
+		integrationNothing(t, r)
+	})
+
+	t.Run("Priority", func(t *testing.T) {
+		q := integrationQueue(t)
+		s, err := c.Sender(electron.Target(q))
+		test.FatalIf(t, err)
+		for i, p := range []uint8{1, 4, 9} {
+			m := amqp.NewMessageWith(fmt.Sprintf("priority-%d", i))
+			m.SetPriority(p)
+			test.FatalIf(t, s.SendSync(m).Error)
+		}
+		// Messages queued before the receiver attaches are delivered highest priority first.
+		r, err := c.Receiver(electron.Source(q))
+		test.FatalIf(t, err)
+		for _, i := range []int{2, 1, 0} {
+			integrationReceive(t, r, fmt.Sprintf("priority-%d", i))
+		}
+	})
+
+	t.Run("TxnCommit", func(t *testing.T) {
+		q := integrationQueue(t)
+		ssn, err := c.Session()
+		test.FatalIf(t, err)
+		s, err := ssn.Sender(electron.Target(q))
+		test.FatalIf(t, err)
+		r, err := c.Receiver(electron.Source(q))
+		test.FatalIf(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		txn, err := ssn.BeginTransaction(ctx)
+		test.FatalIf(t, err)
+		_, err = txn.SendIn(ctx, s, amqp.NewMessageWith("committed"))
+		test.FatalIf(t, err)
+		integrationNothing(t, r) // Not visible until commit
+		test.FatalIf(t, txn.Commit(ctx))
+		integrationReceive(t, r, "committed")
+	})
+
+	t.Run("TxnRollback", func(t *testing.T) {
+		q := integrationQueue(t)
+		ssn, err := c.Session()
+		test.FatalIf(t, err)
+		s, err := ssn.Sender(electron.Target(q))
+		test.FatalIf(t, err)
+		r, err := c.Receiver(electron.Source(q))
+		test.FatalIf(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		txn, err := ssn.BeginTransaction(ctx)
+		test.FatalIf(t, err)
+		_, err = txn.SendIn(ctx, s, amqp.NewMessageWith("aborted"))
+		test.FatalIf(t, err)
+		test.FatalIf(t, txn.Abort(ctx))
+		integrationNothing(t, r)
+
+		// The session can be used for a new transaction after the rollback.
+		txn, err = ssn.BeginTransaction(ctx)
+		test.FatalIf(t, err)
+		_, err = txn.SendIn(ctx, s, amqp.NewMessageWith("after"))
+		test.FatalIf(t, err)
+		test.FatalIf(t, txn.Commit(ctx))
+		integrationReceive(t, r, "after")
+	})
+}
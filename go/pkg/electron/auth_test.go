@@ -22,6 +22,7 @@ package electron
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -38,6 +39,9 @@ func TestAuthAnonymous(t *testing.T) {
 	test.FatalIf(t, p.server.Sync())
 	test.ErrorIf(t, test.Differ("anonymous", p.server.User()))
 	test.ErrorIf(t, test.Differ("vhost", p.server.VirtualHost()))
+	test.ErrorIf(t, test.Differ("ANONYMOUS", p.server.SASLMechanism()))
+	test.FatalIf(t, p.client.Sync())
+	test.ErrorIf(t, test.Differ("ANONYMOUS", p.client.Connection().SASLMechanism()))
 }
 
 func TestAuthPlain(t *testing.T) {
@@ -47,6 +51,7 @@ func TestAuthPlain(t *testing.T) {
 		[]ConnectionOption{SASLAllowInsecure(true), SASLAllowedMechs("PLAIN")})
 	test.FatalIf(t, p.server.Sync())
 	test.ErrorIf(t, test.Differ("fred@proton", p.server.User()))
+	test.ErrorIf(t, test.Differ("PLAIN", p.server.SASLMechanism()))
 }
 
 func TestAuthBadPass(t *testing.T) {
@@ -54,8 +59,11 @@ func TestAuthBadPass(t *testing.T) {
 	p := newPipe(t,
 		[]ConnectionOption{SASLAllowInsecure(true), SASLAllowedMechs("PLAIN"), User("fred@proton"), Password([]byte("yyy"))},
 		[]ConnectionOption{SASLAllowInsecure(true), SASLAllowedMechs("PLAIN")})
-	if p.server.Sync() == nil {
-		t.Error("Expected auth failure for bad pass")
+	if _, ok := p.server.Sync().(AuthError); !ok {
+		t.Error("Expected auth failure for bad pass, got", p.server.Error())
+	}
+	if _, ok := p.client.Connection().Wait().(AuthError); !ok {
+		t.Error("Expected client auth failure for bad pass, got", p.client.Connection().Error())
 	}
 }
 
@@ -69,6 +77,100 @@ func TestAuthBadUser(t *testing.T) {
 	}
 }
 
+// The server offers no mechanism on an insecure connection. Both ends must fail
+// rather than wait for events raised while the transport writes its output.
+func TestAuthNoMechanism(t *testing.T) {
+	p := newPipe(t,
+		[]ConnectionOption{SASLAllowInsecure(true), User("fred"), Password([]byte("xxx"))},
+		[]ConnectionOption{SASLAllowedMechs("PLAIN")})
+	if p.server.Sync() == nil {
+		t.Error("Expected server failure with no mechanism")
+	}
+	if _, ok := p.client.Connection().Wait().(AuthError); !ok {
+		t.Error("Expected client auth failure with no mechanism, got", p.client.Connection().Error())
+	}
+}
+
+func checkPassword(user string, password []byte) error {
+	if user != "fred" || string(password) != "secret" {
+		return fmt.Errorf("bad password for %q", user)
+	}
+	return nil
+}
+
+func TestAuthPlainAuthenticator(t *testing.T) {
+	users := make(chan string, 1)
+	policy := func(in IncomingLink) error { users <- in.AuthenticatedUser(); return nil }
+	p := newPipe(t,
+		[]ConnectionOption{SASLAllowInsecure(true), User("fred"), Password([]byte("secret"))},
+		[]ConnectionOption{SASLAllowInsecure(true), SASLPlainAuthenticator(checkPassword), LinkPolicy(policy)})
+	defer p.close()
+	test.FatalIf(t, p.server.Sync())
+	test.ErrorIf(t, test.Differ("fred", p.server.User()))
+	test.ErrorIf(t, test.Differ("fred", p.server.AuthenticatedUser()))
+	test.ErrorIf(t, test.Differ("PLAIN", p.server.SASLMechanism()))
+	test.FatalIf(t, p.client.Sync())
+	test.ErrorIf(t, test.Differ("PLAIN", p.client.Connection().SASLMechanism()))
+	p.sender(Target("q"))
+	test.ErrorIf(t, test.Differ("fred", <-users))
+}
+
+func TestAuthPlainAuthenticatorBadPass(t *testing.T) {
+	p := newPipe(t,
+		[]ConnectionOption{SASLAllowInsecure(true), User("fred"), Password([]byte("wrong"))},
+		[]ConnectionOption{SASLAllowInsecure(true), SASLPlainAuthenticator(checkPassword)})
+	if _, ok := p.server.Sync().(AuthError); !ok {
+		t.Error("Expected server auth failure for bad pass, got", p.server.Error())
+	}
+	if _, ok := p.client.Connection().Wait().(AuthError); !ok {
+		t.Error("Expected client auth failure for bad pass, got", p.client.Connection().Error())
+	}
+}
+
+// The password passed to the authenticator is erased when it returns.
+func TestAuthPlainAuthenticatorErasesPassword(t *testing.T) {
+	var kept []byte
+	verify := func(user string, password []byte) error {
+		kept = password
+		return checkPassword(user, password)
+	}
+	p := newPipe(t,
+		[]ConnectionOption{SASLAllowInsecure(true), User("fred"), Password([]byte("secret"))},
+		[]ConnectionOption{SASLAllowInsecure(true), SASLPlainAuthenticator(verify)})
+	defer p.close()
+	test.FatalIf(t, p.server.Sync())
+	test.ErrorIf(t, test.Differ(make([]byte, len("secret")), kept))
+}
+
+// The authenticator offers only PLAIN, a client that won't use it is refused.
+func TestAuthPlainAuthenticatorMechMismatch(t *testing.T) {
+	p := newPipe(t,
+		[]ConnectionOption{SASLAllowInsecure(true), SASLAllowedMechs("ANONYMOUS")},
+		[]ConnectionOption{SASLAllowInsecure(true), SASLPlainAuthenticator(checkPassword)})
+	if p.server.Sync() == nil {
+		t.Error("Expected server auth failure for mechanism mismatch")
+	}
+	if _, ok := p.client.Connection().Wait().(AuthError); !ok {
+		t.Error("Expected client auth failure for mechanism mismatch, got", p.client.Connection().Error())
+	}
+
+	// PLAIN is not offered on an insecure connection by default.
+	p = newPipe(t,
+		[]ConnectionOption{SASLAllowInsecure(true), User("fred"), Password([]byte("secret"))},
+		[]ConnectionOption{SASLPlainAuthenticator(checkPassword)})
+	if p.server.Sync() == nil {
+		t.Error("Expected server auth failure for insecure PLAIN")
+	}
+}
+
+func TestAuthPlainAuthenticatorClient(t *testing.T) {
+	cli, _ := net.Pipe()
+	_, err := NewConnection(cli, SASLPlainAuthenticator(checkPassword))
+	if err == nil {
+		t.Error("expected error for client connection")
+	}
+}
+
 type extendedSASLState struct {
 	err error
 	dir string
@@ -20,10 +20,15 @@ under the License.
 package electron
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io"
+	"net"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/apache/qpid-proton/go/pkg/amqp"
 	"github.com/apache/qpid-proton/go/pkg/internal/test"
@@ -33,6 +38,8 @@ import (
 //     go test -bench=. -args -capacity 100
 var capacity = flag.Int("capacity", 1000, "Prefetch capacity")
 var bodySize = flag.Int("bodySize", 1000, "Message body size")
+var batchSize = flag.Int("batchSize", 100, "Messages per SendBatch")
+var latency = flag.Duration("latency", 2*time.Millisecond, "One-way latency for BenchmarkSessionCapacity")
 
 type bmCommon struct {
 	b    *testing.B
@@ -116,6 +123,25 @@ func BenchmarkSendAsync(b *testing.B) {
 	bm.done.Wait()
 }
 
+func BenchmarkSendBatch(b *testing.B) {
+	bm := newBmCommon(newPipe(b, nil, nil), 1)
+	defer bm.p.close()
+
+	go bm.receiveAccept()
+	msgs := make([]amqp.Message, *batchSize)
+	for i := range msgs {
+		msgs[i] = emptyMsg
+	}
+	for n := 0; n < b.N; n += len(msgs) {
+		if b.N-n < len(msgs) {
+			msgs = msgs[:b.N-n]
+		}
+		_, err := bm.s.SendBatch(context.Background(), msgs)
+		test.FatalIf(b, err)
+	}
+	bm.done.Wait()
+}
+
 // Create a new message for each send, with body and property.
 func BenchmarkSendAsyncNewMessage(b *testing.B) {
 	body := strings.Repeat("x", *bodySize)
@@ -131,3 +157,100 @@ func BenchmarkSendAsyncNewMessage(b *testing.B) {
 	}
 	bm.done.Wait()
 }
+
+// latencyConn delays each write by a fixed latency without limiting bandwidth.
+type latencyConn struct {
+	net.Conn
+	latency time.Duration
+	writes  chan latencyWrite
+	done    chan struct{}
+	once    sync.Once
+}
+
+type latencyWrite struct {
+	due time.Time
+	b   []byte
+}
+
+func newLatencyConn(c net.Conn, latency time.Duration) *latencyConn {
+	lc := &latencyConn{Conn: c, latency: latency, writes: make(chan latencyWrite, 1024), done: make(chan struct{})}
+	go lc.run()
+	return lc
+}
+
+func (c *latencyConn) Write(b []byte) (int, error) {
+	w := latencyWrite{time.Now().Add(c.latency), append([]byte(nil), b...)}
+	select {
+	case c.writes <- w:
+		return len(b), nil
+	case <-c.done:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (c *latencyConn) run() {
+	for {
+		select {
+		case w := <-c.writes:
+			time.Sleep(time.Until(w.due))
+			if _, err := c.Conn.Write(w.b); err != nil {
+				_ = c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *latencyConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// Receive messages over a connection with latency, the receiving session's
+// capacity limits the number of frames in flight.
+//
+//     go test -bench=SessionCapacity -args -latency 10ms -bodySize 10000
+func BenchmarkSessionCapacity(b *testing.B) {
+	for _, frames := range []uint{1, 8, 64} {
+		b.Run(fmt.Sprintf("frames=%d", frames), func(b *testing.B) { benchmarkSessionCapacity(b, frames) })
+	}
+}
+
+func benchmarkSessionCapacity(b *testing.B, frames uint) {
+	const frameSize = 16 * 1024
+	if uint(*bodySize) >= frames*frameSize {
+		b.Skip("a message must fit in the session capacity")
+	}
+	cli, srv := net.Pipe()
+	sc, _ := NewConnection(newLatencyConn(srv, *latency), Server(), ContainerId("server"))
+	cc, _ := NewConnection(newLatencyConn(cli, *latency), ContainerId("client"), MaxFrameSize(frameSize),
+		DefaultSessionOptions(IncomingCapacity(frames*frameSize)))
+	p := newPair(b, cc, sc)
+	defer p.close()
+	var err error
+	p.client, err = cc.DefaultSession()
+	test.FatalIf(b, err)
+	r, s := p.receiver(Capacity(*capacity), Prefetch(true))
+	msg := amqp.NewMessageWith(strings.Repeat("x", *bodySize))
+	ack := make(chan Outcome, *capacity)
+	b.SetBytes(int64(*bodySize))
+	b.ResetTimer()
+
+	go func() {
+		for n := 0; n < b.N; n++ {
+			s.SendAsync(msg, ack, nil)
+		}
+	}()
+	go func() {
+		for n := 0; n < b.N; n++ {
+			<-ack
+		}
+	}()
+	for n := 0; n < b.N; n++ {
+		rm, err := r.Receive()
+		test.FatalIf(b, err)
+		test.FatalIf(b, rm.Accept())
+	}
+}
@@ -34,6 +34,7 @@ type pair struct {
 	server   Connection
 	capacity int
 	prefetch bool
+	maxSize  uint64
 	rchan    chan Receiver
 	schan    chan Sender
 	auth     connectionSettings
@@ -57,6 +58,7 @@ func newPair(t testing.TB, cli, srv Connection) *pair {
 					i.SetCapacity(p.capacity)
 				}
 				i.SetPrefetch(p.prefetch)
+				i.SetMaxMessageSize(p.maxSize)
 				p.rchan <- i.Accept().(Receiver)
 				break
 			case *IncomingSender:
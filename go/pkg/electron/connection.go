@@ -23,11 +23,17 @@ package electron
 import "C"
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
 	"fmt"
 	"net"
+	"net/url"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/apache/qpid-proton/go/pkg/amqp"
@@ -39,7 +45,8 @@ type ConnectionSettings interface {
 	// Authenticated user name associated with the connection.
 	User() string
 
-	// The AMQP virtual host name for the connection.
+	// The AMQP virtual host name for the connection, the hostname field of the
+	// client's open frame.
 	//
 	// Optional, useful when the server has multiple names and provides different
 	// service based on the name the client uses to connect.
@@ -51,13 +58,82 @@ type ConnectionSettings interface {
 	// Returns error if the connection fails to authenticate.
 	VirtualHost() string
 
+	// ContainerId is the container-id sent to the remote peer in our open frame,
+	// see the ContainerId() and Parent() options.
+	ContainerId() string
+
+	// RemoteContainerId is the container-id sent by the remote peer, "" until
+	// the remote peer has opened the connection.
+	RemoteContainerId() string
+
+	// RemoteProperties are the connection properties sent by the remote peer,
+	// for example "product" and "version". Nil until the remote peer has opened
+	// the connection.
+	RemoteProperties() map[amqp.Symbol]interface{}
+
+	// RemoteOfferedCapabilities are the capabilities offered by the remote peer,
+	// for example AnonymousRelay. Nil until the remote peer has opened the connection.
+	RemoteOfferedCapabilities() []amqp.Symbol
+
+	// RemoteDesiredCapabilities are the capabilities the remote peer would like
+	// us to offer.
+	RemoteDesiredCapabilities() []amqp.Symbol
+
+	// RemoteMaxFrameSize is the largest frame the remote peer will accept, in
+	// bytes. 0 until the remote peer has opened the connection.
+	RemoteMaxFrameSize() uint32
+
+	// RemoteChannelMax is the highest session channel number the remote peer
+	// allows, one less than the number of sessions it allows. 0 until the remote
+	// peer has opened the connection.
+	RemoteChannelMax() uint16
+
+	// MaxFrameSize is the largest frame we accept from the remote peer, see the
+	// MaxFrameSize() option. 0 means no limit.
+	MaxFrameSize() uint32
+
+	// ChannelMax is the highest session channel number that can be used on the
+	// connection: our limit from the ChannelMax() option, lowered to
+	// RemoteChannelMax() once the remote peer has opened the connection.
+	ChannelMax() uint16
+
 	// Heartbeat is the maximum delay between sending frames that the remote peer
 	// has requested of us. If the interval expires an empty "heartbeat" frame
 	// will be sent automatically to keep the connection open.
 	Heartbeat() time.Duration
+
+	// LocalHeartbeat is the maximum delay between frames that we have requested
+	// of the remote peer with the Heartbeat() option, 0 if none.
+	LocalHeartbeat() time.Duration
+
+	// RemoteIdleTimeout is the idle-timeout sent by the remote peer, 0 if none.
+	// It is the same as Heartbeat(): we send at least one frame per interval.
+	RemoteIdleTimeout() time.Duration
+
+	// LocalIdleTimeout is how long we wait for a frame from the remote peer
+	// before closing the connection with an IdleTimeoutError, 0 if we never
+	// time out. It is twice the LocalHeartbeat() to allow for network delays.
+	LocalIdleTimeout() time.Duration
+
+	// SASLMechanism is the SASL mechanism used to authenticate the connection,
+	// or "" if SASL was not used.
+	SASLMechanism() string
+
+	// AuthenticatedUser is the identity established by SASL authentication, or
+	// "" if there was none.
+	//
+	// On a server it is the identity of the client. On a client it is the
+	// identity the server accepted: the User() option or, for the EXTERNAL
+	// mechanism without User(), the subject of our TLS certificate.
+	AuthenticatedUser() string
 }
 
 // Connection is an AMQP connection, created by a Container.
+//
+// If the connection is closed by an idle-timeout, Error() returns an
+// IdleTimeoutError rather than the bare amqp.Error. Check for it with a type
+// assertion, err.(IdleTimeoutError). The amqp.ResourceLimitExceeded condition
+// is in its Err field, and amqp.MakeError() returns it.
 type Connection interface {
 	Endpoint
 	ConnectionSettings
@@ -70,14 +146,55 @@ type Connection interface {
 
 	// DefaultSession() returns a default session for the connection. It is opened
 	// on the first call to DefaultSession and returned on subsequent calls.
+	// See the DefaultSessionOptions() option to configure it.
 	DefaultSession() (Session, error)
 
 	// Session opens a new session.
 	Session(...SessionOption) (Session, error)
 
+	// Sessions returns a snapshot of the sessions that are currently open on
+	// the connection, in no particular order.
+	Sessions() []Session
+
+	// SessionCount is the number of sessions open on the connection. Unlike
+	// Sessions() it does not wait for the connection, it is safe to call from
+	// any goroutine.
+	SessionCount() int
+
 	// Container for the connection.
 	Container() Container
 
+	// Echo sends a small request to the remote peer's "$management" node and
+	// returns the time taken to get a reply, the content of the reply is
+	// ignored. It is useful to check that the peer is responsive.
+	//
+	// The first call attaches a sender and a dynamic reply receiver on a new
+	// session, later calls re-use them.
+	//
+	// Returns ctx.Err() if ctx is done before the reply arrives.
+	Echo(ctx context.Context) (time.Duration, error)
+
+	// MaxMessageSize is the largest message the remote peer will accept on this
+	// connection, or 0 if there is no limit.
+	//
+	// AMQP sets max-message-size per link when the link is attached, so this
+	// is the smallest non-zero limit set by the remote peer on the links
+	// currently open. A Sender checks the limit for its own link and fails
+	// messages that are too large with ErrMessageTooLarge.
+	MaxMessageSize() uint64
+
+	// Shutdown closes the connection gracefully, without losing in-flight
+	// messages. New sessions and links fail with ErrShutdown, and remote
+	// requests to open them are refused. Messages buffered for Receive() are
+	// released. Shutdown waits until every sent message has an outcome and the
+	// application has settled every message it received, then closes links,
+	// sessions and the connection in that order.
+	//
+	// If ctx is done first the connection is closed anyway, and Shutdown
+	// returns a ShutdownError that counts the abandoned messages. Senders
+	// may keep sending during Shutdown, which delays it.
+	Shutdown(ctx context.Context) error
+
 	// Disconnect the connection abruptly with an error.
 	Disconnect(error)
 
@@ -100,20 +217,126 @@ type Connection interface {
 	// blocking electron event loop. Normally you would run a loop in a goroutine
 	// to handle incoming types that interest and Accept() those that don't.
 	Incoming() <-chan Incoming
+
+	// SetFrameEventSink reports every frame sent or received on the connection
+	// to sink from now on, nil stops reporting. See FrameEventSink.
+	SetFrameEventSink(sink FrameEventSink)
+
+	// StateChanges returns a channel that reports the state of the connection
+	// as it changes: ConnectionConnecting when it is created, ConnectionOpen
+	// when the remote peer opens it, ConnectionReconnecting when it is lost and
+	// will be re-dialed, and finally ConnectionClosed, after which the channel
+	// is closed. See ConnectionState.
+	StateChanges() <-chan ConnectionState
 }
 
 type connectionSettings struct {
-	user, virtualHost string
-	heartbeat         time.Duration
+	user, virtualHost, saslMech, authUser string
+	containerId, remoteContainerId        string
+	heartbeat, localHeartbeat             time.Duration
+	remoteProperties                      map[amqp.Symbol]interface{}
+	remoteOffered, remoteDesired          []amqp.Symbol
+	remoteMaxFrame, maxFrame              uint32
+	remoteChannelMax, channelMax          uint16
 }
 
-func (c connectionSettings) User() string             { return c.user }
-func (c connectionSettings) VirtualHost() string      { return c.virtualHost }
-func (c connectionSettings) Heartbeat() time.Duration { return c.heartbeat }
+func (c connectionSettings) User() string                  { return c.user }
+func (c connectionSettings) VirtualHost() string           { return c.virtualHost }
+func (c connectionSettings) Heartbeat() time.Duration      { return c.heartbeat }
+func (c connectionSettings) LocalHeartbeat() time.Duration { return c.localHeartbeat }
+func (c connectionSettings) RemoteIdleTimeout() time.Duration {
+	return c.heartbeat
+}
+func (c connectionSettings) LocalIdleTimeout() time.Duration {
+	return 2 * c.localHeartbeat
+}
+func (c connectionSettings) SASLMechanism() string     { return c.saslMech }
+func (c connectionSettings) AuthenticatedUser() string { return c.authUser }
+func (c connectionSettings) ContainerId() string       { return c.containerId }
+func (c connectionSettings) RemoteContainerId() string { return c.remoteContainerId }
+func (c connectionSettings) RemoteProperties() map[amqp.Symbol]interface{} {
+	return c.remoteProperties
+}
+func (c connectionSettings) RemoteOfferedCapabilities() []amqp.Symbol { return c.remoteOffered }
+func (c connectionSettings) RemoteDesiredCapabilities() []amqp.Symbol { return c.remoteDesired }
+func (c connectionSettings) RemoteMaxFrameSize() uint32               { return c.remoteMaxFrame }
+func (c connectionSettings) RemoteChannelMax() uint16                 { return c.remoteChannelMax }
+func (c connectionSettings) MaxFrameSize() uint32                     { return c.maxFrame }
+func (c connectionSettings) ChannelMax() uint16                       { return c.channelMax }
+
+// Called in handler goroutine when the remote peer opens the connection.
+func (c *connectionSettings) remoteOpened(pc proton.Connection, t proton.Transport) {
+	c.remoteContainerId = pc.RemoteContainer()
+	c.remoteProperties = nil
+	if d := pc.RemoteProperties(); !d.IsNil() && !d.Empty() {
+		_ = d.Unmarshal(&c.remoteProperties) // Ignore invalid properties
+	}
+	c.remoteOffered = capabilities(pc.RemoteOfferedCapabilities())
+	c.remoteDesired = capabilities(pc.RemoteDesiredCapabilities())
+	c.remoteMaxFrame = t.RemoteMaxFrame()
+	c.remoteChannelMax = t.RemoteChannelMax()
+	c.channelMax = t.ChannelMax() // Negotiated
+}
 
 // ConnectionOption arguments can be passed when creating a connection to configure it.
 type ConnectionOption func(*connection)
 
+// AnonymousRelay is the connection capability offered by a peer that accepts
+// Anonymous() senders.
+const AnonymousRelay = amqp.Symbol("ANONYMOUS-RELAY")
+
+// ConnectionProperties returns a ConnectionOption that adds properties to the
+// open frame. By default a connection sends "product", "version" and
+// "platform" properties to identify this library, the values in m replace
+// them or add to them.
+func ConnectionProperties(m map[amqp.Symbol]interface{}) ConnectionOption {
+	return func(c *connection) {
+		for k, v := range m {
+			c.properties[k] = v
+		}
+	}
+}
+
+// ConnectionOfferedCapabilities returns a ConnectionOption to set the
+// capabilities offered to the remote peer in the open frame.
+func ConnectionOfferedCapabilities(caps ...amqp.Symbol) ConnectionOption {
+	return func(c *connection) { c.offeredCapabilities = caps }
+}
+
+// ConnectionDesiredCapabilities returns a ConnectionOption to set the
+// capabilities requested from the remote peer in the open frame, for example
+// AnonymousRelay.
+func ConnectionDesiredCapabilities(caps ...amqp.Symbol) ConnectionOption {
+	return func(c *connection) { c.desiredCapabilities = caps }
+}
+
+func defaultProperties() map[amqp.Symbol]interface{} {
+	return map[amqp.Symbol]interface{}{
+		"product":  "qpid-proton-go",
+		"version":  amqp.Version,
+		"platform": fmt.Sprintf("%s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH),
+	}
+}
+
+// Set the properties and capabilities to send in the open frame.
+func (c *connection) setOpenFields() {
+	t := c.engine.Transport()
+	c.maxFrame, c.channelMax = t.MaxFrame(), t.ChannelMax()
+	if err := c.pConnection.Properties().Marshal(c.properties); err != nil {
+		panic(err) // Shouldn't happen
+	}
+	if len(c.offeredCapabilities) > 0 {
+		if err := c.pConnection.OfferedCapabilities().Marshal(c.offeredCapabilities); err != nil {
+			panic(err) // Shouldn't happen
+		}
+	}
+	if len(c.desiredCapabilities) > 0 {
+		if err := c.pConnection.DesiredCapabilities().Marshal(c.desiredCapabilities); err != nil {
+			panic(err) // Shouldn't happen
+		}
+	}
+}
+
 // User returns a ConnectionOption sets the user name for a connection
 func User(user string) ConnectionOption {
 	return func(c *connection) {
@@ -124,6 +347,9 @@ func User(user string) ConnectionOption {
 
 // VirtualHost returns a ConnectionOption to set the AMQP virtual host for the connection.
 // Only applies to outbound client connection.
+//
+// The virtual host is only sent in the open frame, it does not change the
+// address that is dialed or the server name used to verify a TLS certificate.
 func VirtualHost(virtualHost string) ConnectionOption {
 	return func(c *connection) {
 		c.virtualHost = virtualHost
@@ -132,7 +358,8 @@ func VirtualHost(virtualHost string) ConnectionOption {
 }
 
 // Password returns a ConnectionOption to set the password used to establish a
-// connection.  Only applies to outbound client connection.
+// connection.  Only applies to outbound client connection. It is only
+// sent to the remote peer by a SASL mechanism that uses it, such as PLAIN.
 //
 // The connection will erase its copy of the password from memory as soon as it
 // has been used to authenticate. If you are concerned about passwords staying in
@@ -159,6 +386,17 @@ func AllowIncoming() ConnectionOption {
 	return func(c *connection) { c.incoming = make(chan Incoming) }
 }
 
+// LinkPolicy returns a ConnectionOption that authorizes incoming links. policy
+// is called for each link the remote peer attaches, before it is sent to
+// Connection.Incoming(). If policy returns an error the link is refused with
+// that error as the condition, otherwise it is handled as usual.
+//
+// policy is called in the connection's event-loop goroutine, it must return
+// quickly and must not call methods of the connection or its endpoints.
+func LinkPolicy(policy func(IncomingLink) error) ConnectionOption {
+	return func(c *connection) { c.linkPolicy = policy }
+}
+
 // Parent returns a ConnectionOption that associates the Connection with it's Container
 // If not set a connection will create its own default container.
 func Parent(cont Container) ConnectionOption {
@@ -171,30 +409,112 @@ func ContainerId(id string) ConnectionOption {
 	return func(c *connection) { c.container = NewContainer(id).(*container) }
 }
 
+// DefaultSessionOptions returns a ConnectionOption that sets the SessionOptions
+// used to open the DefaultSession(), for example IncomingCapacity().
+func DefaultSessionOptions(opts ...SessionOption) ConnectionOption {
+	return func(c *connection) { c.defaultSessionOpts = opts }
+}
+
 type connection struct {
 	endpoint
 	connectionSettings
 
-	defaultSessionOnce, closeOnce sync.Once
+	defaultSessionOnce, closeOnce, stopOnce sync.Once
 
 	container      *container
-	conn           net.Conn
+	conn           *engineConn
+	tlsConfig      *tls.Config
+	tlsHost        string
+	tlsSubject     string   // Subject of our TLS certificate
+	webSocketURL   *url.URL // Set by DialWebSocket()
+	proxy          func(target *url.URL) (*url.URL, error)
+	dialer         *net.Dialer // Set by WithDialer()
 	server, client bool
+	saslEnabled    bool
 	incoming       chan Incoming
+	linkPolicy     func(IncomingLink) error
 	handler        *handler
+	sessionCount   int32 // Atomic, see SessionCount()
 	engine         *proton.Engine
 	pConnection    proton.Connection
 	mc             amqp.MessageCodec
 
-	defaultSession Session
+	defaultSession     Session
+	defaultSessionOpts []SessionOption
+	echo               echo
+	shutdown           *shutdown // Set by Shutdown(), used in handler goroutine
+	metrics            Metrics
+	logger             Logger // Used in handler and run goroutines, nil means no logging
+	badOption          error  // Set by an option with an invalid value
+	frameSink          frameSink
+	states             stateChanges
+
+	properties                               map[amqp.Symbol]interface{}
+	offeredCapabilities, desiredCapabilities []amqp.Symbol
+
+	senderNames, receiverNames nameRegistry // Names of locally opened links
+
+	// Automatic reconnect, see Reconnect()
+	opts         []ConnectionOption
+	reconnect    *reconnectPolicy
+	redial       func() (net.Conn, error)
+	attempts     int           // Consecutive reconnect attempts, used in run goroutine
+	reconnectErr error         // Why we are reconnecting, guarded by lock
+	replaced     chan struct{} // Closed when the engine is replaced, guarded by lock
+	down         chan struct{} // Closed while reconnecting with no engine running, guarded by lock
+	closing      chan struct{} // Closed by Close or Disconnect to stop reconnecting
+	lock         sync.Mutex    // Guards engine, handler, pConnection and conn while reconnecting
 }
 
 // NewConnection creates a connection with the given options.
 // Options are applied in order.
 func NewConnection(conn net.Conn, opts ...ConnectionOption) (*connection, error) {
+	c, err := newConnection(opts...)
+	if err != nil {
+		_ = conn.Close() // Never used
+		return nil, err
+	}
+	if err = c.start(conn); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// dialer makes the net.Conn for a connection, using settings from its
+// ConnectionOptions such as Proxy().
+type dialer func(ctx context.Context, c *connection) (net.Conn, error)
+
+// dialConnection creates a connection with opts, then calls dial with ctx to
+// make the net.Conn. dial is called without ctx to reconnect.
+func dialConnection(ctx context.Context, address string, dial dialer, opts ...ConnectionOption) (Connection, error) {
+	c, err := newConnection(append(opts, dialed(address, dial))...)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dial(ctx, c)
+	if err != nil {
+		c.engine.Free()
+		return nil, err
+	}
+	if err = c.start(conn); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newConnection creates a connection and applies opts, but does not give it a
+// net.Conn or start it.
+func newConnection(opts ...ConnectionOption) (*connection, error) {
 	c := &connection{
-		conn: conn,
+		opts:       opts,
+		replaced:   make(chan struct{}),
+		down:       make(chan struct{}),
+		closing:    make(chan struct{}),
+		properties: defaultProperties(),
+		metrics:    NopMetrics{},
+		states:     newStateChanges(),
 	}
+	c.conn = newEngineConn(nil, &c.frameSink) // Conn is set by start()
 	c.handler = newHandler(c)
 	var err error
 	c.engine, err = proton.NewEngine(c.conn, c.handler.delegator)
@@ -210,19 +530,41 @@ func NewConnection(conn net.Conn, opts ...ConnectionOption) (*connection, error)
 			c.client = true
 		}
 	}
+	if c.badOption != nil {
+		c.engine.Free()
+		return nil, c.badOption
+	}
+	return c, nil
+}
+
+// start the connection on conn. If it fails conn is closed and the engine freed.
+func (c *connection) start(conn net.Conn) (err error) {
+	defer func() {
+		if err != nil {
+			_ = conn.Close() // Never used
+			c.engine.Free()
+		}
+	}()
 	if c.container == nil {
 		// Generate a random container-id. Not an RFC4122-compliant UUID but probably-unique
 		id := make([]byte, 16)
 		if _, err = rand.Read(id); err != nil {
-			return nil, err
+			return err
 		}
 		c.container = NewContainer(hex.EncodeToString(id)).(*container)
 	}
-	c.pConnection.SetContainer(c.container.Id())
+	c.conn.Conn = c.wrapWebSocket(c.wrapTLS(conn))
+	c.containerId = c.container.Id()
+	c.pConnection.SetContainer(c.containerId)
+	c.setOpenFields()
 	saslConfig.setup(c.engine)
 	c.endpoint.init(c.engine.String())
+	if err = c.container.connections.add(c); err != nil {
+		return err
+	}
+	c.states.send(ConnectionState{ConnectionConnecting, time.Now(), nil})
 	go c.run()
-	return c, nil
+	return nil
 }
 
 func (c *connection) setServer() {
@@ -237,18 +579,54 @@ func (c *connection) setServer() {
 func (c *connection) run() {
 	if !c.server {
 		c.pConnection.Open()
+		c.log(LogDebug, "open sent")
+	}
+	for {
+		if err := handshake(c.conn.Conn); err != nil {
+			c.log(LogError, "handshake failed", "error", err)
+			c.err.Set(err)
+			_ = c.conn.Close() // Engine.Run will stop
+		} else {
+			c.externalSecurity()
+		}
+		_ = c.engine.Run()
+		if c.Error() != nil || !c.canReconnect() {
+			break
+		}
+		// Handler kept the endpoints for reconnect.
+		c.lock.Lock()
+		close(c.down)
+		c.lock.Unlock()
+		if !c.reconnectLoop() {
+			c.handler.shutdown(c.reconnectError())
+			break
+		}
 	}
-	_ = c.engine.Run()
 	if c.incoming != nil {
 		close(c.incoming)
 	}
+	err := c.Error() // Report before Done() is closed
+	if err == Closed {
+		err = nil
+	}
+	c.stateChange(ConnectionClosed, err)
+	c.logError("connection closed", err)
 	_ = c.closed(Closed)
+	c.container.connections.remove(c)
+	c.lock.Lock()
+	close(c.replaced)
+	c.lock.Unlock()
 }
 
+// stop any reconnect in progress.
+func (c *connection) stop() { c.stopOnce.Do(func() { close(c.closing) }) }
+
 func (c *connection) Close(err error) {
 	c.closeOnce.Do(func() {
 		c.err.Set(err)
-		c.engine.Close(err)
+		c.stop()
+		eng, _ := c.current()
+		eng.Close(err)
 		c.mc.Close()
 	})
 
@@ -256,16 +634,21 @@ func (c *connection) Close(err error) {
 
 func (c *connection) Disconnect(err error) {
 	c.err.Set(err)
-	c.engine.Disconnect(err)
+	c.stop()
+	eng, _ := c.current()
+	eng.Disconnect(err)
 }
 
 func (c *connection) Session(opts ...SessionOption) (Session, error) {
 	var s Session
-	err := c.engine.InjectWait(func() error {
+	err := c.injectWait(func() error {
 		if c.Error() != nil {
 			return c.Error()
 		}
-		pSession, err := c.engine.Connection().Session()
+		if c.shutdown != nil {
+			return ErrShutdown
+		}
+		pSession, err := c.pConnection.Session()
 		if err == nil {
 			pSession.Open()
 			if err == nil {
@@ -279,9 +662,35 @@ func (c *connection) Session(opts ...SessionOption) (Session, error) {
 
 func (c *connection) Container() Container { return c.container }
 
+func (c *connection) MaxMessageSize() (max uint64) {
+	_ = c.injectWait(func() error {
+		for l := range c.handler.links {
+			if m := l.RemoteMaxMessageSize(); m > 0 && (max == 0 || m < max) {
+				max = m
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (c *connection) Sessions() (sessions []Session) {
+	_ = c.injectWait(func() error {
+		for ps, s := range c.handler.sessions {
+			if s.Error() == nil && ps.State().LocalActive() {
+				sessions = append(sessions, s)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (c *connection) SessionCount() int { return int(atomic.LoadInt32(&c.sessionCount)) }
+
 func (c *connection) DefaultSession() (s Session, err error) {
 	c.defaultSessionOnce.Do(func() {
-		c.defaultSession, err = c.Session()
+		c.defaultSession, err = c.Session(c.defaultSessionOpts...)
 	})
 	if err == nil {
 		err = c.Error()
@@ -307,6 +716,14 @@ func (c *connection) Receiver(opts ...LinkOption) (Receiver, error) {
 
 func (c *connection) Connection() Connection { return c }
 
+// linkNames returns the registry of local sender or receiver link names.
+func (c *connection) linkNames(isSender bool) *nameRegistry {
+	if isSender {
+		return &c.senderNames
+	}
+	return &c.receiverNames
+}
+
 func (c *connection) Wait() error { return c.WaitTimeout(Forever) }
 func (c *connection) WaitTimeout(timeout time.Duration) error {
 	_, err := timedReceive(c.done, timeout)
@@ -356,7 +773,88 @@ func (in *IncomingConnection) Accept() Endpoint {
 	return in.AcceptConnection()
 }
 
-func sasl(c *connection) proton.SASL { return c.engine.Transport().SASL() }
+func sasl(c *connection) proton.SASL {
+	c.saslEnabled = true
+	return c.engine.Transport().SASL()
+}
+
+// Called in the engine goroutine when the connection opens, record SASL results.
+func (c *connection) authenticated(t proton.Transport) {
+	if !c.saslEnabled {
+		return
+	}
+	s := t.SASL()
+	c.saslMech = s.Mech()
+	if s.Outcome() == proton.SASLOk {
+		c.authUser = s.User()
+		if c.authUser == "" && c.saslMech == "EXTERNAL" {
+			c.authUser = c.tlsSubject
+		}
+	}
+}
+
+// saslFailed returns the mechanism and true if the remote peer refused SASL
+// authentication. The SASL layer reports the failure as a transport error
+// when it next writes, which it does not do if the connection closes first.
+func (c *connection) saslFailed(t proton.Transport) (string, bool) {
+	if !c.saslEnabled {
+		return "", false
+	}
+	s := t.SASL()
+	outcome := s.Outcome()
+	return s.Mech(), outcome != proton.SASLNone && outcome != proton.SASLOk
+}
+
+// AuthError is the Connection error if SASL authentication fails, as opposed
+// to a network or protocol error.
+type AuthError struct {
+	// Err is the amqp.Error with name amqp.UnauthorizedAccess set by the SASL layer.
+	Err error
+}
+
+func (e AuthError) Error() string { return fmt.Sprintf("authentication failed: %v", e.Err) }
+
+// Unwrap returns Err, for errors.Is() and errors.As().
+func (e AuthError) Unwrap() error { return e.Err }
+
+// IdleTimeoutError is the Connection error if the connection was closed
+// because no frames arrived within the idle-timeout, as opposed to a network
+// failure or an error detected by the peer.
+type IdleTimeoutError struct {
+	// Local is true if we timed out the remote peer: nothing was received for
+	// our LocalIdleTimeout(). It is false if the remote peer closed the
+	// connection because we did not send within its RemoteIdleTimeout().
+	Local bool
+	// Err is the amqp.Error with name amqp.ResourceLimitExceeded.
+	Err error
+}
+
+func (e IdleTimeoutError) Error() string {
+	if e.Local {
+		return fmt.Sprintf("remote peer idle: %v", e.Err)
+	}
+	return fmt.Sprintf("timed out by remote peer: %v", e.Err)
+}
+
+// Unwrap returns Err, so errors.Is() and amqp.MakeError() see the
+// amqp.ResourceLimitExceeded condition.
+func (e IdleTimeoutError) Unwrap() error { return e.Err }
+
+// Is matches ErrIdleTimeout, for errors.Is() in Go 1.13 or later.
+func (e IdleTimeoutError) Is(target error) bool { return target == ErrIdleTimeout }
+
+// ErrIdleTimeout matches any IdleTimeoutError with errors.Is() in Go 1.13 or
+// later.
+var ErrIdleTimeout = fmt.Errorf("idle timeout")
+
+// idleTimeout returns an IdleTimeoutError if err is the error proton sends
+// when an idle-timeout expires, err otherwise.
+func idleTimeout(err error, local bool) error {
+	if e, ok := err.(amqp.Error); ok && e.Name == amqp.ResourceLimitExceeded && strings.Contains(e.Description, "idle-timeout") {
+		return IdleTimeoutError{local, err}
+	}
+	return err
+}
 
 // SASLEnable returns a ConnectionOption that enables SASL authentication.
 // Only required if you don't set any other SASL options.
@@ -395,13 +893,76 @@ func SASLAllowInsecure(b bool) ConnectionOption {
 	return func(c *connection) { sasl(c).SetAllowInsecureMechs(b) }
 }
 
+// SASLPlainAuthenticator returns a ConnectionOption for a Server() connection
+// that authenticates clients by calling verify, instead of using the SASL
+// library and its configuration. Clients must authenticate.
+//
+// The server offers the PLAIN mechanism, verify is called with the client's
+// user name and password. PLAIN is only offered on a TLS connection unless
+// SASLAllowInsecure(true) is set. If the client has a TLS certificate the
+// server also offers EXTERNAL, verify is called with the certificate subject
+// and an empty password.
+//
+// If verify returns an error the client is refused with a SASL authentication
+// failure, otherwise the user name is the connection's User() and
+// AuthenticatedUser(). verify is called in the connection's event-loop
+// goroutine, it must not block. The password is erased when verify returns, it
+// must not be retained.
+func SASLPlainAuthenticator(verify func(user string, password []byte) error) ConnectionOption {
+	return func(c *connection) {
+		if !c.server {
+			c.badOption = fmt.Errorf("SASLPlainAuthenticator is only for Server() connections")
+			return
+		}
+		t := c.engine.Transport()
+		sasl(c)
+		t.RequireAuth(true)
+		if err := t.SetSASLAuthenticator(func(_, user string, password []byte) error { return verify(user, password) }); err != nil {
+			c.badOption = err
+		}
+	}
+}
+
 // Heartbeat returns a ConnectionOption that requests the maximum delay
 // between sending frames for the remote peer. If we don't receive any frames
-// within 2*delay we will close the connection.
+// within 2*delay we will close the connection with an IdleTimeoutError.
+//
+// Frames are sent automatically to satisfy the delay requested by the remote
+// peer, see ConnectionSettings.Heartbeat()
 //
 func Heartbeat(delay time.Duration) ConnectionOption {
 	// Proton-C divides the idle-timeout by 2 before sending, so compensate.
-	return func(c *connection) { c.engine.Transport().SetIdleTimeout(2 * delay) }
+	return func(c *connection) {
+		c.localHeartbeat = delay
+		c.engine.Transport().SetIdleTimeout(2 * delay)
+	}
+}
+
+// minMaxFrameSize is the smallest max-frame-size allowed by the AMQP spec.
+const minMaxFrameSize = 512
+
+// MaxFrameSize returns a ConnectionOption that sets the largest frame, in
+// bytes, that we accept from the remote peer. A large message is sent to us in
+// frames of this size, so raising it means fewer frames and less overhead.
+//
+// 0 means no limit, the default. NewConnection() and the Dial functions return
+// an error for a non-zero size less than the AMQP minimum of 512 bytes.
+func MaxFrameSize(size uint32) ConnectionOption {
+	return func(c *connection) {
+		if size != 0 && size < minMaxFrameSize {
+			c.badOption = fmt.Errorf("max-frame-size %d is less than the minimum %d", size, minMaxFrameSize)
+			return
+		}
+		c.engine.Transport().SetMaxFrame(size)
+	}
+}
+
+// ChannelMax returns a ConnectionOption that sets the highest session channel
+// number we allow, so at most n+1 sessions can be open at once. Some brokers
+// refuse connections with a channel-max above their own limit. The value is
+// also capped by the proton library, see ConnectionSettings.ChannelMax().
+func ChannelMax(n uint16) ConnectionOption {
+	return func(c *connection) { c.engine.Transport().SetChannelMax(n) }
 }
 
 type saslConfigState struct {
@@ -473,19 +1034,78 @@ func SASLExtended() bool { return proton.SASLExtended() }
 // Dial is shorthand for using net.Dial() then NewConnection()
 // See net.Dial() for the meaning of the network, address arguments.
 func Dial(network, address string, opts ...ConnectionOption) (c Connection, err error) {
-	conn, err := net.Dial(network, address)
-	if err == nil {
-		c, err = NewConnection(conn, opts...)
-	}
-	return
+	return DialWithDialer(nil, network, address, opts...)
 }
 
 // DialWithDialer is shorthand for using dialer.Dial() then NewConnection()
 // See net.Dial() for the meaning of the network, address arguments.
+// A nil dialer is the same as Dial(), using the WithDialer() option if set.
 func DialWithDialer(dialer *net.Dialer, network, address string, opts ...ConnectionOption) (c Connection, err error) {
-	conn, err := dialer.Dial(network, address)
-	if err == nil {
-		c, err = NewConnection(conn, opts...)
+	return dialConnection(context.Background(), address, func(ctx context.Context, c *connection) (net.Conn, error) {
+		return c.dial(ctx, dialer, network, address)
+	}, opts...)
+}
+
+// DialContext is like Dial() but ctx limits the time to dial and open the
+// connection, including any proxy tunnel, TLS and SASL handshakes. If ctx is
+// done before the remote peer opens the connection, the connection is
+// disconnected and DialContext returns ctx.Err(). ctx has no effect once the
+// connection is open, including on Reconnect().
+func DialContext(ctx context.Context, network, address string, opts ...ConnectionOption) (Connection, error) {
+	c, err := dialConnection(ctx, address, func(ctx context.Context, c *connection) (net.Conn, error) {
+		return c.dial(ctx, nil, network, address)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-c.(*connection).active:
+		if err = c.Error(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case <-ctx.Done():
+		c.Disconnect(ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+// WithDialer returns a ConnectionOption to dial with d instead of a default
+// net.Dialer, for example to set TCP keep-alive or the local address. It is
+// used by Dial(), DialContext(), DialURL(), DialWebSocket() and
+// Container.Dial(), and to reconnect with Reconnect(). A dialer passed to
+// DialWithDialer() takes precedence.
+func WithDialer(d *net.Dialer) ConnectionOption {
+	return func(c *connection) { c.dialer = d }
+}
+
+// DialURL connects to an AMQP URL, see amqp.ParseURL() for the URL format.
+//
+// An "amqps" URL connects with TLS, using the configuration from the TLS()
+// option if there is one, or a default tls.Config otherwise.
+func DialURL(rawURL string, opts ...ConnectionOption) (c Connection, err error) {
+	u, err := amqp.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "amqps" {
+		opts = append([]ConnectionOption{TLS(&tls.Config{})}, opts...)
+	}
+	return Dial("tcp", u.Host, opts...)
+}
+
+// dialed returns a ConnectionOption used by the Dial functions to remember the
+// address for TLS verification and how to dial it, including for Reconnect().
+// The host is also the default VirtualHost().
+func dialed(address string, dial dialer) ConnectionOption {
+	return func(c *connection) {
+		c.redial = func() (net.Conn, error) { return dial(context.Background(), c) }
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			c.tlsHost = host
+			if c.virtualHost == "" {
+				c.virtualHost = host
+			}
+			c.pConnection.SetHostname(c.virtualHost)
+		}
 	}
-	return
 }
@@ -20,9 +20,13 @@ under the License.
 package electron
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net"
 	"strconv"
 	"sync/atomic"
+	"time"
 
 	"github.com/apache/qpid-proton/go/pkg/proton"
 )
@@ -42,6 +46,12 @@ type Container interface {
 	// Connection creates a connection associated with this container.
 	Connection(conn net.Conn, opts ...ConnectionOption) (Connection, error)
 
+	// ConnectionFromConn creates a connection associated with this container
+	// on a connection established by other means: any byte stream, such as a
+	// net.Pipe(), a unix socket or an SSH channel. If conn is not a net.Conn,
+	// it has no addresses or deadlines. The connection cannot Reconnect().
+	ConnectionFromConn(conn io.ReadWriteCloser, opts ...ConnectionOption) (Connection, error)
+
 	// Dial is shorthand for
 	//     conn, err := net.Dial(); c, err := Connection(conn, opts...)
 	// See net.Dial() for the meaning of the network, address arguments.
@@ -51,13 +61,20 @@ type Container interface {
 	//     conn, err := l.Accept(); c, err := Connection(conn, append(opts, Server()...)
 	Accept(l net.Listener, opts ...ConnectionOption) (Connection, error)
 
+	// Shutdown calls Connection.Shutdown for every open connection in parallel,
+	// and makes new connections fail with ErrShutdown. If ctx is done before
+	// the connections finish their in-flight work, the ShutdownError adds up
+	// the work abandoned on all connections.
+	Shutdown(ctx context.Context) error
+
 	// String returns Id()
 	String() string
 }
 
 type container struct {
-	id         string
-	tagCounter uint64
+	id          string
+	tagCounter  uint64
+	connections connections
 }
 
 func (cont *container) nextTag() string {
@@ -89,12 +106,35 @@ func (cont *container) Connection(conn net.Conn, opts ...ConnectionOption) (Conn
 	return NewConnection(conn, append(opts, Parent(cont))...)
 }
 
-func (cont *container) Dial(network, address string, opts ...ConnectionOption) (c Connection, err error) {
-	conn, err := net.Dial(network, address)
-	if err == nil {
-		c, err = cont.Connection(conn, opts...)
+func (cont *container) ConnectionFromConn(conn io.ReadWriteCloser, opts ...ConnectionOption) (Connection, error) {
+	nc, ok := conn.(net.Conn)
+	if !ok {
+		nc = streamConn{conn}
 	}
-	return
+	return cont.Connection(nc, opts...)
+}
+
+// streamConn is a net.Conn for a plain byte stream.
+type streamConn struct{ io.ReadWriteCloser }
+
+// streamAddr is the address of both ends of a streamConn.
+type streamAddr struct{}
+
+func (streamAddr) Network() string { return "stream" }
+func (streamAddr) String() string  { return "stream" }
+
+var errNoDeadline = errors.New("deadlines are not supported on a stream")
+
+func (streamConn) LocalAddr() net.Addr              { return streamAddr{} }
+func (streamConn) RemoteAddr() net.Addr             { return streamAddr{} }
+func (streamConn) SetDeadline(time.Time) error      { return errNoDeadline }
+func (streamConn) SetReadDeadline(time.Time) error  { return errNoDeadline }
+func (streamConn) SetWriteDeadline(time.Time) error { return errNoDeadline }
+
+func (cont *container) Dial(network, address string, opts ...ConnectionOption) (c Connection, err error) {
+	return dialConnection(context.Background(), address, func(ctx context.Context, c *connection) (net.Conn, error) {
+		return c.dial(ctx, nil, network, address)
+	}, append(opts, Parent(cont))...)
 }
 
 func (cont *container) Accept(l net.Listener, opts ...ConnectionOption) (c Connection, err error) {
@@ -0,0 +1,84 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+// FlowPolicy decides when a pre-fetch receiver issues more credit, and how
+// much. Set it with the CreditPolicy() option.
+//
+// Credit is called in the connection's event-loop goroutine when the receiver
+// opens, when its pre-fetch window changes and each time the application
+// receives a message. It must return quickly and must not block.
+type FlowPolicy interface {
+	// Credit returns the credit to issue. window is the pre-fetch window,
+	// outstanding is the credit already issued plus the messages buffered and
+	// not yet received by the application. Credit is limited to the space
+	// left in the receiver's buffer.
+	Credit(window, outstanding int) int
+}
+
+// FlowPolicyFunc is a function that implements FlowPolicy.
+type FlowPolicyFunc func(window, outstanding int) int
+
+func (f FlowPolicyFunc) Credit(window, outstanding int) int { return f(window, outstanding) }
+
+// CreditPolicy returns a LinkOption that enables pre-fetch and issues credit
+// according to p. The default tops the window up each time the application
+// receives a message, which sends a flow frame per message. Not relevant for a
+// sender.
+func CreditPolicy(p FlowPolicy) LinkOption {
+	return func(l *linkSettings) {
+		l.prefetch = true
+		l.creditPolicy = p
+	}
+}
+
+// TopUpWhenBelow returns a FlowPolicy that waits until fewer than n messages
+// are outstanding, then tops up to the full pre-fetch window. n < 1 is treated
+// as 1: credit is issued only when none is left.
+func TopUpWhenBelow(n int) FlowPolicy {
+	if n < 1 {
+		n = 1
+	}
+	return FlowPolicyFunc(func(window, outstanding int) int {
+		if outstanding < n {
+			return window - outstanding
+		}
+		return 0
+	})
+}
+
+// BatchedEveryN returns a FlowPolicy that issues credit in batches of at least
+// n, when n or more messages are missing from the pre-fetch window. It also
+// tops up when nothing is outstanding, so a window smaller than n can't stall.
+func BatchedEveryN(n int) FlowPolicy {
+	return FlowPolicyFunc(func(window, outstanding int) int {
+		if need := window - outstanding; need >= n || outstanding == 0 {
+			return need
+		}
+		return 0
+	})
+}
+
+// Manual returns a FlowPolicy that never issues credit, the application
+// issues it with Receiver.Flow(). ReceiveBatch() still issues the credit it
+// needs for the batch.
+func Manual() FlowPolicy {
+	return FlowPolicyFunc(func(window, outstanding int) int { return 0 })
+}
@@ -0,0 +1,69 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"testing"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// receiveFlows receives n messages on a receiver with a pre-fetch window of 10
+// and the given options, returns the credit of each flow it issued.
+func receiveFlows(t *testing.T, n int, flow int, opts ...LinkOption) []int {
+	m := newTestMetrics()
+	p := newPipe(t, []ConnectionOption{ConnectionMetrics(m)}, nil)
+	defer func() { p.close() }()
+	rcv, snd := p.receiver(append([]LinkOption{PrefetchWindow(10)}, opts...)...)
+	if flow > 0 {
+		test.FatalIfN(1, t, rcv.Flow(flow))
+	}
+	for i := 0; i < n; i++ {
+		snd.SendForget(amqp.NewMessageWith(i))
+	}
+	for i := 0; i < n; i++ {
+		rm, err := rcv.Receive()
+		test.FatalIfN(1, t, err)
+		test.ErrorIfN(1, t, test.Differ(int64(i), rm.Message.Body()))
+		rcv.Credit() // Wait for the flow injected by Receive
+	}
+	var flows []int
+	m.get(func() { flows = m.flows[rcv] })
+	return flows
+}
+
+func TestCreditPolicy(t *testing.T) {
+	// The default tops up after every message.
+	test.ErrorIf(t, test.Differ([]int{10, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, receiveFlows(t, 10, 0)))
+	test.ErrorIf(t, test.Differ([]int{10, 6}, receiveFlows(t, 10, 0, CreditPolicy(TopUpWhenBelow(5)))))
+	test.ErrorIf(t, test.Differ([]int{10, 4, 4}, receiveFlows(t, 10, 0, CreditPolicy(BatchedEveryN(4)))))
+	test.ErrorIf(t, test.Differ([]int{3}, receiveFlows(t, 3, 3, CreditPolicy(Manual()))))
+	f := FlowPolicyFunc(func(window, outstanding int) int { return 2 })
+	test.ErrorIf(t, test.Differ([]int{2, 2, 2}, receiveFlows(t, 2, 0, CreditPolicy(f))))
+}
+
+func TestBatchedEveryNSmallWindow(t *testing.T) {
+	p := BatchedEveryN(20)
+	test.ErrorIf(t, test.Differ(10, p.Credit(10, 0))) // Never stall
+	test.ErrorIf(t, test.Differ(0, p.Credit(10, 1)))
+	test.ErrorIf(t, test.Differ(1, TopUpWhenBelow(0).Credit(1, 0)))
+	test.ErrorIf(t, test.Differ(0, TopUpWhenBelow(0).Credit(1, 1)))
+}
@@ -0,0 +1,155 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// Hides the net.Conn methods of a stream.
+type plainStream struct{ io.ReadWriteCloser }
+
+func TestConnectionFromConn(t *testing.T) {
+	cli, srv := net.Pipe()
+	sc, err := NewContainer("server").ConnectionFromConn(plainStream{srv}, Server(), AllowIncoming())
+	test.FatalIf(t, err)
+	cc, err := NewContainer("client").ConnectionFromConn(plainStream{cli})
+	test.FatalIf(t, err)
+	p := newPair(t, cc, sc)
+	defer p.close()
+
+	test.FatalIf(t, cc.Sync())
+	test.ErrorIf(t, test.Differ("server", cc.RemoteContainerId()))
+	s, r := p.sender()
+	webSocketSend(t, s, r, "stream")
+
+	// A net.Conn is used as-is.
+	cli, srv = net.Pipe()
+	c, err := NewContainer("").ConnectionFromConn(cli)
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(cli, c.(*connection).conn.Conn))
+	c.Disconnect(nil)
+	srv.Close()
+}
+
+func TestWithDialer(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	test.FatalIf(t, err)
+	defer l.Close()
+	servers, rcvs := make(chan Connection, 10), make(chan Receiver, 10)
+	go reconnectServer(l, servers, rcvs)
+
+	var dials int32
+	d := &net.Dialer{Control: func(network, address string, _ syscall.RawConn) error {
+		atomic.AddInt32(&dials, 1)
+		return nil
+	}}
+	events := make(chan ReconnectEvent, 10)
+	c, err := Dial("tcp", l.Addr().String(), WithDialer(d),
+		Reconnect(BackoffExponential(time.Millisecond, 10*time.Millisecond, 0.5), ReconnectEvents(events)))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	test.FatalIf(t, c.Sync())
+	test.ErrorIf(t, test.Differ(int32(1), atomic.LoadInt32(&dials)))
+
+	// Reconnect uses the same dialer.
+	(<-servers).Disconnect(fmt.Errorf("drop"))
+	<-events
+	if e := <-events; !e.Reconnected {
+		t.Errorf("want reconnected event got %v", e)
+	}
+	test.ErrorIf(t, test.Differ(int32(2), atomic.LoadInt32(&dials)))
+
+	// An explicit DialWithDialer dialer takes precedence.
+	c2, err := DialWithDialer(&net.Dialer{}, "tcp", l.Addr().String(), WithDialer(d))
+	test.FatalIf(t, err)
+	c2.Close(nil)
+	test.ErrorIf(t, test.Differ(int32(2), atomic.LoadInt32(&dials)))
+}
+
+func TestDialContext(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	test.FatalIf(t, err)
+	defer l.Close()
+	servers, rcvs := make(chan Connection, 10), make(chan Receiver, 10)
+	go reconnectServer(l, servers, rcvs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := DialContext(ctx, "tcp", l.Addr().String())
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	// The connection is already open.
+	select {
+	case <-c.(*connection).active:
+	default:
+		t.Error("connection not open")
+	}
+	s, err := c.Sender(Target("ctx"))
+	test.FatalIf(t, err)
+	webSocketSend(t, s, <-rcvs, "ctx")
+
+	// A cancelled context fails before dialing.
+	cancelled, cancel2 := context.WithCancel(context.Background())
+	cancel2()
+	if _, err = DialContext(cancelled, "tcp", l.Addr().String()); err == nil {
+		t.Error("want error dialing with a cancelled context")
+	}
+}
+
+func TestDialContextOpenTimeout(t *testing.T) {
+	// A peer that accepts the socket but never opens the AMQP connection.
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	test.FatalIf(t, err)
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	c, err := DialContext(ctx, "tcp", l.Addr().String())
+	if err != context.DeadlineExceeded || c != nil {
+		t.Errorf("want DeadlineExceeded got %v, %v", c, err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("DialContext took %v", d)
+	}
+	// The half-open connection was disconnected.
+	conn := <-accepted
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.Copy(ioutil.Discard, conn)
+	test.ErrorIf(t, err)
+}
@@ -0,0 +1,166 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+)
+
+// managementNode is the address of the AMQP management node used by Echo()
+const managementNode = "$management"
+
+// echo holds the links to the management node, created on the first call to Echo()
+type echo struct {
+	lock    sync.Mutex
+	links   *echoLinks
+	next    uint64
+	waiting map[uint64]chan struct{}
+}
+
+// echoLinks is one attempt to attach the management links. If it fails the
+// next call to Echo() makes a new attempt.
+type echoLinks struct {
+	ready   chan struct{} // Closed when links are attached or err is set
+	err     error
+	snd     Sender
+	replyTo string
+}
+
+// failed is true if the attempt is finished and did not attach the links.
+func (l *echoLinks) failed() bool {
+	select {
+	case <-l.ready:
+		return l.err != nil
+	default:
+		return false
+	}
+}
+
+// start returns the current attempt to attach the links, starting a new one
+// if there is none or the last one failed.
+func (e *echo) start(c *connection) *echoLinks {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.links == nil || e.links.failed() {
+		if e.waiting == nil {
+			e.waiting = make(map[uint64]chan struct{})
+		}
+		e.links = &echoLinks{ready: make(chan struct{})}
+		go e.attach(c, e.links)
+	}
+	return e.links
+}
+
+func (c *connection) Echo(ctx context.Context) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	e := &c.echo
+	l := e.start(c)
+	select {
+	case <-l.ready:
+		if l.err != nil {
+			return 0, l.err
+		}
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	e.lock.Lock()
+	e.next++
+	id := e.next
+	replied := make(chan struct{})
+	e.waiting[id] = replied
+	e.lock.Unlock()
+	defer func() {
+		e.lock.Lock()
+		delete(e.waiting, id)
+		e.lock.Unlock()
+	}()
+
+	m := amqp.NewMessage()
+	m.SetMessageId(id)
+	m.SetReplyTo(l.replyTo)
+	m.SetApplicationProperties(map[string]interface{}{
+		"operation": "GET-MGMT-NODES",
+		"type":      "org.amqp.management",
+		"name":      "self",
+	})
+	start := time.Now()
+	go l.snd.SendAsyncContext(ctx, m, nil, nil) // Don't block without credit, gives up with ctx.
+	select {
+	case <-replied:
+		return time.Since(start), nil
+	case <-l.snd.Done():
+		return 0, l.snd.Error()
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// attach the management links for l, called in its own goroutine.
+func (e *echo) attach(c *connection, l *echoLinks) {
+	defer close(l.ready)
+	var sn Session
+	var rcv Receiver
+	if sn, l.err = c.Session(); l.err != nil {
+		return
+	}
+	if rcv, l.err = sn.Receiver(DynamicReceiver(), Prefetch(true), Capacity(10)); l.err != nil {
+		return
+	}
+	if l.err = rcv.Sync(); l.err != nil {
+		return
+	}
+	if l.replyTo, l.err = rcv.(*receiver).remoteSource(); l.err != nil {
+		return
+	}
+	if l.snd, l.err = sn.Sender(Target(managementNode)); l.err == nil {
+		l.err = l.snd.Sync()
+	}
+	if l.err != nil {
+		rcv.Close(nil)
+		return
+	}
+	go e.replies(rcv)
+}
+
+// replies wakes the caller waiting for each reply until rcv closes.
+func (e *echo) replies(rcv Receiver) {
+	for {
+		rm, err := rcv.Receive()
+		if err != nil {
+			return
+		}
+		_ = rm.Accept()
+		if id, ok := rm.Message.CorrelationId().(uint64); ok {
+			e.lock.Lock()
+			if replied, ok := e.waiting[id]; ok {
+				close(replied)
+				delete(e.waiting, id)
+			}
+			e.lock.Unlock()
+		}
+	}
+}
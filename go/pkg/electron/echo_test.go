@@ -0,0 +1,125 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+func TestEcho(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	p.prefetch = true
+	c := p.client.Connection()
+
+	go func() { // Management node, replies to 2 requests then goes quiet.
+		replies := <-p.schan
+		requests := <-p.rchan
+		for i := 0; i < 2; i++ {
+			rm, err := requests.Receive()
+			if err != nil {
+				return
+			}
+			test.ErrorIf(t, test.Differ(managementNode, requests.Target()))
+			_ = rm.Accept()
+			reply := amqp.NewMessage()
+			reply.SetCorrelationId(rm.Message.MessageId())
+			replies.SendForget(reply)
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		d, err := c.Echo(context.Background())
+		test.FatalIf(t, err)
+		if d <= 0 {
+			t.Errorf("want positive duration, got %v", d)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.Echo(ctx)
+	test.ErrorIf(t, test.Differ(context.Canceled, err))
+
+	// No reply, returns when the context expires.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.Echo(ctx)
+	test.ErrorIf(t, test.Differ(context.DeadlineExceeded, err))
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Echo took %v after context expired", d)
+	}
+}
+
+// A failed attach of the management links is tried again by the next Echo().
+func TestEchoRetry(t *testing.T) {
+	cli, srv := net.Pipe()
+	sc, _ := NewConnection(srv, Server())
+	defer sc.Close(nil)
+	cc, _ := NewConnection(cli)
+	defer cc.Close(nil)
+
+	go func() { // Rejects the first reply link, then replies to every request.
+		rejected := false
+		var replies Sender
+		for in := range sc.Incoming() {
+			switch in := in.(type) {
+			case *IncomingSender:
+				if !rejected {
+					rejected = true
+					in.Reject(amqp.Errorf(amqp.NotAllowed, "not yet"))
+					break
+				}
+				replies = in.Accept().(Sender)
+			case *IncomingReceiver:
+				in.SetPrefetch(true)
+				requests := in.Accept().(Receiver)
+				go func(replies Sender) {
+					for {
+						rm, err := requests.Receive()
+						if err != nil {
+							return
+						}
+						_ = rm.Accept()
+						reply := amqp.NewMessage()
+						reply.SetCorrelationId(rm.Message.MessageId())
+						replies.SendForget(reply)
+					}
+				}(replies)
+			default:
+				in.Accept()
+			}
+		}
+	}()
+
+	_, err := cc.Echo(context.Background())
+	if err == nil {
+		t.Fatal("want error from rejected link")
+	}
+	_, err = cc.Echo(context.Background())
+	test.ErrorIf(t, err)
+}
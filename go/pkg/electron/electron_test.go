@@ -20,12 +20,20 @@ under the License.
 package electron
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/apache/qpid-proton/go/pkg/amqp"
 	"github.com/apache/qpid-proton/go/pkg/internal/test"
+	"github.com/apache/qpid-proton/go/pkg/proton"
 )
 
 // Send a message one way with a client sender and server receiver, verify ack.
@@ -101,6 +109,168 @@ func TestClientReceiver(t *testing.T) {
 	}
 }
 
+func TestRejectWith(t *testing.T) {
+	sink := make(chanSink, 100)
+	p := newPipe(t, []ConnectionOption{ConnectionFrameEvents(sink)}, nil)
+	p.prefetch = true
+	defer func() { p.close() }()
+	snd, rcv := p.sender(Target("reject"))
+	ack := snd.SendWaitable(amqp.NewMessage())
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	test.FatalIf(t, rm.RejectWith(amqp.Errorf(amqp.DecodeError, "bad data")))
+	out := <-ack
+	test.ErrorIf(t, test.Differ(Rejected, out.Status))
+	test.ErrorIf(t, test.Differ(amqp.Errorf(amqp.DecodeError, "bad data"), out.Error))
+
+	// The info map is sent in the rejected outcome's error.
+	info := map[amqp.Symbol]interface{}{"x-opt-reason": "poison"}
+	ack = snd.SendWaitable(amqp.NewMessage())
+	rm, err = rcv.Receive()
+	test.FatalIf(t, err)
+	test.FatalIf(t, rm.RejectWith(&amqp.Error{Name: "x:dead-letter", Description: "poison message", Info: &info}))
+	out = <-ack
+	test.ErrorIf(t, test.Differ(Rejected, out.Status))
+	if e, ok := out.Error.(amqp.Error); !ok || e.Info == nil {
+		t.Errorf("no info in %#v", out.Error)
+	} else {
+		test.ErrorIf(t, test.Differ(info, *e.Info))
+	}
+	var fields amqp.List
+	for n := 0; n < 2; { // Second disposition
+		if e := sink.next(Inbound); performativeName(e.performative) == "disposition" {
+			fields = e.performative.(amqp.Described).Value.(amqp.List)
+			n++
+		}
+	}
+	condition := amqp.Described{Descriptor: uint64(0x1d), Value: amqp.List{
+		amqp.Symbol("x:dead-letter"), "poison message", amqp.Map{amqp.Symbol("x-opt-reason"): "poison"}}}
+	test.ErrorIf(t, test.Differ(amqp.Described{Descriptor: uint64(0x25), Value: amqp.List{condition}}, fields[4]))
+
+	// A plain Go error is mapped by amqp.MakeCondition.
+	ack = snd.SendWaitable(amqp.NewMessage())
+	rm, err = rcv.Receive()
+	test.FatalIf(t, err)
+	test.FatalIf(t, rm.RejectWith(fmt.Errorf("oops")))
+	out = <-ack
+	test.ErrorIf(t, test.Differ(amqp.Errorf(amqp.InternalError, "oops"), out.Error))
+}
+
+func TestModify(t *testing.T) {
+	sink := make(chanSink, 100)
+	p := newPipe(t, []ConnectionOption{ConnectionFrameEvents(sink)}, nil)
+	p.prefetch = true
+	defer func() { p.close() }()
+	snd, rcv := p.sender(Target("modify"))
+	ack := snd.SendWaitable(amqp.NewMessage())
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	annotations := map[amqp.Symbol]interface{}{"x-opt-reason": "poison"}
+	test.FatalIf(t, rm.Modify(true, true, annotations))
+	out := <-ack
+	test.ErrorIf(t, test.Differ(Released, out.Status))
+	test.ErrorIf(t, test.Differ(&ModifiedState{true, true, annotations}, out.Modified))
+
+	// The disposition frame carries the described modified list.
+	e := sink.next(Inbound)
+	for performativeName(e.performative) != "disposition" {
+		e = sink.next(Inbound)
+	}
+	fields := e.performative.(amqp.Described).Value.(amqp.List)
+	test.ErrorIf(t, test.Differ(amqp.Described{Descriptor: uint64(0x27), Value: amqp.List{true, true, amqp.Map{amqp.Symbol("x-opt-reason"): "poison"}}}, fields[4]))
+
+	// Release without modification has no Modified fields.
+	ack = snd.SendWaitable(amqp.NewMessage())
+	rm, err = rcv.Receive()
+	test.FatalIf(t, err)
+	test.FatalIf(t, rm.Release())
+	out = <-ack
+	test.ErrorIf(t, test.Differ(Released, out.Status))
+	test.ErrorIf(t, test.Differ((*ModifiedState)(nil), out.Modified))
+}
+
+func TestOutcomeState(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	p.prefetch = true
+	defer func() { p.close() }()
+	snd, rcv := p.sender(Target("state"))
+	info := map[amqp.Symbol]interface{}{"x-vendor-retry-after": int64(30)}
+	annotations := map[amqp.Symbol]interface{}{"x-opt-reason": "poison"}
+	for _, x := range []struct {
+		settle func(*ReceivedMessage) error
+		want   DeliveryState
+	}{
+		{(*ReceivedMessage).Accept, AcceptedState{}},
+		{(*ReceivedMessage).Reject, RejectedState{}},
+		{func(rm *ReceivedMessage) error {
+			// Send a condition with vendor-specific info.
+			return rm.settle(func(d proton.Delivery) {
+				d.Local().Condition().SetError(amqp.Error{Name: "vendor:busy", Description: "try later", Info: &info})
+				d.Update(proton.Rejected)
+			})
+		}, RejectedState{&amqp.Error{Name: "vendor:busy", Description: "try later", Info: &info}}},
+		{(*ReceivedMessage).Release, ReleasedState{}},
+		{func(rm *ReceivedMessage) error { return rm.Modify(true, false, annotations) },
+			ModifiedState{DeliveryFailed: true, MessageAnnotations: annotations}},
+	} {
+		ack := snd.SendWaitable(amqp.NewMessage())
+		rm, err := rcv.Receive()
+		test.FatalIf(t, err)
+		test.FatalIf(t, x.settle(&rm))
+		test.ErrorIf(t, test.Differ(x.want, (<-ack).State()))
+	}
+	test.ErrorIf(t, test.Differ(nil, Outcome{Status: Unacknowledged, Error: Timeout}.State()))
+}
+
+func TestBrokerAnnotations(t *testing.T) {
+	enqueued := time.Unix(1500000000, 123000000)
+	m := amqp.NewMessage()
+	m.SetMessageAnnotations(amqp.Annotations{
+		amqp.AnnotationKeySymbol("x-opt-sequence-number"): int64(42),
+		amqp.AnnotationKeySymbol("x-opt-enqueued-time"):   enqueued,
+	})
+	bytes, err := m.Encode(nil)
+	test.FatalIf(t, err)
+	m, err = amqp.DecodeMessage(bytes)
+	test.FatalIf(t, err)
+	rm := ReceivedMessage{Message: m}
+
+	n, ok := rm.SequenceNumber()
+	test.ErrorIf(t, test.Differ(true, ok))
+	test.ErrorIf(t, test.Differ(int64(42), n))
+	et, ok := rm.EnqueuedTime()
+	test.ErrorIf(t, test.Differ(true, ok))
+	if !et.Equal(enqueued) {
+		t.Errorf("want %v got %v", enqueued, et)
+	}
+
+	rm = ReceivedMessage{Message: amqp.NewMessage()}
+	_, ok = rm.SequenceNumber()
+	test.ErrorIf(t, test.Differ(false, ok))
+	_, ok = rm.EnqueuedTime()
+	test.ErrorIf(t, test.Differ(false, ok))
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	p.prefetch = true
+	p.maxSize = 1024
+	snd, rcv := p.sender(Target("max"))
+	test.FatalIf(t, snd.Sync())
+	test.ErrorIf(t, test.Differ(uint64(1024), p.client.Connection().MaxMessageSize()))
+
+	out := snd.SendSync(amqp.NewMessageWith(make([]byte, 2048)))
+	test.ErrorIf(t, test.Differ(Outcome{Status: Unsent, Error: ErrMessageTooLarge}, out))
+
+	// Smaller messages are still sent
+	ack := snd.SendWaitable(amqp.NewMessageWith("small"))
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	test.FatalIf(t, rm.Accept())
+	test.ErrorIf(t, test.Differ(Accepted, (<-ack).Status))
+}
+
 // Test timeout versions of waiting functions.
 func TestTimeouts(t *testing.T) {
 	p := newPipe(t, nil, nil)
@@ -149,6 +319,177 @@ func TestTimeouts(t *testing.T) {
 	}
 }
 
+func TestSendTimeout(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	short := 10 * time.Millisecond
+
+	// No credit, message is not sent
+	snd, rcv := p.sender(Target("test"), SendTimeout(short))
+	test.ErrorIf(t, test.Differ(short, snd.SendTimeout()))
+	out := snd.SendSync(amqp.NewMessageWith("unsent"))
+	test.ErrorIf(t, test.Differ(Outcome{Status: Unsent, Error: Timeout}, out))
+
+	// Credit but no outcome, message is sent and settled locally
+	go func() { _, _ = rcv.Receive() }()
+	<-snd.Sendable()
+	out = snd.SendSync(amqp.NewMessageWith("unacknowledged"))
+	test.ErrorIf(t, test.Differ(Outcome{Status: Unacknowledged, Error: Timeout}, plain(out)))
+
+	// The link is still usable, 0 means wait forever
+	snd.SetSendTimeout(0)
+	test.ErrorIf(t, test.Differ(time.Duration(0), snd.SendTimeout()))
+	ack := make(chan Outcome, 1)
+	go func() { ack <- snd.SendSync(amqp.NewMessageWith("accepted")) }()
+	time.Sleep(2 * short) // Longer than the old timeout
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ("accepted", rm.Message.Body()))
+	test.FatalIf(t, rm.Accept())
+	test.ErrorIf(t, test.Differ(Outcome{Status: Accepted}, plain(<-ack)))
+
+	// The default is no timeout
+	snd, _ = p.sender(Target("test"))
+	test.ErrorIf(t, test.Differ(time.Duration(0), snd.SendTimeout()))
+}
+
+// plain returns o without the fields that differ from run to run: the
+// generated delivery tag and the times.
+func plain(o Outcome) Outcome {
+	o.tag, o.outcomeAt, o.settledAt = "", time.Time{}, time.Time{}
+	return o
+}
+
+func TestDeliveryTags(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	var next []byte
+	snd, rcv := p.sender(Target("test"), DeliveryTags(func() []byte { return next }))
+
+	// send sends m, accepts it and returns the received tag and the outcome.
+	send := func(f func() (Outcome, error)) ([]byte, Outcome) {
+		tags := make(chan []byte, 1)
+		go func() {
+			rm, err := rcv.Receive()
+			test.ErrorIf(t, err)
+			test.ErrorIf(t, rm.Accept())
+			tags <- rm.DeliveryTag()
+		}()
+		o, err := f()
+		test.ErrorIf(t, err)
+		return <-tags, o
+	}
+	m := amqp.NewMessageWith("x")
+
+	// Explicit tag
+	tag, o := send(func() (Outcome, error) { return snd.SendTagged(context.Background(), []byte("mine"), m) })
+	test.ErrorIf(t, test.Differ([]byte("mine"), tag))
+	test.ErrorIf(t, test.Differ(Outcome{Status: Accepted}, plain(o)))
+	test.ErrorIf(t, test.Differ([]byte("mine"), o.DeliveryTag()))
+
+	// Generated tags, the default is used if the generator returns none
+	next = []byte("generated")
+	tag, o = send(func() (Outcome, error) { return snd.SendContext(context.Background(), m) })
+	test.ErrorIf(t, test.Differ([]byte("generated"), tag))
+	test.ErrorIf(t, test.Differ([]byte("generated"), o.DeliveryTag()))
+	next = nil
+	tag, o = send(func() (Outcome, error) { return snd.SendContext(context.Background(), m) })
+	test.ErrorIf(t, test.Differ(o.DeliveryTag(), tag))
+	if len(tag) == 0 {
+		t.Error("no default tag")
+	}
+
+	// Tags that are too long are not sent
+	long := make([]byte, MaxDeliveryTagSize+1)
+	o, err := snd.SendTagged(context.Background(), long, m)
+	test.ErrorIf(t, test.Differ(ErrDeliveryTagTooLong, err))
+	test.ErrorIf(t, test.Differ(Unsent, o.Status))
+	next = long
+	go func() { _, _ = rcv.Receive() }() // Credit for the send
+	o = snd.SendSync(m)
+	test.ErrorIf(t, test.Differ(Outcome{Status: Unsent, Error: ErrDeliveryTagTooLong}, o))
+	test.ErrorIf(t, test.Differ(0, len(o.DeliveryTag())))
+}
+
+func TestSendBatch(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	p.prefetch = true
+	snd, rcv := p.sender()
+
+	// More messages than credit
+	msgs := make([]amqp.Message, 3*p.capacity)
+	for i := range msgs {
+		msgs[i] = amqp.NewMessageWith(int64(i))
+	}
+	go func() {
+		for i := range msgs {
+			rm, err := rcv.Receive()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			test.ErrorIf(t, test.Differ(int64(i), rm.Message.Body()))
+			test.ErrorIf(t, rm.Accept())
+		}
+	}()
+	outcomes, err := snd.SendBatch(context.Background(), msgs)
+	test.FatalIf(t, err)
+	test.FatalIf(t, test.Differ(len(msgs), len(outcomes)))
+	for i, o := range outcomes {
+		test.ErrorIf(t, test.Differ(Outcome{Status: Accepted, Value: i}, plain(o)))
+	}
+
+	// Context done before the messages are sent
+	p.prefetch = false
+	snd, _ = p.sender()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	outcomes, err = snd.SendBatch(ctx, msgs[:3])
+	canceled := SendCanceledError{Unsent, context.DeadlineExceeded}
+	test.ErrorIf(t, test.Differ(canceled, err))
+	for i, o := range outcomes {
+		test.ErrorIf(t, test.Differ(Outcome{Status: Unsent, Error: canceled, Value: i}, o))
+	}
+}
+
+func TestSendBatchClosed(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	p.prefetch = false // Credit for one message per Receive()
+	snd, rcv := p.sender()
+
+	msgs := make([]amqp.Message, 10)
+	for i := range msgs {
+		msgs[i] = amqp.NewMessageWith(int64(i))
+	}
+	type result struct {
+		outcomes []Outcome
+		err      error
+	}
+	done := make(chan result)
+	go func() {
+		outcomes, err := snd.SendBatch(context.Background(), msgs)
+		done <- result{outcomes, err}
+	}()
+	for i := 0; i < 3; i++ {
+		_, err := rcv.Receive() // Not settled
+		test.FatalIf(t, err)
+	}
+	closeErr := amqp.Errorf("test", "closed mid-batch")
+	rcv.Close(closeErr)
+	r := <-done
+	test.ErrorIf(t, test.Differ(closeErr, r.err))
+	test.FatalIf(t, test.Differ(len(msgs), len(r.outcomes)))
+	for i, o := range r.outcomes {
+		want := Outcome{Status: Unsent, Error: closeErr, Value: i}
+		if i < 3 {
+			want.Status = Unacknowledged
+		}
+		test.ErrorIf(t, test.Differ(want, plain(o)))
+	}
+}
+
 type result struct {
 	label string
 	err   error
@@ -172,6 +513,503 @@ func doDisposition(ack <-chan Outcome, results chan result) {
 }
 
 // Senders get credit immediately if receivers have prefetch set
+func TestSendContext(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	snd, rcv := p.sender(Target("test"))
+	m := amqp.NewMessageWith("hello")
+
+	// Context already done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out, err := snd.SendContext(ctx, m)
+	test.ErrorIf(t, test.Differ(Unsent, out.Status))
+	test.ErrorIf(t, test.Differ(SendCanceledError{Unsent, context.Canceled}, err))
+
+	// No credit, give up waiting for it
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	out, err = snd.SendContext(ctx, m)
+	cancel()
+	test.ErrorIf(t, test.Differ(Unsent, out.Status))
+	test.ErrorIf(t, test.Differ(SendCanceledError{Unsent, context.DeadlineExceeded}, err))
+
+	// Sent but not acknowledged, cancel after the receiver has the message.
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		_, err := rcv.Receive()
+		test.ErrorIf(t, err)
+		cancel()
+	}()
+	out, err = snd.SendContext(ctx, m)
+	test.ErrorIf(t, test.Differ(Unacknowledged, out.Status))
+	test.ErrorIf(t, test.Differ(SendCanceledError{Unacknowledged, context.Canceled}, err))
+
+	// Link is still usable
+	ack := make(chan Outcome, 1)
+	go snd.SendAsyncContext(context.Background(), m, ack, "v")
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	test.FatalIf(t, rm.Accept())
+	test.ErrorIf(t, test.Differ(Outcome{Status: Accepted, Value: "v"}, plain(<-ack)))
+}
+
+func TestFlush(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	p.prefetch = true
+	defer func() { p.close() }()
+	snd, rcv := p.sender(Target("test"))
+
+	// Nothing to flush
+	test.ErrorIf(t, snd.Flush(context.Background()))
+
+	// Not acknowledged, give up waiting
+	const n = 10
+	ack := make(chan Outcome, n)
+	for i := 0; i < n; i++ {
+		snd.SendAsync(amqp.NewMessageWith(i), ack, i)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	test.ErrorIf(t, test.Differ(context.DeadlineExceeded, snd.Flush(ctx)))
+	cancel()
+	test.ErrorIf(t, test.Differ(0, len(ack)))
+
+	// All outcomes arrive before Flush returns
+	go func() {
+		for i := 0; i < n; i++ {
+			rm, err := rcv.Receive()
+			test.ErrorIf(t, err)
+			time.Sleep(time.Millisecond)
+			test.ErrorIf(t, rm.Accept())
+		}
+	}()
+	test.ErrorIf(t, snd.Flush(context.Background()))
+	test.FatalIf(t, test.Differ(n, len(ack)))
+	for i := 0; i < n; i++ {
+		test.ErrorIf(t, test.Differ(Outcome{Status: Accepted, Value: i}, plain(<-ack)))
+	}
+
+	// Sender closes while flushing
+	snd.SendAsync(amqp.NewMessage(), ack, nil)
+	go func() { rcv.Close(nil) }()
+	test.ErrorIf(t, test.Differ(Closed, snd.Flush(context.Background())))
+}
+
+func TestCloseWithDrain(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	p.prefetch = true
+	defer func() { p.close() }()
+
+	// Sender waits for outcomes before closing.
+	snd, rcv := p.sender(Target("test"))
+	const n = 3
+	ack := make(chan Outcome, n)
+	for i := 0; i < n; i++ {
+		snd.SendAsync(amqp.NewMessageWith(i), ack, i)
+	}
+	go func() {
+		for i := 0; i < n; i++ {
+			rm, err := rcv.Receive()
+			test.ErrorIf(t, err)
+			time.Sleep(time.Millisecond)
+			test.ErrorIf(t, rm.Accept())
+		}
+	}()
+	test.ErrorIf(t, snd.CloseWithDrain(context.Background()))
+	test.ErrorIf(t, test.Differ(n, len(ack)))
+	<-snd.Done()
+	test.ErrorIf(t, test.Differ(Closed, snd.Error()))
+	<-rcv.Done()
+
+	// Sender closes when ctx is done, the message is never accepted.
+	snd, rcv = p.sender(Target("test"))
+	ack = make(chan Outcome, 1)
+	snd.SendAsync(amqp.NewMessageWith("x"), ack, "x")
+	_, err := rcv.Receive()
+	test.FatalIf(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	test.ErrorIf(t, test.Differ(context.DeadlineExceeded, snd.CloseWithDrain(ctx)))
+	if o := <-ack; o.Status == Accepted {
+		t.Errorf("unexpected outcome %v", o)
+	}
+	<-snd.Done()
+
+	// Receiver keeps messages sent during the drain.
+	r, s := p.receiver(Source("test"), Capacity(10), Prefetch(true))
+	s.SendForget(amqp.NewMessageWith("a"))
+	s.SendForget(amqp.NewMessageWith("b"))
+	test.ErrorIf(t, r.CloseWithDrain(context.Background()))
+	for _, want := range []string{"a", "b"} {
+		rm, err := r.Receive()
+		test.ErrorIf(t, err)
+		test.ErrorIf(t, test.Differ(want, rm.Message.Body()))
+	}
+	_, err = r.Receive()
+	test.ErrorIf(t, test.Differ(Closed, err))
+
+	// Receiver closes at once if ctx is done.
+	r, _ = p.receiver(Source("test"), Capacity(10), Prefetch(true))
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	test.ErrorIf(t, test.Differ(context.Canceled, r.CloseWithDrain(ctx)))
+	<-r.Done()
+	test.ErrorIf(t, test.Differ(Closed, r.Error()))
+}
+
+func TestMaxUnsettled(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	p.prefetch = true
+	defer func() { p.close() }()
+	snd, rcv := p.sender(Target("test"), MaxUnsettled(2))
+
+	ack := make(chan Outcome, 10)
+	snd.SendAsync(amqp.NewMessageWith(0), ack, 0)
+	snd.SendAsync(amqp.NewMessageWith(1), ack, 1)
+	var rms []ReceivedMessage
+	for i := 0; i < 2; i++ {
+		rm, err := rcv.Receive()
+		test.FatalIf(t, err)
+		rms = append(rms, rm)
+	}
+	test.ErrorIf(t, test.Differ(2, snd.Unsettled()))
+
+	// At the limit: TrySend is not ready, a send waits and can be cancelled.
+	_, ok, err := snd.TrySend(amqp.NewMessageWith("try"))
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ(false, ok))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	out, err := snd.SendContext(ctx, amqp.NewMessageWith("canceled"))
+	cancel()
+	test.ErrorIf(t, test.Differ(SendCanceledError{Unsent, context.DeadlineExceeded}, err))
+	test.ErrorIf(t, test.Differ(Unsent, out.Status))
+	sent := make(chan struct{})
+	go func() {
+		snd.SendAsync(amqp.NewMessageWith(2), ack, 2)
+		close(sent)
+	}()
+	_, err = rcv.ReceiveTimeout(time.Millisecond)
+	test.ErrorIf(t, test.Differ(Timeout, err))
+	select {
+	case <-sent:
+		t.Error("send did not wait")
+	default:
+	}
+
+	// An outcome releases the waiting message.
+	test.FatalIf(t, rms[0].Accept())
+	test.ErrorIf(t, test.Differ(0, (<-ack).Value))
+	<-sent
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(int64(2), rm.Message.Body()))
+	test.ErrorIf(t, test.Differ(2, snd.Unsettled()))
+
+	// Connection loss fails the unsettled messages and the waiting ones.
+	go snd.SendAsync(amqp.NewMessageWith(3), ack, 3)
+	time.Sleep(time.Millisecond)
+	p.client.Connection().Disconnect(fmt.Errorf("drop"))
+	outcomes := map[interface{}]SentStatus{}
+	for i := 0; i < 3; i++ {
+		o := <-ack
+		outcomes[o.Value] = o.Status
+	}
+	test.ErrorIf(t, test.Differ(map[interface{}]SentStatus{1: Unacknowledged, 2: Unacknowledged, 3: Unsent}, outcomes))
+	test.ErrorIf(t, test.Differ(0, snd.Unsettled()))
+}
+
+func TestAnonymousSender(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	p.prefetch = true
+	defer func() { p.close() }()
+	snd, rcv := p.sender(Anonymous(), Target("ignored"))
+	test.ErrorIf(t, test.Differ("", rcv.Target()))
+
+	m := amqp.NewMessageWith("hello")
+	out, err := snd.SendContext(context.Background(), m)
+	test.ErrorIf(t, test.Differ(Unsent, out.Status))
+	test.ErrorIf(t, test.Differ(ErrMissingToAddress, err))
+
+	go func() {
+		rm, err := rcv.Receive()
+		test.ErrorIf(t, err)
+		test.ErrorIf(t, test.Differ("queue", rm.Message.Address()))
+		test.ErrorIf(t, rm.Accept())
+	}()
+	out, err = snd.SendTo(context.Background(), "queue", m)
+	test.ErrorIf(t, err)
+	test.ErrorIf(t, test.Differ(Accepted, out.Status))
+	test.ErrorIf(t, test.Differ("", m.Address()))
+}
+
+func TestMessageID(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	p.prefetch = true
+	defer func() { p.close() }()
+
+	// sendID sends m and returns the message-id received.
+	sendID := func(snd Sender, rcv Receiver, m amqp.Message) interface{} {
+		ack := snd.SendWaitable(m)
+		rm, err := rcv.Receive()
+		test.FatalIfN(1, t, err)
+		test.ErrorIfN(1, t, rm.Accept())
+		test.ErrorIfN(1, t, test.Differ(Accepted, (<-ack).Status))
+		return rm.Message.MessageId()
+	}
+
+	// Default UUID generator, the id is set on the sent message.
+	snd, rcv := p.sender(Target("q"), AutoMessageID(nil))
+	m := amqp.NewMessageWith("x")
+	id := sendID(snd, rcv, m)
+	u, ok := id.(amqp.UUID)
+	test.ErrorIf(t, test.Differ(true, ok))
+	test.ErrorIf(t, test.Differ(byte(0x40), u[6]&0xF0)) // Version 4
+	test.ErrorIf(t, test.Differ(id, m.MessageId()))
+	if id2 := sendID(snd, rcv, amqp.NewMessageWith("y")); id2 == id {
+		t.Errorf("duplicate id %v", id)
+	}
+	// An existing id is kept.
+	m = amqp.NewMessageWith("x")
+	m.SetMessageId("mine")
+	test.ErrorIf(t, test.Differ("mine", sendID(snd, rcv, m)))
+
+	// Custom generator
+	n := uint64(0)
+	snd, rcv = p.sender(Target("q"), AutoMessageID(func() interface{} { n++; return n }))
+	test.ErrorIf(t, test.Differ(uint64(1), sendID(snd, rcv, amqp.NewMessageWith("x"))))
+	test.ErrorIf(t, test.Differ(uint64(2), sendID(snd, rcv, amqp.NewMessageWith("x"))))
+
+	// Required id
+	snd, rcv = p.sender(Target("q"), RequireMessageID())
+	out, err := snd.SendContext(context.Background(), amqp.NewMessageWith("x"))
+	test.ErrorIf(t, test.Differ(ErrMissingMessageID, err))
+	test.ErrorIf(t, test.Differ(Unsent, out.Status))
+	m = amqp.NewMessageWith("x")
+	m.SetMessageId("mine")
+	test.ErrorIf(t, test.Differ("mine", sendID(snd, rcv, m)))
+
+	// No policy, no id
+	snd, rcv = p.sender(Target("q"))
+	test.ErrorIf(t, test.Differ(nil, sendID(snd, rcv, amqp.NewMessageWith("x"))))
+}
+
+func TestReceiveContext(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	p.prefetch = true
+	defer func() { p.close() }()
+	snd, rcv := p.sender(Target("test"))
+
+	// Cancel before call, a buffered message is not consumed.
+	snd.SendForget(amqp.NewMessageWith(0))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := rcv.ReceiveContext(ctx)
+	test.ErrorIf(t, test.Differ(context.Canceled, err))
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(int64(0), rm.Message.Body()))
+
+	// Cancel while waiting
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	_, err = rcv.ReceiveContext(ctx)
+	cancel()
+	test.ErrorIf(t, test.Differ(context.DeadlineExceeded, err))
+
+	// Cancel racing an arriving message, no message is lost.
+	const n = 100
+	for i := 1; i <= n; i++ {
+		ctx, cancel = context.WithCancel(context.Background())
+		snd.SendForget(amqp.NewMessageWith(i))
+		cancel()
+		if rm, err = rcv.ReceiveContext(ctx); err != nil {
+			test.ErrorIf(t, test.Differ(context.Canceled, err))
+			rm, err = rcv.Receive()
+			test.FatalIf(t, err)
+		}
+		test.ErrorIf(t, test.Differ(int64(i), rm.Message.Body()))
+	}
+}
+
+func TestReceiveBatch(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	snd, rcv := p.sender(Target("test"))
+	ctx := context.Background()
+	send := func(n int) {
+		go func() {
+			for i := 0; i < n; i++ {
+				snd.SendForget(amqp.NewMessageWith(int64(i))) // Waits for credit
+			}
+		}()
+	}
+	check := func(n int, batch []ReceivedMessage, err error) {
+		t.Helper()
+		test.FatalIf(t, err)
+		if len(batch) != n {
+			t.Fatalf("want %v messages, got %v", n, len(batch))
+		}
+		for i, rm := range batch {
+			test.ErrorIf(t, test.Differ(int64(i), rm.Message.Body()))
+		}
+	}
+
+	// Credit for the whole batch without prefetch
+	send(5)
+	batch, err := rcv.ReceiveBatch(ctx, 5, Forever)
+	check(5, batch, err)
+
+	// maxWait returns a partial batch
+	test.FatalIf(t, rcv.SetPrefetch(2))
+	send(2)
+	for _, queued := rcv.Credit(); queued < 2; _, queued = rcv.Credit() {
+		time.Sleep(time.Millisecond)
+	}
+	batch, err = rcv.ReceiveBatch(ctx, 5, 10*time.Millisecond)
+	check(2, batch, err)
+
+	// Never an empty batch: wait past maxWait for the first message.
+	go func() { time.Sleep(20 * time.Millisecond); send(1) }()
+	batch, err = rcv.ReceiveBatch(ctx, 5, time.Millisecond)
+	check(1, batch, err)
+
+	// ctx done before the first message
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	batch, err = rcv.ReceiveBatch(ctx2, 5, Forever)
+	cancel()
+	test.ErrorIf(t, test.Differ(context.DeadlineExceeded, err))
+	test.ErrorIf(t, test.Differ(0, len(batch)))
+
+	// Batch size limits
+	_, err = rcv.ReceiveBatch(ctx, 0, Forever)
+	test.ErrorIf(t, test.Differ(fmt.Errorf("batch size 0 out of range 1-100"), err))
+	_, err = rcv.ReceiveBatch(ctx, rcv.Capacity()+1, Forever)
+	test.ErrorIf(t, test.Differ(fmt.Errorf("batch size 101 out of range 1-100"), err))
+
+	// Credit covers the batch with a smaller pre-fetch window
+	send(10)
+	batch, err = rcv.ReceiveBatch(ctx, 10, Forever)
+	check(10, batch, err)
+
+	// Closed receiver returns its error
+	snd.Close(nil)
+	_, err = rcv.ReceiveBatch(ctx, 5, Forever)
+	test.ErrorIf(t, test.Differ(Closed, err))
+}
+
+func TestDrain(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rcv, snd := p.receiver(Source("test"), ManualCredit(), Capacity(10))
+	credit := func() int { n, _ := rcv.Credit(); return n }
+	send := func(n int) { // Queue n messages at the sender before it has credit
+		go func() {
+			msgs := make([]amqp.Message, n)
+			for i := range msgs {
+				msgs[i] = amqp.NewMessageWith(int64(i))
+			}
+			_, _ = snd.SendBatch(ctx, msgs)
+		}()
+		waitQueued(snd, n)
+	}
+
+	// Peer with nothing to send returns the credit.
+	test.FatalIf(t, rcv.Flow(5))
+	test.FatalIf(t, rcv.Drain(ctx))
+	test.ErrorIf(t, test.Differ(0, credit()))
+
+	// Drain with no credit completes at once.
+	test.FatalIf(t, rcv.Drain(ctx))
+
+	// Peer sends what it has, then returns the rest.
+	send(3)
+	test.FatalIf(t, rcv.Flow(5))
+	test.FatalIf(t, rcv.Drain(ctx))
+	test.ErrorIf(t, test.Differ(0, credit()))
+	for i := 0; i < 3; i++ {
+		rm, err := rcv.ReceiveTimeout(0)
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ(int64(i), rm.Message.Body()))
+	}
+
+	// Peer has more than the credit, it stops at the credit.
+	send(4)
+	test.FatalIf(t, rcv.Flow(2))
+	test.FatalIf(t, rcv.Drain(ctx))
+	test.ErrorIf(t, test.Differ(0, credit()))
+	_, queued := rcv.Credit()
+	test.ErrorIf(t, test.Differ(2, queued))
+
+	// ctx done before the call
+	ctx2, cancel2 := context.WithCancel(ctx)
+	cancel2()
+	test.ErrorIf(t, test.Differ(context.Canceled, rcv.Drain(ctx2)))
+
+	rcv.Close(nil)
+	<-rcv.Done()
+	test.ErrorIf(t, test.Differ(Closed, rcv.Drain(ctx)))
+}
+
+// waitQueued waits for n messages queued at s waiting for credit.
+func waitQueued(s Sender, n int) {
+	queued := func() (n int) {
+		_ = s.(*sender).connection().injectWait(func() error { n = len(s.(*sender).sending); return nil })
+		return n
+	}
+	for queued() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAutoDrainOnClose(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	rcv, snd := p.receiver(Source("test"), ManualCredit(), Capacity(10), AutoDrainOnClose())
+	for i := 0; i < 3; i++ {
+		go snd.SendAsync(amqp.NewMessageWith(int64(i)), nil, nil) // Waits for credit
+		waitQueued(snd, i+1)
+	}
+	test.FatalIf(t, rcv.Flow(5))
+	rcv.Close(nil)
+	// Messages in flight arrive before the link closes
+	for i := 0; i < 3; i++ {
+		rm, err := rcv.Receive()
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ(int64(i), rm.Message.Body()))
+	}
+	_, err := rcv.Receive()
+	test.ErrorIf(t, test.Differ(Closed, err))
+	test.ErrorIf(t, test.Differ(Closed, snd.Sync()))
+}
+
+// Close the link anyway if the peer never completes the drain.
+func TestAutoDrainOnCloseTimeout(t *testing.T) {
+	defer func(d time.Duration) { autoDrainTimeout = d }(autoDrainTimeout)
+	autoDrainTimeout = 10 * time.Millisecond
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	rcv, _ := p.receiver(Source("test"), ManualCredit(), Capacity(10), AutoDrainOnClose())
+	test.FatalIf(t, rcv.Flow(5))
+	// Freeze the server so it never answers the drain.
+	unfreeze := make(chan bool)
+	defer close(unfreeze)
+	test.FatalIf(t, p.server.(*connection).engine.Inject(func() { <-unfreeze }))
+	rcv.Close(nil)
+	r := rcv.(*receiver)
+	active := func() (ok bool) {
+		_ = r.connection().injectWait(func() error { ok = r.pLink.State().LocalActive(); return nil })
+		return ok
+	}
+	deadline := time.Now().Add(time.Second)
+	for active() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	test.ErrorIf(t, test.Differ(false, active()))
+	unfreeze <- true
+	_, err := rcv.Receive()
+	test.ErrorIf(t, test.Differ(Closed, err))
+}
+
 func TestSendReceivePrefetch(t *testing.T) {
 	p := newPipe(t, nil, nil)
 	p.prefetch = true
@@ -204,7 +1042,433 @@ func TestSendReceiveNoPrefetch(t *testing.T) {
 	}
 }
 
+func TestCreditWindow(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+
+	rcv, _ := p.receiver(Capacity(10), PrefetchWindow(2))
+	current, queued := rcv.Credit()
+	test.ErrorIf(t, test.Differ([]int{2, 0}, []int{current, queued}))
+	test.ErrorIf(t, test.Differ(true, rcv.Prefetch()))
+	if err := rcv.SetPrefetch(11); err == nil {
+		t.Error("expected error for window larger than capacity")
+	}
+	// Grow the window at runtime
+	test.FatalIf(t, rcv.SetPrefetch(5))
+	current, queued = rcv.Credit()
+	test.ErrorIf(t, test.Differ([]int{5, 0}, []int{current, queued}))
+	// Disable pre-fetch, issued credit is not revoked.
+	test.FatalIf(t, rcv.SetPrefetch(0))
+	test.ErrorIf(t, test.Differ(false, rcv.Prefetch()))
+	current, _ = rcv.Credit()
+	test.ErrorIf(t, test.Differ(5, current))
+
+	rcv, snd := p.receiver(Capacity(10), ManualCredit())
+	current, _ = rcv.Credit()
+	test.ErrorIf(t, test.Differ(0, current))
+	test.FatalIf(t, rcv.Flow(3))
+	current, _ = rcv.Credit()
+	test.ErrorIf(t, test.Differ(3, current))
+	test.FatalIf(t, rcv.Flow(100)) // Limited by capacity
+	current, _ = rcv.Credit()
+	test.ErrorIf(t, test.Differ(10, current))
+	snd.SendForget(amqp.NewMessageWith("x"))
+	_, err := rcv.Receive()
+	test.FatalIf(t, err)
+	current, queued = rcv.Credit() // No automatic top-up
+	test.ErrorIf(t, test.Differ([]int{9, 0}, []int{current, queued}))
+}
+
+// trySend retries TrySend until it sends m, the connection may be busy.
+func trySend(t *testing.T, snd Sender, m amqp.Message) <-chan Outcome {
+	for {
+		out, ok, err := snd.TrySend(m)
+		test.FatalIfN(1, t, err)
+		if ok {
+			return out
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestTrySend(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	snd, rcv := p.sender()
+
+	// No credit
+	test.FatalIf(t, snd.Sync())
+	out, ok, err := snd.TrySend(amqp.NewMessageWith("none"))
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(false, ok))
+	test.ErrorIf(t, test.Differ((<-chan Outcome)(nil), out))
+
+	// Credit arrives
+	test.FatalIf(t, rcv.Flow(1))
+	out = trySend(t, snd, amqp.NewMessageWith("one"))
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ("one", rm.Message.Body()))
+	test.FatalIf(t, rm.Accept())
+	test.ErrorIf(t, test.Differ(Accepted, (<-out).Status))
+	_, ok, err = snd.TrySend(amqp.NewMessageWith("none"))
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(false, ok))
+
+	// A blocking send waiting for credit gets it first.
+	waiting := make(chan Outcome, 1)
+	go func() { waiting <- snd.SendSync(amqp.NewMessageWith("waiting")) }()
+	_, ok, err = snd.TrySend(amqp.NewMessageWith("none"))
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(false, ok))
+	test.FatalIf(t, rcv.Flow(1))
+	rm, err = rcv.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ("waiting", rm.Message.Body()))
+	test.FatalIf(t, rm.Accept())
+	test.ErrorIf(t, test.Differ(Accepted, (<-waiting).Status))
+
+	snd.Close(nil)
+	<-snd.Done()
+	_, ok, err = snd.TrySend(amqp.NewMessageWith("closed"))
+	test.ErrorIf(t, test.Differ(Closed, err))
+	test.ErrorIf(t, test.Differ(false, ok))
+}
+
+// Credit granted concurrently with TrySend is never over-used and no message
+// is lost or duplicated.
+func TestTrySendCreditRace(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	snd, rcv := p.sender()
+	const n = 50
+	go func() {
+		for i := 0; i < n; i++ {
+			_ = rcv.Flow(1)
+			runtime.Gosched()
+		}
+	}()
+	outs := make([]<-chan Outcome, n)
+	for i := range outs {
+		outs[i] = trySend(t, snd, amqp.NewMessageWith(int64(i)))
+	}
+	for i := range outs {
+		rm, err := rcv.Receive()
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ(int64(i), rm.Message.Body()))
+		test.FatalIf(t, rm.Accept())
+		test.ErrorIf(t, test.Differ(Accepted, (<-outs[i]).Status))
+	}
+	credit, err := snd.Credit()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(0, credit))
+	_, ok, err := snd.TrySend(amqp.NewMessageWith("none"))
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(false, ok))
+}
+
+func TestSendable(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	rcv, snd := p.receiver(ManualCredit(), Capacity(10))
+	m := amqp.NewMessageWith("x")
+
+	credit, err := snd.Credit()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(0, credit))
+	select {
+	case <-snd.Sendable():
+		t.Error("sendable with no credit")
+	default:
+	}
+	// Drip credit one at a time
+	for i := 0; i < 3; i++ {
+		test.FatalIf(t, rcv.Flow(1))
+		select {
+		case <-snd.Sendable():
+		case <-time.After(time.Second):
+			t.Fatal("no sendable signal")
+		}
+		credit, err = snd.Credit()
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ(1, credit))
+		snd.SendForget(m)
+		credit, err = snd.Credit()
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ(0, credit))
+		_, err = rcv.Receive()
+		test.FatalIf(t, err)
+	}
+	snd.Close(nil)
+	if _, ok := <-snd.Sendable(); ok {
+		t.Error("want Sendable closed")
+	}
+}
+
 // Test that closing Links interrupts blocked link functions.
+func TestSessionsAndLinks(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	c := p.client.Connection()
+	n := len(c.Sessions()) // Includes the pair's own session
+
+	var sessions []Session
+	for i := 0; i < 3; i++ {
+		s, err := c.Session()
+		test.FatalIf(t, err)
+		test.FatalIf(t, s.Sync()) // Closing before the begin reply is a protocol error
+		sessions = append(sessions, s)
+	}
+	test.ErrorIf(t, test.Differ(n+3, len(c.Sessions())))
+	sessions[1].Close(nil)
+	got := c.Sessions()
+	test.ErrorIf(t, test.Differ(n+2, len(got)))
+	for _, s := range got {
+		if s == sessions[1] {
+			t.Error("closed session in Sessions()")
+		}
+	}
+
+	test.ErrorIf(t, test.Differ(0, len(sessions[0].Links())))
+	snd, _ := p.sender(Target("a"))
+	rcv, _ := p.receiver(Source("b"))
+	test.ErrorIf(t, test.Differ(2, len(p.client.Links())))
+	snd.Close(nil)
+	links := p.client.Links()
+	test.ErrorIf(t, test.Differ([]Link{rcv}, links))
+	test.ErrorIf(t, test.Differ("b", links[0].Source()))
+}
+
+func TestSessionAndLinkCount(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	c := p.client.Connection()
+	test.FatalIf(t, p.client.Sync())
+	test.ErrorIf(t, test.Differ(1, c.SessionCount())) // The pair's own session
+
+	s, err := c.Session()
+	test.FatalIf(t, err)
+	test.FatalIf(t, s.Sync())
+	test.ErrorIf(t, test.Differ(2, c.SessionCount()))
+	test.ErrorIf(t, test.Differ(0, s.LinkCount()))
+
+	snd, err := s.Sender(Target("a"))
+	test.FatalIf(t, err)
+	<-p.rchan
+	rcv, err := s.Receiver(Source("b"))
+	test.FatalIf(t, err)
+	<-p.schan
+	test.FatalIf(t, snd.Sync())
+	test.FatalIf(t, rcv.Sync())
+	test.ErrorIf(t, test.Differ(2, s.LinkCount()))
+	test.ErrorIf(t, test.Differ(0, p.client.LinkCount()))
+
+	snd.Close(nil)
+	<-snd.Done()
+	test.ErrorIf(t, test.Differ(1, s.LinkCount()))
+
+	// Closing the session detaches its remaining links.
+	s.Close(nil)
+	<-s.Done()
+	test.ErrorIf(t, test.Differ(0, s.LinkCount()))
+	test.ErrorIf(t, test.Differ(1, c.SessionCount()))
+
+	c.Close(nil)
+	<-c.Done()
+	test.ErrorIf(t, test.Differ(0, c.SessionCount()))
+}
+
+func TestConnectionProperties(t *testing.T) {
+	p := newPipe(t,
+		[]ConnectionOption{ConnectionProperties(map[amqp.Symbol]interface{}{"product": "test", "x": 1}), ConnectionDesiredCapabilities(AnonymousRelay)},
+		[]ConnectionOption{ConnectionOfferedCapabilities(AnonymousRelay, "other")})
+	defer func() { p.close() }()
+	c := p.client.Connection()
+	test.FatalIf(t, c.Sync())
+	test.FatalIf(t, p.server.Sync())
+
+	test.ErrorIf(t, test.Differ([]amqp.Symbol{AnonymousRelay, "other"}, c.RemoteOfferedCapabilities()))
+	test.ErrorIf(t, test.Differ([]amqp.Symbol(nil), c.RemoteDesiredCapabilities()))
+	test.ErrorIf(t, test.Differ("qpid-proton-go", c.RemoteProperties()["product"]))
+	test.ErrorIf(t, test.Differ(amqp.Version, c.RemoteProperties()["version"]))
+
+	test.ErrorIf(t, test.Differ([]amqp.Symbol{AnonymousRelay}, p.server.RemoteDesiredCapabilities()))
+	test.ErrorIf(t, test.Differ([]amqp.Symbol(nil), p.server.RemoteOfferedCapabilities()))
+	props := p.server.RemoteProperties()
+	test.ErrorIf(t, test.Differ("test", props["product"]))
+	test.ErrorIf(t, test.Differ(int64(1), props["x"]))
+	test.ErrorIf(t, test.Differ(amqp.Version, props["version"]))
+}
+
+func TestConnectionRemoteLimits(t *testing.T) {
+	p := newPipe(t, []ConnectionOption{MaxFrameSize(100000)}, []ConnectionOption{ChannelMax(9)})
+	defer func() { p.close() }()
+	c := p.client.Connection()
+	test.FatalIf(t, c.Sync())
+	test.FatalIf(t, p.server.Sync())
+	test.ErrorIf(t, test.Differ(uint16(9), c.RemoteChannelMax()))
+	test.ErrorIf(t, test.Differ(uint32(100000), p.server.RemoteMaxFrameSize()))
+	if c.RemoteMaxFrameSize() == 0 {
+		t.Error("no remote max-frame-size")
+	}
+	test.ErrorIf(t, test.Differ(uint32(100000), c.MaxFrameSize()))
+	test.ErrorIf(t, test.Differ(uint16(9), c.ChannelMax())) // Negotiated
+	test.ErrorIf(t, test.Differ(uint16(9), p.server.ChannelMax()))
+
+	// Zero until the remote peer opens
+	cli, srv := net.Pipe()
+	c2, err := NewConnection(cli)
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(uint32(0), c2.RemoteMaxFrameSize()))
+	test.ErrorIf(t, test.Differ(uint16(0), c2.RemoteChannelMax()))
+	srv.Close()
+	c2.Close(nil)
+}
+
+// transferCounter counts the AMQP transfer frames written to a connection.
+type transferCounter struct {
+	net.Conn
+	lock      sync.Mutex
+	buf       []byte
+	header    bool
+	transfers int
+}
+
+func (c *transferCounter) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	c.buf = append(c.buf, b...)
+	if !c.header && len(c.buf) >= 8 {
+		c.buf, c.header = c.buf[8:], true // Protocol header
+	}
+	for c.header && len(c.buf) >= 8 {
+		size := int(binary.BigEndian.Uint32(c.buf))
+		if len(c.buf) < size {
+			break
+		}
+		body := c.buf[4*int(c.buf[4]) : size]                  // Skip doff words of header
+		if len(body) >= 3 && body[0] == 0 && body[2] == 0x14 { // Described by ulong 0x14: transfer
+			c.transfers++
+		}
+		c.buf = c.buf[size:]
+	}
+	c.lock.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *transferCounter) count() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.transfers
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	body := amqp.Binary(make([]byte, 100000))
+	transfers := func(size uint32) int {
+		cli, srv := net.Pipe()
+		sc, err := NewConnection(srv, Server(), MaxFrameSize(size))
+		test.FatalIf(t, err)
+		counter := &transferCounter{Conn: cli}
+		cc, err := NewConnection(counter)
+		test.FatalIf(t, err)
+		p := newPair(t, cc, sc)
+		defer p.close()
+		snd, rcv := p.sender()
+		go func() { snd.SendSync(amqp.NewMessageWith(body)) }()
+		rm, err := rcv.Receive()
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ(body, rm.Message.Body()))
+		test.ErrorIf(t, test.Differ(size, sc.MaxFrameSize()))
+		return counter.count()
+	}
+	small, large := transfers(4096), transfers(64*1024)
+	if small < 25 || large > 2 {
+		t.Errorf("want fewer frames with larger max-frame-size, got %v for 4K and %v for 64K", small, large)
+	}
+
+	cli, srv := net.Pipe()
+	defer srv.Close()
+	_, err := NewConnection(cli, MaxFrameSize(511))
+	if err == nil || !strings.Contains(err.Error(), "max-frame-size 511") {
+		t.Errorf("want error for small max-frame-size, got %v", err)
+	}
+}
+
+func TestHandleMax(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	s, err := p.client.Connection().Session(HandleMax(1))
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(uint32(1), s.HandleMax()))
+	test.ErrorIf(t, test.Differ(uint32(math.MaxUint32), p.client.HandleMax()))
+	var snds []Sender
+	for i := 0; i < 2; i++ {
+		snd, err := s.Sender()
+		test.FatalIf(t, err)
+		<-p.rchan
+		snds = append(snds, snd)
+	}
+	_, err = s.Sender()
+	if e, ok := err.(amqp.Error); !ok || e.Name != amqp.ResourceLimitExceeded {
+		t.Errorf("want %v, got %#v", amqp.ResourceLimitExceeded, err)
+	}
+	// Closing a link makes room
+	snds[0].Close(nil)
+	<-snds[0].Done()
+	_, err = s.Receiver()
+	test.ErrorIf(t, err)
+
+	// Incoming links beyond the limit are refused
+	cli, srv := net.Pipe()
+	sc, err := NewConnection(srv, Server())
+	test.FatalIf(t, err)
+	defer sc.Close(nil)
+	go func() {
+		for in := range sc.Incoming() {
+			if is, ok := in.(*IncomingSession); ok {
+				is.SetHandleMax(0)
+			}
+			in.Accept()
+		}
+	}()
+	cc, err := NewConnection(cli)
+	test.FatalIf(t, err)
+	defer cc.Close(nil)
+	snd, err := cc.Sender()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, snd.Sync())
+	snd, err = cc.Sender()
+	test.FatalIf(t, err)
+	if e, ok := snd.Sync().(amqp.Error); !ok || e.Name != amqp.ResourceLimitExceeded {
+		t.Errorf("want %v, got %#v", amqp.ResourceLimitExceeded, snd.Sync())
+	}
+}
+
+func TestSessionWindows(t *testing.T) {
+	p := newPipe(t, []ConnectionOption{MaxFrameSize(1024), DefaultSessionOptions(IncomingCapacity(10*1024), OutgoingWindow(7))}, nil)
+	defer func() { p.close() }()
+	s, err := p.client.Connection().DefaultSession()
+	test.FatalIf(t, err)
+	test.FatalIf(t, s.Sync())
+	test.ErrorIf(t, test.Differ(uint(10*1024), s.IncomingCapacity()))
+	test.ErrorIf(t, test.Differ(uint(7), s.OutgoingWindow()))
+	// The server has no max-frame-size so its window is unlimited.
+	test.ErrorIf(t, test.Differ(uint(math.MaxInt32), s.RemoteIncomingWindow()))
+
+	_, err = s.Sender(Target("x"))
+	test.FatalIf(t, err)
+	ss := (<-p.rchan).Session()
+	test.ErrorIf(t, test.Differ(uint(10), ss.RemoteIncomingWindow())) // 10 frames of 1024 bytes
+	test.ErrorIf(t, test.Differ(uint(7), ss.RemoteOutgoingWindow()))
+	test.ErrorIf(t, test.Differ(uint(0), ss.IncomingCapacity()))
+
+	// Options on a session opened explicitly
+	s, err = p.client.Connection().Session(IncomingCapacity(4096))
+	test.FatalIf(t, err)
+	test.FatalIf(t, s.Sync())
+	test.ErrorIf(t, test.Differ(uint(4096), s.IncomingCapacity()))
+	test.ErrorIf(t, test.Differ(uint(math.MaxInt32), s.OutgoingWindow()))
+}
+
 func TestLinkCloseInterrupt(t *testing.T) {
 	want := amqp.Error{Name: "x", Description: "all bad"}
 	p := newPipe(t, nil, nil)
@@ -230,6 +1494,32 @@ func TestLinkCloseInterrupt(t *testing.T) {
 	}
 }
 
+// The full error condition passed to Close is sent to the remote end.
+func TestCloseCondition(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	info := map[amqp.Symbol]interface{}{"reason": "maintenance", "retry": int32(30)}
+
+	want := amqp.Error{Name: amqp.DetachForced, Description: "moving", Info: &info}
+	snd, rcv := p.sender()
+	test.FatalIf(t, snd.Sync())
+	snd.Close(&want)
+	<-rcv.Done()
+	test.ErrorIf(t, test.Differ(want, rcv.Error()))
+	<-snd.Done()
+	test.ErrorIf(t, test.Differ(want, snd.Error()))
+
+	// Plain errors still arrive as an amqp.Error
+	snd, rcv = p.sender()
+	test.FatalIf(t, snd.Sync())
+	rcv.Close(fmt.Errorf("oops"))
+	<-snd.Done()
+	test.ErrorIf(t, test.Differ(amqp.Error{Name: amqp.InternalError, Description: "oops"}, snd.Error()))
+
+	want = amqp.Error{Name: amqp.ConnectionForced, Description: "shutting down", Info: &info}
+	p.client.Connection().Close(want)
+	test.ErrorIf(t, test.Differ(want, p.server.Wait()))
+}
+
 // Test closing the server end of a connection.
 func TestConnectionCloseInterrupt1(t *testing.T) {
 	want := amqp.Error{Name: "x", Description: "bad"}
@@ -291,6 +1581,30 @@ func TestConnectionCloseInterrupt2(t *testing.T) {
 	}
 }
 
+// SendAsyncTimeout on a closed connection must report the message unsent, not
+// wait for the timeout.
+func TestSendAsyncTimeoutClosed(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+	snd, _ := p.sender()
+	c := p.client.Connection().(*connection)
+	c.Close(amqp.Errorf("x", "closed"))
+	for c.inject(func() {}) == nil { // Wait for the engine to stop
+		time.Sleep(time.Millisecond)
+	}
+	ack := make(chan Outcome, 1)
+	snd.SendAsyncTimeout(amqp.NewMessage(), ack, nil, time.Hour)
+	select {
+	case out := <-ack:
+		test.ErrorIf(t, test.Differ(Unsent, out.Status))
+		if out.Error == nil {
+			t.Error("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no outcome")
+	}
+}
+
 func TestHeartbeat(t *testing.T) {
 	p := newSocketPair(t,
 		[]ConnectionOption{Heartbeat(102 * time.Millisecond)},
@@ -305,6 +1619,10 @@ func TestHeartbeat(t *testing.T) {
 	test.FatalIf(t, p.client.Sync())
 	test.ErrorIf(t, test.Differ(101*time.Millisecond, p.client.Connection().Heartbeat()))
 	test.ErrorIf(t, test.Differ(102*time.Millisecond, p.server.Heartbeat()))
+	test.ErrorIf(t, test.Differ(102*time.Millisecond, p.client.Connection().LocalHeartbeat()))
+	test.ErrorIf(t, test.Differ(101*time.Millisecond, p.server.LocalHeartbeat()))
+	test.ErrorIf(t, test.Differ(101*time.Millisecond, p.client.Connection().RemoteIdleTimeout()))
+	test.ErrorIf(t, test.Differ(204*time.Millisecond, p.client.Connection().LocalIdleTimeout()))
 
 	// Freeze the server for less than a heartbeat
 	test.FatalIf(t, freeze())
@@ -318,7 +1636,7 @@ func TestHeartbeat(t *testing.T) {
 	test.FatalIf(t, freeze())
 	select {
 	case <-p.client.Done():
-		if amqp.ResourceLimitExceeded != p.client.Error().(amqp.Error).Name {
+		if err, ok := p.client.Error().(IdleTimeoutError); !ok || !err.Local || amqp.ResourceLimitExceeded != amqp.MakeError(err).Name {
 			t.Error("bad timeout error:", p.client.Error())
 		}
 	case <-time.After(1400 * time.Millisecond):
@@ -331,3 +1649,62 @@ func TestHeartbeat(t *testing.T) {
 		t.Error("expected server side  time-out or connection abort error")
 	}
 }
+
+func TestErrorUnwrap(t *testing.T) {
+	forced := amqp.Errorf(amqp.ConnectionForced, "restarting")
+	if !amqp.IsConnectionError(ReconnectError{forced}) {
+		t.Error("ReconnectError does not unwrap")
+	}
+	denied := amqp.Errorf(amqp.UnauthorizedAccess, "denied")
+	test.ErrorIf(t, test.Differ(denied, AuthError{denied}.Unwrap()))
+	if !amqp.IsLinkError(DetachError{amqp.Errorf(amqp.DetachForced, "moved")}) {
+		t.Error("DetachError does not unwrap")
+	}
+}
+
+// Close must not block after a local idle timeout when the peer has stopped
+// reading and a write is stuck.
+func TestCloseIdleTimeoutNoRead(t *testing.T) {
+	cli, srv := net.Pipe() // Nothing reads srv, the first write blocks
+	defer srv.Close()
+	c, err := NewConnection(cli, Heartbeat(100*time.Millisecond))
+	test.FatalIf(t, err)
+	done := make(chan struct{})
+	go func() {
+		_ = c.Wait()
+		c.Close(nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+		if _, ok := c.Error().(IdleTimeoutError); !ok {
+			t.Error("expected idle timeout, got", c.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked")
+	}
+}
+
+// The remote peer closes the connection because it timed out.
+func TestRemoteIdleTimeout(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	test.FatalIf(t, p.client.Sync())
+	err := amqp.Errorf(amqp.ResourceLimitExceeded, "local-idle-timeout expired")
+	p.server.Close(err)
+	<-p.client.Done()
+	test.ErrorIf(t, test.Differ(IdleTimeoutError{Local: false, Err: err}, p.client.Connection().Error()))
+	if cerr, ok := p.client.Connection().Error().(IdleTimeoutError); !ok || !cerr.Is(ErrIdleTimeout) || cerr.Unwrap() != error(err) {
+		t.Error("bad idle timeout error", p.client.Connection().Error())
+	}
+	test.ErrorIf(t, test.Differ(err, amqp.MakeError(p.client.Connection().Error())))
+
+	// Other resource limits are not idle timeouts
+	p = newPipe(t, nil, nil)
+	defer func() { p.close() }()
+	test.FatalIf(t, p.client.Sync())
+	err = amqp.Errorf(amqp.ResourceLimitExceeded, "too many links")
+	p.server.Close(err)
+	<-p.client.Done()
+	test.ErrorIf(t, test.Differ(err, p.client.Connection().Error()))
+}
@@ -33,6 +33,24 @@ var Closed = io.EOF
 // was closed cleanly.
 var EOF = io.EOF
 
+// Detached is returned as an error when a link was detached cleanly without
+// being closed, see Receiver.Detach()
+var Detached = fmt.Errorf("link detached")
+
+// DetachError is the Link error if the remote peer detached the link with an
+// error, as opposed to closing it. A link that is detached cleanly has error
+// Detached.
+type DetachError struct {
+	// Err is the error sent by the remote peer, or the local error passed to
+	// Detach().
+	Err error
+}
+
+func (e DetachError) Error() string { return fmt.Sprintf("link detached: %v", e.Err) }
+
+// Unwrap returns Err, so amqp.IsLinkError() and errors.Is() see the detach error.
+func (e DetachError) Unwrap() error { return e.Err }
+
 // Endpoint is the local end of a communications channel to the remote peer
 // process.  The following interface implement Endpoint: Connection, Session,
 // Sender and Receiver.
@@ -43,13 +61,16 @@ var EOF = io.EOF
 //
 type Endpoint interface {
 	// Close an endpoint and signal an error to the remote end if error != nil.
+	// Pass an amqp.Error or *amqp.Error to set the condition name, description
+	// and info sent to the remote end, see amqp.MakeError for other errors.
 	Close(error)
 
 	// String is a human readable identifier, useful for debugging and logging.
 	String() string
 
 	// Error returns nil if the endpoint is open, otherwise returns an error.
-	// Error() == Closed means the endpoint was closed without error.
+	// Error() == Closed means the endpoint was closed without error. An error
+	// condition sent by the remote end is returned as an amqp.Error.
 	Error() error
 
 	// Connection is the connection associated with this endpoint.
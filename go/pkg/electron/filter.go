@@ -0,0 +1,84 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+)
+
+// ErrNotSupported is returned by operations the remote peer or the
+// configured extension does not support.
+var ErrNotSupported = fmt.Errorf("not supported")
+
+// FilterUpdater changes the filter of an attached Receiver.
+//
+// AMQP has no standard way to modify a source filter after attach, some brokers
+// provide a broker-specific management operation to do it. Implement
+// FilterUpdater to send that operation for your broker and pass it to the
+// receiver with the FilterUpdate() LinkOption.
+//
+// UpdateFilter is called in a separate goroutine by Receiver.SetFilter. It
+// should return nil only if the broker has accepted the new filter.
+type FilterUpdater interface {
+	UpdateFilter(r Receiver, filter map[amqp.Symbol]interface{}) error
+}
+
+// NoOpFilterUpdater is the default FilterUpdater, it returns ErrNotSupported.
+type NoOpFilterUpdater struct{}
+
+func (NoOpFilterUpdater) UpdateFilter(Receiver, map[amqp.Symbol]interface{}) error {
+	return ErrNotSupported
+}
+
+// FilterUpdate returns a LinkOption that sets the FilterUpdater used by
+// Receiver.SetFilter.
+func FilterUpdate(u FilterUpdater) LinkOption {
+	return func(l *linkSettings) { l.filterUpdater = u }
+}
+
+func (r *receiver) SetFilter(ctx context.Context, filter map[amqp.Symbol]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := r.Error(); err != nil {
+		return err
+	}
+	u := r.filterUpdater
+	if u == nil {
+		u = NoOpFilterUpdater{}
+	}
+	done := make(chan error, 1)
+	go func() { done <- u.UpdateFilter(r, filter) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return r.connection().injectWait(func() error {
+		r.filter = filter
+		return nil
+	})
+}
@@ -0,0 +1,51 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import "github.com/apache/qpid-proton/go/pkg/amqp"
+
+//go:generate go run ../../cmd/amqp-gen flow.go
+
+// FlowFrame is the AMQP flow performative, which carries session window and
+// link credit state between peers. The engine applies incoming flow frames to
+// the session windows (see Session.RemoteIncomingWindow()) and to sender
+// credit (see Sender.Credit()); FlowFrame is for inspecting or checking the
+// flow frames themselves, for example in a FrameEventSink:
+//
+//	var f FlowFrame
+//	if err := f.UnmarshalAMQP(performative); err == nil { ... }
+//
+// Handle, DeliveryCount, LinkCredit and Available are nil in a session-only
+// flow frame. NextIncomingId is nil before the peer has received a begin.
+//
+// amqp:described 0x13 amqp:flow:list
+type FlowFrame struct {
+	NextIncomingId *uint32
+	IncomingWindow uint32
+	NextOutgoingId uint32
+	OutgoingWindow uint32
+	Handle         *uint32
+	DeliveryCount  *uint32
+	LinkCredit     *uint32
+	Available      *uint32
+	Drain          bool `amqp:",omitempty"`
+	Echo           bool `amqp:",omitempty"`
+	Properties     map[amqp.Symbol]interface{}
+}
@@ -0,0 +1,113 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Code generated by amqp-gen. DO NOT EDIT.
+
+package electron
+
+import (
+	"fmt"
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+)
+
+// MarshalAMQP encodes t as a list described by 0x13.
+func (t FlowFrame) MarshalAMQP() (interface{}, error) {
+	l := make(amqp.List, 11)
+	if t.NextIncomingId != nil {
+		l[0] = *t.NextIncomingId
+	}
+	l[1] = t.IncomingWindow
+	l[2] = t.NextOutgoingId
+	l[3] = t.OutgoingWindow
+	if t.Handle != nil {
+		l[4] = *t.Handle
+	}
+	if t.DeliveryCount != nil {
+		l[5] = *t.DeliveryCount
+	}
+	if t.LinkCredit != nil {
+		l[6] = *t.LinkCredit
+	}
+	if t.Available != nil {
+		l[7] = *t.Available
+	}
+	if t.Drain != *new(bool) {
+		l[8] = t.Drain
+	}
+	if t.Echo != *new(bool) {
+		l[9] = t.Echo
+	}
+	if t.Properties != nil {
+		l[10] = t.Properties
+	}
+	for len(l) > 0 && l[len(l)-1] == nil {
+		l = l[:len(l)-1]
+	}
+	return amqp.Described{Descriptor: uint64(0x13), Value: l}, nil
+}
+
+// UnmarshalAMQP decodes t from a list described by 0x13 or amqp:flow:list.
+func (t *FlowFrame) UnmarshalAMQP(v interface{}) error {
+	*t = FlowFrame{}
+	if v == nil {
+		return nil
+	}
+	d, ok := v.(amqp.Described)
+	if !ok || !(d.Descriptor == uint64(0x13) || d.Descriptor == amqp.Symbol("amqp:flow:list")) {
+		return fmt.Errorf("cannot unmarshal %v as FlowFrame, expected descriptor 0x13", v)
+	}
+	l, ok := d.Value.(amqp.List)
+	if !ok && d.Value != nil {
+		return fmt.Errorf("cannot unmarshal %v as FlowFrame, expected a list", d.Value)
+	}
+	for i, x := range l {
+		if x == nil {
+			continue
+		}
+		var err error
+		switch i {
+		case 0:
+			err = amqp.Convert(x, &t.NextIncomingId)
+		case 1:
+			err = amqp.Convert(x, &t.IncomingWindow)
+		case 2:
+			err = amqp.Convert(x, &t.NextOutgoingId)
+		case 3:
+			err = amqp.Convert(x, &t.OutgoingWindow)
+		case 4:
+			err = amqp.Convert(x, &t.Handle)
+		case 5:
+			err = amqp.Convert(x, &t.DeliveryCount)
+		case 6:
+			err = amqp.Convert(x, &t.LinkCredit)
+		case 7:
+			err = amqp.Convert(x, &t.Available)
+		case 8:
+			err = amqp.Convert(x, &t.Drain)
+		case 9:
+			err = amqp.Convert(x, &t.Echo)
+		case 10:
+			err = amqp.Convert(x, &t.Properties)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal field %d of FlowFrame: %v", i, err)
+		}
+	}
+	return nil
+}
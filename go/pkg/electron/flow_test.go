@@ -0,0 +1,156 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// injectConn lets a test write frames between the frames written by the engine.
+type injectConn struct {
+	net.Conn
+	lock sync.Mutex
+}
+
+func (c *injectConn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.Conn.Write(b)
+}
+
+// inject writes an AMQP frame carrying performative on channel.
+func (c *injectConn) inject(channel uint16, performative interface{}) error {
+	body, err := amqp.Marshal(performative, nil)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(frameHeaderSize+len(body)))
+	frame[4] = 2 // Data offset in 4-byte words
+	binary.BigEndian.PutUint16(frame[6:], channel)
+	_, err = c.Write(append(frame, body...))
+	return err
+}
+
+func uint32p(n uint32) *uint32 { return &n }
+
+func TestFlowFrameRoundTrip(t *testing.T) {
+	for _, f := range []FlowFrame{
+		{IncomingWindow: 10, NextOutgoingId: 1, OutgoingWindow: 20},
+		{NextIncomingId: uint32p(3), IncomingWindow: 10, NextOutgoingId: 1, OutgoingWindow: 20,
+			Handle: uint32p(0), DeliveryCount: uint32p(5), LinkCredit: uint32p(100), Available: uint32p(2),
+			Drain: true, Echo: true, Properties: map[amqp.Symbol]interface{}{"x": int32(1)}},
+	} {
+		b, err := amqp.Marshal(f, nil)
+		test.FatalIf(t, err)
+		var v interface{}
+		_, err = amqp.Unmarshal(b, &v)
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ("flow", performativeName(v)))
+		var got FlowFrame
+		test.FatalIf(t, got.UnmarshalAMQP(v))
+		test.ErrorIf(t, test.Differ(f, got))
+	}
+	var f FlowFrame
+	if err := f.UnmarshalAMQP(amqp.Described{Descriptor: uint64(0x12), Value: amqp.List{}}); err == nil {
+		t.Error("expected error decoding attach as flow")
+	}
+}
+
+// A synthetic flow frame from the peer updates sender credit and session windows.
+func TestFlowFrameInject(t *testing.T) {
+	cli, srv := net.Pipe()
+	ic := &injectConn{Conn: srv}
+	sc, err := NewConnection(ic, Server(), ContainerId("server"))
+	test.FatalIf(t, err)
+	sink := make(chanSink, 100)
+	cc, err := NewConnection(cli, ContainerId("client"), ConnectionFrameEvents(sink))
+	test.FatalIf(t, err)
+	p := newPair(t, cc, sc)
+	defer p.close()
+
+	snd, _ := p.sender(Target("foo"))
+	test.FatalIf(t, snd.Sync())
+	credit, err := snd.Credit()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(0, credit))
+
+	// The server has one session on channel 0 and one link with handle 0.
+	flow := FlowFrame{NextIncomingId: uint32p(0), IncomingWindow: 42, OutgoingWindow: 9,
+		Handle: uint32p(0), DeliveryCount: uint32p(0), LinkCredit: uint32p(7)}
+	test.FatalIf(t, ic.inject(0, flow))
+
+	var got FlowFrame
+	for got.LinkCredit == nil {
+		if e := sink.next(Inbound); performativeName(e.performative) == "flow" {
+			test.FatalIf(t, got.UnmarshalAMQP(e.performative))
+		}
+	}
+	test.ErrorIf(t, test.Differ(flow, got))
+
+	deadline := time.Now().Add(time.Second)
+	for credit, err = snd.Credit(); err == nil && credit != 7 && time.Now().Before(deadline); credit, err = snd.Credit() {
+		time.Sleep(time.Millisecond)
+	}
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(7, credit))
+	test.ErrorIf(t, test.Differ(uint(42), p.client.RemoteIncomingWindow()))
+	test.ErrorIf(t, test.Differ(uint(9), p.client.RemoteOutgoingWindow()))
+}
+
+// A synthetic flow frame from the peer sender updates receiver Available() and DeliveryCount().
+func TestReceiverAvailable(t *testing.T) {
+	cli, srv := net.Pipe()
+	ic := &injectConn{Conn: srv}
+	sc, err := NewConnection(ic, Server(), ContainerId("server"))
+	test.FatalIf(t, err)
+	cc, err := NewConnection(cli, ContainerId("client"))
+	test.FatalIf(t, err)
+	p := newPair(t, cc, sc)
+	defer p.close()
+
+	rcv, snd := p.receiver(Source("foo"), Capacity(10), Prefetch(true))
+	test.FatalIf(t, rcv.Sync())
+	test.ErrorIf(t, test.Differ(uint32(0), rcv.Available()))
+	test.ErrorIf(t, test.Differ(uint32(0), rcv.DeliveryCount()))
+
+	// The server has one session on channel 0 and one link with handle 0.
+	test.FatalIf(t, ic.inject(0, FlowFrame{NextIncomingId: uint32p(0), IncomingWindow: 100, OutgoingWindow: 100,
+		Handle: uint32p(0), DeliveryCount: uint32p(3), LinkCredit: uint32p(7), Available: uint32p(5)}))
+	deadline := time.Now().Add(time.Second)
+	for rcv.Available() != 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	test.ErrorIf(t, test.Differ(uint32(5), rcv.Available()))
+	test.ErrorIf(t, test.Differ(uint32(3), rcv.DeliveryCount()))
+
+	// Each transfer advances the delivery-count.
+	snd.SendForget(amqp.NewMessageWith("x"))
+	_, err = rcv.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(uint32(4), rcv.DeliveryCount()))
+}
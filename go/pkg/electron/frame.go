@@ -0,0 +1,196 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+)
+
+// Direction of a frame reported to a FrameEventSink.
+type Direction int
+
+const (
+	Inbound  Direction = iota // Received from the remote peer
+	Outbound                  // Sent to the remote peer
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "inbound"
+	case Outbound:
+		return "outbound"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+// FrameEventSink is told about every AMQP and SASL frame sent or received on a
+// connection, to debug protocol problems without a packet capture. Set it with
+// the ConnectionFrameEvents() option or Connection.SetFrameEventSink().
+//
+// performative is an amqp.Described with a uint64 descriptor code, for
+// example 0x10 for open, and an amqp.List of the performative's fields. It is
+// nil for an empty (heartbeat) frame, or an amqp.Binary of the frame body if
+// the body can't be decoded. The payload of a transfer frame is not included.
+// A flow frame can be decoded with FlowFrame.UnmarshalAMQP().
+//
+// OnFrame is called in the goroutines that read and write the connection, so
+// it must be safe for concurrent use and must not block. Frames are decoded
+// only while a sink is set.
+type FrameEventSink interface {
+	OnFrame(dir Direction, channel uint16, performative interface{})
+}
+
+// LoggingFrameEventSink returns a FrameEventSink that logs each frame to l at
+// LogDebug, with the performative name and fields.
+func LoggingFrameEventSink(l Logger) FrameEventSink { return loggingFrameSink{l} }
+
+type loggingFrameSink struct{ l Logger }
+
+func (s loggingFrameSink) OnFrame(dir Direction, channel uint16, performative interface{}) {
+	var fields interface{}
+	if d, ok := performative.(amqp.Described); ok {
+		fields = d.Value
+	}
+	s.l.Log(LogDebug, "frame", "direction", dir, "channel", channel,
+		"performative", performativeName(performative), "fields", fields)
+}
+
+var performativeNames = map[uint64]string{
+	0x10: "open", 0x11: "begin", 0x12: "attach", 0x13: "flow", 0x14: "transfer",
+	0x15: "disposition", 0x16: "detach", 0x17: "end", 0x18: "close",
+	0x40: "sasl-mechanisms", 0x41: "sasl-init", 0x42: "sasl-challenge",
+	0x43: "sasl-response", 0x44: "sasl-outcome",
+}
+
+// performativeName returns the AMQP name of a performative passed to OnFrame.
+func performativeName(performative interface{}) string {
+	switch p := performative.(type) {
+	case nil:
+		return "empty"
+	case amqp.Described:
+		if code, ok := p.Descriptor.(uint64); ok {
+			if name, ok := performativeNames[code]; ok {
+				return name
+			}
+		}
+		return fmt.Sprintf("%v", p.Descriptor)
+	default:
+		return "invalid"
+	}
+}
+
+// frameSink holds the FrameEventSink, it is set and read in different goroutines.
+type frameSink struct{ v atomic.Value }
+
+type frameSinkBox struct{ sink FrameEventSink } // atomic.Value can't hold a nil interface
+
+func (f *frameSink) set(s FrameEventSink) { f.v.Store(frameSinkBox{s}) }
+
+func (f *frameSink) get() FrameEventSink {
+	b, _ := f.v.Load().(frameSinkBox)
+	return b.sink
+}
+
+func (c *connection) SetFrameEventSink(sink FrameEventSink) { c.frameSink.set(sink) }
+
+// ConnectionFrameEvents returns a ConnectionOption that sets the
+// FrameEventSink before the connection starts, so the sink sees all the
+// frames including the open frames. See Connection.SetFrameEventSink().
+func ConnectionFrameEvents(sink FrameEventSink) ConnectionOption {
+	return func(c *connection) { c.frameSink.set(sink) }
+}
+
+// frameParser finds the frame boundaries in one direction of a connection's
+// byte stream, and decodes frames for the FrameEventSink if there is one.
+// It always tracks the frame boundaries so a sink can be set at any time.
+type frameParser struct {
+	dir       Direction
+	sink      *frameSink
+	header    []byte         // Partial frame header or protocol header
+	body      []byte         // Frame body, only if capturing
+	remaining int            // Bytes of the current frame body not yet seen
+	skip      int            // Extended header bytes at the start of body
+	channel   uint16         // Channel of the current frame
+	capture   FrameEventSink // Sink for the current frame, or nil
+}
+
+const frameHeaderSize = 8 // Also the size of a protocol header
+
+func (p *frameParser) parse(b []byte) {
+	for len(b) > 0 {
+		if p.remaining == 0 {
+			n := frameHeaderSize - len(p.header)
+			if n > len(b) {
+				n = len(b)
+			}
+			p.header, b = append(p.header, b[:n]...), b[n:]
+			if len(p.header) < frameHeaderSize {
+				return
+			}
+			h := p.header
+			p.header = p.header[:0]
+			if string(h[:4]) == "AMQP" { // Protocol header, not a frame
+				continue
+			}
+			p.remaining = int(binary.BigEndian.Uint32(h)) - frameHeaderSize
+			p.skip = int(h[4])*4 - frameHeaderSize
+			p.channel = binary.BigEndian.Uint16(h[6:])
+			p.capture, p.body = p.sink.get(), p.body[:0]
+			if p.remaining <= 0 {
+				p.remaining = 0
+				p.emit()
+			}
+			continue
+		}
+		n := p.remaining
+		if n > len(b) {
+			n = len(b)
+		}
+		if p.capture != nil {
+			p.body = append(p.body, b[:n]...)
+		}
+		p.remaining, b = p.remaining-n, b[n:]
+		if p.remaining == 0 {
+			p.emit()
+		}
+	}
+}
+
+func (p *frameParser) emit() {
+	if p.capture == nil {
+		return
+	}
+	var performative interface{}
+	if p.skip < 0 || p.skip > len(p.body) {
+		performative = amqp.Binary(p.body)
+	} else if body := p.body[p.skip:]; len(body) > 0 {
+		if _, err := amqp.Unmarshal(body, &performative); err != nil {
+			performative = amqp.Binary(body)
+		}
+	}
+	p.capture.OnFrame(p.dir, p.channel, performative)
+	p.capture = nil
+}
@@ -0,0 +1,161 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"net"
+	"testing"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+type frameEvent struct {
+	dir          Direction
+	channel      uint16
+	performative interface{}
+}
+
+func (e frameEvent) String() string { return e.dir.String() + " " + performativeName(e.performative) }
+
+// chanSink is a FrameEventSink that sends frames on a channel.
+type chanSink chan frameEvent
+
+func (c chanSink) OnFrame(dir Direction, channel uint16, performative interface{}) {
+	c <- frameEvent{dir, channel, performative}
+}
+
+// next returns the next frame in direction dir. Inbound and outbound frames
+// are reported by different goroutines so their relative order may vary.
+func (c chanSink) next(dir Direction) frameEvent {
+	for {
+		if e := <-c; e.dir == dir {
+			return e
+		}
+	}
+}
+
+// names returns the names of the next n frames in direction dir.
+func (c chanSink) names(dir Direction, n int) (names []string) {
+	for len(names) < n {
+		names = append(names, performativeName(c.next(dir).performative))
+	}
+	return names
+}
+
+func TestFrameEvents(t *testing.T) {
+	sink := make(chanSink, 100)
+	p := newPipe(t, []ConnectionOption{ConnectionFrameEvents(sink)}, nil)
+	defer func() { p.close() }()
+	snd, _ := p.sender(Target("foo"))
+	test.FatalIf(t, snd.Sync())
+
+	e := sink.next(Outbound)
+	test.ErrorIf(t, test.Differ(uint16(0), e.channel))
+	d, _ := e.performative.(amqp.Described)
+	test.ErrorIf(t, test.Differ(uint64(0x10), d.Descriptor))
+	if l, ok := d.Value.(amqp.List); !ok || len(l) == 0 || l[0] != "client" { // container-id
+		t.Errorf("want open fields, got %#v", d.Value)
+	}
+	test.ErrorIf(t, test.Differ([]string{"begin", "attach"}, sink.names(Outbound, 2)))
+
+	// Stop and re-start reporting on a live connection.
+	p.client.Connection().SetFrameEventSink(nil)
+	for len(sink) > 0 {
+		<-sink
+	}
+	snd.Close(nil)
+	<-snd.Done()
+	test.ErrorIf(t, test.Differ(0, len(sink)))
+	p.client.Connection().SetFrameEventSink(sink)
+	snd, _ = p.sender(Target("bar"))
+	test.FatalIf(t, snd.Sync())
+	test.ErrorIf(t, test.Differ([]string{"attach"}, sink.names(Inbound, 1)))
+}
+
+func TestFrameEventsInbound(t *testing.T) {
+	sink := make(chanSink, 100)
+	p := newPipe(t, []ConnectionOption{ConnectionFrameEvents(sink)}, nil)
+	defer func() { p.close() }()
+	snd, _ := p.sender()
+	test.FatalIf(t, snd.Sync())
+	test.ErrorIf(t, test.Differ([]string{"open", "begin", "attach"}, sink.names(Inbound, 3)))
+}
+
+func TestLoggingFrameEventSink(t *testing.T) {
+	log := &testLogger{}
+	p := newPipe(t, []ConnectionOption{ConnectionFrameEvents(LoggingFrameEventSink(log))}, nil)
+	test.FatalIf(t, p.client.Sync())
+	p.close()
+	test.ErrorIf(t, test.Differ(Closed, p.client.Connection().Wait()))
+
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	for _, r := range log.records {
+		if r.keyvals[1] == Outbound {
+			test.ErrorIf(t, test.Differ(LogDebug, r.level))
+			test.ErrorIf(t, test.Differ("frame", r.msg))
+			test.ErrorIf(t, test.Differ([]interface{}{"direction", Outbound, "channel", uint16(0), "performative", "open"}, r.keyvals[:6]))
+			return
+		}
+	}
+	t.Error("no outbound frames logged")
+}
+
+// Frames split across reads and writes at any point are parsed correctly.
+func TestFrameParser(t *testing.T) {
+	cli, srv := net.Pipe()
+	sc, err := NewConnection(srv, Server())
+	test.FatalIf(t, err)
+	go func() {
+		for in := range sc.Incoming() {
+			in.Accept()
+		}
+	}()
+	rc := &recordConn{Conn: cli}
+	cc, err := NewConnection(rc, SASLEnable(), SASLAllowInsecure(true), SASLAllowedMechs("ANONYMOUS"))
+	test.FatalIf(t, err)
+	snd, err := cc.Sender(Target("foo"))
+	test.FatalIf(t, err)
+	test.FatalIf(t, snd.Sync())
+	cc.Close(nil)
+	<-cc.Done()
+	sc.Close(nil)
+
+	rc.lock.Lock()
+	stream := rc.written
+	rc.lock.Unlock()
+	sink := make(chanSink, 100)
+	fs := &frameSink{}
+	fs.set(sink)
+	p := frameParser{dir: Outbound, sink: fs}
+	for i := range stream {
+		p.parse(stream[i : i+1])
+	}
+	close(sink)
+	var names []string
+	for e := range sink {
+		names = append(names, performativeName(e.performative))
+	}
+	want := []string{"sasl-init", "open", "begin", "attach", "close"}
+	if !contains(names, want...) {
+		t.Errorf("want %v in %v", want, names)
+	}
+}
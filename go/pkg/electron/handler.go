@@ -20,6 +20,9 @@ under the License.
 package electron
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/apache/qpid-proton/go/pkg/amqp"
 	"github.com/apache/qpid-proton/go/pkg/proton"
 )
@@ -64,11 +67,29 @@ func (h *handler) HandleMessagingEvent(t proton.MessagingEvent, e proton.Event)
 			h.linkError(e.Link(), "no receiver")
 		}
 
+	case proton.MAccepted, proton.MRejected, proton.MReleased:
+		if sm, ok := h.sent[e.Delivery()]; ok && !e.Delivery().Settled() {
+			if s, ok := h.links[e.Link()].(*sender); ok {
+				s.unsettledOutcome(sm, e)
+			}
+		}
+
 	case proton.MSettled:
 		if sm, ok := h.sent[e.Delivery()]; ok {
-			d := e.Delivery().Remote()
-			sm.ack <- Outcome{sentStatus(d.Type()), d.Condition().Error(), sm.v}
-			delete(h.sent, e.Delivery())
+			sm.settledAt = time.Now()
+			if sm.outcomeAt.IsZero() {
+				sm.outcomeAt = sm.settledAt
+			}
+		}
+		if !h.outcome(e) {
+			if r, ok := h.links[e.Link()].(*receiver); ok {
+				r.settled(e.Delivery())
+			}
+		}
+
+	case proton.MDrained:
+		if r, ok := h.links[e.Link()].(*receiver); ok {
+			r.drained()
 		}
 
 	case proton.MSendable:
@@ -80,6 +101,11 @@ func (h *handler) HandleMessagingEvent(t proton.MessagingEvent, e proton.Event)
 
 	case proton.MConnectionOpening:
 		h.connection.heartbeat = e.Transport().RemoteIdleTimeout()
+		h.connection.remoteOpened(e.Connection(), e.Transport())
+		h.connection.authenticated(e.Transport())
+		h.connection.reconnected()
+		h.connection.stateChange(ConnectionOpen, nil)
+		h.connection.log(LogInfo, "connection opened", "remote-container", h.connection.remoteContainerId)
 		if e.Connection().State().LocalUninit() { // Remotely opened
 			h.incoming(newIncomingConnection(h.connection))
 		}
@@ -89,7 +115,9 @@ func (h *handler) HandleMessagingEvent(t proton.MessagingEvent, e proton.Event)
 		if e.Session().State().LocalUninit() { // Remotely opened
 			h.incoming(newIncomingSession(h, e.Session()))
 		}
-		h.sessions[e.Session()].wakeSync()
+		if s := h.sessions[e.Session()]; s != nil { // Not closed already
+			s.wakeSync()
+		}
 
 	case proton.MSessionClosed:
 		h.sessionClosed(e.Session(), proton.EndpointError(e.Session()))
@@ -105,7 +133,11 @@ func (h *handler) HandleMessagingEvent(t proton.MessagingEvent, e proton.Event)
 				}
 			}
 			if ep, ok := h.links[l]; ok {
-				ep.(endpointInternal).wakeSync()
+				if !refused(l) { // Sync() will be woken with the error by the detach
+					h.connection.log(LogInfo, "link attached", "link", l.Name(), "type", l.Type())
+					h.resume(ep)
+					ep.(endpointInternal).wakeSync()
+				}
 			} else {
 				h.linkError(l, "no link")
 			}
@@ -117,13 +149,30 @@ func (h *handler) HandleMessagingEvent(t proton.MessagingEvent, e proton.Event)
 		e.Link().Close()
 
 	case proton.MLinkClosed:
-		h.linkClosed(e.Link(), proton.EndpointError(e.Link()))
+		err := proton.EndpointError(e.Link())
+		if !e.Link().State().RemoteClosed() { // Remote peer detached without closing
+			if err == nil {
+				err = Detached
+			} else {
+				err = DetachError{err}
+			}
+		}
+		if err != nil {
+			h.connection.log(LogWarn, "link closed", "link", e.Link().Name(), "error", err)
+		} else {
+			h.connection.log(LogDebug, "link closed", "link", e.Link().Name())
+		}
+		h.linkClosed(e.Link(), err)
 
 	case proton.MConnectionClosing:
 		h.connection.err.Set(e.Connection().RemoteCondition().Error())
 
 	case proton.MConnectionClosed:
-		h.shutdown(proton.EndpointError(e.Connection()))
+		err := proton.EndpointError(e.Connection())
+		if e.Connection().RemoteCondition().IsSet() {
+			err = idleTimeout(err, false)
+		}
+		h.shutdown(err)
 
 	case proton.MDisconnected:
 		var err error
@@ -131,38 +180,131 @@ func (h *handler) HandleMessagingEvent(t proton.MessagingEvent, e proton.Event)
 			if err = e.Connection().Condition().Error(); err == nil {
 				if err = e.Transport().Condition().Error(); err == nil {
 					err = amqp.Errorf(amqp.IllegalState, "unexpected disconnect on %s", h.connection)
+				} else if err.(amqp.Error).Name == amqp.UnauthorizedAccess {
+					err = AuthError{err} // Set by the SASL layer
+				} else if mech, failed := h.connection.saslFailed(e.Transport()); failed {
+					err = AuthError{amqp.Errorf(amqp.UnauthorizedAccess, "Authentication failed [mech=%s]", mech)}
+				} else {
+					err = idleTimeout(err, true)
 				}
 			}
+		} else {
+			err = idleTimeout(err, false)
 		}
-		h.shutdown(err)
+		h.connection.log(LogWarn, "disconnected", "error", err)
+		if !h.disconnected(err) {
+			h.shutdown(err)
+		}
+	}
+	h.connection.checkShutdown()
+}
+
+// outcome reports the remote outcome of a sent message, returns false if the
+// delivery is not waiting for an outcome.
+func (h *handler) outcome(e proton.Event) bool {
+	sm, ok := h.sent[e.Delivery()]
+	if !ok {
+		return false
+	}
+	d := e.Delivery().Remote()
+	if sm.remote != nil {
+		sm.remote(d)
 	}
+	status, err := remoteOutcome(d)
+	sm.outcome(status, err, remoteModified(d)).send(sm.ack)
+	delete(h.sent, e.Delivery())
+	if s, ok := h.links[e.Link()].(*sender); ok {
+		h.connection.metrics.OnSettle(s, status, time.Since(sm.sentAt))
+		s.settledSent()
+	}
+	return true
 }
 
 func (h *handler) incoming(in Incoming) {
-	var err error
-	if h.connection.incoming != nil {
+	err := h.authorize(in)
+	switch {
+	case h.connection.shutdown != nil:
+		err = amqp.Errorf(amqp.ConnectionForced, "connection is shutting down")
+	case h.linksFull(in.pEndpoint()):
+		err = amqp.Errorf(amqp.ResourceLimitExceeded, "session handle-max exceeded")
+	case err != nil: // Refused by the LinkPolicy
+	case h.connection.incoming != nil:
 		h.connection.incoming <- in
 		// Must block until accept/reject, subsequent events may use the incoming endpoint.
 		err = in.wait()
-	} else {
+	default:
 		err = amqp.Errorf(amqp.NotAllowed, "rejected incoming %s %s",
 			in.pEndpoint().Type(), in.pEndpoint().String())
 	}
 	if err == nil {
+		h.connection.log(LogDebug, "accepted incoming", "type", in.pEndpoint().Type())
 		in.pEndpoint().Open()
 	} else {
+		h.connection.log(LogWarn, "refused incoming", "type", in.pEndpoint().Type(), "error", err)
+		if l, ok := in.pEndpoint().(proton.Link); ok {
+			// Refuse the link by attaching with a null terminus before detaching.
+			if l.IsSender() {
+				l.Source().SetType(proton.Unspecified)
+			} else {
+				l.Target().SetType(proton.Unspecified)
+			}
+		}
 		proton.CloseError(in.pEndpoint(), err)
 	}
 }
 
+// authorize returns the error from the connection's LinkPolicy if in is a link
+// that the policy refuses.
+func (h *handler) authorize(in Incoming) error {
+	if h.connection.linkPolicy != nil {
+		switch l := in.(type) {
+		case *IncomingSender:
+			return h.connection.linkPolicy(l)
+		case *IncomingReceiver:
+			return h.connection.linkPolicy(l)
+		}
+	}
+	return nil
+}
+
+// refused is true if the remote peer attached a locally opened link only to
+// detach it: the remote end has already closed, or has attached with a null
+// source (for our receiver) or target (for our sender).
+func refused(l proton.Link) bool {
+	if l.State().RemoteClosed() {
+		return true
+	}
+	if l.IsSender() {
+		return l.RemoteTarget().Type() == proton.Unspecified
+	}
+	return l.RemoteSource().Type() == proton.Unspecified
+}
+
+// linksFull is true if ep is a link on a session that has reached its handle-max.
+func (h *handler) linksFull(ep proton.Endpoint) bool {
+	if l, ok := ep.(proton.Link); ok {
+		if s := h.sessions[l.Session()]; s != nil {
+			return s.linksFull()
+		}
+	}
+	return false
+}
+
+func (h *handler) addSession(s *session) {
+	h.sessions[s.pSession] = s
+	atomic.AddInt32(&h.connection.sessionCount, 1)
+}
+
 func (h *handler) addLink(pl proton.Link, el Endpoint) {
 	h.links[pl] = el
+	atomic.AddInt32(&el.(Link).Session().(*session).linkCount, 1)
 }
 
 func (h *handler) linkClosed(l proton.Link, err error) {
 	if link, ok := h.links[l]; ok {
 		_ = link.(endpointInternal).closed(err)
 		delete(h.links, l)
+		atomic.AddInt32(&link.(Link).Session().(*session).linkCount, -1)
 		l.Free()
 	}
 }
@@ -170,6 +312,7 @@ func (h *handler) linkClosed(l proton.Link, err error) {
 func (h *handler) sessionClosed(ps proton.Session, err error) {
 	if s, ok := h.sessions[ps]; ok {
 		delete(h.sessions, ps)
+		atomic.AddInt32(&h.connection.sessionCount, -1)
 		err = s.closed(err)
 		for l, _ := range h.links {
 			if l.Session() == ps {
@@ -184,14 +327,7 @@ func (h *handler) shutdown(err error) {
 	err = h.connection.closed(err)
 	for _, sm := range h.sent {
 		// Don't block but ensure outcome is sent eventually.
-		if sm.ack != nil {
-			o := Outcome{Unacknowledged, err, sm.v}
-			select {
-			case sm.ack <- o:
-			default:
-				go func(ack chan<- Outcome) { ack <- o }(sm.ack) // Deliver it eventually
-			}
-		}
+		sm.outcome(Unacknowledged, err, nil).sendEventually(sm.ack)
 	}
 	h.sent = nil
 	for _, l := range h.links {
@@ -200,6 +336,8 @@ func (h *handler) shutdown(err error) {
 	h.links = nil
 	for _, s := range h.sessions {
 		_ = s.closed(err)
+		atomic.StoreInt32(&s.linkCount, 0)
 	}
+	atomic.StoreInt32(&h.connection.sessionCount, 0)
 	h.sessions = nil
 }
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"github.com/apache/qpid-proton/go/pkg/amqp"
 	"github.com/apache/qpid-proton/go/pkg/proton"
+	"sync"
 	"time"
 )
 
@@ -34,8 +35,12 @@ type LinkSettings interface {
 	// Target address that messages are going to.
 	Target() string
 
-	// Name is a unique name for the link among links between the same
-	// containers in the same direction. By default generated automatically.
+	// LinkName is the name of the link, sent in the attach frame. Both ends of a
+	// link have the same name, so it is also the remote peer's name for it.
+	//
+	// The name must be unique among links between the same containers in the
+	// same direction. It is set by the LinkName() option, or generated from
+	// the container-id if not set.
 	LinkName() string
 
 	// IsSender is true if this is the sending end of the link.
@@ -47,9 +52,19 @@ type LinkSettings interface {
 	// SndSettle defines when the sending end of the link settles message delivery.
 	SndSettle() SndSettleMode
 
-	// RcvSettle defines when the sending end of the link settles message delivery.
+	// RcvSettle defines when the receiving end of the link settles message delivery.
 	RcvSettle() RcvSettleMode
 
+	// RemoteSndSettle is the send settle mode in the remote peer's attach. Once
+	// the link is open this is the mode in effect: the remote sender's choice,
+	// or the mode our remote receiver accepted, which may differ from SndSettle().
+	RemoteSndSettle() SndSettleMode
+
+	// RemoteRcvSettle is the receive settle mode in the remote peer's attach. Once
+	// the link is open this is the mode in effect: the remote receiver's choice,
+	// or the mode our remote sender accepted, which may differ from RcvSettle().
+	RemoteRcvSettle() RcvSettleMode
+
 	// Session containing the Link
 	Session() Session
 
@@ -61,6 +76,67 @@ type LinkSettings interface {
 
 	// Advanced settings for the target
 	TargetSettings() TerminusSettings
+
+	// RemoteSourceSettings are the source settings in the remote peer's
+	// attach. The peer may change the values we asked for, for example the
+	// timeout and expiry policy of a dynamic source.
+	RemoteSourceSettings() TerminusSettings
+
+	// RemoteTargetSettings are the target settings in the remote peer's attach.
+	RemoteTargetSettings() TerminusSettings
+
+	// Properties are the link properties sent to the remote peer in the attach
+	// frame, set by LinkProperties(). Nil if there are none.
+	Properties() map[amqp.Symbol]interface{}
+
+	// RemoteProperties are the link properties sent by the remote peer when it
+	// attached the link, nil if there were none.
+	RemoteProperties() map[amqp.Symbol]interface{}
+
+	// RemoteOfferedCapabilities are the capabilities offered by the remote peer
+	// when it attached the link.
+	RemoteOfferedCapabilities() []amqp.Symbol
+
+	// RemoteDesiredCapabilities are the capabilities desired by the remote peer
+	// when it attached the link.
+	RemoteDesiredCapabilities() []amqp.Symbol
+
+	// RemoteMaxMessageSize is the largest message (in bytes) the remote peer
+	// will accept on the link, 0 means no limit.
+	RemoteMaxMessageSize() uint64
+}
+
+// Link is the interface common to Sender and Receiver.
+type Link interface {
+	Endpoint
+	LinkSettings
+}
+
+// IncomingLink is an incoming request to attach a link, an *IncomingSender or
+// *IncomingReceiver. It is passed to the LinkPolicy() function.
+//
+// IsSender() and IsReceiver() give the role of the local link, the Remote*
+// methods give the attach fields sent by the remote peer.
+type IncomingLink interface {
+	Incoming
+
+	Source() string
+	Target() string
+	LinkName() string
+	IsSender() bool
+	IsReceiver() bool
+	RemoteProperties() map[amqp.Symbol]interface{}
+	RemoteOfferedCapabilities() []amqp.Symbol
+	RemoteDesiredCapabilities() []amqp.Symbol
+
+	// Address of the local node: the Source() of an incoming sender, which the
+	// remote peer receives from, or the Target() of an incoming receiver, which
+	// the remote peer sends to.
+	Address() string
+
+	// AuthenticatedUser is the identity of the remote peer established by SASL
+	// authentication, see ConnectionSettings.AuthenticatedUser().
+	AuthenticatedUser() string
 }
 
 // LinkOption can be passed when creating a sender or receiver link to set optional configuration.
@@ -72,13 +148,36 @@ func Source(s string) LinkOption { return func(l *linkSettings) { l.source = s }
 // Target returns a LinkOption that sets address that messages are going to.
 func Target(s string) LinkOption { return func(l *linkSettings) { l.target = s } }
 
-// LinkName returns a LinkOption that sets the link name.
+// Anonymous returns a LinkOption for a sender attached to the remote peer's
+// anonymous relay: the target address is null and each message is routed by
+// its own address, see amqp.Message.SetAddress() and Sender.SendTo(). Sending
+// a message with no address fails with ErrMissingToAddress. A Target() option
+// is ignored.
+//
+// Not all peers support anonymous relay, they offer the AnonymousRelay
+// connection capability if they do, see Connection.RemoteOfferedCapabilities().
+func Anonymous() LinkOption { return func(l *linkSettings) { l.anonymous = true } }
+
+// LinkName returns a LinkOption that sets the link name. Use a fixed name to
+// resume a link such as a durable subscription, see DurableSubscription(). The
+// name must be unique among links between the same containers in the same
+// direction, opening a link with the name of an open link on the same
+// connection fails with ErrLinkNameInUse.
 func LinkName(s string) LinkOption { return func(l *linkSettings) { l.linkName = s } }
 
-// SndSettle returns a LinkOption that sets the send settle mode
+// SndSettle returns a LinkOption that sets the send settle mode. It is
+// decided by the sender, a receiver may ask for a mode but the remote sender
+// has the final say, see LinkSettings.RemoteSndSettle().
 func SndSettle(m SndSettleMode) LinkOption { return func(l *linkSettings) { l.sndSettle = m } }
 
-// RcvSettle returns a LinkOption that sets the send settle mode
+// RcvSettle returns a LinkOption that sets the receive settle mode. It is
+// decided by the receiver, a sender may ask for a mode but the remote receiver
+// has the final say, see LinkSettings.RemoteRcvSettle().
+//
+// With RcvSecond a Receiver's ReceivedMessage.Accept() and the other
+// acknowledgements send the outcome and wait for the sender to settle before
+// settling and returning. A Sender reports the Outcome and settles when it gets
+// the receiver's outcome. Not all peers support RcvSecond.
 func RcvSettle(m RcvSettleMode) LinkOption { return func(l *linkSettings) { l.rcvSettle = m } }
 
 // Capacity returns a LinkOption that sets the link capacity
@@ -87,9 +186,35 @@ func Capacity(n int) LinkOption { return func(l *linkSettings) { l.capacity = n
 // Prefetch returns a LinkOption that sets a receivers pre-fetch flag. Not relevant for a sender.
 func Prefetch(p bool) LinkOption { return func(l *linkSettings) { l.prefetch = p } }
 
+// PrefetchWindow returns a LinkOption that enables pre-fetch and keeps up to n
+// messages (buffered or in flight) available to the receiver. Capacity is
+// raised to n if it is smaller. Not relevant for a sender.
+func PrefetchWindow(n int) LinkOption {
+	return func(l *linkSettings) {
+		l.prefetch = true
+		l.window = n
+		if l.capacity < n {
+			l.capacity = n
+		}
+	}
+}
+
+// ManualCredit returns a LinkOption that stops a receiver from issuing credit
+// automatically. The application issues credit with Receiver.Flow(). Not
+// relevant for a sender.
+func ManualCredit() LinkOption {
+	return func(l *linkSettings) {
+		l.prefetch = false
+		l.manualCredit = true
+	}
+}
+
 // DurableSubscription returns a LinkOption that configures a Receiver as a named durable
 // subscription.  The name overrides (and is overridden by) LinkName() so you should normally
 // only use one of these options.
+//
+// The source is durable with unsettled state and never expires. Use
+// Receiver.Detach() to disconnect and keep the subscription, Close() to end it.
 func DurableSubscription(name string) LinkOption {
 	return func(l *linkSettings) {
 		l.linkName = name
@@ -98,6 +223,69 @@ func DurableSubscription(name string) LinkOption {
 	}
 }
 
+// DynamicReceiver returns a LinkOption that asks the remote peer to create a
+// source node for a receiver and assign its address, for example a temporary
+// queue for replies. Receiver.DynamicAddress() returns the assigned address.
+func DynamicReceiver() LinkOption {
+	return func(l *linkSettings) { l.sourceSettings.Dynamic = true }
+}
+
+// Browse returns a LinkOption that asks the source of a receiver for copies of
+// its messages (distribution-mode copy), so you can look at the contents of a
+// queue without consuming them. A source may ignore the request, check
+// RemoteSourceSettings().DistributionMode after Sync().
+//
+// Browsed messages are usually sent pre-settled. They can still be settled with
+// Accept() or another ReceivedMessage method, the settlement only releases the
+// local delivery.
+func Browse() LinkOption {
+	return func(l *linkSettings) { l.sourceSettings.DistributionMode = proton.DistModeCopy }
+}
+
+// Durability returns a LinkOption that sets the durability of the terminus at
+// the remote end of the link: the source for a receiver, the target for a sender.
+func Durability(d amqp.TerminusDurability) LinkOption {
+	return func(l *linkSettings) { l.remoteTerminus().Durability = proton.Durability(d) }
+}
+
+// ExpiryPolicy returns a LinkOption that sets the expiry policy of the terminus
+// at the remote end of the link: the source for a receiver, the target for a sender.
+func ExpiryPolicy(p amqp.TerminusExpiryPolicy) LinkOption {
+	return func(l *linkSettings) {
+		switch p {
+		case amqp.ExpiryPolicyLinkDetach:
+			l.remoteTerminus().Expiry = proton.ExpireWithLink
+		case amqp.ExpiryPolicySessionEnd:
+			l.remoteTerminus().Expiry = proton.ExpireWithSession
+		case amqp.ExpiryPolicyConnectionClose:
+			l.remoteTerminus().Expiry = proton.ExpireWithConnection
+		case amqp.ExpiryPolicyNever:
+			l.remoteTerminus().Expiry = proton.ExpireNever
+		}
+	}
+}
+
+// TerminusExpiryPolicy is the same as ExpiryPolicy(), named to match
+// TerminusTimeout().
+func TerminusExpiryPolicy(p amqp.TerminusExpiryPolicy) LinkOption { return ExpiryPolicy(p) }
+
+// TerminusTimeout returns a LinkOption that sets the timeout of the terminus at
+// the remote end of the link: the source for a receiver, the target for a
+// sender. The terminus expires the given number of seconds after the event set
+// by ExpiryPolicy(), for example to clean up a DynamicReceiver() queue after the
+// connection closes. 0 means expire immediately.
+func TerminusTimeout(seconds uint32) LinkOption {
+	return func(l *linkSettings) { l.remoteTerminus().Timeout = time.Duration(seconds) * time.Second }
+}
+
+// The settings for the terminus at the remote end of the link.
+func (l *linkSettings) remoteTerminus() *TerminusSettings {
+	if l.isSender {
+		return &l.targetSettings
+	}
+	return &l.sourceSettings
+}
+
 // AtMostOnce returns a LinkOption that sets "fire and forget" mode, messages
 // are sent but no acknowledgment is received, messages can be lost if there is
 // a network failure. Sets SndSettleMode=SendSettled and RcvSettleMode=RcvFirst
@@ -125,6 +313,121 @@ func Filter(m map[amqp.Symbol]interface{}) LinkOption {
 	return func(l *linkSettings) { l.filter = m }
 }
 
+// Filter key and descriptor for a JMS-style message selector.
+const (
+	selectorKey        = amqp.Symbol("selector")
+	selectorDescriptor = amqp.Symbol("apache.org:selector-filter:string")
+)
+
+// Selector returns a LinkOption that adds a JMS-style message selector, for
+// example "JMSPriority > 4", to the filter set of a receiver's source.
+// It is added to any filter set by a preceding Filter() option, a following
+// Filter() option replaces it.
+//
+// Brokers that do not support selectors omit the selector from the filter set
+// they return, see Receiver.RemoteFilter()
+func Selector(selector string) LinkOption {
+	return func(l *linkSettings) {
+		m := make(map[amqp.Symbol]interface{}, len(l.filter)+1)
+		for k, v := range l.filter {
+			m[k] = v
+		}
+		m[selectorKey] = amqp.Described{Descriptor: selectorDescriptor, Value: selector}
+		l.filter = m
+	}
+}
+
+// LinkProperties returns a LinkOption that sets the properties sent in the
+// attach frame. Some brokers use link properties to configure the link.
+func LinkProperties(m map[amqp.Symbol]interface{}) LinkOption {
+	return func(l *linkSettings) { l.properties = m }
+}
+
+// OfferedCapabilities returns a LinkOption that sets the capabilities offered
+// to the remote peer when attaching.
+func OfferedCapabilities(caps ...amqp.Symbol) LinkOption {
+	return func(l *linkSettings) { l.offeredCapabilities = caps }
+}
+
+// DesiredCapabilities returns a LinkOption that sets the capabilities requested
+// from the remote peer when attaching.
+func DesiredCapabilities(caps ...amqp.Symbol) LinkOption {
+	return func(l *linkSettings) { l.desiredCapabilities = caps }
+}
+
+// MaxMessageSize returns a LinkOption that sets the largest message (in bytes)
+// that this end of the link will accept. 0 means no limit.
+func MaxMessageSize(size uint64) LinkOption {
+	return func(l *linkSettings) { l.maxMessageSize = size }
+}
+
+// AutoDrainOnClose returns a LinkOption that makes Receiver.Close() drain the
+// link before closing it, see Receiver.Drain(). Messages in flight arrive
+// before the link closes, and the sender knows no more are wanted. Close()
+// returns at once but the link does not close until the peer completes the
+// drain, or 10 seconds have passed. Use Receiver.CloseWithDrain() to choose
+// how long to wait. Not relevant for a sender.
+func AutoDrainOnClose() LinkOption { return func(l *linkSettings) { l.autoDrain = true } }
+
+// SendTimeout returns a LinkOption that sets the default send timeout for a
+// sender, see Sender.SetSendTimeout(). Not relevant for a receiver.
+func SendTimeout(d time.Duration) LinkOption {
+	return func(l *linkSettings) { l.sendTimeout = d }
+}
+
+// DeliveryTags returns a LinkOption that makes a sender use tags from
+// generate for messages sent without a tag of their own, see
+// Sender.SendTagged(). generate is called in the connection goroutine for
+// each message, it must not block. The tags must be unique among the
+// unsettled messages on the sender, a tag longer than MaxDeliveryTagSize
+// fails the send with ErrDeliveryTagTooLong and an empty tag is replaced by
+// the library's default. Not relevant for a receiver.
+func DeliveryTags(generate func() []byte) LinkOption {
+	return func(l *linkSettings) { l.tagGenerator = generate }
+}
+
+// AutoMessageID returns a LinkOption that makes a sender set the message-id of
+// messages sent without one to a value returned by generate. The id is set on
+// the amqp.Message itself, so it can be logged after the send. If generate is
+// nil the id is a random (version 4) amqp.UUID. generate is called in the
+// connection goroutine, it must not block and must return a string, uint64,
+// amqp.UUID or amqp.Binary. Not relevant for a receiver.
+func AutoMessageID(generate func() interface{}) LinkOption {
+	if generate == nil {
+		generate = func() interface{} { return amqp.UUID(proton.UUID4()) }
+	}
+	return func(l *linkSettings) { l.messageID = generate }
+}
+
+// RequireMessageID returns a LinkOption that makes a sender refuse messages
+// that have no message-id: they are Unsent with ErrMissingMessageID.
+// AutoMessageID() takes precedence. Not relevant for a receiver.
+func RequireMessageID() LinkOption {
+	return func(l *linkSettings) { l.requireMessageID = true }
+}
+
+// MaxUnsettled returns a LinkOption that limits a sender to n messages sent
+// and waiting for an outcome. Further messages wait, as they do for credit,
+// until an outcome arrives: the Send methods block and TrySend returns ok ==
+// false. The wait ends with an Unsent outcome if the send times out or its
+// context is done. Pre-settled messages are not counted. 0 means no limit,
+// which is the default. Not relevant for a receiver.
+func MaxUnsettled(n int) LinkOption {
+	return func(l *linkSettings) { l.maxUnsettled = n }
+}
+
+// WaitForSettlement returns a LinkOption that makes a sender report the
+// Outcome of a message when the remote receiver settles it. By default the
+// Outcome is reported when the receiver's outcome arrives, which may be before
+// the receiver settles. Outcome.OutcomeAt() and SettledAt() give both times.
+//
+// A receiver with RcvSecond waits for the sender to settle first, so the
+// sender settles when the outcome arrives and reports the Outcome then,
+// with or without this option. Not relevant for a receiver.
+func WaitForSettlement() LinkOption {
+	return func(l *linkSettings) { l.waitSettled = true }
+}
+
 // SourceSettings returns a LinkOption that sets all the SourceSettings.
 // Note: it will override the source address set by a Source() option
 func SourceSettings(ts TerminusSettings) LinkOption {
@@ -170,9 +473,30 @@ type linkSettings struct {
 	rcvSettle      RcvSettleMode
 	capacity       int
 	prefetch       bool
+	window         int        // Pre-fetch window, <= capacity
+	manualCredit   bool       // Credit is issued only by Receiver.Flow()
+	creditPolicy   FlowPolicy // Credit for the pre-fetch window, nil tops up on every receive
+	autoDrain      bool       // Drain credit before closing a receiver
+	maxMessageSize uint64
+	sendTimeout    time.Duration // Initial Sender.SendTimeout()
+	tagGenerator   func() []byte // Delivery tags for a sender, nil for the default
 	filter         map[amqp.Symbol]interface{}
+	filterUpdater  FilterUpdater
 	session        *session
 	pLink          proton.Link
+	remote         bool // Opened by the remote peer
+	anonymous      bool // Sender with a null target
+	coordinator    bool // Sender to a transaction coordinator
+	nameRegistered bool // linkName is registered with the connection, see nameRegistry
+
+	messageID        func() interface{} // Message-id for messages sent without one
+	requireMessageID bool               // Fail messages sent without a message-id
+	maxUnsettled     int                // Limit on sent messages waiting for an outcome, 0 for none
+	waitSettled      bool               // Report outcomes when the receiver settles
+
+	properties          map[amqp.Symbol]interface{}
+	offeredCapabilities []amqp.Symbol
+	desiredCapabilities []amqp.Symbol
 }
 
 // Advanced AMQP settings for the source or target of a link.
@@ -183,37 +507,282 @@ type TerminusSettings struct {
 	Expiry     proton.ExpiryPolicy
 	Timeout    time.Duration
 	Dynamic    bool
+
+	// DefaultOutcome is the outcome the source uses for messages that are
+	// settled without one, for example ReleasedState{}. It can be any value
+	// that marshals as a described outcome, nil if there is none. Only used
+	// for a source.
+	DefaultOutcome interface{}
+	// Outcomes lists the outcomes the source supports, for example
+	// "amqp:accepted:list". Only used for a source.
+	Outcomes []amqp.Symbol
+	// DistributionMode says if the source moves messages to the receiver or
+	// sends copies, see Browse(). Only used for a source.
+	DistributionMode proton.DistributionMode
 }
 
 func makeTerminusSettings(t proton.Terminus) TerminusSettings {
-	return TerminusSettings{
+	ts := TerminusSettings{
 		Durability: t.Durability(),
 		Expiry:     t.ExpiryPolicy(),
 		Timeout:    t.Timeout(),
 		Dynamic:    t.IsDynamic(),
+
+		DistributionMode: t.DistributionMode(),
+	}
+	if d := t.DefaultOutcome(); !d.Empty() {
+		var v interface{}
+		if d.Unmarshal(&v) == nil {
+			ts.DefaultOutcome = decodeOutcome(v)
+		}
+	}
+	if o := t.Outcomes(); !o.Empty() {
+		_ = o.Unmarshal(&ts.Outcomes) // Ignore outcomes that are not symbols
 	}
+	return ts
+}
+
+// setOutcomes sets the default outcome and outcomes of a source terminus.
+func (ts *TerminusSettings) setOutcomes(t proton.Terminus) error {
+	if ts.DefaultOutcome != nil {
+		if err := t.DefaultOutcome().Marshal(ts.DefaultOutcome); err != nil {
+			return err
+		}
+	}
+	if len(ts.Outcomes) > 0 {
+		if err := t.Outcomes().Marshal(ts.Outcomes); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type link struct {
 	endpoint
 	linkSettings
+	generation int // Incremented each time the link is re-attached after reconnect
+	credit     int // Credit last reported to Metrics, used in handler goroutine
+}
+
+func (l *linkSettings) Source() string                          { return l.source }
+func (l *linkSettings) Target() string                          { return l.target }
+func (l *linkSettings) LinkName() string                        { return l.linkName }
+func (l *linkSettings) IsSender() bool                          { return l.isSender }
+func (l *linkSettings) IsReceiver() bool                        { return !l.isSender }
+func (l *linkSettings) SndSettle() SndSettleMode                { return l.sndSettle }
+func (l *linkSettings) RcvSettle() RcvSettleMode                { return l.rcvSettle }
+func (l *linkSettings) Filter() map[amqp.Symbol]interface{}     { return l.filter }
+func (l *linkSettings) Properties() map[amqp.Symbol]interface{} { return l.properties }
+func (l *linkSettings) SourceSettings() TerminusSettings        { return l.sourceSettings }
+func (l *linkSettings) TargetSettings() TerminusSettings        { return l.targetSettings }
+
+// Remote attach fields are read directly from the proton link. This is safe for
+// incoming links because the handler is blocked until they are accepted. The
+// link methods below inject the read for open links.
+
+func (l *linkSettings) RemoteProperties() (m map[amqp.Symbol]interface{}) {
+	if d := l.pLink.RemoteProperties(); !d.IsNil() && !d.Empty() {
+		_ = d.Unmarshal(&m) // Ignore invalid properties
+	}
+	return m
+}
+
+func (l *linkSettings) RemoteOfferedCapabilities() []amqp.Symbol {
+	return capabilities(l.pLink.RemoteOfferedCapabilities())
+}
+
+func (l *linkSettings) RemoteDesiredCapabilities() []amqp.Symbol {
+	return capabilities(l.pLink.RemoteDesiredCapabilities())
+}
+
+func (l *linkSettings) RemoteMaxMessageSize() uint64 { return l.pLink.RemoteMaxMessageSize() }
+
+func (l *linkSettings) RemoteSourceSettings() TerminusSettings {
+	return makeTerminusSettings(l.pLink.RemoteSource())
+}
+
+func (l *linkSettings) RemoteTargetSettings() TerminusSettings {
+	return makeTerminusSettings(l.pLink.RemoteTarget())
+}
+
+func (l *linkSettings) RemoteSndSettle() SndSettleMode {
+	return SndSettleMode(l.pLink.RemoteSndSettleMode())
+}
+
+func (l *linkSettings) RemoteRcvSettle() RcvSettleMode {
+	return RcvSettleMode(l.pLink.RemoteRcvSettleMode())
+}
+
+// capabilities may be a single symbol or an array of symbols.
+func capabilities(d proton.Data) (caps []amqp.Symbol) {
+	if d.IsNil() || d.Empty() {
+		return nil
+	}
+	var v interface{}
+	_ = d.Unmarshal(&v)
+	switch v := v.(type) {
+	case amqp.Symbol:
+		caps = []amqp.Symbol{v}
+	case []amqp.Symbol:
+		caps = v
+	}
+	return caps
+}
+
+// Set the settle modes, properties, capabilities and max-message-size to send
+// in the attach frame. An incoming link echoes the remote settle modes.
+func (l *linkSettings) setAttachFields() {
+	l.pLink.SetSndSettleMode(proton.SndSettleMode(l.sndSettle))
+	l.pLink.SetRcvSettleMode(proton.RcvSettleMode(l.rcvSettle))
+	l.pLink.SetMaxMessageSize(l.maxMessageSize)
+	if len(l.properties) > 0 {
+		if err := l.pLink.Properties().Marshal(l.properties); err != nil {
+			panic(err) // Shouldn't happen
+		}
+	}
+	if len(l.offeredCapabilities) > 0 {
+		if err := l.pLink.OfferedCapabilities().Marshal(l.offeredCapabilities); err != nil {
+			panic(err) // Shouldn't happen
+		}
+	}
+	if len(l.desiredCapabilities) > 0 {
+		if err := l.pLink.DesiredCapabilities().Marshal(l.desiredCapabilities); err != nil {
+			panic(err) // Shouldn't happen
+		}
+	}
+}
+
+func (l *link) RemoteProperties() (m map[amqp.Symbol]interface{}) {
+	_ = l.connection().injectWait(func() error {
+		if l.Error() == nil {
+			m = l.linkSettings.RemoteProperties()
+		}
+		return nil
+	})
+	return
+}
+
+func (l *link) RemoteOfferedCapabilities() (caps []amqp.Symbol) {
+	_ = l.connection().injectWait(func() error {
+		if l.Error() == nil {
+			caps = l.linkSettings.RemoteOfferedCapabilities()
+		}
+		return nil
+	})
+	return
+}
+
+func (l *link) RemoteDesiredCapabilities() (caps []amqp.Symbol) {
+	_ = l.connection().injectWait(func() error {
+		if l.Error() == nil {
+			caps = l.linkSettings.RemoteDesiredCapabilities()
+		}
+		return nil
+	})
+	return
+}
+
+func (l *link) RemoteMaxMessageSize() (size uint64) {
+	_ = l.connection().injectWait(func() error {
+		if l.Error() == nil {
+			size = l.linkSettings.RemoteMaxMessageSize()
+		}
+		return nil
+	})
+	return
+}
+
+func (l *link) RemoteSourceSettings() (ts TerminusSettings) {
+	_ = l.connection().injectWait(func() error {
+		if l.Error() == nil {
+			ts = l.linkSettings.RemoteSourceSettings()
+		}
+		return nil
+	})
+	return
+}
+
+func (l *link) RemoteTargetSettings() (ts TerminusSettings) {
+	_ = l.connection().injectWait(func() error {
+		if l.Error() == nil {
+			ts = l.linkSettings.RemoteTargetSettings()
+		}
+		return nil
+	})
+	return
+}
+
+func (l *link) RemoteSndSettle() (m SndSettleMode) {
+	m = l.SndSettle()
+	_ = l.connection().injectWait(func() error {
+		if l.Error() == nil {
+			m = l.linkSettings.RemoteSndSettle()
+		}
+		return nil
+	})
+	return
+}
+
+func (l *link) RemoteRcvSettle() (m RcvSettleMode) {
+	m = l.RcvSettle()
+	_ = l.connection().injectWait(func() error {
+		if l.Error() == nil {
+			m = l.linkSettings.RemoteRcvSettle()
+		}
+		return nil
+	})
+	return
 }
 
-func (l *linkSettings) Source() string                      { return l.source }
-func (l *linkSettings) Target() string                      { return l.target }
-func (l *linkSettings) LinkName() string                    { return l.linkName }
-func (l *linkSettings) IsSender() bool                      { return l.isSender }
-func (l *linkSettings) IsReceiver() bool                    { return !l.isSender }
-func (l *linkSettings) SndSettle() SndSettleMode            { return l.sndSettle }
-func (l *linkSettings) RcvSettle() RcvSettleMode            { return l.rcvSettle }
-func (l *linkSettings) Filter() map[amqp.Symbol]interface{} { return l.filter }
-func (l *linkSettings) SourceSettings() TerminusSettings    { return l.sourceSettings }
-func (l *linkSettings) TargetSettings() TerminusSettings    { return l.targetSettings }
+func (l *link) Session() Session        { return l.session }
+func (l *link) Connection() Connection  { return l.session.Connection() }
+func (l *link) connection() *connection { return l.session.connection }
+func (l *link) handler() *handler       { return l.session.connection.handler }
+
+// closed releases a registered link name so it can be used by a new link.
+func (l *link) closed(err error) error {
+	if l.nameRegistered {
+		l.nameRegistered = false
+		l.connection().linkNames(l.isSender).Release(l.linkName)
+	}
+	return l.endpoint.closed(err)
+}
+
+// ErrLinkNameInUse is returned when opening a link with the same name and
+// direction as an open link on the same connection, see LinkName().
+var ErrLinkNameInUse = fmt.Errorf("link name in use")
+
+// nameRegistry tracks the names of open links in one direction on a
+// connection. AMQP requires link names to be unique within a container pair.
+type nameRegistry struct {
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+// Register returns ErrLinkNameInUse if name is registered already, otherwise
+// registers it. The empty name is not registered, a name will be generated.
+func (r *nameRegistry) Register(name string) error {
+	if name == "" {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.names[name]; ok {
+		return ErrLinkNameInUse
+	}
+	if r.names == nil {
+		r.names = make(map[string]struct{})
+	}
+	r.names[name] = struct{}{}
+	return nil
+}
 
-func (l *link) Session() Session       { return l.session }
-func (l *link) Connection() Connection { return l.session.Connection() }
-func (l *link) engine() *proton.Engine { return l.session.connection.engine }
-func (l *link) handler() *handler      { return l.session.connection.handler }
+// Release makes name available to Register again.
+func (r *nameRegistry) Release(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.names, name)
+}
 
 // Open a link and return the linkSettings.
 func makeLocalLink(sn *session, isSender bool, setting ...LinkOption) (linkSettings, error) {
@@ -226,16 +795,37 @@ func makeLocalLink(sn *session, isSender bool, setting ...LinkOption) (linkSetti
 	for _, set := range setting {
 		set(&l)
 	}
+	if sn.linksFull() {
+		return l, amqp.Errorf(amqp.ResourceLimitExceeded, "session handle-max %d exceeded", sn.handleMax)
+	}
+	names := sn.connection.linkNames(isSender)
+	if err := names.Register(l.linkName); err != nil {
+		return l, err
+	}
 	if l.linkName == "" {
 		l.linkName = l.session.connection.container.nextLinkName()
+	} else {
+		l.nameRegistered = true
+	}
+	if err := l.openPLink(); err != nil {
+		if l.nameRegistered {
+			names.Release(l.linkName)
+		}
+		return l, err
 	}
+	l.pLink.Open()
+	return l, nil
+}
+
+// Create and configure the proton link from the settings.
+func (l *linkSettings) openPLink() error {
 	if l.IsSender() {
 		l.pLink = l.session.pSession.Sender(l.linkName)
 	} else {
 		l.pLink = l.session.pSession.Receiver(l.linkName)
 	}
 	if l.pLink.IsNil() {
-		return l, fmt.Errorf("cannot create link %s", l.pLink)
+		return fmt.Errorf("cannot create link %s", l.pLink)
 	}
 	l.pLink.Source().SetAddress(l.source)
 
@@ -248,17 +838,28 @@ func makeLocalLink(sn *session, isSender bool, setting ...LinkOption) (linkSetti
 	l.pLink.Source().SetExpiryPolicy(l.sourceSettings.Expiry)
 	l.pLink.Source().SetTimeout(l.sourceSettings.Timeout)
 	l.pLink.Source().SetDynamic(l.sourceSettings.Dynamic)
+	l.pLink.Source().SetDistributionMode(l.sourceSettings.DistributionMode)
+	if err := l.sourceSettings.setOutcomes(l.pLink.Source()); err != nil {
+		l.pLink.Free()
+		return err
+	}
 
-	l.pLink.Target().SetAddress(l.target)
+	switch {
+	case l.coordinator:
+		l.pLink.Target().SetType(proton.Coordinator)
+		if err := l.pLink.Target().Capabilities().Marshal([]amqp.Symbol{localTransactions}); err != nil {
+			panic(err) // Shouldn't happen
+		}
+	case !l.anonymous:
+		l.pLink.Target().SetAddress(l.target)
+	}
 	l.pLink.Target().SetDurability(l.targetSettings.Durability)
 	l.pLink.Target().SetExpiryPolicy(l.targetSettings.Expiry)
 	l.pLink.Target().SetTimeout(l.targetSettings.Timeout)
 	l.pLink.Target().SetDynamic(l.targetSettings.Dynamic)
 
-	l.pLink.SetSndSettleMode(proton.SndSettleMode(l.sndSettle))
-	l.pLink.SetRcvSettleMode(proton.RcvSettleMode(l.rcvSettle))
-	l.pLink.Open()
-	return l, nil
+	l.setAttachFields()
+	return nil
 }
 
 func makeIncomingLinkSettings(pLink proton.Link, sn *session) linkSettings {
@@ -275,6 +876,7 @@ func makeIncomingLinkSettings(pLink proton.Link, sn *session) linkSettings {
 		prefetch:       false,
 		pLink:          pLink,
 		session:        sn,
+		remote:         true,
 	}
 	filter := l.pLink.RemoteSource().Filter()
 	if !filter.Empty() {
@@ -285,7 +887,7 @@ func makeIncomingLinkSettings(pLink proton.Link, sn *session) linkSettings {
 
 // Not part of Link interface but use by Sender and Receiver.
 func (l *link) Credit() (credit int, err error) {
-	err = l.engine().InjectWait(func() error {
+	err = l.connection().injectWait(func() error {
 		if l.Error() != nil {
 			return l.Error()
 		}
@@ -299,9 +901,18 @@ func (l *link) Credit() (credit int, err error) {
 func (l *link) Capacity() int { return l.capacity }
 
 func (l *link) Close(err error) {
-	_ = l.engine().Inject(func() {
+	_ = l.connection().inject(func() {
 		if l.Error() == nil {
 			localClose(l.pLink, err)
 		}
 	})
 }
+
+func (l *link) Detach(err error) {
+	_ = l.connection().inject(func() {
+		if l.Error() == nil && l.pLink.State().LocalActive() {
+			l.pLink.Detach()
+			proton.CloseError(l.pLink, err)
+		}
+	})
+}
@@ -21,7 +21,12 @@ under the License.
 package electron
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -66,3 +71,653 @@ func TestLinkSettings(t *testing.T) {
 	c.Close(nil)
 	<-done
 }
+
+// Test that explicit link names are used and unnamed links get distinct names
+func TestLinkName(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+
+	snd, rcv := p.sender(LinkName("named"))
+	test.ErrorIf(t, test.Differ("named", snd.LinkName()))
+	test.ErrorIf(t, test.Differ("named", rcv.LinkName()))
+
+	// A name can't be reused by an open link in the same direction.
+	_, err := p.client.Sender(LinkName("named"))
+	test.ErrorIf(t, test.Differ(ErrLinkNameInUse, err))
+	_, err = p.client.Connection().Sender(LinkName("named")) // Another session
+	test.ErrorIf(t, test.Differ(ErrLinkNameInUse, err))
+	r, _ := p.receiver(LinkName("named"))
+	test.ErrorIf(t, r.Sync())
+	snd.Close(nil)
+	<-snd.Done()
+	snd, _ = p.sender(LinkName("named"))
+	test.ErrorIf(t, snd.Sync())
+
+	// Unnamed links get distinct names generated from the container-id.
+	snd1, rcv1 := p.sender()
+	snd2, _ := p.sender()
+	if !strings.HasPrefix(snd1.LinkName(), "client@") {
+		t.Errorf("bad generated name %q", snd1.LinkName())
+	}
+	test.ErrorIf(t, test.Differ(snd1.LinkName(), rcv1.LinkName()))
+	if snd1.LinkName() == snd2.LinkName() {
+		t.Errorf("duplicate generated name %q", snd1.LinkName())
+	}
+	r, s := p.receiver()
+	if r.LinkName() == "" || r.LinkName() != s.LinkName() {
+		t.Errorf("bad generated receiver name %q, %q", r.LinkName(), s.LinkName())
+	}
+}
+
+// Test that the source default-outcome and outcomes are sent in the attach
+func TestLinkDefaultOutcome(t *testing.T) {
+	b, err := amqp.Marshal(ReleasedState{}, nil)
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ([]byte{0x00, 0x53, 0x26, 0x45}, b)) // Described ulong 0x26, empty list
+
+	sink := make(chanSink, 100)
+	p := newPipe(t, []ConnectionOption{ConnectionFrameEvents(sink)}, nil)
+	defer func() { p.close() }()
+	outcomes := []amqp.Symbol{"amqp:accepted:list", "amqp:released:list"}
+	rcv, snd := p.receiver(Source("q"), SourceSettings(TerminusSettings{DefaultOutcome: ReleasedState{}, Outcomes: outcomes}))
+	test.FatalIf(t, rcv.Sync())
+
+	e := sink.next(Outbound)
+	for performativeName(e.performative) != "attach" {
+		e = sink.next(Outbound)
+	}
+	source := e.performative.(amqp.Described).Value.(amqp.List)[5].(amqp.Described).Value.(amqp.List)
+	test.ErrorIf(t, test.Differ(amqp.Described{Descriptor: uint64(0x26), Value: amqp.List{}}, source[8]))
+	test.ErrorIf(t, test.Differ(outcomes, source[9]))
+
+	test.ErrorIf(t, test.Differ(ReleasedState{}, snd.SourceSettings().DefaultOutcome))
+	test.ErrorIf(t, test.Differ(outcomes, snd.SourceSettings().Outcomes))
+	test.ErrorIf(t, test.Differ(ReleasedState{}, rcv.SourceSettings().DefaultOutcome))
+}
+
+func TestDecodeOutcome(t *testing.T) {
+	info := map[amqp.Symbol]interface{}{"x": int64(1)}
+	for _, v := range []interface{}{
+		AcceptedState{},
+		RejectedState{},
+		RejectedState{&amqp.Error{Name: "amqp:not-found", Description: "gone", Info: &info}},
+		ReleasedState{},
+		ModifiedState{true, false, nil},
+		ModifiedState{false, true, info},
+	} {
+		b, err := amqp.Marshal(v, nil)
+		test.FatalIf(t, err)
+		var x interface{}
+		_, err = amqp.Unmarshal(b, &x)
+		test.FatalIf(t, err)
+		test.ErrorIf(t, test.Differ(v, decodeOutcome(x)))
+	}
+	test.ErrorIf(t, test.Differ("x", decodeOutcome("x")))
+}
+
+// Test that settle modes are sent in the attach and the peer's modes are available
+func TestLinkSettleModes(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+
+	r, s := p.receiver(Source("q"), SndSettle(SndSettled), RcvSettle(RcvSecond))
+	test.FatalIf(t, r.Sync())
+	test.ErrorIf(t, test.Differ(SndSettled, s.SndSettle()))
+	test.ErrorIf(t, test.Differ(RcvSecond, s.RcvSettle()))
+	test.ErrorIf(t, test.Differ(SndSettled, r.RemoteSndSettle()))
+	test.ErrorIf(t, test.Differ(RcvSecond, r.RemoteRcvSettle()))
+
+	snd, rcv := p.sender(Target("q"))
+	test.FatalIf(t, snd.Sync())
+	test.ErrorIf(t, test.Differ(SndUnsettled, rcv.SndSettle()))
+	test.ErrorIf(t, test.Differ(RcvFirst, rcv.RcvSettle()))
+	test.ErrorIf(t, test.Differ(SndUnsettled, snd.RemoteSndSettle()))
+	test.ErrorIf(t, test.Differ(RcvFirst, snd.RemoteRcvSettle()))
+
+	snd.Close(nil)
+	// The local setting is returned once the link is closed.
+	test.ErrorIf(t, test.Differ(SndUnsettled, snd.RemoteSndSettle()))
+}
+
+// Test the receiver settles second: the sender gets the outcome and settles
+// before the receiver's acknowledgement returns.
+func TestRcvSettleSecond(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+
+	snd, rcv := p.sender(Target("q"), RcvSettle(RcvSecond))
+	test.FatalIf(t, snd.Sync())
+	test.ErrorIf(t, test.Differ(RcvSecond, rcv.RcvSettle()))
+	test.ErrorIf(t, test.Differ(RcvSecond, snd.RemoteRcvSettle()))
+
+	for i, ack := range []func(*ReceivedMessage) error{
+		(*ReceivedMessage).Accept,
+		(*ReceivedMessage).Reject,
+		(*ReceivedMessage).Release,
+	} {
+		outs := make(chan Outcome, 1)
+		go snd.SendAsync(amqp.NewMessageWith(i), outs, i) // Waits for credit
+		rm, err := rcv.Receive()
+		test.FatalIf(t, err)
+		test.ErrorIf(t, ack(&rm))
+		select {
+		case out := <-outs: // Must already have the outcome
+			want := []SentStatus{Accepted, Rejected, Released}[i]
+			test.ErrorIf(t, test.Differ(want, out.Status))
+			test.ErrorIf(t, test.Differ(out.OutcomeAt(), out.SettledAt()))
+		default:
+			t.Errorf("%v: acknowledged before the sender settled", i)
+			<-outs
+		}
+	}
+}
+
+func TestWaitForSettlement(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+
+	for _, wait := range []bool{false, true} {
+		opts := []LinkOption{Target("q")}
+		if wait {
+			opts = append(opts, WaitForSettlement())
+		}
+		snd, rcv := p.sender(opts...)
+		outs := make(chan Outcome, 1)
+		go snd.SendAsync(amqp.NewMessageWith(wait), outs, nil) // Waits for credit
+		rm, err := rcv.Receive()
+		test.FatalIf(t, err)
+		remote := func(f func(proton.Delivery)) {
+			test.FatalIf(t, p.server.(*connection).injectWait(func() error { f(rm.pDelivery); return nil }))
+		}
+
+		// Give an outcome but don't settle.
+		remote(func(d proton.Delivery) { d.Update(proton.Accepted) })
+		var out Outcome
+		if wait {
+			select {
+			case out = <-outs:
+				t.Fatalf("outcome before settlement: %v", out)
+			case <-time.After(10 * time.Millisecond):
+			}
+			remote(proton.Delivery.Settle)
+			out = <-outs
+			test.ErrorIf(t, test.Differ(Accepted, out.Status))
+			test.ErrorIf(t, test.Differ(false, out.SettledAt().IsZero()))
+			test.ErrorIf(t, test.Differ(false, out.SettledAt().Before(out.OutcomeAt())))
+		} else {
+			out = <-outs
+			test.ErrorIf(t, test.Differ(Accepted, out.Status))
+			test.ErrorIf(t, test.Differ(true, out.SettledAt().IsZero()))
+			remote(proton.Delivery.Settle)
+		}
+		test.ErrorIf(t, test.Differ(false, out.OutcomeAt().IsZero()))
+		snd.Close(nil)
+	}
+}
+
+// Test that a link refused by the remote peer reports the peer's error from Sync()
+func TestLinkRefused(t *testing.T) {
+	cConn, sConn := net.Pipe()
+	refuse := make(chan error, 1)
+	go func() { // Server
+		defer sConn.Close()
+		c, err := NewConnection(sConn, Server())
+		test.FatalIf(t, err)
+		for in := range c.Incoming() {
+			switch in.(type) {
+			case *IncomingSender, *IncomingReceiver:
+				in.Reject(<-refuse)
+			default:
+				in.Accept()
+			}
+		}
+	}()
+
+	c, err := NewConnection(cConn)
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+
+	notFound := amqp.Errorf(amqp.NotFound, "no such node")
+	refuse <- notFound
+	s, err := c.Sender(Target("missing"))
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(notFound, s.Sync()))
+	test.ErrorIf(t, test.Differ(notFound, s.Error()))
+
+	unauthorized := amqp.Errorf(amqp.UnauthorizedAccess, "not allowed")
+	refuse <- unauthorized
+	r, err := c.Receiver(Source("secret"))
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(unauthorized, r.Sync()))
+	_, err = r.Receive()
+	test.ErrorIf(t, test.Differ(unauthorized, err))
+}
+
+func TestLinkPolicy(t *testing.T) {
+	type request struct {
+		address, user string
+		sender        bool
+		props         map[amqp.Symbol]interface{}
+		caps          []amqp.Symbol
+	}
+	requests := make(chan request, 10)
+	unauthorized := amqp.Errorf(amqp.UnauthorizedAccess, "not allowed")
+	policy := func(in IncomingLink) error {
+		requests <- request{in.Address(), in.AuthenticatedUser(), in.IsSender(),
+			in.RemoteProperties(), in.RemoteDesiredCapabilities()}
+		if in.Address() != "allowed" {
+			return unauthorized
+		}
+		return nil
+	}
+	p := newPipe(t, nil, []ConnectionOption{LinkPolicy(policy)})
+	defer p.close()
+
+	props := map[amqp.Symbol]interface{}{"x": "y"}
+	snd, _ := p.sender(Target("allowed"), LinkProperties(props), DesiredCapabilities("cap"))
+	test.ErrorIf(t, snd.Sync())
+	test.ErrorIf(t, test.Differ(request{"allowed", "", false, props, []amqp.Symbol{"cap"}}, <-requests))
+
+	rcv, _ := p.receiver(Source("allowed"))
+	test.ErrorIf(t, rcv.Sync())
+	test.ErrorIf(t, test.Differ(request{"allowed", "", true, nil, nil}, <-requests))
+
+	snd, err := p.client.Sender(Target("secret"))
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(unauthorized, snd.Sync()))
+	test.ErrorIf(t, test.Differ(request{"secret", "", false, nil, nil}, <-requests))
+
+	rcv, err = p.client.Receiver(Source("secret"))
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(unauthorized, rcv.Sync()))
+	_, err = rcv.Receive()
+	test.ErrorIf(t, test.Differ(unauthorized, err))
+	test.ErrorIf(t, test.Differ(request{"secret", "", true, nil, nil}, <-requests))
+}
+
+// echoAttach sets the attach fields of an incoming link from the remote peer's,
+// offering the capabilities the peer desired and desiring those it offered.
+type echoAttach interface {
+	RemoteProperties() map[amqp.Symbol]interface{}
+	RemoteOfferedCapabilities() []amqp.Symbol
+	RemoteDesiredCapabilities() []amqp.Symbol
+	RemoteMaxMessageSize() uint64
+	SetMaxMessageSize(uint64)
+	SetProperties(map[amqp.Symbol]interface{})
+	SetOfferedCapabilities(...amqp.Symbol)
+	SetDesiredCapabilities(...amqp.Symbol)
+}
+
+func echoAttachFields(in echoAttach) {
+	in.SetMaxMessageSize(in.RemoteMaxMessageSize())
+	in.SetProperties(in.RemoteProperties())
+	in.SetOfferedCapabilities(in.RemoteDesiredCapabilities()...)
+	in.SetDesiredCapabilities(in.RemoteOfferedCapabilities()...)
+}
+
+// Test that link properties, capabilities and max-message-size are sent and
+// received in the attach frames.
+func TestLinkAttachFields(t *testing.T) {
+	cConn, sConn := net.Pipe()
+	go func() { // Echo server
+		defer sConn.Close()
+		c, err := NewConnection(sConn, Server())
+		test.FatalIf(t, err)
+		for in := range c.Incoming() {
+			switch in := in.(type) {
+			case *IncomingSender:
+				echoAttachFields(in)
+			case *IncomingReceiver:
+				echoAttachFields(in)
+			}
+			in.Accept()
+		}
+	}()
+
+	rc := &recordConn{Conn: cConn}
+	c, err := NewConnection(rc)
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+
+	props := map[amqp.Symbol]interface{}{"com.microsoft:timeout": int32(1000), "str": "hello"}
+	opts := []LinkOption{
+		LinkProperties(props),
+		OfferedCapabilities("one", "two"),
+		DesiredCapabilities("three"),
+		MaxMessageSize(4096),
+	}
+	s, err := c.Sender(append(opts, Target("echo"))...)
+	test.FatalIf(t, err)
+	test.FatalIf(t, s.Sync())
+	r, err := c.Receiver(append(opts, Source("echo"))...)
+	test.FatalIf(t, err)
+	test.FatalIf(t, r.Sync())
+	for _, l := range []LinkSettings{s, r} {
+		test.ErrorIf(t, test.Differ(props, l.Properties()))
+		test.ErrorIf(t, test.Differ(props, l.RemoteProperties()))
+		test.ErrorIf(t, test.Differ([]amqp.Symbol{"three"}, l.RemoteOfferedCapabilities()))
+		test.ErrorIf(t, test.Differ([]amqp.Symbol{"one", "two"}, l.RemoteDesiredCapabilities()))
+		test.ErrorIf(t, test.Differ(uint64(4096), l.RemoteMaxMessageSize()))
+	}
+
+	// The properties are encoded in the attach frames
+	for k, v := range props {
+		for _, x := range []interface{}{k, v} {
+			b, err := amqp.Marshal(x, nil)
+			test.FatalIf(t, err)
+			if !rc.contains(b) {
+				t.Errorf("%#v not in attach frame", x)
+			}
+		}
+	}
+
+	// Nothing set, nothing echoed
+	s, err = c.Sender(Target("plain"))
+	test.FatalIf(t, err)
+	test.FatalIf(t, s.Sync())
+	test.ErrorIf(t, test.Differ(map[amqp.Symbol]interface{}(nil), s.Properties()))
+	test.ErrorIf(t, test.Differ(map[amqp.Symbol]interface{}(nil), s.RemoteProperties()))
+	test.ErrorIf(t, test.Differ([]amqp.Symbol(nil), s.RemoteOfferedCapabilities()))
+	test.ErrorIf(t, test.Differ([]amqp.Symbol(nil), s.RemoteDesiredCapabilities()))
+	test.ErrorIf(t, test.Differ(uint64(0), s.RemoteMaxMessageSize()))
+}
+
+// recordConn records the bytes written to it.
+type recordConn struct {
+	net.Conn
+	lock    sync.Mutex
+	written []byte
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	c.written = append(c.written, b...)
+	c.lock.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *recordConn) contains(b []byte) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return bytes.Contains(c.written, b)
+}
+
+// filterUpdater sends an UPDATE-FILTER management request for the receiver's link.
+type filterUpdater struct{ snd Sender }
+
+func (u *filterUpdater) UpdateFilter(r Receiver, filter map[amqp.Symbol]interface{}) error {
+	m := amqp.NewMessageWith(filter)
+	m.SetApplicationProperties(map[string]interface{}{
+		"operation": "UPDATE-FILTER",
+		"link-name": r.LinkName(),
+	})
+	if out := u.snd.SendSync(m); out.Status != Accepted {
+		return fmt.Errorf("filter update failed: %v %v", out.Status, out.Error)
+	}
+	return nil
+}
+
+func TestSetFilter(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+
+	oldFilter := map[amqp.Symbol]interface{}{"selector": "colour = 'red'"}
+	newFilter := map[amqp.Symbol]interface{}{"selector": "colour = 'blue'"}
+
+	// No FilterUpdater
+	rcv, _ := p.receiver(Source("q"), Filter(oldFilter))
+	test.ErrorIf(t, test.Differ(ErrNotSupported, rcv.SetFilter(context.Background(), newFilter)))
+	test.ErrorIf(t, test.Differ(oldFilter, rcv.Filter()))
+
+	u := &filterUpdater{}
+	rcv, _ = p.receiver(Source("q"), LinkName("sub"), Filter(oldFilter), FilterUpdate(u))
+	var mgmt Receiver
+	u.snd, mgmt = p.sender(Target("$management"))
+
+	// Context already done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	test.ErrorIf(t, test.Differ(context.Canceled, rcv.SetFilter(ctx, newFilter)))
+
+	done := make(chan error)
+	go func() { done <- rcv.SetFilter(context.Background(), newFilter) }()
+	rm, err := mgmt.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(map[string]interface{}{
+		"operation": "UPDATE-FILTER",
+		"link-name": "sub",
+	}, rm.Message.ApplicationProperties()))
+	var got map[amqp.Symbol]interface{}
+	rm.Message.Unmarshal(&got)
+	test.ErrorIf(t, test.Differ(newFilter, got))
+	test.FatalIf(t, rm.Accept())
+	test.FatalIf(t, <-done)
+	test.ErrorIf(t, test.Differ(newFilter, rcv.Filter()))
+}
+
+func TestSelector(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+
+	selector := amqp.Described{
+		Descriptor: amqp.Symbol("apache.org:selector-filter:string"),
+		Value:      "JMSPriority > 4",
+	}
+	other := map[amqp.Symbol]interface{}{"other": "x"}
+	rcv, snd := p.receiver(Source("q"), Filter(other), Selector("JMSPriority > 4"))
+	test.FatalIf(t, rcv.Sync())
+	// Selector is added to the filter set sent in the attach frame
+	want := map[amqp.Symbol]interface{}{"other": "x", "selector": selector}
+	test.ErrorIf(t, test.Differ(want, snd.Filter()))
+	test.ErrorIf(t, test.Differ(want, rcv.Filter()))
+	test.ErrorIf(t, test.Differ(want, rcv.RemoteFilter()))
+	test.ErrorIf(t, test.Differ(map[amqp.Symbol]interface{}{"other": "x"}, other)) // Not modified
+
+	rcv, _ = p.receiver(Source("q"))
+	test.FatalIf(t, rcv.Sync())
+	test.ErrorIf(t, test.Differ(map[amqp.Symbol]interface{}(nil), rcv.RemoteFilter()))
+}
+
+func TestDurableSubscription(t *testing.T) {
+	cConn, sConn := net.Pipe()
+	senders := make(chan Sender, 1)
+	go func() { // Broker-like server, records subscription senders
+		defer sConn.Close()
+		c, err := NewConnection(sConn, Server())
+		test.FatalIf(t, err)
+		for in := range c.Incoming() {
+			if s, ok := in.Accept().(Sender); ok {
+				senders <- s
+			}
+		}
+	}()
+	c, err := NewConnection(cConn)
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+
+	durable := TerminusSettings{Durability: proton.Deliveries, Expiry: proton.ExpireNever}
+	r, err := c.Receiver(Source("topic"), DurableSubscription("sub"))
+	test.FatalIf(t, err)
+	test.FatalIf(t, r.Sync())
+	s := <-senders
+	test.ErrorIf(t, test.Differ("sub", s.LinkName()))
+	test.ErrorIf(t, test.Differ(durable, s.SourceSettings()))
+
+	// Detach keeps the subscription, both ends see Detached
+	r.Detach(nil)
+	<-r.Done()
+	test.ErrorIf(t, test.Differ(Detached, r.Error()))
+	<-s.Done()
+	test.ErrorIf(t, test.Differ(Detached, s.Error()))
+
+	// Resume and then end the subscription, both ends see Closed
+	r, err = c.Receiver(Source("topic"), DurableSubscription("sub"))
+	test.FatalIf(t, err)
+	test.FatalIf(t, r.Sync())
+	s = <-senders
+	test.ErrorIf(t, test.Differ("sub", s.LinkName()))
+	r.Close(nil)
+	<-r.Done()
+	test.ErrorIf(t, test.Differ(Closed, r.Error()))
+	<-s.Done()
+	test.ErrorIf(t, test.Differ(Closed, s.Error()))
+
+	// Lower level options set the terminus at the remote end of the link
+	r, err = c.Receiver(Source("q"), Durability(amqp.DurabilityConfiguration), ExpiryPolicy(amqp.ExpiryPolicySessionEnd))
+	test.FatalIf(t, err)
+	s = <-senders
+	test.ErrorIf(t, test.Differ(TerminusSettings{Durability: proton.Configuration, Expiry: proton.ExpireWithSession}, s.SourceSettings()))
+	test.ErrorIf(t, test.Differ(TerminusSettings{Durability: proton.Configuration, Expiry: proton.ExpireWithSession}, r.SourceSettings()))
+	snd, err := c.Sender(Target("q"), Durability(amqp.DurabilityUnsettledState), ExpiryPolicy(amqp.ExpiryPolicyNever))
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ(durable, snd.TargetSettings()))
+}
+
+// attachTerminus returns the fields of the source (i == 5) or target (i == 6)
+// in the next outbound attach frame.
+func attachTerminus(sink chanSink, i int) amqp.List {
+	for {
+		if e := sink.next(Outbound); performativeName(e.performative) == "attach" {
+			fields := e.performative.(amqp.Described).Value.(amqp.List)
+			return fields[i].(amqp.Described).Value.(amqp.List)
+		}
+	}
+}
+
+func TestTerminusTimeout(t *testing.T) {
+	sink := make(chanSink, 100)
+	p := newPipe(t, []ConnectionOption{ConnectionFrameEvents(sink)}, nil)
+	defer p.close()
+
+	r, s := p.receiver(DynamicReceiver(), TerminusTimeout(60), TerminusExpiryPolicy(amqp.ExpiryPolicyConnectionClose))
+	test.FatalIf(t, r.Sync())
+	source := attachTerminus(sink, 5)
+	test.ErrorIf(t, test.Differ(amqp.Symbol("connection-close"), source[2])) // expiry-policy
+	test.ErrorIf(t, test.Differ(uint32(60), source[3]))                      // timeout
+	test.ErrorIf(t, test.Differ(true, source[4]))                            // dynamic
+	for _, ts := range []TerminusSettings{r.SourceSettings(), s.SourceSettings(), r.RemoteSourceSettings()} {
+		test.ErrorIf(t, test.Differ(proton.ExpireWithConnection, ts.Expiry))
+		test.ErrorIf(t, test.Differ(time.Minute, ts.Timeout))
+	}
+
+	snd, rcv := p.sender(Target("q"), TerminusTimeout(5), ExpiryPolicy(amqp.ExpiryPolicyLinkDetach))
+	test.FatalIf(t, snd.Sync())
+	target := attachTerminus(sink, 6)
+	test.ErrorIf(t, test.Differ(amqp.Symbol("link-detach"), target[2]))
+	test.ErrorIf(t, test.Differ(uint32(5), target[3]))
+	for _, ts := range []TerminusSettings{snd.TargetSettings(), rcv.TargetSettings(), snd.RemoteTargetSettings()} {
+		test.ErrorIf(t, test.Differ(proton.ExpireWithLink, ts.Expiry))
+		test.ErrorIf(t, test.Differ(5*time.Second, ts.Timeout))
+	}
+	// The source of a sender is not affected.
+	test.ErrorIf(t, test.Differ(time.Duration(0), snd.RemoteSourceSettings().Timeout))
+}
+
+func TestBrowse(t *testing.T) {
+	sink := make(chanSink, 100)
+	p := newPipe(t, []ConnectionOption{ConnectionFrameEvents(sink)}, nil)
+	defer p.close()
+
+	for _, mode := range []RcvSettleMode{RcvFirst, RcvSecond} {
+		r, s := p.receiver(Source("q"), Browse(), SndSettle(SndSettled), RcvSettle(mode))
+		test.FatalIf(t, r.Sync())
+		source := attachTerminus(sink, 5)
+		test.ErrorIf(t, test.Differ(amqp.Symbol("copy"), source[6])) // distribution-mode
+		test.ErrorIf(t, test.Differ(proton.DistModeCopy, s.SourceSettings().DistributionMode))
+		test.ErrorIf(t, test.Differ(proton.DistModeCopy, r.RemoteSourceSettings().DistributionMode))
+
+		// Settling a pre-settled, browsed message is not an error.
+		for _, settle := range []func(*ReceivedMessage) error{(*ReceivedMessage).Accept, (*ReceivedMessage).Release} {
+			go s.SendForget(amqp.NewMessageWith("x"))
+			rm, err := r.Receive()
+			test.FatalIf(t, err)
+			test.ErrorIf(t, settle(&rm))
+		}
+		r.Close(nil)
+	}
+
+	// The default leaves the mode to the source.
+	r, _ := p.receiver(Source("q"))
+	test.FatalIf(t, r.Sync())
+	if source := attachTerminus(sink, 5); len(source) > 6 && source[6] != nil {
+		t.Errorf("unexpected distribution-mode %v", source[6])
+	}
+	test.ErrorIf(t, test.Differ(proton.DistModeUnspecified, r.RemoteSourceSettings().DistributionMode))
+}
+
+func TestLinkDetach(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+
+	// Detach with an error, both ends see a DetachError
+	want := amqp.Errorf("x:detach", "going away")
+	snd, rcv := p.sender(LinkName("l"))
+	test.FatalIf(t, snd.Sync())
+	snd.Detach(want)
+	<-snd.Done()
+	test.ErrorIf(t, test.Differ(DetachError{want}, snd.Error()))
+	<-rcv.Done()
+	test.ErrorIf(t, test.Differ(DetachError{want}, rcv.Error()))
+
+	// A remote detach-forced is a link error
+	snd, rcv = p.sender()
+	test.FatalIf(t, snd.Sync())
+	rcv.Detach(amqp.Errorf(amqp.DetachForced, "moved"))
+	<-snd.Done()
+	if !amqp.IsLinkError(snd.Error()) {
+		t.Errorf("not a link error: %v", snd.Error())
+	}
+
+	// Re-attach with the same name after a detach
+	snd, rcv = p.sender(LinkName("l"))
+	test.FatalIf(t, snd.Sync())
+	go func() { _ = snd.SendSync(amqp.NewMessageWith("x")) }()
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, rm.Accept())
+	test.ErrorIf(t, test.Differ("x", rm.Message.Body()))
+
+	// Remote detach of a receiver, clean detach on both ends
+	rcv.Detach(nil)
+	<-rcv.Done()
+	test.ErrorIf(t, test.Differ(Detached, rcv.Error()))
+	<-snd.Done()
+	test.ErrorIf(t, test.Differ(Detached, snd.Error()))
+	snd.Detach(nil) // No-op after the link is finished
+	snd.Close(nil)
+	test.ErrorIf(t, test.Differ(Detached, snd.Error()))
+}
+
+// One end detaches while the other closes, in both directions. Depending on
+// which frame arrives first each end sees Detached or Closed, and both finish.
+func TestLinkDetachCloseRace(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+
+	check := func(l Endpoint) {
+		t.Helper()
+		select {
+		case <-l.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not finished", l)
+		}
+		if err := l.Error(); err != Detached && err != Closed {
+			t.Errorf("%s: want Detached or Closed, got %v", l, err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		snd, rcv := p.sender(LinkName("l"))
+		test.FatalIf(t, snd.Sync())
+		go snd.Detach(nil)
+		rcv.Close(nil)
+		check(snd)
+		check(rcv)
+
+		snd, rcv = p.sender(LinkName("l"))
+		test.FatalIf(t, snd.Sync())
+		go snd.Close(nil)
+		rcv.Detach(nil)
+		check(snd)
+		check(rcv)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"fmt"
+)
+
+// LogLevel is the severity of a log record. The values are the same as the
+// corresponding levels of the standard log/slog package.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = -4
+	LogInfo  LogLevel = 0
+	LogWarn  LogLevel = 4
+	LogError LogLevel = 8
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	case LogError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+}
+
+// Logger receives log records for the life-cycle of a connection and its
+// endpoints: dialing, opening, links attaching and detaching, credit,
+// reconnecting and errors. Set it with the ConnectionLogger() option.
+//
+// keyvals are alternating keys and values, the key is always a string. Every
+// record has a "connection" key. This is the same form as the log/slog
+// package, so with Go 1.21 or later a *slog.Logger can be used like this:
+//
+//	ConnectionLogger(LoggerFunc(func(l LogLevel, msg string, kv ...interface{}) {
+//	    logger.Log(context.Background(), slog.Level(l), msg, kv...)
+//	}))
+//
+// Log is called from the connection's goroutines, it must be safe for
+// concurrent use and must not call methods of the connection or its endpoints.
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// LoggerFunc adapts a function to the Logger interface.
+type LoggerFunc func(level LogLevel, msg string, keyvals ...interface{})
+
+func (f LoggerFunc) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	f(level, msg, keyvals...)
+}
+
+// ConnectionLogger returns a ConnectionOption that logs to l. By default a
+// connection does not log anything.
+func ConnectionLogger(l Logger) ConnectionOption {
+	return func(c *connection) { c.logger = l }
+}
+
+func (c *connection) log(level LogLevel, msg string, keyvals ...interface{}) {
+	if c.logger != nil {
+		c.logger.Log(level, msg, append([]interface{}{"connection", c.String()}, keyvals...)...)
+	}
+}
+
+// logError logs msg at LogError with the error, or at LogInfo if err is nil.
+func (c *connection) logError(msg string, err error, keyvals ...interface{}) {
+	if err == nil {
+		c.log(LogInfo, msg, keyvals...)
+	} else {
+		c.log(LogError, msg, append(keyvals, "error", err)...)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+type logRecord struct {
+	level   LogLevel
+	msg     string
+	keyvals []interface{}
+}
+
+type testLogger struct {
+	lock    sync.Mutex
+	records []logRecord
+}
+
+func (l *testLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.records = append(l.records, logRecord{level, msg, keyvals})
+}
+
+// messages returns the log messages so far, and checks every record has a
+// "connection" key and well-formed key-value pairs.
+func (l *testLogger) messages(t *testing.T) (msgs []string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, r := range l.records {
+		if len(r.keyvals)%2 != 0 || r.keyvals[0] != "connection" {
+			t.Errorf("bad key-values %q: %v", r.msg, r.keyvals)
+		}
+		for i := 0; i < len(r.keyvals); i += 2 {
+			if _, ok := r.keyvals[i].(string); !ok {
+				t.Errorf("bad key %q: %v", r.msg, r.keyvals)
+			}
+		}
+		msgs = append(msgs, r.msg)
+	}
+	return msgs
+}
+
+// find returns the first record with msg, fails the test if there is none.
+func (l *testLogger) find(t *testing.T, msg string) logRecord {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, r := range l.records {
+		if r.msg == msg {
+			return r
+		}
+	}
+	t.Helper()
+	t.Errorf("no %q in log", msg)
+	return logRecord{}
+}
+
+// contains is true if want appears in msgs in order, possibly with other messages in between.
+func contains(msgs []string, want ...string) bool {
+	for _, m := range msgs {
+		if len(want) > 0 && m == want[0] {
+			want = want[1:]
+		}
+	}
+	return len(want) == 0
+}
+
+func TestLogger(t *testing.T) {
+	cl, sl := &testLogger{}, &testLogger{}
+	p := newPipe(t, []ConnectionOption{ConnectionLogger(cl)}, []ConnectionOption{ConnectionLogger(sl)})
+	snd, rcv := p.sender(LinkName("foo"))
+	go func() { snd.SendSync(amqp.NewMessageWith("hello")) }()
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	test.FatalIf(t, rm.Accept())
+
+	snd.Close(nil)
+	_, err = rcv.Receive()
+	test.ErrorIf(t, test.Differ(Closed, err))
+	p.close()
+	test.ErrorIf(t, test.Differ(Closed, p.client.Connection().Wait()))
+	test.ErrorIf(t, test.Differ(Closed, p.server.Wait()))
+
+	msgs := cl.messages(t)
+	if want := []string{"open sent", "connection opened", "link attached", "connection closed"}; !contains(msgs, want...) {
+		t.Errorf("client: want %q in %q", want, msgs)
+	}
+	msgs = sl.messages(t)
+	if want := []string{"connection opened", "accepted incoming", "link attached", "credit granted", "link closed", "connection closed"}; !contains(msgs, want...) {
+		t.Errorf("server: want %q in %q", want, msgs)
+	}
+	r := sl.find(t, "credit granted")
+	test.ErrorIf(t, test.Differ(LogDebug, r.level))
+	test.ErrorIf(t, test.Differ([]interface{}{"connection", p.server.String(), "link", "foo", "credit", 1}, r.keyvals))
+	r = cl.find(t, "connection closed")
+	test.ErrorIf(t, test.Differ(LogInfo, r.level))
+}
+
+func TestLoggerReconnect(t *testing.T) {
+	l, err := net.Listen("tcp4", ":0")
+	test.FatalIf(t, err)
+	defer l.Close()
+	servers, rcvs := make(chan Connection), make(chan Receiver)
+	go reconnectServer(l, servers, rcvs)
+
+	log := &testLogger{}
+	c, err := Dial(l.Addr().Network(), l.Addr().String(),
+		Reconnect(BackoffExponential(time.Millisecond, 10*time.Millisecond, 0)), ConnectionLogger(log))
+	test.FatalIf(t, err)
+	srv := <-servers
+	srv.Disconnect(fmt.Errorf("drop"))
+	srv = <-servers
+	srv.Close(amqp.Errorf(amqp.ConnectionForced, "bye"))
+	test.ErrorIf(t, test.Differ(amqp.Errorf(amqp.ConnectionForced, "bye"), c.Wait()))
+
+	msgs := log.messages(t)
+	// The first connection may be dropped before the client sees it open.
+	if want := []string{"open sent", "disconnected", "reconnect scheduled", "dialing", "connection opened", "connection closed"}; !contains(msgs, want...) {
+		t.Errorf("want %q in %q", want, msgs)
+	}
+	r := log.find(t, "reconnect scheduled")
+	test.ErrorIf(t, test.Differ(LogInfo, r.level))
+	test.ErrorIf(t, test.Differ([]interface{}{"connection", c.String(), "attempt", 1, "delay", time.Millisecond}, r.keyvals))
+	r = log.find(t, "connection closed")
+	test.ErrorIf(t, test.Differ(LogError, r.level))
+}
@@ -0,0 +1,114 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metrics receives measurements from a connection and its links, for example
+// to update Prometheus counters. Set it with the ConnectionMetrics() option.
+//
+// Methods are called in the connection's event-loop goroutine, in the order
+// that things happen. They must return quickly and must not block: the
+// connection does nothing else while they run. They must not call methods of
+// the connection or its endpoints, which would deadlock. Hand work off to
+// another goroutine if necessary. Methods of a Metrics shared by several
+// connections may be called concurrently.
+//
+// Embed NopMetrics to implement only some of the methods.
+type Metrics interface {
+	// OnTransfer is called when a message of the given encoded size is sent or
+	// received on link.
+	OnTransfer(link Link, bytes int)
+
+	// OnSettle is called when the remote receiver settles a message sent on
+	// link, with the outcome and the time since the message was sent.
+	OnSettle(link Link, status SentStatus, latency time.Duration)
+
+	// OnCreditChange is called when the credit on link changes. For a sender a
+	// credit of 0 means it can't send until the remote receiver grants more.
+	OnCreditChange(link Link, credit int)
+
+	// OnFlow is called when a receiver issues credit to the remote sender,
+	// which sends a flow frame. See CreditPolicy().
+	OnFlow(link Link, credit int)
+
+	// OnConnectionStateChange is called when c is opened by the remote peer,
+	// starts reconnecting or closes. err is the reason for reconnecting or
+	// closing, nil for a clean close.
+	OnConnectionStateChange(c Connection, status ConnectionStatus, err error)
+}
+
+// NopMetrics is a Metrics that does nothing, the default for a connection.
+type NopMetrics struct{}
+
+func (NopMetrics) OnTransfer(Link, int)                                        {}
+func (NopMetrics) OnSettle(Link, SentStatus, time.Duration)                    {}
+func (NopMetrics) OnCreditChange(Link, int)                                    {}
+func (NopMetrics) OnFlow(Link, int)                                            {}
+func (NopMetrics) OnConnectionStateChange(Connection, ConnectionStatus, error) {}
+
+// ConnectionStatus is the state of a connection reported to Metrics and
+// Connection.StateChanges().
+type ConnectionStatus int
+
+const (
+	// ConnectionOpen means the remote peer has opened the connection, also
+	// after reconnecting.
+	ConnectionOpen ConnectionStatus = iota
+	// ConnectionReconnecting means the connection was lost and will be re-dialed.
+	ConnectionReconnecting
+	// ConnectionClosed means the connection is finished.
+	ConnectionClosed
+	// ConnectionConnecting means the connection has been created but the
+	// remote peer has not opened it yet. It is not reported to Metrics.
+	ConnectionConnecting
+)
+
+func (s ConnectionStatus) String() string {
+	switch s {
+	case ConnectionOpen:
+		return "open"
+	case ConnectionReconnecting:
+		return "reconnecting"
+	case ConnectionClosed:
+		return "closed"
+	case ConnectionConnecting:
+		return "connecting"
+	default:
+		return fmt.Sprintf("ConnectionStatus(%d)", int(s))
+	}
+}
+
+// ConnectionMetrics returns a ConnectionOption that reports measurements for
+// the connection and its links to m.
+func ConnectionMetrics(m Metrics) ConnectionOption {
+	return func(c *connection) { c.metrics = m }
+}
+
+// Called in handler goroutine, reports the credit of ep if it has changed.
+func (l *link) creditChanged(ep Link) {
+	if credit := l.pLink.Credit(); credit != l.credit {
+		l.credit = credit
+		l.connection().metrics.OnCreditChange(ep, credit)
+	}
+}
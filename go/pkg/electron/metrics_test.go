@@ -0,0 +1,148 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// testMetrics is an example Metrics adapter that records what it is told, a
+// real adapter would update counters and histograms.
+type testMetrics struct {
+	lock      sync.Mutex
+	transfers []int
+	settled   []SentStatus
+	latency   time.Duration
+	credit    map[Link][]int
+	flows     map[Link][]int
+	states    []ConnectionStatus
+	changed   chan ConnectionStatus
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{credit: make(map[Link][]int), flows: make(map[Link][]int), changed: make(chan ConnectionStatus, 10)}
+}
+
+func (m *testMetrics) OnTransfer(l Link, bytes int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.transfers = append(m.transfers, bytes)
+}
+
+func (m *testMetrics) OnSettle(l Link, status SentStatus, latency time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.settled = append(m.settled, status)
+	m.latency += latency
+}
+
+func (m *testMetrics) OnCreditChange(l Link, credit int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.credit[l] = append(m.credit[l], credit)
+}
+
+func (m *testMetrics) OnFlow(l Link, credit int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.flows[l] = append(m.flows[l], credit)
+}
+
+func (m *testMetrics) OnConnectionStateChange(c Connection, status ConnectionStatus, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.states = append(m.states, status)
+	m.changed <- status // Buffered, never blocks in these tests
+}
+
+func (m *testMetrics) get(f func()) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f()
+}
+
+func TestMetrics(t *testing.T) {
+	cm, sm := newTestMetrics(), newTestMetrics()
+	p := newPipe(t, []ConnectionOption{ConnectionMetrics(cm)}, []ConnectionOption{ConnectionMetrics(sm)})
+	snd, rcv := p.sender()
+
+	msg := amqp.NewMessageWith("hello")
+	out := make(chan Outcome, 1)
+	go func() { out <- snd.SendSync(msg) }()
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	test.FatalIf(t, rm.Accept())
+	test.FatalIf(t, (<-out).Error)
+	test.ErrorIf(t, test.Differ(ConnectionOpen, <-cm.changed))
+	test.ErrorIf(t, test.Differ(ConnectionOpen, <-sm.changed))
+
+	var sent, received []int
+	cm.get(func() {
+		sent = cm.transfers
+		test.ErrorIf(t, test.Differ([]SentStatus{Accepted}, cm.settled))
+		if cm.latency <= 0 {
+			t.Errorf("want latency > 0, got %v", cm.latency)
+		}
+		// Credit granted by Receive() and used by the message
+		test.ErrorIf(t, test.Differ([]int{1, 0}, cm.credit[snd]))
+	})
+	sm.get(func() {
+		received = sm.transfers
+		test.ErrorIf(t, test.Differ([]SentStatus(nil), sm.settled))
+		test.ErrorIf(t, test.Differ([]int{1, 0}, sm.credit[rcv]))
+	})
+	if len(sent) != 1 || sent[0] <= 0 {
+		t.Errorf("want one transfer, got %v", sent)
+	}
+	test.ErrorIf(t, test.Differ(sent, received))
+
+	p.close()
+	test.ErrorIf(t, test.Differ(ConnectionClosed, <-cm.changed))
+	test.ErrorIf(t, test.Differ(ConnectionClosed, <-sm.changed))
+}
+
+func TestMetricsReconnect(t *testing.T) {
+	l, err := net.Listen("tcp4", ":0")
+	test.FatalIf(t, err)
+	defer l.Close()
+	servers, rcvs := make(chan Connection), make(chan Receiver)
+	go reconnectServer(l, servers, rcvs)
+
+	m := newTestMetrics()
+	c, err := Dial(l.Addr().Network(), l.Addr().String(),
+		Reconnect(BackoffExponential(time.Millisecond, 10*time.Millisecond, 0)), ConnectionMetrics(m))
+	test.FatalIf(t, err)
+	srv := <-servers
+	test.ErrorIf(t, test.Differ(ConnectionOpen, <-m.changed))
+	srv.Disconnect(fmt.Errorf("drop"))
+	test.ErrorIf(t, test.Differ(ConnectionReconnecting, <-m.changed))
+	srv = <-servers
+	test.ErrorIf(t, test.Differ(ConnectionOpen, <-m.changed))
+	c.Close(nil)
+	test.ErrorIf(t, test.Differ(ConnectionClosed, <-m.changed))
+	srv.Close(nil)
+}
@@ -0,0 +1,102 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"sync"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+)
+
+// MessageHandler handles a message dispatched by a Multiplexer and returns how
+// to settle it: Accepted, Rejected or Released. A Rejected message is rejected
+// with err if it is not nil, see ReceivedMessage.RejectWith(). Any other
+// status releases the message.
+type MessageHandler func(m amqp.Message) (SentStatus, error)
+
+// Multiplexer receives messages from a Receiver and dispatches them to a
+// MessageHandler chosen by the message content-type, like an HTTP request
+// multiplexer chooses a handler by path.
+type Multiplexer struct {
+	// UnknownHandler is called for messages with a content-type that has no
+	// handler. If it is nil such messages are rejected with amqp:not-implemented.
+	UnknownHandler MessageHandler
+
+	r        Receiver
+	lock     sync.Mutex
+	handlers map[string]MessageHandler
+}
+
+// NewMultiplexer returns a Multiplexer for messages from r. Call Handle() to
+// add handlers, then Run().
+func NewMultiplexer(r Receiver) *Multiplexer {
+	return &Multiplexer{r: r, handlers: make(map[string]MessageHandler)}
+}
+
+// Handle sets the handler for messages with contentType, replacing any
+// previous handler. It is safe to call while Run() is running.
+func (m *Multiplexer) Handle(contentType string, h MessageHandler) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.handlers[contentType] = h
+}
+
+func (m *Multiplexer) handler(contentType string) MessageHandler {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if h := m.handlers[contentType]; h != nil {
+		return h
+	}
+	if m.UnknownHandler != nil {
+		return m.UnknownHandler
+	}
+	return func(amqp.Message) (SentStatus, error) {
+		return Rejected, amqp.Errorf(amqp.NotImplemented, "no handler for content-type %q", contentType)
+	}
+}
+
+// Run receives messages and dispatches them one at a time, settling each one
+// with the outcome returned by its handler.
+//
+// Run returns ctx.Err() if ctx is done, or the receiver's error if the
+// receiver closes or a message cannot be settled.
+func (m *Multiplexer) Run(ctx context.Context) error {
+	for {
+		rm, err := m.r.ReceiveContext(ctx)
+		if err != nil {
+			return err
+		}
+		status, err := m.handler(rm.Message.ContentType())(rm.Message)
+		switch {
+		case status == Accepted:
+			err = rm.Accept()
+		case status == Rejected && err != nil:
+			err = rm.RejectWith(err)
+		case status == Rejected:
+			err = rm.Reject()
+		default:
+			err = rm.Release()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
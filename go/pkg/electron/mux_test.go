@@ -0,0 +1,73 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+func TestMultiplexer(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	p.prefetch = true
+	defer func() { p.close() }()
+	snd, rcv := p.sender(Target("mux"))
+
+	mux := NewMultiplexer(rcv)
+	text := make(chan interface{}, 1)
+	mux.Handle("text/plain", func(m amqp.Message) (SentStatus, error) {
+		text <- m.Body()
+		return Accepted, nil
+	})
+	mux.Handle("application/json", func(m amqp.Message) (SentStatus, error) {
+		return Rejected, amqp.Errorf(amqp.DecodeError, "bad json")
+	})
+	mux.Handle("application/retry", func(m amqp.Message) (SentStatus, error) { return Released, nil })
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- mux.Run(ctx) }()
+
+	send := func(contentType string) Outcome {
+		m := amqp.NewMessageWith(contentType)
+		m.SetContentType(contentType)
+		return snd.SendSync(m)
+	}
+	test.ErrorIf(t, test.Differ(Outcome{Status: Accepted}, plain(send("text/plain"))))
+	test.ErrorIf(t, test.Differ("text/plain", <-text))
+	test.ErrorIf(t, test.Differ(Outcome{Status: Rejected, Error: amqp.Errorf(amqp.DecodeError, "bad json")}, plain(send("application/json"))))
+	test.ErrorIf(t, test.Differ(Outcome{Status: Released}, plain(send("application/retry"))))
+	o := send("image/png")
+	test.ErrorIf(t, test.Differ(Rejected, o.Status))
+	test.ErrorIf(t, test.Differ(amqp.NotImplemented, o.Error.(amqp.Error).Name))
+
+	cancel()
+	test.ErrorIf(t, test.Differ(context.Canceled, <-done))
+
+	mux.UnknownHandler = func(m amqp.Message) (SentStatus, error) { return Accepted, nil }
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go func() { done <- mux.Run(ctx) }()
+	test.ErrorIf(t, test.Differ(Outcome{Status: Accepted}, plain(send("image/png"))))
+	rcv.Close(nil)
+	test.ErrorIf(t, test.Differ(Closed, <-done))
+}
@@ -0,0 +1,331 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// Copy data both ways between the proxy client and remote until either closes.
+// Reads from the client come from r, which may have buffered data.
+func tunnel(client net.Conn, r io.Reader, remote net.Conn) {
+	go func() { _, _ = io.Copy(client, remote); _ = client.Close() }()
+	_, _ = io.Copy(remote, r)
+	_ = remote.Close()
+}
+
+// Start an HTTP CONNECT proxy that requires Basic credentials auth if not
+// empty. Return the listener and a channel of tunnel addresses.
+func connectProxy(t *testing.T, auth string) (net.Listener, chan string) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	test.FatalIfN(1, t, err)
+	tunnels := make(chan string, 10)
+	refuse := func(conn net.Conn, status string) {
+		_, _ = io.WriteString(conn, "HTTP/1.1 "+status+"\r\n\r\n")
+		_ = conn.Close()
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				br := bufio.NewReader(conn)
+				req, err := http.ReadRequest(br)
+				switch {
+				case err != nil:
+					_ = conn.Close()
+					return
+				case req.Method != "CONNECT":
+					refuse(conn, "405 Method Not Allowed")
+					return
+				case auth != "" && req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)):
+					refuse(conn, "407 Proxy Authentication Required")
+					return
+				}
+				remote, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					refuse(conn, "502 Bad Gateway")
+					return
+				}
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				tunnels <- req.Host
+				tunnel(conn, br, remote)
+			}()
+		}
+	}()
+	return l, tunnels
+}
+
+// Start a SOCKS5 proxy that requires username/password authentication with
+// user, pass. Return the listener and a channel of tunnel addresses.
+func socks5Proxy(t *testing.T, user, pass string) (net.Listener, chan string) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	test.FatalIfN(1, t, err)
+	tunnels := make(chan string, 10)
+	read := func(conn net.Conn, n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil
+		}
+		return b
+	}
+	handshake := func(conn net.Conn) string {
+		head := read(conn, 2)
+		if head == nil || head[0] != socks5Version || read(conn, int(head[1])) == nil {
+			return ""
+		}
+		_, _ = conn.Write([]byte{socks5Version, socks5PasswordAuth})
+		head = read(conn, 2)
+		if head == nil {
+			return ""
+		}
+		u := string(read(conn, int(head[1])))
+		p := string(read(conn, int(read(conn, 1)[0])))
+		if u != user || p != pass {
+			_, _ = conn.Write([]byte{1, 1})
+			return ""
+		}
+		_, _ = conn.Write([]byte{1, 0})
+		req := read(conn, 4)
+		if req == nil || req[3] != socks5IPv4 {
+			return ""
+		}
+		addr := read(conn, net.IPv4len+2)
+		port := binary.BigEndian.Uint16(addr[net.IPv4len:])
+		return net.JoinHostPort(net.IP(addr[:net.IPv4len]).String(), fmt.Sprint(port))
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				address := handshake(conn)
+				if address == "" {
+					_ = conn.Close()
+					return
+				}
+				remote, err := net.Dial("tcp", address)
+				if err != nil {
+					_, _ = conn.Write([]byte{socks5Version, 5, 0, socks5IPv4, 0, 0, 0, 0, 0, 0})
+					_ = conn.Close()
+					return
+				}
+				_, _ = conn.Write([]byte{socks5Version, 0, 0, socks5IPv4, 127, 0, 0, 1, 0, 0})
+				tunnels <- address
+				tunnel(conn, conn, remote)
+			}()
+		}
+	}()
+	return l, tunnels
+}
+
+// Start an AMQP server, return the listener and a channel of its Receivers.
+func proxyTarget(t *testing.T) (net.Listener, chan Connection, chan Receiver) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	test.FatalIfN(1, t, err)
+	servers, rcvs := make(chan Connection, 10), make(chan Receiver, 10)
+	go reconnectServer(l, servers, rcvs)
+	return l, servers, rcvs
+}
+
+func proxyURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	test.FatalIfN(1, t, err)
+	return u
+}
+
+func TestProxy(t *testing.T) {
+	target, _, rcvs := proxyTarget(t)
+	defer target.Close()
+	proxy, tunnels := connectProxy(t, "user:secret")
+	defer proxy.Close()
+
+	c, err := Dial("tcp", target.Addr().String(), Proxy(proxyURL(t, fmt.Sprintf("http://user:secret@%s", proxy.Addr()))))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	test.ErrorIf(t, test.Differ(target.Addr().String(), <-tunnels))
+	s, err := c.Sender(Target("proxy"))
+	test.FatalIf(t, err)
+	webSocketSend(t, s, <-rcvs, "tunnelled")
+
+	// A nil URL dials directly.
+	c, err = Dial("tcp", target.Addr().String(), Proxy(nil))
+	test.FatalIf(t, err)
+	test.ErrorIf(t, c.Sync())
+	c.Close(nil)
+	select {
+	case a := <-tunnels:
+		t.Errorf("unexpected tunnel to %v", a)
+	default:
+	}
+}
+
+func TestProxyTLS(t *testing.T) {
+	cert, pool := testCertificate(t, "server")
+	amqpsURL, target, _, rcvs := tlsServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer target.Close()
+	proxy, tunnels := connectProxy(t, "")
+	defer proxy.Close()
+
+	// TLS is negotiated with the AMQP server, through the tunnel.
+	c, err := DialURL(amqpsURL, TLS(&tls.Config{RootCAs: pool}),
+		Proxy(proxyURL(t, fmt.Sprintf("http://%s", proxy.Addr()))))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	test.FatalIf(t, c.Sync())
+	test.ErrorIf(t, test.Differ(target.Addr().String(), <-tunnels))
+	s, err := c.Sender(Target("proxy"))
+	test.FatalIf(t, err)
+	webSocketSend(t, s, <-rcvs, "secret")
+}
+
+func TestProxySOCKS5(t *testing.T) {
+	target, _, rcvs := proxyTarget(t)
+	defer target.Close()
+	proxy, tunnels := socks5Proxy(t, "user", "secret")
+	defer proxy.Close()
+
+	c, err := Dial("tcp", target.Addr().String(), Proxy(proxyURL(t, fmt.Sprintf("socks5://user:secret@%s", proxy.Addr()))))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	test.ErrorIf(t, test.Differ(target.Addr().String(), <-tunnels))
+	s, err := c.Sender(Target("proxy"))
+	test.FatalIf(t, err)
+	webSocketSend(t, s, <-rcvs, "socks")
+
+	_, err = Dial("tcp", target.Addr().String(), Proxy(proxyURL(t, fmt.Sprintf("socks5://user:wrong@%s", proxy.Addr()))))
+	if _, ok := err.(ProxyError); !ok {
+		t.Errorf("want ProxyError got %#v", err)
+	}
+}
+
+func TestProxyError(t *testing.T) {
+	target, _, _ := proxyTarget(t)
+	defer target.Close()
+	proxy, _ := connectProxy(t, "user:secret")
+	defer proxy.Close()
+	address := target.Addr().String()
+
+	_, err := Dial("tcp", address, Proxy(proxyURL(t, fmt.Sprintf("http://user:wrong@%s", proxy.Addr()))))
+	if pe, ok := err.(ProxyError); !ok {
+		t.Errorf("want ProxyError got %#v", err)
+	} else {
+		test.ErrorIf(t, test.Differ(proxy.Addr().String(), pe.Proxy))
+		if !strings.Contains(pe.Err.Error(), "407") {
+			t.Errorf("want 407 status got %v", pe.Err)
+		}
+	}
+
+	// The proxy cannot reach the remote address.
+	closed, err := net.Listen("tcp4", "127.0.0.1:0")
+	test.FatalIf(t, err)
+	closed.Close()
+	_, err = Dial("tcp", closed.Addr().String(), Proxy(proxyURL(t, fmt.Sprintf("http://user:secret@%s", proxy.Addr()))))
+	if _, ok := err.(ProxyError); !ok || !strings.Contains(err.Error(), "502") {
+		t.Errorf("want ProxyError with 502 status got %#v", err)
+	}
+
+	// The proxy itself is unreachable, as distinct from the remote address.
+	_, err = Dial("tcp", address, Proxy(proxyURL(t, fmt.Sprintf("http://%s", closed.Addr()))))
+	if _, ok := err.(ProxyError); !ok {
+		t.Errorf("want ProxyError got %#v", err)
+	}
+	_, err = Dial("tcp", closed.Addr().String())
+	if _, ok := err.(ProxyError); ok || err == nil {
+		t.Errorf("want direct dial error got %#v", err)
+	}
+
+	_, err = Dial("tcp", address, Proxy(proxyURL(t, "ftp://localhost")))
+	if _, ok := err.(ProxyError); !ok || !strings.Contains(err.Error(), "scheme") {
+		t.Errorf("want ProxyError for scheme got %#v", err)
+	}
+}
+
+func TestProxyReconnect(t *testing.T) {
+	target, servers, rcvs := proxyTarget(t)
+	defer target.Close()
+	proxy, tunnels := connectProxy(t, "")
+	defer proxy.Close()
+
+	events := make(chan ReconnectEvent, 10)
+	c, err := Dial("tcp", target.Addr().String(), Proxy(proxyURL(t, fmt.Sprintf("http://%s", proxy.Addr()))),
+		Reconnect(BackoffExponential(time.Millisecond, 10*time.Millisecond, 0.5), ReconnectEvents(events)))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	s, err := c.Sender(Target("proxy"))
+	test.FatalIf(t, err)
+	webSocketSend(t, s, <-rcvs, "before")
+	<-tunnels
+	(<-servers).Disconnect(fmt.Errorf("drop"))
+
+	<-events
+	if e := <-events; !e.Reconnected {
+		t.Errorf("want reconnected event got %v", e)
+	}
+	// The connection is re-dialed through the proxy.
+	test.ErrorIf(t, test.Differ(target.Addr().String(), <-tunnels))
+	webSocketSend(t, s, <-rcvs, "after")
+}
+
+func TestProxyWebSocket(t *testing.T) {
+	l, reqs, _, rcvs := webSocketServer(t, nil, "amqp")
+	defer l.Close()
+	proxy, tunnels := connectProxy(t, "")
+	defer proxy.Close()
+
+	c, err := DialWebSocket(context.Background(), fmt.Sprintf("ws://%s/amqp", l.Addr()),
+		Proxy(proxyURL(t, fmt.Sprintf("http://%s", proxy.Addr()))))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	test.ErrorIf(t, test.Differ(l.Addr().String(), <-tunnels))
+	s, err := c.Sender(Target("ws"))
+	test.FatalIf(t, err)
+	webSocketSend(t, s, <-rcvs, "hello")
+	test.ErrorIf(t, test.Differ("/amqp", (<-reqs).URL.Path))
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	target, _, _ := proxyTarget(t)
+	defer target.Close()
+
+	// Connections to localhost are never proxied.
+	c, err := Dial("tcp", target.Addr().String(), ProxyFromEnvironment())
+	test.FatalIf(t, err)
+	test.ErrorIf(t, c.Sync())
+	c.Close(nil)
+}
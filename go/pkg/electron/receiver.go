@@ -20,7 +20,9 @@ under the License.
 package electron
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/apache/qpid-proton/go/pkg/amqp"
@@ -43,6 +45,28 @@ type Receiver interface {
 	// Receive remains on the link. It will be used by the next call to Receive.
 	ReceiveTimeout(timeout time.Duration) (ReceivedMessage, error)
 
+	// ReceiveContext is like Receive but gives up and returns ctx.Err() if ctx
+	// is done first. A message is never lost: if it arrives as ctx is done it
+	// stays buffered for the next call.
+	ReceiveContext(ctx context.Context) (ReceivedMessage, error)
+
+	// ReceiveBatch receives up to max messages. It blocks until the first
+	// message is available, then returns as soon as it has max messages or
+	// maxWait has elapsed since the call, whichever is first. Once maxWait is up
+	// it returns the messages already buffered without waiting for more.
+	// maxWait == 0 returns as soon as one or more messages are available,
+	// Forever waits for max messages.
+	//
+	// It never returns an empty batch with a nil error. If ctx is done or the
+	// receiver closes before the first message, it returns ctx.Err() or the
+	// receiver error, otherwise the messages received so far.
+	//
+	// max must be between 1 and Capacity(). Credit is issued to cover max
+	// messages, including when Prefetch() has a smaller window. As for
+	// ReceiveTimeout, credit left over when the batch is returned stays on the
+	// link for the next call.
+	ReceiveBatch(ctx context.Context, max int, maxWait time.Duration) ([]ReceivedMessage, error)
+
 	// Prefetch==true means the Receiver will automatically issue credit to the
 	// remote sender to keep its buffer as full as possible, i.e. it will
 	// "pre-fetch" messages independently of the application calling
@@ -64,6 +88,77 @@ type Receiver interface {
 	// Capacity is the size (number of messages) of the local message buffer
 	// These are messages received but not yet returned to the application by a call to Receive()
 	Capacity() int
+
+	// SetPrefetch changes the pre-fetch window of an open receiver. n > 0
+	// enables pre-fetch and keeps up to n messages buffered or in flight, n == 0
+	// disables pre-fetch. n must not exceed Capacity(). Credit already issued is
+	// not revoked, so a smaller window takes effect as that credit is used.
+	SetPrefetch(n int) error
+
+	// Flow issues n more credits to the remote sender, in addition to any credit
+	// already issued. Intended for receivers with the ManualCredit() option.
+	// Credit is limited so that messages in flight and buffered never exceed
+	// Capacity().
+	Flow(n int) error
+
+	// Credit returns the credit currently issued to the remote sender and the
+	// number of messages received and buffered but not yet returned by Receive().
+	Credit() (current, queued int)
+
+	// Available is the number of messages the remote sender has ready to send,
+	// from the available field of the last flow frame it sent. It is only a
+	// hint, to help decide whether to issue more credit or wait. Senders are
+	// not required to set it, in which case it is 0.
+	Available() uint32
+
+	// DeliveryCount is the link delivery-count: the sender's count of messages
+	// sent on the link, advanced by each transfer received and updated from the
+	// sender's flow frames, for example when credit is drained.
+	DeliveryCount() uint32
+
+	// SetFilter replaces the source filter of the attached Receiver without
+	// detaching, using the FilterUpdater set by the FilterUpdate() LinkOption.
+	// Returns ErrNotSupported if no FilterUpdater was set, or ctx.Err() if ctx
+	// is done before the update completes. On success Filter() returns the new
+	// filter.
+	SetFilter(ctx context.Context, filter map[amqp.Symbol]interface{}) error
+
+	// RemoteFilter is the filter set of the source returned by the remote peer
+	// when it attached the link. A peer omits any filter that it does not
+	// support, so this can be used to detect an ignored Selector().
+	RemoteFilter() map[amqp.Symbol]interface{}
+
+	// DynamicAddress waits for the remote peer to attach a receiver opened
+	// with DynamicReceiver() and returns the address it assigned to the
+	// source. Returns "" if the link failed to attach.
+	DynamicAddress() string
+
+	// Drain asks the sender to use up all the credit on the link, by sending
+	// messages or by returning the credit it can't use. It returns when the
+	// sender confirms the link has no credit left, or with ctx.Err() if ctx is
+	// done first. Messages sent in the drain cycle are buffered for Receive().
+	//
+	// A receiver that issues credit automatically may issue more afterwards,
+	// use ManualCredit() or SetPrefetch(0) to stop receiving after a drain.
+	Drain(ctx context.Context) error
+
+	// CloseWithDrain is like Drain followed by Close(nil): messages sent in
+	// the drain cycle are buffered for Receive() before the receiver closes.
+	// If ctx is done first the receiver is closed without waiting for the
+	// drain and ctx.Err() is returned. Unlike Close, it does not drain again
+	// if the receiver has AutoDrainOnClose().
+	CloseWithDrain(ctx context.Context) error
+
+	// Detach detaches the receiver from its source without closing the link,
+	// and signals an error to the remote end if err != nil. The remote peer
+	// keeps a durable source, such as a DurableSubscription(), so a receiver
+	// attached later with the same link name resumes it. Close() closes the
+	// link and ends the subscription.
+	//
+	// When the remote end replies Error() is Detached, or a DetachError if
+	// there was an error. If the remote peer closed the link at the same time
+	// Error() is Closed or the remote error, as for Close().
+	Detach(err error)
 }
 
 // Receiver implementation
@@ -71,10 +166,139 @@ type receiver struct {
 	link
 	buffer  chan ReceivedMessage
 	callers int
+
+	// Deliveries acknowledged with RcvSecond, waiting for the sender to settle.
+	// Only used in the handler goroutine.
+	unsettled map[proton.Delivery]chan error
+
+	// Received messages that are not settled, by delivery tag, and the state of
+	// resuming them after reconnect. Only used in the handler goroutine with
+	// ResumeDeliveries().
+	received  map[string]*receivedDelivery
+	expecting int            // Resumed deliveries still expected from the sender
+	unresumed []amqp.Message // Reported when the link is recovered
+
+	// Drain() calls waiting for the drain cycle to complete, and the Close()
+	// waiting for it with AutoDrainOnClose(). Only used in the handler goroutine.
+	drains   []chan error
+	closing  bool
+	closeErr error
+
+	// Lock for prefetch, window and manualCredit which can be changed by
+	// SetPrefetch(). Only needed when reading outside the handler goroutine.
+	modeLock sync.Mutex
+}
+
+func (r *receiver) Capacity() int { return cap(r.buffer) }
+
+func (r *receiver) DynamicAddress() string {
+	if r.Sync() != nil {
+		return ""
+	}
+	addr, _ := r.remoteSource()
+	return addr
+}
+
+// remoteSource returns the source address set by the remote peer, for example
+// the address assigned to a dynamic source.
+func (r *receiver) remoteSource() (addr string, err error) {
+	err = r.connection().injectWait(func() error {
+		if r.Error() != nil {
+			return r.Error()
+		}
+		addr = r.pLink.RemoteSource().Address()
+		return nil
+	})
+	return
+}
+
+func (r *receiver) RemoteFilter() (filter map[amqp.Symbol]interface{}) {
+	_ = r.connection().injectWait(func() error {
+		if r.Error() == nil {
+			if f := r.pLink.RemoteSource().Filter(); !f.Empty() {
+				_ = f.Unmarshal(&filter) // Ignore an invalid filter set
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (r *receiver) Prefetch() bool {
+	r.modeLock.Lock()
+	defer r.modeLock.Unlock()
+	return r.prefetch
 }
 
-func (r *receiver) Capacity() int  { return cap(r.buffer) }
-func (r *receiver) Prefetch() bool { return r.prefetch }
+// perCaller is true if credit is issued for each call to Receive.
+func (r *receiver) perCaller() bool {
+	r.modeLock.Lock()
+	defer r.modeLock.Unlock()
+	return !r.prefetch && !r.manualCredit
+}
+
+func (r *receiver) SetPrefetch(n int) error {
+	if n < 0 || n > r.Capacity() {
+		return fmt.Errorf("pre-fetch window %d out of range 0-%d", n, r.Capacity())
+	}
+	return r.connection().injectWait(func() error {
+		if err := r.Error(); err != nil {
+			return err
+		}
+		r.modeLock.Lock()
+		r.prefetch, r.window, r.manualCredit = n > 0, n, false
+		r.modeLock.Unlock()
+		if r.prefetch {
+			r.flow(r.prefetchFlow())
+		} else {
+			r.caller(0)
+		}
+		return nil
+	})
+}
+
+func (r *receiver) Flow(n int) error {
+	return r.connection().injectWait(func() error {
+		if err := r.Error(); err != nil {
+			return err
+		}
+		if max := r.maxFlow(); n > max {
+			n = max
+		}
+		r.flow(n)
+		return nil
+	})
+}
+
+func (r *receiver) Credit() (current, queued int) {
+	_ = r.connection().injectWait(func() error {
+		if r.Error() == nil {
+			current = r.pLink.Credit()
+		}
+		return nil
+	})
+	return current, len(r.buffer)
+}
+
+func (r *receiver) Available() (n uint32) {
+	_ = r.connection().injectWait(func() error {
+		if r.Error() == nil {
+			n = uint32(r.pLink.Available())
+		}
+		return nil
+	})
+	return n
+}
+
+func (r *receiver) DeliveryCount() (n uint32) {
+	_ = r.connection().injectWait(func() error {
+		if r.Error() == nil {
+			n = r.pLink.DeliveryCount()
+		}
+		return nil
+	})
+	return n
+}
 
 // Call in proton goroutine
 func newReceiver(ls linkSettings) *receiver {
@@ -83,11 +307,18 @@ func newReceiver(ls linkSettings) *receiver {
 	if r.capacity < 1 {
 		r.capacity = 1
 	}
+	if r.window <= 0 || r.window > r.capacity {
+		r.window = r.capacity
+	}
 	r.buffer = make(chan ReceivedMessage, r.capacity)
+	r.unsettled = make(map[proton.Delivery]chan error)
 	r.handler().addLink(r.pLink, r)
+	if r.remote {
+		r.setAttachFields()
+	}
 	r.link.pLink.Open()
 	if r.prefetch {
-		r.flow(r.maxFlow())
+		r.flow(r.prefetchFlow())
 	}
 	return r
 }
@@ -95,30 +326,72 @@ func newReceiver(ls linkSettings) *receiver {
 // Call in proton goroutine. Max additional credit we can request.
 func (r *receiver) maxFlow() int { return cap(r.buffer) - len(r.buffer) - r.pLink.Credit() }
 
+// Call in proton goroutine. Credit needed to fill the pre-fetch window.
+func (r *receiver) prefetchFlow() int {
+	outstanding := len(r.buffer) + r.pLink.Credit()
+	need := r.window - outstanding
+	if r.creditPolicy != nil {
+		need = r.creditPolicy.Credit(r.window, outstanding)
+	}
+	if max := r.maxFlow(); need > max {
+		need = max
+	}
+	return need
+}
+
 func (r *receiver) flow(credit int) {
-	if credit > 0 {
+	if credit > 0 && r.connection().shutdown == nil { // No new credit during Shutdown()
 		r.pLink.Flow(credit)
+		r.connection().metrics.OnFlow(r, credit)
+		r.creditChanged(r)
+		r.connection().log(LogDebug, "credit granted", "link", r.LinkName(), "credit", credit)
+	}
+}
+
+// Call in proton goroutine. Replaces credit used by a delivery that is not
+// passed to the application.
+func (r *receiver) restoreCredit() {
+	r.creditChanged(r)
+	switch {
+	case r.prefetch:
+		r.flow(r.prefetchFlow())
+	case !r.manualCredit:
+		r.caller(0)
 	}
 }
 
 // Inject flow check per-caller call when prefetch is off.
 // Called with inc=1 at start of call, inc = -1 at end
-func (r *receiver) caller(inc int) {
-	_ = r.engine().Inject(func() {
-		r.callers += inc
-		need := r.callers - (len(r.buffer) + r.pLink.Credit())
-		max := r.maxFlow()
-		if need > max {
-			need = max
-		}
-		r.flow(need)
+func (r *receiver) perCallerFlow(inc int) {
+	_ = r.connection().inject(func() {
+		if r.Error() == nil { // The link may be closed and freed
+			r.caller(inc)
+		}
 	})
 }
 
+// Call in proton goroutine.
+func (r *receiver) caller(inc int) {
+	r.callers += inc
+	if r.prefetch || r.manualCredit {
+		return
+	}
+	need := r.callers - (len(r.buffer) + r.pLink.Credit())
+	max := r.maxFlow()
+	if need > max {
+		need = max
+	}
+	r.flow(need)
+}
+
 // Inject flow top-up if prefetch is enabled
 func (r *receiver) flowTopUp() {
-	if r.prefetch {
-		_ = r.engine().Inject(func() { r.flow(r.maxFlow()) })
+	if r.Prefetch() {
+		_ = r.connection().inject(func() {
+			if r.prefetch && r.Error() == nil {
+				r.flow(r.prefetchFlow())
+			}
+		})
 	}
 }
 
@@ -130,7 +403,7 @@ func (r *receiver) ReceiveTimeout(timeout time.Duration) (rm ReceivedMessage, er
 	if r.buffer == nil {
 		panic(fmt.Errorf("Receiver is not open: %s", r))
 	}
-	if !r.prefetch { // Per-caller flow control
+	if r.perCaller() { // Per-caller flow control
 		select { // Check for immediate availability, avoid caller() inject
 		case rm2, ok := <-r.buffer:
 			if ok {
@@ -140,8 +413,8 @@ func (r *receiver) ReceiveTimeout(timeout time.Duration) (rm ReceivedMessage, er
 			}
 			return
 		default: // Not immediately available, inject caller() counts
-			r.caller(+1)
-			defer r.caller(-1)
+			r.perCallerFlow(+1)
+			defer r.perCallerFlow(-1)
 		}
 	}
 	rmi, err := timedReceive(r.buffer, timeout)
@@ -155,6 +428,200 @@ func (r *receiver) ReceiveTimeout(timeout time.Duration) (rm ReceivedMessage, er
 	return
 }
 
+func (r *receiver) ReceiveContext(ctx context.Context) (rm ReceivedMessage, err error) {
+	if r.buffer == nil {
+		panic(fmt.Errorf("Receiver is not open: %s", r))
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if r.perCaller() { // Per-caller flow control, see ReceiveTimeout
+		select {
+		case rm2, ok := <-r.buffer:
+			if ok {
+				rm = rm2
+			} else {
+				err = r.Error()
+			}
+			return
+		default:
+			r.perCallerFlow(+1)
+			defer r.perCallerFlow(-1)
+		}
+	}
+	select {
+	case rm2, ok := <-r.buffer:
+		if ok {
+			r.flowTopUp()
+			rm = rm2
+		} else {
+			err = r.Error()
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// Inject flow so buffered messages and credit cover n messages, for pre-fetch
+// receivers with a window smaller than n.
+func (r *receiver) batchFlow(n int) {
+	_ = r.connection().inject(func() {
+		if r.prefetch && r.Error() == nil {
+			need := n - len(r.buffer) - r.pLink.Credit()
+			if max := r.maxFlow(); need > max {
+				need = max
+			}
+			r.flow(need)
+		}
+	})
+}
+
+func (r *receiver) ReceiveBatch(ctx context.Context, max int, maxWait time.Duration) ([]ReceivedMessage, error) {
+	if r.buffer == nil {
+		panic(fmt.Errorf("Receiver is not open: %s", r))
+	}
+	if max < 1 || max > r.Capacity() {
+		return nil, fmt.Errorf("batch size %d out of range 1-%d", max, r.Capacity())
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r.perCaller() { // Count the batch as max callers
+		r.perCallerFlow(+max)
+		defer r.perCallerFlow(-max)
+	} else {
+		r.batchFlow(max)
+	}
+	waiting := maxWait > 0 // false once maxWait is up
+	var expired <-chan time.Time
+	if waiting && maxWait != Forever {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	batch := make([]ReceivedMessage, 0, max)
+	closed := false
+loop:
+	for len(batch) < max {
+		if !waiting && len(batch) > 0 { // Take what is buffered, don't wait
+			select {
+			case rm, ok := <-r.buffer:
+				if closed = !ok; closed {
+					break loop
+				}
+				batch = append(batch, rm)
+				continue
+			default:
+				break loop
+			}
+		}
+		select {
+		case rm, ok := <-r.buffer:
+			if closed = !ok; closed {
+				break loop
+			}
+			batch = append(batch, rm)
+		case <-expired:
+			waiting, expired = false, nil
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	if len(batch) == 0 {
+		if closed {
+			return nil, r.Error()
+		}
+		return nil, ctx.Err()
+	}
+	r.flowTopUp()
+	return batch, nil
+}
+
+func (r *receiver) Drain(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	err := r.connection().injectWait(func() error {
+		if err := r.Error(); err != nil {
+			return err
+		}
+		r.drains = append(r.drains, done)
+		r.drain()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return err
+}
+
+func (r *receiver) CloseWithDrain(ctx context.Context) error {
+	err := r.Drain(ctx)
+	r.link.Close(nil)
+	return err
+}
+
+// How long Close() with AutoDrainOnClose() waits for the peer to complete the
+// drain before closing the link anyway.
+var autoDrainTimeout = 10 * time.Second
+
+func (r *receiver) Close(err error) {
+	_ = r.connection().inject(func() {
+		if r.Error() != nil || r.closing {
+			return
+		}
+		if r.autoDrain && r.pLink.State().LocalActive() {
+			r.closing, r.closeErr = true, err
+			r.drain()
+			if r.pLink.State().LocalActive() {
+				// Don't wait forever for a peer that ignores the drain.
+				pLink := r.pLink
+				time.AfterFunc(autoDrainTimeout, func() {
+					_ = r.connection().inject(func() {
+						if r.Error() == nil && r.pLink == pLink {
+							localClose(pLink, r.closeErr)
+						}
+					})
+				})
+			}
+		} else {
+			localClose(r.pLink, err)
+		}
+	})
+}
+
+// Called in handler goroutine, start a drain cycle unless one is in progress.
+func (r *receiver) drain() {
+	if !r.pLink.IsDrain() {
+		r.pLink.Drain(0)
+	}
+	r.drained()
+}
+
+// Called in handler goroutine, completes the drain cycle if the sender has
+// used or returned all the credit.
+func (r *receiver) drained() {
+	if !r.pLink.IsDrain() || r.pLink.Draining() {
+		return
+	}
+	r.pLink.SetDrain(false)
+	r.pLink.Drained() // Reset the count of returned credit
+	for _, done := range r.drains {
+		done <- nil
+	}
+	r.drains = nil
+	if r.closing {
+		localClose(r.pLink, r.closeErr)
+	}
+}
+
 // Called in proton goroutine on MMessage event.
 func (r *receiver) message(delivery proton.Delivery) {
 	if r.pLink.State().RemoteClosed() {
@@ -162,6 +629,9 @@ func (r *receiver) message(delivery proton.Delivery) {
 		return
 	}
 	if delivery.HasMessage() {
+		if delivery.Resumed() && r.resumed(delivery) {
+			return
+		}
 		bytes, err := delivery.MessageBytes()
 		var m amqp.Message
 		if err == nil {
@@ -173,17 +643,66 @@ func (r *receiver) message(delivery proton.Delivery) {
 			return
 		}
 		r.pLink.Advance()
+		r.connection().metrics.OnTransfer(r, len(bytes))
+		r.creditChanged(r)
 		if r.pLink.Credit() < 0 {
 			localClose(r.pLink, fmt.Errorf("received message in excess of credit limit"))
+		} else if r.connection().shutdown != nil {
+			delivery.SettleAs(proton.Released) // Sent on credit issued before Shutdown()
 		} else {
+			rm := ReceivedMessage{m, delivery, r, r.generation, nil, delivery.Tag().String()}
+			if !delivery.Settled() && r.resumes() {
+				rm.received = r.track(delivery, m)
+			}
 			// We never issue more credit than cap(buffer) so this will not block.
-			r.buffer <- ReceivedMessage{m, delivery, r}
+			r.buffer <- rm
 		}
+		r.drained()
+	}
+}
+
+// Called in handler goroutine to wait for the sender to settle d, which has
+// been acknowledged but not settled. Returns a channel for the result.
+func (r *receiver) settleSecond(d proton.Delivery) chan error {
+	settled := make(chan error, 1)
+	if d.Settled() { // Sender has already settled
+		d.Settle()
+		settled <- nil
+	} else {
+		r.unsettled[d] = settled
+	}
+	return settled
+}
+
+// Called in handler goroutine when the sender settles d.
+func (r *receiver) settled(d proton.Delivery) {
+	if settled, ok := r.unsettled[d]; ok {
+		delete(r.unsettled, d)
+		d.Settle()
+		settled <- nil
+	} else {
+		r.settledReceived(d)
+	}
+}
+
+// Called in handler goroutine, fail deliveries waiting for the sender to settle.
+func (r *receiver) abandonUnsettled(err error) {
+	for d, settled := range r.unsettled {
+		delete(r.unsettled, d)
+		settled <- err
 	}
 }
 
 func (r *receiver) closed(err error) error {
 	e := r.link.closed(err)
+	r.abandonUnsettled(e)
+	for _, rd := range r.received {
+		r.untrack(rd, e)
+	}
+	for _, done := range r.drains {
+		done <- e
+	}
+	r.drains = nil
 	if r.buffer != nil {
 		close(r.buffer)
 	}
@@ -195,16 +714,89 @@ type ReceivedMessage struct {
 	// Message is the received message.
 	Message amqp.Message
 
-	pDelivery proton.Delivery
-	receiver  Receiver
+	pDelivery  proton.Delivery
+	receiver   Receiver
+	generation int
+	received   *receivedDelivery // Set if the delivery can be resumed after reconnect
+	tag        string            // Delivery tag
+}
+
+// settle injects f to update the delivery state and then settles the delivery,
+// unless the message was received before the connection was lost and
+// re-established. With RcvSecond it waits for the sender to settle first.
+func (rm *ReceivedMessage) settle(f func(proton.Delivery)) error {
+	r := rm.receiver.(*receiver)
+	c := r.connection()
+	if rm.received != nil {
+		var done chan error
+		err := c.injectWait(func() error {
+			done = r.ackReceived(rm.received, f)
+			c.checkShutdown()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return <-done
+	}
+	c.lock.Lock()
+	stale := rm.generation != r.generation
+	c.lock.Unlock()
+	if stale {
+		return ReconnectError{c.reconnectError()}
+	}
+	if r.RcvSettle() != RcvSecond {
+		return c.inject(func() {
+			// Deliveries are valid as long as the connection is, unless settled.
+			if rm.generation == r.generation {
+				f(rm.pDelivery)
+				rm.pDelivery.Settle()
+				c.checkShutdown()
+			}
+		})
+	}
+	var settled chan error
+	err := c.injectWait(func() error {
+		if rm.generation == r.generation {
+			f(rm.pDelivery)
+			settled = r.settleSecond(rm.pDelivery)
+			c.checkShutdown()
+		}
+		return nil
+	})
+	if err != nil || settled == nil {
+		return err
+	}
+	return <-settled
+}
+
+// Message annotation keys set by some brokers, for example Azure Service Bus.
+var (
+	sequenceNumberKey = amqp.AnnotationKeySymbol("x-opt-sequence-number")
+	enqueuedTimeKey   = amqp.AnnotationKeySymbol("x-opt-enqueued-time")
+)
+
+// SequenceNumber returns the broker-assigned sequence number from the
+// "x-opt-sequence-number" message annotation, false if there is none.
+func (rm *ReceivedMessage) SequenceNumber() (int64, bool) {
+	n, ok := rm.Message.MessageAnnotations()[sequenceNumberKey].(int64)
+	return n, ok
 }
 
+// EnqueuedTime returns the time the broker enqueued the message from the
+// "x-opt-enqueued-time" message annotation, false if there is none.
+func (rm *ReceivedMessage) EnqueuedTime() (time.Time, bool) {
+	t, ok := rm.Message.MessageAnnotations()[enqueuedTimeKey].(time.Time)
+	return t, ok
+}
+
+// DeliveryTag returns the delivery tag the sender gave the message, see
+// Sender.SendTagged().
+func (rm *ReceivedMessage) DeliveryTag() []byte { return []byte(rm.tag) }
+
 // Acknowledge a ReceivedMessage with the given delivery status.
 func (rm *ReceivedMessage) acknowledge(status uint64) error {
-	return rm.receiver.(*receiver).engine().Inject(func() {
-		// Deliveries are valid as long as the connection is, unless settled.
-		rm.pDelivery.SettleAs(uint64(status))
-	})
+	return rm.settle(func(d proton.Delivery) { d.Update(status) })
 }
 
 // Accept tells the sender that we take responsibility for processing the message.
@@ -213,10 +805,44 @@ func (rm *ReceivedMessage) Accept() error { return rm.acknowledge(proton.Accepte
 // Reject tells the sender we consider the message invalid and unusable.
 func (rm *ReceivedMessage) Reject() error { return rm.acknowledge(proton.Rejected) }
 
+// RejectWith is like Reject but also sends an error condition describing why
+// the message was rejected. The condition is made from err by amqp.MakeCondition,
+// pass an amqp.Error or *amqp.Error to set the name, description and info
+// exactly. The sender sees the condition as Outcome.Error.
+func (rm *ReceivedMessage) RejectWith(err error) error {
+	return rm.settle(func(d proton.Delivery) {
+		if err != nil {
+			d.Local().Condition().SetError(amqp.MakeCondition(err))
+		}
+		d.Update(proton.Rejected)
+	})
+}
+
 // Release tells the sender we will not process the message but some other
 // receiver might.
 func (rm *ReceivedMessage) Release() error { return rm.acknowledge(proton.Released) }
 
+// Modify is like Release but also tells the sender how to treat the message.
+// deliveryFailed counts this as a failed delivery attempt, undeliverableHere
+// asks the sender not to redeliver the message to this receiver, and
+// annotations are merged into the message-annotations of the message.
+func (rm *ReceivedMessage) Modify(deliveryFailed, undeliverableHere bool, annotations map[amqp.Symbol]interface{}) error {
+	if len(annotations) > 0 {
+		if _, err := amqp.Marshal(annotations, nil); err != nil {
+			return err // Report bad annotations without settling
+		}
+	}
+	return rm.settle(func(d proton.Delivery) {
+		l := d.Local()
+		l.SetFailed(deliveryFailed)
+		l.SetUndeliverable(undeliverableHere)
+		if len(annotations) > 0 {
+			_ = l.Annotations().Marshal(annotations)
+		}
+		d.Update(proton.Modified)
+	})
+}
+
 // IncomingReceiver is sent on the Connection.Incoming() channel when there is
 // an incoming request to open a receiver link.
 type IncomingReceiver struct {
@@ -237,6 +863,29 @@ func (in *IncomingReceiver) SetCapacity(capacity int) { in.capacity = capacity }
 // SetPrefetch sets the pre-fetch mode of the incoming receiver, call before Accept()
 func (in *IncomingReceiver) SetPrefetch(prefetch bool) { in.prefetch = prefetch }
 
+// SetMaxMessageSize sets the largest message (in bytes) the incoming receiver
+// will accept, call before Accept(). 0 means no limit.
+func (in *IncomingReceiver) SetMaxMessageSize(size uint64) { in.maxMessageSize = size }
+
+// SetProperties sets the link properties to send to the remote peer, call before Accept()
+func (in *IncomingReceiver) SetProperties(m map[amqp.Symbol]interface{}) { in.properties = m }
+
+// SetOfferedCapabilities sets the capabilities offered to the remote peer, call before Accept()
+func (in *IncomingReceiver) SetOfferedCapabilities(caps ...amqp.Symbol) {
+	in.offeredCapabilities = caps
+}
+
+// SetDesiredCapabilities sets the capabilities requested from the remote peer, call before Accept()
+func (in *IncomingReceiver) SetDesiredCapabilities(caps ...amqp.Symbol) {
+	in.desiredCapabilities = caps
+}
+
+// Address is the Target() address the remote peer wants to send to.
+func (in *IncomingReceiver) Address() string { return in.target }
+
+// AuthenticatedUser is the SASL identity of the remote peer.
+func (in *IncomingReceiver) AuthenticatedUser() string { return in.session.connection.authUser }
+
 // Accept accepts an incoming receiver endpoint
 func (in *IncomingReceiver) Accept() Endpoint {
 	return in.accept(func() Endpoint { return newReceiver(in.linkSettings) })
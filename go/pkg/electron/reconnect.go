@@ -0,0 +1,421 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/proton"
+)
+
+// Backoff returns the delay to wait before reconnect attempt number n, n >= 1.
+type Backoff func(n int) time.Duration
+
+// BackoffExponential returns a Backoff that starts with delay initial and
+// doubles for each attempt up to max.
+//
+// jitter is a fraction between 0 and 1, each delay is randomly reduced by up to
+// jitter*delay so that many clients that lose their connections at the same time
+// do not all reconnect at the same time.
+func BackoffExponential(initial, max time.Duration, jitter float64) Backoff {
+	return func(n int) time.Duration {
+		d := initial
+		for i := 1; i < n && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		if jitter > 0 {
+			d -= time.Duration(jitter * rand.Float64() * float64(d))
+		}
+		return d
+	}
+}
+
+// ReconnectEvent is sent on the channel set by ReconnectEvents() to report
+// the progress of automatic reconnection.
+type ReconnectEvent struct {
+	// Attempt is 0 when the connection is lost, then counts the reconnect attempts from 1.
+	Attempt int
+	// Err is the reason the connection was lost (Attempt == 0), or the reason an attempt failed.
+	Err error
+	// Reconnected is true if the remote peer has re-opened the connection.
+	Reconnected bool
+	// Link is set when a re-attached link has resumed its unsettled
+	// deliveries, see ResumeDeliveries().
+	Link Link
+	// Unresumed holds the messages on Link whose deliveries could not be resumed.
+	Unresumed []amqp.Message
+}
+
+func (e ReconnectEvent) String() string {
+	switch {
+	case e.Link != nil:
+		return fmt.Sprintf("link %s recovered (%d unresumed)", e.Link.LinkName(), len(e.Unresumed))
+	case e.Reconnected:
+		return fmt.Sprintf("reconnected (attempt %d)", e.Attempt)
+	case e.Attempt == 0:
+		return fmt.Sprintf("disconnected: %v", e.Err)
+	default:
+		return fmt.Sprintf("reconnect attempt %d failed: %v", e.Attempt, e.Err)
+	}
+}
+
+// ReconnectError is the Outcome.Error for a message that was sent but not
+// acknowledged before the connection was lost, or the error returned when
+// acknowledging a message that was received before the connection was lost.
+//
+// The operation can be retried: a message can be re-sent, but it may be
+// received twice. A received message will be re-delivered by the sender.
+type ReconnectError struct {
+	// Err is the reason the connection was lost.
+	Err error
+}
+
+func (e ReconnectError) Error() string { return fmt.Sprintf("connection lost: %v", e.Err) }
+
+// Unwrap returns Err, for errors.Is() and errors.As().
+func (e ReconnectError) Unwrap() error { return e.Err }
+
+// ReconnectOption sets optional configuration for Reconnect()
+type ReconnectOption func(*reconnectPolicy)
+
+// MaxAttempts returns a ReconnectOption that limits the number of consecutive
+// failed reconnect attempts. When the limit is reached the Connection and all
+// its endpoints are closed with the last error. n == 0 means no limit.
+func MaxAttempts(n int) ReconnectOption { return func(p *reconnectPolicy) { p.maxAttempts = n } }
+
+// ReconnectEvents returns a ReconnectOption to report reconnect progress on
+// events.  Events are dropped if the channel is not ready to receive, so it
+// should be buffered.
+func ReconnectEvents(events chan<- ReconnectEvent) ReconnectOption {
+	return func(p *reconnectPolicy) { p.events = events }
+}
+
+type reconnectPolicy struct {
+	backoff     Backoff
+	maxAttempts int
+	events      chan<- ReconnectEvent
+	resume      bool
+}
+
+func (p *reconnectPolicy) event(e ReconnectEvent) {
+	if p.events != nil {
+		select {
+		case p.events <- e:
+		default:
+		}
+	}
+}
+
+// Reconnect returns a ConnectionOption that enables automatic reconnect for
+// connections created by Dial(), DialWithDialer() or Container.Dial(). It has
+// no effect on other connections.
+//
+// If the connection is lost without being closed by either peer, it is
+// re-dialed after a delay given by backoff and re-opened with the same
+// ConnectionOptions. Sessions and links opened by this end are re-opened with
+// the same settings, so Senders and Receivers can continue to be used.
+//
+// Operations on the connection and its endpoints block while reconnecting.
+// Messages sent but not yet acknowledged when the connection is lost get an
+// Outcome with a ReconnectError, unless ResumeDeliveries() is used. Incoming
+// links opened by the remote peer are closed with a ReconnectError.
+//
+// Note that the Password() option must not be overwritten by the caller
+// while the connection is in use, it is needed to reconnect.
+func Reconnect(backoff Backoff, opts ...ReconnectOption) ConnectionOption {
+	return func(c *connection) {
+		c.reconnect = &reconnectPolicy{backoff: backoff}
+		for _, o := range opts {
+			o(c.reconnect)
+		}
+	}
+}
+
+func (c *connection) canReconnect() bool { return c.reconnect != nil && c.redial != nil }
+
+func (c *connection) reconnectError() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.reconnectErr
+}
+
+func (c *connection) setReconnectError(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reconnectErr = err
+}
+
+// Called in the engine goroutine when the remote peer opens the connection.
+func (c *connection) reconnected() {
+	if c.attempts > 0 {
+		c.reconnect.event(ReconnectEvent{Attempt: c.attempts, Reconnected: true})
+		c.attempts = 0
+	}
+}
+
+// current returns the current engine, and a channel that is closed when the
+// engine is replaced by reconnecting or the connection is finished.
+func (c *connection) current() (*proton.Engine, <-chan struct{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.engine, c.replaced
+}
+
+// engineDown returns a channel that is closed while the connection is
+// reconnecting and no engine is running, so inject would wait.
+func (c *connection) engineDown() <-chan struct{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.down
+}
+
+// whileDown calls f and returns true if no engine is running, with the lock
+// held so that a new engine does not start until f returns.
+func (c *connection) whileDown(f func()) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	select {
+	case <-c.down:
+		f()
+		return true
+	default:
+		return false
+	}
+}
+
+// inject f into the current engine. If the connection is reconnecting, wait
+// and inject into the new engine.
+func (c *connection) inject(f func()) error {
+	for {
+		eng, replaced := c.current()
+		err := eng.Inject(f)
+		if err == nil || !c.canReconnect() {
+			return err
+		}
+		select {
+		case <-replaced:
+			select {
+			case <-c.done:
+				return c.Error()
+			default: // Try the new engine
+			}
+		case <-c.done:
+			return c.Error()
+		}
+	}
+}
+
+// tryInject is like inject but returns false instead of blocking if the engine
+// is busy, or is being replaced by a reconnect.
+func (c *connection) tryInject(f func()) (bool, error) {
+	eng, _ := c.current()
+	ok, err := eng.TryInject(f)
+	if err != nil && c.canReconnect() && c.Error() == nil {
+		return false, nil // Reconnecting
+	}
+	return ok, err
+}
+
+// injectWait is like inject but waits for f to complete and returns its error.
+func (c *connection) injectWait(f func() error) error {
+	done := make(chan error, 1)
+	if err := c.inject(func() { done <- f() }); err != nil {
+		return err
+	}
+	return <-done
+}
+
+// Called in the engine goroutine on disconnect. Return true if we will
+// reconnect, in which case the handler keeps its endpoints.
+func (h *handler) disconnected(err error) bool {
+	c := h.connection
+	if !c.canReconnect() || c.Error() != nil {
+		return false
+	}
+	if _, ok := err.(AuthError); ok { // Retrying won't help
+		return false
+	}
+	c.setReconnectError(err)
+	if c.resumes() {
+		h.suspendSent()
+	}
+	rerr := ReconnectError{err}
+	for _, sm := range h.sent {
+		sm.outcome(Unacknowledged, rerr, nil).sendEventually(sm.ack)
+	}
+	h.sent = make(map[proton.Delivery]*sendable)
+	for _, l := range h.links {
+		if s, ok := l.(*sender); ok {
+			s.unsettled = 0
+			s.flushed()
+		}
+	}
+	c.reconnect.event(ReconnectEvent{Err: err})
+	c.stateChange(ConnectionReconnecting, err)
+	return true
+}
+
+// reconnectLoop is called in the run goroutine after the engine has stopped.
+// It returns true if a new engine is ready to run, false if the connection
+// is finished. Attempts are counted until the remote peer opens the
+// connection, so a peer that accepts and then drops the connection still
+// counts towards MaxAttempts().
+func (c *connection) reconnectLoop() bool {
+	for c.attempts++; c.reconnect.maxAttempts == 0 || c.attempts <= c.reconnect.maxAttempts; c.attempts++ {
+		delay := c.reconnect.backoff(c.attempts)
+		c.log(LogInfo, "reconnect scheduled", "attempt", c.attempts, "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-c.closing:
+			return false
+		}
+		c.log(LogDebug, "dialing", "attempt", c.attempts)
+		conn, err := c.redial()
+		if err == nil {
+			if err = c.reopen(conn); err == nil {
+				return true
+			}
+			_ = conn.Close()
+		}
+		c.setReconnectError(err)
+		c.log(LogWarn, "reconnect failed", "attempt", c.attempts, "error", err)
+		c.reconnect.event(ReconnectEvent{Attempt: c.attempts, Err: err})
+		if c.Error() != nil {
+			return false
+		}
+	}
+	return false
+}
+
+// reopen the connection on conn with a new engine and re-attach endpoints.
+func (c *connection) reopen(conn net.Conn) error {
+	conn = c.wrapWebSocket(c.wrapTLS(conn))
+	if err := handshake(conn); err != nil {
+		return err
+	}
+	old := c.handler
+	h := newHandler(c)
+	ec := newEngineConn(conn, &c.frameSink)
+	eng, err := proton.NewEngine(ec, h.delegator)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.Error() != nil { // Closed while we were dialing
+		return c.Error()
+	}
+	c.conn, c.handler, c.engine, c.pConnection = ec, h, eng, eng.Connection()
+	close(c.replaced)
+	c.replaced = make(chan struct{})
+	c.down = make(chan struct{})
+
+	// Re-apply options to the new engine, but keep the existing container and incoming channel.
+	container, incoming := c.container, c.incoming
+	for _, opt := range c.opts {
+		opt(c)
+	}
+	c.container, c.incoming = container, incoming
+	c.pConnection.SetContainer(c.container.Id())
+	c.setOpenFields()
+	c.pConnection.Open()
+
+	// Counts start again from zero as sessions and links are re-attached.
+	atomic.StoreInt32(&c.sessionCount, 0)
+	for _, s := range old.sessions {
+		atomic.StoreInt32(&s.linkCount, 0)
+	}
+	for _, s := range old.sessions {
+		s.reattach(h)
+	}
+	rerr := ReconnectError{c.reconnectErr}
+	for _, ep := range old.links {
+		switch l := ep.(type) {
+		case *sender:
+			l.reattach(h, rerr)
+		case *receiver:
+			l.reattach(h, rerr)
+		}
+	}
+	return nil
+}
+
+// reattach a session to the new connection, called with the old session state.
+func (s *session) reattach(h *handler) {
+	ps, err := h.connection.pConnection.Session()
+	if err != nil {
+		_ = s.closed(err)
+		return
+	}
+	s.pSession = ps
+	h.addSession(s)
+	s.setWindows()
+	ps.Open()
+}
+
+// reattach a link to its (reattached) session. Returns false if the link cannot be
+// re-attached and has been closed.
+func (l *link) reattach(h *handler, ep Endpoint, err error) bool {
+	if l.remote || l.session.Error() != nil {
+		_ = ep.(endpointInternal).closed(err)
+		return false
+	}
+	if err := l.openPLink(); err != nil {
+		_ = ep.(endpointInternal).closed(err)
+		return false
+	}
+	l.generation++
+	h.addLink(l.pLink, ep)
+	return true
+}
+
+func (s *sender) reattach(h *handler, err error) {
+	if s.link.reattach(h, s, err) {
+		s.noCredit = true // The new link starts with no credit
+		if s.resumes() {
+			s.setUnsettled()
+		}
+		s.pLink.Open()
+	}
+}
+
+func (r *receiver) reattach(h *handler, err error) {
+	r.abandonUnsettled(err) // Already a ReconnectError
+	if r.link.reattach(h, r, err) {
+		if r.resumes() {
+			r.setUnsettled()
+		}
+		r.pLink.Open()
+		switch {
+		case r.prefetch:
+			r.flow(r.prefetchFlow())
+		case !r.manualCredit:
+			r.caller(0)
+		}
+	}
+}
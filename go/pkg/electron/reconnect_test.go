@@ -0,0 +1,199 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// Accept server connections on l, send them on servers and their receivers on rcvs.
+func reconnectServer(l net.Listener, servers chan<- Connection, rcvs chan<- Receiver) {
+	for {
+		c, err := NewContainer("server").Accept(l)
+		if err != nil {
+			return
+		}
+		servers <- c
+		go func() {
+			for in := range c.Incoming() {
+				if r, ok := in.(*IncomingReceiver); ok {
+					r.SetPrefetch(true)
+					rcvs <- r.Accept().(Receiver)
+				} else {
+					in.Accept()
+				}
+			}
+		}()
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	l, err := net.Listen("tcp4", ":0")
+	test.FatalIf(t, err)
+	defer l.Close()
+	servers, rcvs := make(chan Connection), make(chan Receiver)
+	go reconnectServer(l, servers, rcvs)
+
+	events := make(chan ReconnectEvent, 10)
+	c, err := Dial(l.Addr().Network(), l.Addr().String(),
+		Reconnect(BackoffExponential(time.Millisecond, 10*time.Millisecond, 0.5), ReconnectEvents(events)))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	srv := <-servers
+	snd, err := c.Sender(Target("q"))
+	test.FatalIf(t, err)
+	rcv := <-rcvs
+
+	// Leave a message unacknowledged, then drop the connection.
+	ack := snd.SendWaitable(amqp.NewMessageWith("x"))
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	srv.Disconnect(fmt.Errorf("drop"))
+
+	out := <-ack
+	if _, ok := out.Error.(ReconnectError); !ok {
+		t.Errorf("want ReconnectError got %#v", out)
+	}
+	if e := <-events; e.Attempt != 0 || e.Err == nil {
+		t.Errorf("want disconnect event got %v", e)
+	}
+	srv = <-servers
+	if e := <-events; !e.Reconnected || e.Attempt != 1 {
+		t.Errorf("want reconnected event got %v", e)
+	}
+
+	// The sender is re-attached and can still be used.
+	rcv = <-rcvs
+	ack = snd.SendWaitable(amqp.NewMessageWith("y"))
+	rm, err = rcv.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ("y", rm.Message.Body()))
+	test.ErrorIf(t, rm.Accept())
+	out = <-ack
+	test.ErrorIf(t, out.Error)
+	test.ErrorIf(t, test.Differ(Accepted, out.Status))
+	test.ErrorIf(t, snd.Error())
+	srv.Close(nil)
+}
+
+func TestReconnectMaxAttempts(t *testing.T) {
+	l, err := net.Listen("tcp4", ":0")
+	test.FatalIf(t, err)
+	servers, rcvs := make(chan Connection), make(chan Receiver)
+	go reconnectServer(l, servers, rcvs)
+
+	events := make(chan ReconnectEvent, 10)
+	c, err := Dial(l.Addr().Network(), l.Addr().String(),
+		Reconnect(BackoffExponential(time.Millisecond, time.Millisecond, 0), MaxAttempts(2), ReconnectEvents(events)))
+	test.FatalIf(t, err)
+	srv := <-servers
+	snd, err := c.Sender(Target("q"))
+	test.FatalIf(t, err)
+	<-rcvs
+
+	// Stop listening so reconnect attempts fail.
+	l.Close()
+	srv.Disconnect(fmt.Errorf("drop"))
+	test.ErrorIf(t, test.Differ(0, (<-events).Attempt))
+	for i := 1; i <= 2; i++ {
+		if e := <-events; e.Attempt != i || e.Err == nil || e.Reconnected {
+			t.Errorf("want failed attempt %v got %v", i, e)
+		}
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed")
+	}
+	if c.Error() == nil || snd.Error() == nil {
+		t.Errorf("want errors got %v, %v", c.Error(), snd.Error())
+	}
+}
+
+// An RcvSecond acknowledgement interrupted by reconnect fails with a
+// ReconnectError for the original cause.
+func TestReconnectRcvSecond(t *testing.T) {
+	l, err := net.Listen("tcp4", ":0")
+	test.FatalIf(t, err)
+	defer l.Close()
+	servers, snds := make(chan Connection, 2), make(chan Sender, 2)
+	go func() {
+		for {
+			c, err := NewContainer("server").Accept(l)
+			if err != nil {
+				return
+			}
+			servers <- c
+			go func() {
+				for in := range c.Incoming() {
+					if s, ok := in.(*IncomingSender); ok {
+						snds <- s.Accept().(Sender)
+					} else {
+						in.Accept()
+					}
+				}
+			}()
+		}
+	}()
+
+	c, err := Dial(l.Addr().Network(), l.Addr().String(),
+		Reconnect(BackoffExponential(time.Millisecond, 10*time.Millisecond, 0.5)))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	srv := <-servers
+	rcv, err := c.Receiver(Source("q"), RcvSettle(RcvSecond), Prefetch(true))
+	test.FatalIf(t, err)
+	snd := <-snds
+	snd.SendAsync(amqp.NewMessageWith("x"), make(chan Outcome, 1), nil)
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+
+	// Stop the server so it can't settle, then drop the connection.
+	drop := make(chan struct{})
+	eng := srv.(*connection).engine
+	test.FatalIf(t, eng.Inject(func() {
+		<-drop
+		eng.Transport().Condition().SetError(fmt.Errorf("drop"))
+		eng.Transport().CloseTail()
+		eng.Transport().CloseHead()
+	}))
+	accepted := make(chan error, 1)
+	go func() { accepted <- rm.Accept() }()
+	for n := 0; n == 0; time.Sleep(time.Millisecond) { // Wait for Accept to wait for settlement
+		_ = c.(*connection).injectWait(func() error { n = len(rcv.(*receiver).unsettled); return nil })
+	}
+	close(drop)
+	select {
+	case err := <-accepted:
+		if e, ok := err.(ReconnectError); !ok {
+			t.Errorf("want ReconnectError got %#v", err)
+		} else if _, ok := e.Err.(ReconnectError); ok {
+			t.Errorf("nested ReconnectError %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept blocked")
+	}
+}
@@ -0,0 +1,364 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/proton"
+)
+
+// ResumeDeliveries returns a ReconnectOption to resume unsettled deliveries
+// when links are re-attached after reconnecting, instead of abandoning them
+// with a ReconnectError.
+//
+// A re-attached link sends the tags and states of its unsettled deliveries in
+// the attach unsettled map. The map sent back by the remote peer says which
+// deliveries it still has:
+//
+// A sent message that the receiver has settled gets the receiver's Outcome. A
+// message the receiver has but has not settled is sent again as a resumed
+// delivery. A message the receiver does not have is sent again as a new
+// delivery if the receiver uses RcvSecond, since it cannot have settled the
+// message before we did.
+//
+// A received message that the sender still has can be acknowledged as if the
+// connection had not been lost: the sender resumes the delivery and the
+// message is not received again. A message the sender no longer has was
+// settled by the sender, acknowledging it has no effect.
+//
+// When a re-attached link has resumed its deliveries, a ReconnectEvent with the
+// Link and the messages that could not be resumed is sent. Those messages are
+// handled as described for Reconnect(). Nothing can be resumed if the remote
+// peer does not send an unsettled map, and a sent message unknown to a
+// receiver that uses RcvFirst cannot be resumed.
+func ResumeDeliveries() ReconnectOption { return func(p *reconnectPolicy) { p.resume = true } }
+
+// resumes is true if unsettled deliveries are resumed after reconnect.
+func (c *connection) resumes() bool { return c.reconnect != nil && c.reconnect.resume }
+
+// resumes is true if deliveries on the link are resumed, incoming links are
+// not re-attached.
+func (l *link) resumes() bool { return !l.remote && l.connection().resumes() }
+
+// Called in the engine goroutine when a re-attached link has resumed its
+// deliveries.
+func (c *connection) recovered(l Link, unresumed []amqp.Message) {
+	c.log(LogInfo, "link recovered", "link", l.LinkName(), "unresumed", len(unresumed))
+	c.reconnect.event(ReconnectEvent{Link: l, Unresumed: unresumed})
+}
+
+// Called in handler goroutine when the remote peer attaches a link.
+func (h *handler) resume(ep Endpoint) {
+	switch l := ep.(type) {
+	case *sender:
+		if l.generation > 0 && l.resumes() {
+			l.resumeSent()
+		}
+	case *receiver:
+		if l.generation > 0 && l.resumes() {
+			l.resumeReceived()
+		}
+	}
+}
+
+// remoteUnsettled returns the unsettled map sent by the remote peer with the
+// outcomes decoded, ok is false if it did not send one.
+func remoteUnsettled(l proton.Link) (m map[amqp.Binary]interface{}, ok bool) {
+	data := l.RemoteUnsettledMap()
+	if data.Empty() || data.Unmarshal(&m) != nil {
+		return nil, false
+	}
+	for tag, state := range m {
+		m[tag] = decodeOutcome(state)
+	}
+	return m, true
+}
+
+// stateOutcome is the inverse of Outcome.State()
+func stateOutcome(state DeliveryState, v interface{}) Outcome {
+	switch s := state.(type) {
+	case AcceptedState:
+		return Outcome{Status: Accepted, Value: v}
+	case RejectedState:
+		var err error
+		if s.Error != nil {
+			err = *s.Error
+		}
+		return Outcome{Status: Rejected, Error: err, Value: v}
+	case ModifiedState:
+		return Outcome{Status: Released, Value: v, Modified: &s}
+	default:
+		return Outcome{Status: Released, Value: v}
+	}
+}
+
+// Called in handler goroutine on disconnect. Messages waiting for an outcome
+// are kept by their senders, in the order they were sent, until the sender is
+// re-attached.
+func (h *handler) suspendSent() {
+	var sent []*sendable
+	for d, sm := range h.sent {
+		if s, ok := h.links[d.Link()].(*sender); ok && s.resumes() && sm.bytes != nil {
+			sent = append(sent, sm)
+			delete(h.sent, d)
+		}
+	}
+	sort.Slice(sent, func(i, j int) bool { return sent[i].sentAt.Before(sent[j].sentAt) })
+	for _, sm := range sent {
+		s := h.links[sm.d.Link()].(*sender)
+		s.inDoubt = append(s.inDoubt, sm)
+	}
+	for _, l := range h.links {
+		if s, ok := l.(*sender); ok {
+			for _, sm := range s.resuming {
+				if sm.bytes != nil { // Not re-sent before the connection was lost again.
+					s.inDoubt = append(s.inDoubt, sm)
+				}
+			}
+			s.resuming = nil
+		}
+	}
+}
+
+// Called in handler goroutine to set the unsettled map for the re-attach. A
+// sender has no local delivery state.
+func (s *sender) setUnsettled() {
+	m := make(map[amqp.Binary]interface{}, len(s.inDoubt))
+	for _, sm := range s.inDoubt {
+		m[amqp.Binary(sm.tag)] = nil
+	}
+	_ = s.pLink.UnsettledMap().Marshal(m)
+}
+
+// Called in handler goroutine when the re-attached sender is attached by the
+// remote peer. Queues the deliveries to resume ahead of new messages.
+func (s *sender) resumeSent() {
+	remote, ok := remoteUnsettled(s.pLink)
+	rcvSecond := s.pLink.RemoteRcvSettleMode() == proton.RcvSecond
+	var unresumed []amqp.Message
+	for _, sm := range s.inDoubt {
+		tag := amqp.Binary(sm.tag)
+		state, known := remote[tag]
+		delete(remote, tag)
+		if state, settled := state.(DeliveryState); settled {
+			// Report the receiver's outcome and settle our end.
+			o := stateOutcome(state, sm.v)
+			o.tag = sm.tag
+			s.connection().metrics.OnSettle(s, o.Status, time.Since(sm.sentAt))
+			o.send(sm.ack)
+			s.resuming = append(s.resuming, &sendable{tag: sm.tag, resume: true})
+		} else if known || (ok && rcvSecond) {
+			sm.resume = known
+			s.resuming = append(s.resuming, sm)
+		} else {
+			m := amqp.NewMessage()
+			_ = s.session.connection.mc.Decode(m, sm.bytes)
+			unresumed = append(unresumed, m)
+			sm.outcome(Unacknowledged, ReconnectError{s.connection().reconnectError()}, nil).sendEventually(sm.ack)
+		}
+	}
+	for tag := range remote { // Settle deliveries that only the receiver has.
+		s.resuming = append(s.resuming, &sendable{tag: string(tag), resume: true})
+	}
+	s.inDoubt = nil
+	s.flushed()
+	s.connection().recovered(s, unresumed)
+}
+
+// Called in handler goroutine with credit > 0 to send a delivery queued by
+// resumeSent(). A delivery with no message is settled, not re-sent.
+func (s *sender) resend(sm *sendable) {
+	if err := s.Error(); err != nil {
+		sm.outcome(Unacknowledged, err, nil).send(sm.ack)
+		return
+	}
+	var d proton.Delivery
+	if sm.resume {
+		d = s.pLink.Delivery(sm.tag)
+		d.SetResume(true)
+		if n := s.pLink.SendBytes(sm.bytes); n != len(sm.bytes) {
+			sm.outcome(Unacknowledged, fmt.Errorf("resend failed: %v", proton.PnErrorCode(n)), nil).send(sm.ack)
+			return
+		}
+		s.pLink.Advance()
+	} else {
+		var err error
+		if d, err = s.pLink.SendMessageBytesTag(sm.bytes, sm.tag); err != nil {
+			sm.outcome(Unacknowledged, err, nil).send(sm.ack)
+			return
+		}
+	}
+	if sm.bytes == nil {
+		d.Settle()
+		return
+	}
+	s.connection().metrics.OnTransfer(s, len(sm.bytes))
+	sm.d, sm.sentAt, sm.tag = d, time.Now(), d.Tag().String()
+	s.handler().sent[d] = sm
+	s.unsettled++
+}
+
+// receivedDelivery is a received message that is not settled, so it can be
+// resumed after reconnect. Only used in the handler goroutine.
+type receivedDelivery struct {
+	tag        string
+	m          amqp.Message
+	d          proton.Delivery
+	generation int                   // Link generation of d, d is gone if it is not current
+	ack        func(proton.Delivery) // Acknowledgement by the application, nil if none
+	state      DeliveryState         // Outcome set by ack, sent in the unsettled map
+	done       chan error            // Result for the acknowledging caller
+	expected   bool                  // The sender will resume the delivery
+	finished   bool                  // No longer tracked, err is the result
+	err        error
+}
+
+// Called in handler goroutine to track an unsettled received message.
+func (r *receiver) track(d proton.Delivery, m amqp.Message) *receivedDelivery {
+	if r.received == nil {
+		r.received = make(map[string]*receivedDelivery)
+	}
+	rd := &receivedDelivery{tag: d.Tag().String(), m: m, d: d, generation: r.generation}
+	r.received[rd.tag] = rd
+	return rd
+}
+
+// Called in handler goroutine when rd is settled or can't be resumed.
+func (r *receiver) untrack(rd *receivedDelivery, err error) {
+	if r.received[rd.tag] == rd {
+		delete(r.received, rd.tag)
+	}
+	rd.finished, rd.err = true, err
+	if rd.done != nil {
+		rd.done <- err
+		rd.done = nil
+	}
+}
+
+// Called in handler goroutine when the application acknowledges rd. Returns a
+// channel for the result. If rd was received before reconnecting, the
+// acknowledgement is applied when the sender resumes the delivery.
+func (r *receiver) ackReceived(rd *receivedDelivery, ack func(proton.Delivery)) chan error {
+	done := make(chan error, 1)
+	if rd.finished {
+		done <- rd.err
+		return done
+	}
+	rd.ack, rd.done = ack, done
+	if rd.generation == r.generation {
+		r.applyAck(rd)
+	}
+	return done
+}
+
+// Called in handler goroutine with rd.d current. With RcvSecond the delivery
+// is settled when the sender settles it.
+func (r *receiver) applyAck(rd *receivedDelivery) {
+	rd.ack(rd.d)
+	l := rd.d.Local()
+	rd.state = Outcome{Status: sentStatus(l.Type()), Error: l.Condition().Error(), Modified: remoteModified(l)}.State()
+	if r.RcvSettle() != RcvSecond || rd.d.Settled() {
+		rd.d.Settle()
+		r.untrack(rd, nil)
+	}
+}
+
+// Called in handler goroutine when the sender settles d, returns false if d is
+// not tracked.
+func (r *receiver) settledReceived(d proton.Delivery) bool {
+	rd := r.received[d.Tag().String()]
+	if rd == nil || rd.d != d || rd.generation != r.generation {
+		return false
+	}
+	d.Settle()
+	r.untrack(rd, nil)
+	return true
+}
+
+// Called in handler goroutine to set the unsettled map for the re-attach.
+func (r *receiver) setUnsettled() {
+	m := make(map[amqp.Binary]interface{}, len(r.received))
+	for tag, rd := range r.received {
+		m[amqp.Binary(tag)] = rd.state
+	}
+	_ = r.pLink.UnsettledMap().Marshal(m)
+}
+
+// Called in handler goroutine when the re-attached receiver is attached by the
+// remote peer. The link is recovered when the sender has resumed all the
+// deliveries it still has.
+func (r *receiver) resumeReceived() {
+	remote, ok := remoteUnsettled(r.pLink)
+	r.expecting, r.unresumed = 0, nil
+	for tag, rd := range r.received {
+		_, rd.expected = remote[amqp.Binary(tag)]
+		switch {
+		case rd.expected:
+			r.expecting++
+		case ok: // The sender has settled it
+			r.untrack(rd, nil)
+		default:
+			r.unresumed = append(r.unresumed, rd.m)
+			r.untrack(rd, ReconnectError{r.connection().reconnectError()})
+		}
+	}
+	if r.expecting == 0 {
+		r.connection().recovered(r, r.unresumed)
+		r.unresumed = nil
+	}
+}
+
+// Called in handler goroutine for an incoming delivery with the resume flag.
+// Returns false if d is a new message for the application.
+func (r *receiver) resumed(d proton.Delivery) bool {
+	rd := r.received[d.Tag().String()]
+	if rd == nil || rd.generation == r.generation {
+		if d.Pending() > 0 {
+			return false
+		}
+		// Settlement of a delivery we no longer have.
+		r.pLink.Advance()
+		d.Settle()
+		r.restoreCredit()
+		return true
+	}
+	r.pLink.Advance()
+	rd.d, rd.generation = d, r.generation
+	switch {
+	case d.Settled():
+		d.Settle()
+		r.untrack(rd, nil)
+	case rd.ack != nil:
+		r.applyAck(rd)
+	}
+	r.restoreCredit()
+	if rd.expected {
+		rd.expected = false
+		if r.expecting--; r.expecting == 0 {
+			r.connection().recovered(r, r.unresumed)
+			r.unresumed = nil
+		}
+	}
+	return true
+}
@@ -0,0 +1,346 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+	"github.com/apache/qpid-proton/go/pkg/proton"
+)
+
+// resumePeer is a scripted server for resume tests. Incoming links are passed
+// to the test before they are accepted, so the test can set the unsettled map
+// the server sends, and the test can send resumed deliveries from the server.
+type resumePeer struct {
+	t       *testing.T
+	l       net.Listener
+	servers chan Connection
+	ins     chan Incoming
+	events  chan ReconnectEvent
+	c, srv  Connection
+}
+
+func newResumePeer(t *testing.T) *resumePeer {
+	l, err := net.Listen("tcp4", ":0")
+	test.FatalIf(t, err)
+	p := &resumePeer{t: t, l: l, servers: make(chan Connection), ins: make(chan Incoming), events: make(chan ReconnectEvent, 10)}
+	go func() {
+		for {
+			c, err := NewContainer("server").Accept(l)
+			if err != nil {
+				return
+			}
+			p.servers <- c
+			go func() {
+				for in := range c.Incoming() {
+					switch in.(type) {
+					case *IncomingSender, *IncomingReceiver:
+						p.ins <- in
+					default:
+						in.Accept()
+					}
+				}
+			}()
+		}
+	}()
+	p.c, err = Dial(l.Addr().Network(), l.Addr().String(),
+		Reconnect(BackoffExponential(time.Millisecond, 10*time.Millisecond, 0), ReconnectEvents(p.events), ResumeDeliveries()))
+	test.FatalIf(t, err)
+	p.srv = <-p.servers
+	return p
+}
+
+func (p *resumePeer) close() {
+	p.c.Close(nil)
+	p.srv.Close(nil)
+	p.l.Close()
+}
+
+// accept the next incoming link, sending unsettled as the unsettled map if it is not nil.
+func (p *resumePeer) accept(unsettled map[amqp.Binary]interface{}) Endpoint {
+	in := <-p.ins
+	var pLink proton.Link
+	switch in := in.(type) {
+	case *IncomingReceiver:
+		in.SetPrefetch(true)
+		pLink = in.pLink
+	case *IncomingSender:
+		pLink = in.pLink
+	}
+	if unsettled != nil {
+		test.FatalIf(p.t, pLink.UnsettledMap().Marshal(unsettled))
+	}
+	return in.Accept()
+}
+
+// reconnect drops the server connection, waits for the client to reconnect and
+// accepts the re-attached link as for accept(). The server does not finish
+// opening the connection until the link is accepted.
+func (p *resumePeer) reconnect(unsettled map[amqp.Binary]interface{}) Endpoint {
+	p.srv.Disconnect(fmt.Errorf("drop"))
+	if e := <-p.events; e.Attempt != 0 || e.Err == nil {
+		p.t.Fatalf("want disconnect event got %v", e)
+	}
+	p.srv = <-p.servers
+	ep := p.accept(unsettled)
+	if e := <-p.events; !e.Reconnected {
+		p.t.Fatalf("want reconnected event got %v", e)
+	}
+	return ep
+}
+
+// recovered waits for the event reporting that l has resumed its deliveries.
+func (p *resumePeer) recovered(l Link) ReconnectEvent {
+	select {
+	case e := <-p.events:
+		if e.Link != l {
+			p.t.Fatalf("want recovered %v got %v", l, e)
+		}
+		return e
+	case <-time.After(time.Second):
+		p.t.Fatal("link not recovered")
+	}
+	return ReconnectEvent{}
+}
+
+// inject f into the server connection
+func (p *resumePeer) inject(f func()) {
+	test.FatalIf(p.t, p.srv.(*connection).injectWait(func() error { f(); return nil }))
+}
+
+// unsettled returns the unsettled map the client sent for the server end l.
+func (p *resumePeer) unsettled(l Endpoint) (m map[amqp.Binary]interface{}) {
+	p.inject(func() {
+		switch l := l.(type) {
+		case *sender:
+			m, _ = remoteUnsettled(l.pLink)
+		case *receiver:
+			m, _ = remoteUnsettled(l.pLink)
+		}
+	})
+	return m
+}
+
+// A canceled send waiting for the link to resume reports Unacknowledged without
+// waiting for the reconnect.
+func TestResumeSendCanceled(t *testing.T) {
+	p := newResumePeer(t)
+	defer p.close()
+	snd, err := p.c.Sender(Target("q"))
+	test.FatalIf(t, err)
+	rcv := p.accept(nil).(Receiver)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ack := make(chan Outcome, 1)
+	snd.SendAsyncContext(ctx, amqp.NewMessageWith("x"), ack, nil)
+	_, err = rcv.Receive()
+	test.FatalIf(t, err)
+	p.l.Close() // Reconnect attempts fail, the delivery stays in doubt.
+	p.srv.Disconnect(fmt.Errorf("drop"))
+	if e := <-p.events; e.Attempt != 0 || e.Err == nil {
+		t.Fatalf("want disconnect event got %v", e)
+	}
+	cancel()
+	select {
+	case out := <-ack:
+		test.ErrorIf(t, test.Differ(Unacknowledged, out.Status))
+		test.ErrorIf(t, test.Differ(SendCanceledError{Unacknowledged, context.Canceled}, out.Error))
+	case <-time.After(5 * time.Second):
+		t.Fatal("canceled send blocked")
+	}
+}
+
+func TestResumeSent(t *testing.T) {
+	for _, x := range []struct {
+		name      string
+		opts      []LinkOption
+		unsettled func(tag amqp.Binary) map[amqp.Binary]interface{}
+		redeliver bool // Server receives the message again
+		resumed   bool // with the resume flag
+		out       SentStatus
+	}{
+		{"settled", nil,
+			func(tag amqp.Binary) map[amqp.Binary]interface{} {
+				return map[amqp.Binary]interface{}{tag: AcceptedState{}}
+			},
+			false, false, Accepted},
+		{"unsettled", nil,
+			func(tag amqp.Binary) map[amqp.Binary]interface{} { return map[amqp.Binary]interface{}{tag: nil} },
+			true, true, Accepted},
+		{"unknown-rcv-second", []LinkOption{RcvSettle(RcvSecond)},
+			func(tag amqp.Binary) map[amqp.Binary]interface{} { return map[amqp.Binary]interface{}{} },
+			true, false, Accepted},
+		{"unknown-rcv-first", nil,
+			func(tag amqp.Binary) map[amqp.Binary]interface{} { return map[amqp.Binary]interface{}{} },
+			false, false, Unacknowledged},
+		{"no-map", nil,
+			func(tag amqp.Binary) map[amqp.Binary]interface{} { return nil },
+			false, false, Unacknowledged},
+	} {
+		t.Run(x.name, func(t *testing.T) {
+			p := newResumePeer(t)
+			defer p.close()
+			snd, err := p.c.Sender(append(x.opts, Target("q"))...)
+			test.FatalIf(t, err)
+			rcv := p.accept(nil).(Receiver)
+
+			ack := snd.SendWaitable(amqp.NewMessageWith("x"))
+			rm, err := rcv.Receive()
+			test.FatalIf(t, err)
+			tag := amqp.Binary(rm.pDelivery.Tag().String())
+			rcv = p.reconnect(x.unsettled(tag)).(Receiver)
+			test.ErrorIf(t, test.Differ(map[amqp.Binary]interface{}{tag: nil}, p.unsettled(rcv)))
+
+			e := p.recovered(snd)
+			if x.out == Unacknowledged {
+				test.ErrorIf(t, test.Differ(1, len(e.Unresumed)))
+				if len(e.Unresumed) == 1 {
+					test.ErrorIf(t, test.Differ("x", e.Unresumed[0].Body()))
+				}
+			} else {
+				test.ErrorIf(t, test.Differ(0, len(e.Unresumed)))
+			}
+			if x.redeliver {
+				rm, err := rcv.ReceiveTimeout(time.Second)
+				test.FatalIf(t, err)
+				test.ErrorIf(t, test.Differ("x", rm.Message.Body()))
+				test.ErrorIf(t, test.Differ(x.resumed, rm.pDelivery.Resumed()))
+				if x.resumed {
+					test.ErrorIf(t, test.Differ(tag, amqp.Binary(rm.pDelivery.Tag().String())))
+				}
+				test.ErrorIf(t, rm.Accept())
+			}
+			out := <-ack
+			test.ErrorIf(t, test.Differ(x.out, out.Status))
+			if _, ok := out.Error.(ReconnectError); ok != (x.out == Unacknowledged) {
+				t.Errorf("unexpected error %v", out.Error)
+			}
+
+			// Nothing more is received, new messages are sent as usual.
+			ack = snd.SendWaitable(amqp.NewMessageWith("y"))
+			rm, err = rcv.ReceiveTimeout(time.Second)
+			test.FatalIf(t, err)
+			test.ErrorIf(t, test.Differ("y", rm.Message.Body()))
+			test.ErrorIf(t, rm.Accept())
+			test.ErrorIf(t, test.Differ(Accepted, (<-ack).Status))
+		})
+	}
+}
+
+func TestResumeReceived(t *testing.T) {
+	bytes, err := amqp.NewMessageWith("x").Encode(nil)
+	test.FatalIf(t, err)
+	for _, x := range []struct {
+		name      string
+		unsettled bool // Server sends an unsettled map
+		has       bool // that has the delivery
+		resend    bool // Server resumes the delivery with the message, otherwise only settles it
+		err       bool // Accept() fails with ReconnectError
+	}{
+		{"resumed", true, true, true, false},
+		{"settled", true, true, false, false},
+		{"forgotten", true, false, false, false},
+		{"no-map", false, false, false, true},
+	} {
+		t.Run(x.name, func(t *testing.T) {
+			p := newResumePeer(t)
+			defer p.close()
+			rcv, err := p.c.Receiver(Source("q"), Prefetch(true))
+			test.FatalIf(t, err)
+			snd := p.accept(nil).(Sender)
+
+			snd.SendForget(amqp.NewMessageWith("x"))
+			rm, err := rcv.Receive()
+			test.FatalIf(t, err)
+			tag := amqp.Binary(rm.pDelivery.Tag().String())
+
+			var unsettled map[amqp.Binary]interface{}
+			if x.unsettled {
+				unsettled = map[amqp.Binary]interface{}{}
+				if x.has {
+					unsettled[tag] = nil
+				}
+			}
+			snd = p.reconnect(unsettled).(Sender)
+			test.ErrorIf(t, test.Differ(map[amqp.Binary]interface{}{tag: nil}, p.unsettled(snd)))
+
+			// Acknowledge before the delivery is resumed.
+			accepted := make(chan error, 1)
+			go func() { accepted <- rm.Accept() }()
+			var d proton.Delivery
+			if x.has {
+				select {
+				case e := <-p.events:
+					t.Fatalf("recovered before the delivery was resumed: %v", e)
+				case err := <-accepted:
+					t.Fatalf("acknowledged before the delivery was resumed: %v", err)
+				case <-time.After(10 * time.Millisecond):
+				}
+				pLink := snd.(*sender).pLink
+				p.inject(func() {
+					d = pLink.Delivery(string(tag))
+					d.SetResume(true)
+					if x.resend {
+						pLink.SendBytes(bytes)
+					}
+					pLink.Advance()
+					if !x.resend {
+						d.Settle()
+					}
+				})
+			}
+			e := p.recovered(rcv)
+			select {
+			case err := <-accepted:
+				if _, ok := err.(ReconnectError); ok != x.err {
+					t.Errorf("unexpected error %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Accept() blocked")
+			}
+			if x.err {
+				test.ErrorIf(t, test.Differ(1, len(e.Unresumed)))
+			} else {
+				test.ErrorIf(t, test.Differ(0, len(e.Unresumed)))
+			}
+			if x.resend { // The server gets the outcome for the resumed delivery
+				var state uint64
+				for i := 0; i < 100 && state == 0; i++ {
+					time.Sleep(time.Millisecond)
+					p.inject(func() { state = d.Remote().Type() })
+				}
+				test.ErrorIf(t, test.Differ(uint64(proton.Accepted), state))
+			}
+
+			// The message is not received again, new messages are received as usual.
+			snd.SendForget(amqp.NewMessageWith("y"))
+			rm, err = rcv.ReceiveTimeout(time.Second)
+			test.FatalIf(t, err)
+			test.ErrorIf(t, test.Differ("y", rm.Message.Body()))
+			test.ErrorIf(t, rm.Accept())
+		})
+	}
+}
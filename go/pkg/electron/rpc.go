@@ -0,0 +1,180 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+)
+
+// RPCClient makes request/response calls over a Connection. Replies come back
+// to a single dynamic receiver and are matched to calls by correlation-id, so
+// any number of calls can be outstanding at once.
+type RPCClient struct {
+	sn      Session
+	rcv     Receiver
+	replyTo string
+	done    chan struct{} // Closed when replies() returns
+
+	lock    sync.Mutex
+	err     error // Set when the reply receiver closes
+	next    uint64
+	waiting map[uint64]chan amqp.Message
+	senders map[string]Sender
+}
+
+// NewRPCClient opens a session on c and a DynamicReceiver() for replies.
+func NewRPCClient(c Connection) (*RPCClient, error) {
+	sn, err := c.Session()
+	if err != nil {
+		return nil, err
+	}
+	rcv, err := sn.Receiver(DynamicReceiver(), Prefetch(true), Capacity(100))
+	if err == nil {
+		err = rcv.Sync()
+	}
+	if err != nil {
+		sn.Close(nil)
+		return nil, err
+	}
+	rc := &RPCClient{
+		sn:      sn,
+		rcv:     rcv,
+		replyTo: rcv.DynamicAddress(),
+		done:    make(chan struct{}),
+		waiting: make(map[uint64]chan amqp.Message),
+		senders: make(map[string]Sender),
+	}
+	go rc.replies()
+	return rc, nil
+}
+
+// ReplyTo is the address of the dynamic receiver for replies.
+func (rc *RPCClient) ReplyTo() string { return rc.replyTo }
+
+// Call sends a copy of req to address with the reply-to address and a new
+// message-id set, and waits for the reply with that correlation-id.
+//
+// Returns ctx.Err() if ctx is done before the reply arrives, use a context with
+// a deadline to time out a call. A reply that arrives after its call has
+// returned is accepted and dropped.
+func (rc *RPCClient) Call(ctx context.Context, address string, req amqp.Message) (amqp.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rc.lock.Lock()
+	if rc.err != nil {
+		rc.lock.Unlock()
+		return nil, rc.err
+	}
+	snd, err := rc.sender(address)
+	if err != nil {
+		rc.lock.Unlock()
+		return nil, err
+	}
+	rc.next++
+	id := rc.next
+	reply := make(chan amqp.Message, 1)
+	rc.waiting[id] = reply
+	rc.lock.Unlock()
+	defer rc.forget(id)
+
+	m := amqp.NewMessageCopy(req)
+	m.SetMessageId(id)
+	m.SetReplyTo(rc.replyTo)
+	out, err := snd.SendContext(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	if out.Status != Accepted {
+		return nil, fmt.Errorf("request to %s not accepted: %v", address, out.Status)
+	}
+	select {
+	case rm, ok := <-reply:
+		if !ok {
+			return nil, rc.error()
+		}
+		return rm, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close closes the session used by the RPCClient. Outstanding and later calls
+// return with an error.
+func (rc *RPCClient) Close() {
+	rc.sn.Close(nil)
+	<-rc.done
+}
+
+// sender returns the sender for address, opened on the first call. Must hold lock.
+func (rc *RPCClient) sender(address string) (Sender, error) {
+	if s, ok := rc.senders[address]; ok && s.Error() == nil {
+		return s, nil
+	}
+	s, err := rc.sn.Sender(Target(address))
+	if err == nil {
+		rc.senders[address] = s
+	}
+	return s, err
+}
+
+func (rc *RPCClient) forget(id uint64) {
+	rc.lock.Lock()
+	delete(rc.waiting, id)
+	rc.lock.Unlock()
+}
+
+func (rc *RPCClient) error() error {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	return rc.err
+}
+
+// replies passes each reply to its caller until the receiver closes, then
+// wakes all remaining callers with the error.
+func (rc *RPCClient) replies() {
+	defer close(rc.done)
+	for {
+		rm, err := rc.rcv.Receive()
+		if err != nil {
+			rc.lock.Lock()
+			rc.err = err
+			for id, reply := range rc.waiting {
+				close(reply)
+				delete(rc.waiting, id)
+			}
+			rc.lock.Unlock()
+			return
+		}
+		_ = rm.Accept()
+		if id, ok := rm.Message.CorrelationId().(uint64); ok {
+			rc.lock.Lock()
+			if reply, ok := rc.waiting[id]; ok {
+				reply <- rm.Message
+				delete(rc.waiting, id)
+			}
+			rc.lock.Unlock()
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// rpcServer replies to requests sent to "service" and ignores those sent to
+// "slow". Dynamic sources are assigned the address "reply-1".
+func rpcServer(t *testing.T, conn net.Conn) {
+	c, err := NewConnection(conn, Server())
+	test.FatalIf(t, err)
+	replies := make(chan Sender, 1)
+	for in := range c.Incoming() {
+		switch in := in.(type) {
+		case *IncomingSender:
+			in.SetDynamicAddress("reply-1")
+			replies <- in.Accept().(Sender)
+		case *IncomingReceiver:
+			in.SetPrefetch(true)
+			in.SetCapacity(100)
+			r := in.Accept().(Receiver)
+			go func() {
+				snd := <-replies
+				replies <- snd
+				for {
+					rm, err := r.Receive()
+					if err != nil {
+						return
+					}
+					_ = rm.Accept()
+					if r.Target() == "service" {
+						test.ErrorIf(t, test.Differ(snd.Source(), rm.Message.ReplyTo()))
+						reply := amqp.NewMessageWith(fmt.Sprintf("reply %v", rm.Message.Body()))
+						reply.SetCorrelationId(rm.Message.MessageId())
+						go snd.SendForget(reply)
+					}
+				}
+			}()
+		default:
+			in.Accept()
+		}
+	}
+}
+
+func TestRPCClient(t *testing.T) {
+	cConn, sConn := net.Pipe()
+	go rpcServer(t, sConn)
+	c, err := NewConnection(cConn)
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+
+	rc, err := NewRPCClient(c)
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ("reply-1", rc.ReplyTo()))
+
+	// Concurrent calls get their own replies
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := amqp.NewMessageWith(i)
+			reply, err := rc.Call(context.Background(), "service", req)
+			test.ErrorIf(t, err)
+			if err == nil {
+				test.ErrorIf(t, test.Differ(fmt.Sprintf("reply %v", i), reply.Body()))
+			}
+			test.ErrorIf(t, test.Differ(nil, req.MessageId())) // Request is not modified
+		}(i)
+	}
+	wg.Wait()
+
+	// No reply, the call times out and is forgotten
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = rc.Call(ctx, "slow", amqp.NewMessage())
+	test.ErrorIf(t, test.Differ(context.DeadlineExceeded, err))
+	rc.lock.Lock()
+	test.ErrorIf(t, test.Differ(0, len(rc.waiting)))
+	rc.lock.Unlock()
+
+	// Calls fail after Close
+	rc.Close()
+	_, err = rc.Call(context.Background(), "service", amqp.NewMessage())
+	test.ErrorIf(t, test.Differ(Closed, err))
+}
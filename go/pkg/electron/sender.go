@@ -23,7 +23,9 @@ package electron
 import "C"
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/apache/qpid-proton/go/pkg/amqp"
@@ -37,6 +39,8 @@ import (
 // A sender can buffer messages up to the credit limit provided by the remote receiver.
 // All the Send* methods will block if the buffer is full until there is space.
 // Send*Timeout methods will give up after the timeout and set Timeout as Outcome.Error.
+// SetSendTimeout() sets a timeout for the Send methods with no timeout or
+// context of their own.
 //
 type Sender interface {
 	Endpoint
@@ -79,6 +83,111 @@ type Sender interface {
 	SendForgetTimeout(m amqp.Message, timeout time.Duration)
 
 	SendSyncTimeout(m amqp.Message, timeout time.Duration) Outcome
+
+	// SendContext sends a message and blocks until the message is acknowledged
+	// or ctx is done. Returns the Outcome and Outcome.Error.
+	//
+	// If ctx is done before the message is acknowledged the error is a
+	// SendCanceledError, its Status says how far the send got. An unacknowledged
+	// message is settled locally, the remote receiver may or may not process it.
+	SendContext(ctx context.Context, m amqp.Message) (Outcome, error)
+
+	// SendAsyncContext is like SendAsync but gives up if ctx is done before the
+	// message is acknowledged, see SendContext.
+	SendAsyncContext(ctx context.Context, m amqp.Message, ack chan<- Outcome, value interface{})
+
+	// SendTo is like SendContext but sends a copy of m with its address set to
+	// the to address, m is not modified. It is intended for Anonymous() senders.
+	SendTo(ctx context.Context, to string, m amqp.Message) (Outcome, error)
+
+	// SendTagged is like SendContext but sends m with the given delivery tag
+	// instead of a generated one, for example to let the receiver detect
+	// duplicates. The tag must be unique among the unsettled messages on the
+	// sender and at most MaxDeliveryTagSize bytes, a longer tag fails with
+	// ErrDeliveryTagTooLong. An empty tag is replaced by a generated one.
+	SendTagged(ctx context.Context, tag []byte, m amqp.Message) (Outcome, error)
+
+	// SendAsyncTagged is like SendAsyncContext but sends m with the given
+	// delivery tag, see SendTagged.
+	SendAsyncTagged(ctx context.Context, tag []byte, m amqp.Message, ack chan<- Outcome, value interface{})
+
+	// SendBatch sends msgs and blocks until they all have an Outcome. It is
+	// faster than sending the messages one at a time: they are passed to the
+	// connection together and sent as credit allows.
+	//
+	// The outcomes are in the same order as msgs, there is always one for each
+	// message and its Value is the index of the message in msgs. If the sender
+	// closes during the batch, the messages not yet sent are Unsent with the
+	// sender's error. If ctx is done first, messages without an outcome are
+	// abandoned as for SendContext. Returns the first non-nil Outcome.Error.
+	SendBatch(ctx context.Context, msgs []amqp.Message) ([]Outcome, error)
+
+	// TrySend sends m only if it can go at once: the sender has credit that
+	// is not needed by messages already waiting to be sent, and the
+	// connection is ready to take it. Otherwise it returns ok == false and
+	// nothing is sent, m can be sent again later. TrySend never waits for
+	// credit, it can be mixed with the blocking Send methods on the same
+	// sender.
+	//
+	// If ok is true the Outcome is sent on the returned channel, as for
+	// SendWaitable(). err is non-nil if the sender is closed.
+	TrySend(m amqp.Message) (out <-chan Outcome, ok bool, err error)
+
+	// Sendable returns a channel that is signalled when the remote receiver
+	// grants credit to a sender that had none. Messages sent after the signal
+	// will not block waiting for credit, unless the credit has been used by
+	// concurrent sends. The channel is closed when the sender closes.
+	//
+	// Signals are not queued: if credit runs out and is granted again before you
+	// receive from the channel you will get one signal, not two.
+	Sendable() <-chan struct{}
+
+	// SendTimeout returns the send timeout, see SetSendTimeout(). The initial
+	// value is set by the SendTimeout() LinkOption.
+	SendTimeout() time.Duration
+
+	// SetSendTimeout sets the timeout for SendSync, SendWaitable, SendAsync and
+	// SendForget. If a message is not sent and acknowledged within d it fails
+	// with Outcome.Error == Timeout, the sender remains usable.
+	//
+	// The Outcome.Status is Unsent if the message was never sent, because
+	// there was no credit. It is Unacknowledged if the message was sent: it is
+	// settled locally without waiting for the outcome, the remote receiver may
+	// or may not process it. SendForget only times out waiting for credit.
+	//
+	// 0 means wait forever, which is the default. To use a different timeout
+	// for a single message use SendContext with a context deadline.
+	SetSendTimeout(d time.Duration)
+
+	// Credit returns the credit currently granted by the remote receiver: the
+	// number of messages that can be sent without blocking.
+	Credit() (int, error)
+
+	// Detach detaches the sender from its target without closing the link,
+	// and signals an error to the remote end if err != nil. The remote peer
+	// may keep a durable target for a sender attached later with the same link
+	// name. See Receiver.Detach() for the resulting Error().
+	Detach(err error)
+
+	// Flush blocks until every message sent before the call has an Outcome:
+	// the remote receiver has given its outcome (Accepted, Rejected or
+	// Released), it was sent pre-settled, or it failed. Use it before closing the sender
+	// to make sure no messages are dropped.
+	//
+	// Returns ctx.Err() if ctx is done first, or the sender's error if it
+	// closes first.
+	Flush(ctx context.Context) error
+
+	// CloseWithDrain is like Flush followed by Close(nil): it waits for every
+	// message sent before the call to have an Outcome, then closes the
+	// sender. If ctx is done first the sender is closed anyway, messages
+	// without an outcome fail as for Close, and ctx.Err() is returned.
+	// Returns the sender's error if it closed before the flush completed.
+	CloseWithDrain(ctx context.Context) error
+
+	// Unsettled returns the number of messages that have been sent and are
+	// waiting for an outcome from the remote receiver, see MaxUnsettled().
+	Unsettled() int
 }
 
 // Outcome provides information about the outcome of sending a message.
@@ -89,6 +198,195 @@ type Outcome struct {
 	Error error
 	// Value provided by the application in SendAsync()
 	Value interface{}
+	// Modified is set if Status is Released because the receiver returned the
+	// modified outcome, nil otherwise.
+	Modified *ModifiedState
+
+	tag                  string    // Delivery tag, see DeliveryTag()
+	outcomeAt, settledAt time.Time // See OutcomeAt() and SettledAt()
+}
+
+// OutcomeAt returns when the remote receiver's outcome for the message arrived,
+// zero if it did not arrive.
+func (o Outcome) OutcomeAt() time.Time { return o.outcomeAt }
+
+// SettledAt returns when the message was settled by the remote receiver, or by
+// the sender in reply to the outcome if the receiver settles second
+// (RcvSecond). It is zero if the Outcome was reported before the message was
+// settled, see WaitForSettlement(), or if it was never settled.
+func (o Outcome) SettledAt() time.Time { return o.settledAt }
+
+// DeliveryTag returns the delivery tag of the message: the tag given to
+// SendTagged() or SendAsyncTagged(), otherwise the tag made by the sender's
+// DeliveryTags() generator or by the library. It is nil if the message
+// was never sent and had no tag of its own.
+func (o Outcome) DeliveryTag() []byte {
+	if o.tag == "" {
+		return nil
+	}
+	return []byte(o.tag)
+}
+
+// State returns the remote delivery state of the message, nil if the receiver
+// did not settle it with a known outcome. The State holds the same
+// information as Status, Error and Modified in a form suited to a type switch.
+func (o Outcome) State() DeliveryState {
+	switch o.Status {
+	case Accepted:
+		return AcceptedState{}
+	case Rejected:
+		var rs RejectedState
+		if err, ok := o.Error.(amqp.Error); ok {
+			rs.Error = &err
+		}
+		return rs
+	case Released:
+		if o.Modified != nil {
+			return *o.Modified
+		}
+		return ReleasedState{}
+	default:
+		return nil
+	}
+}
+
+// DeliveryState is the outcome a receiver gave to a message. It is one of
+// AcceptedState, RejectedState, ReleasedState or ModifiedState.
+type DeliveryState interface {
+	deliveryState()
+}
+
+// AcceptedState means the receiver took responsibility for the message.
+type AcceptedState struct{}
+
+// RejectedState means the receiver considers the message invalid.
+type RejectedState struct {
+	// Error is the condition sent by the receiver, including any
+	// vendor-specific Info. Nil if the receiver did not send one.
+	Error *amqp.Error
+}
+
+// ReleasedState means the receiver did not process the message.
+type ReleasedState struct{}
+
+// ModifiedState holds the fields of a modified outcome, see ReceivedMessage.Modify()
+type ModifiedState struct {
+	// DeliveryFailed means the message counts as a failed delivery attempt.
+	DeliveryFailed bool
+	// UndeliverableHere means the message must not be redelivered to the same receiver.
+	UndeliverableHere bool
+	// MessageAnnotations to merge into the message-annotations of the message.
+	MessageAnnotations map[amqp.Symbol]interface{}
+}
+
+func (AcceptedState) deliveryState() {}
+func (RejectedState) deliveryState() {}
+func (ReleasedState) deliveryState() {}
+func (ModifiedState) deliveryState() {}
+
+// Descriptors of the AMQP outcomes and error.
+const (
+	acceptedDescriptor uint64 = 0x24
+	rejectedDescriptor uint64 = 0x25
+	releasedDescriptor uint64 = 0x26
+	modifiedDescriptor uint64 = 0x27
+	errorDescriptor    uint64 = 0x1d
+)
+
+// MarshalAMQP encodes s as an amqp:accepted:list.
+func (s AcceptedState) MarshalAMQP() (interface{}, error) {
+	return amqp.Described{Descriptor: acceptedDescriptor, Value: amqp.List{}}, nil
+}
+
+// MarshalAMQP encodes s as an amqp:rejected:list.
+func (s RejectedState) MarshalAMQP() (interface{}, error) {
+	l := amqp.List{}
+	if s.Error != nil {
+		e := amqp.List{amqp.Symbol(s.Error.Name), s.Error.Description}
+		if s.Error.Info != nil {
+			e = append(e, *s.Error.Info)
+		}
+		l = append(l, amqp.Described{Descriptor: errorDescriptor, Value: e})
+	}
+	return amqp.Described{Descriptor: rejectedDescriptor, Value: l}, nil
+}
+
+// MarshalAMQP encodes s as an amqp:released:list.
+func (s ReleasedState) MarshalAMQP() (interface{}, error) {
+	return amqp.Described{Descriptor: releasedDescriptor, Value: amqp.List{}}, nil
+}
+
+// MarshalAMQP encodes s as an amqp:modified:list.
+func (s ModifiedState) MarshalAMQP() (interface{}, error) {
+	l := amqp.List{s.DeliveryFailed, s.UndeliverableHere}
+	if s.MessageAnnotations != nil {
+		l = append(l, s.MessageAnnotations)
+	}
+	return amqp.Described{Descriptor: modifiedDescriptor, Value: l}, nil
+}
+
+// decodeOutcome returns the DeliveryState for an unmarshaled outcome, or v
+// unchanged if it is not an outcome.
+func decodeOutcome(v interface{}) interface{} {
+	d, ok := v.(amqp.Described)
+	if !ok {
+		return v
+	}
+	l, _ := d.Value.(amqp.List)
+	field := func(i int) interface{} {
+		if i < len(l) {
+			return l[i]
+		}
+		return nil
+	}
+	switch d.Descriptor {
+	case acceptedDescriptor, amqp.Symbol("amqp:accepted:list"):
+		return AcceptedState{}
+	case rejectedDescriptor, amqp.Symbol("amqp:rejected:list"):
+		var s RejectedState
+		if e, ok := field(0).(amqp.Described); ok {
+			el, _ := e.Value.(amqp.List)
+			err := amqp.Error{}
+			if len(el) > 0 {
+				err.Name = fmt.Sprint(el[0])
+			}
+			if len(el) > 1 {
+				err.Description, _ = el[1].(string)
+			}
+			if len(el) > 2 {
+				if info := symbolMap(el[2]); info != nil {
+					err.Info = &info
+				}
+			}
+			s.Error = &err
+		}
+		return s
+	case releasedDescriptor, amqp.Symbol("amqp:released:list"):
+		return ReleasedState{}
+	case modifiedDescriptor, amqp.Symbol("amqp:modified:list"):
+		var s ModifiedState
+		s.DeliveryFailed, _ = field(0).(bool)
+		s.UndeliverableHere, _ = field(1).(bool)
+		s.MessageAnnotations = symbolMap(field(2))
+		return s
+	default:
+		return v
+	}
+}
+
+// symbolMap converts a decoded map with symbol keys, nil if v is not a map.
+func symbolMap(v interface{}) map[amqp.Symbol]interface{} {
+	m, ok := v.(amqp.Map)
+	if !ok {
+		return nil
+	}
+	out := make(map[amqp.Symbol]interface{}, len(m))
+	for k, x := range m {
+		if s, ok := k.(amqp.Symbol); ok {
+			out[s] = x
+		}
+	}
+	return out
 }
 
 func (o Outcome) send(ack chan<- Outcome) {
@@ -97,6 +395,18 @@ func (o Outcome) send(ack chan<- Outcome) {
 	}
 }
 
+// sendEventually sends o without blocking the caller, ack may be full or
+// unbuffered.
+func (o Outcome) sendEventually(ack chan<- Outcome) {
+	if ack != nil {
+		select {
+		case ack <- o:
+		default:
+			go func() { ack <- o }() // Deliver it eventually
+		}
+	}
+}
+
 // SentStatus indicates the status of a sent message.
 type SentStatus int
 
@@ -109,7 +419,8 @@ const (
 	Accepted
 	// Message was rejected as invalid by the receiver
 	Rejected
-	// Message was not processed by the receiver but may be valid for a different receiver
+	// Message was not processed by the receiver but may be valid for a different receiver.
+	// Outcome.Modified has the details if the receiver returned the modified outcome.
 	Released
 	// Receiver responded with an unrecognized status.
 	Unknown
@@ -135,6 +446,27 @@ func (s SentStatus) String() string {
 	}
 }
 
+// remoteOutcome returns the status and error for the remote delivery state.
+func remoteOutcome(d proton.Disposition) (SentStatus, error) {
+	if d.Type() == txnState {
+		return txnOutcome(d)
+	}
+	return sentStatus(d.Type()), d.Condition().Error()
+}
+
+// remoteModified returns the fields of a modified remote delivery state, nil
+// for any other state.
+func remoteModified(d proton.Disposition) *ModifiedState {
+	if d.Type() != proton.Modified {
+		return nil
+	}
+	m := &ModifiedState{DeliveryFailed: d.IsFailed(), UndeliverableHere: d.IsUndeliverable()}
+	if a := d.Annotations(); !a.Empty() {
+		_ = a.Unmarshal(&m.MessageAnnotations)
+	}
+	return m
+}
+
 // Convert proton delivery state code to SentStatus value
 func sentStatus(d uint64) SentStatus {
 	switch d {
@@ -149,43 +481,206 @@ func sentStatus(d uint64) SentStatus {
 	}
 }
 
+// ErrMessageTooLarge is the Outcome.Error for a message that was not sent
+// because it is larger than the max-message-size set by the remote receiver.
+var ErrMessageTooLarge = fmt.Errorf("message larger than remote max-message-size")
+
+// MaxDeliveryTagSize is the largest delivery tag allowed by AMQP, in bytes.
+const MaxDeliveryTagSize = 32
+
+// ErrDeliveryTagTooLong is the Outcome.Error for a message that was not sent
+// because its delivery tag is longer than MaxDeliveryTagSize.
+var ErrDeliveryTagTooLong = fmt.Errorf("delivery tag longer than %d bytes", MaxDeliveryTagSize)
+
+// ErrMissingToAddress is the Outcome.Error for a message with no address sent
+// on an Anonymous() sender.
+var ErrMissingToAddress = fmt.Errorf("message has no address for anonymous sender")
+
+// ErrMissingMessageID is the Outcome.Error for a message with no message-id
+// sent on a sender with the RequireMessageID() option.
+var ErrMissingMessageID = fmt.Errorf("message has no message-id")
+
+// SendCanceledError is the Outcome.Error for a SendContext call that was
+// abandoned because its context was done.
+type SendCanceledError struct {
+	// Status is Unsent if the message was never sent, Unacknowledged if it was
+	// sent and then settled without waiting for the remote outcome.
+	Status SentStatus
+	// Err is the context error.
+	Err error
+}
+
+func (e SendCanceledError) Error() string { return fmt.Sprintf("message %v: %v", e.Status, e.Err) }
+
 type sendable struct {
-	m    amqp.Message
-	ack  chan<- Outcome // Channel for acknowledgement of m
-	v    interface{}    // Correlation value
-	sent chan struct{}  // Closed when m is encoded and will be sent
+	m      amqp.Message
+	ack    chan<- Outcome  // Channel for acknowledgement of m
+	v      interface{}     // Correlation value
+	sent   chan struct{}   // Closed when m is encoded and will be sent
+	d      proton.Delivery // Delivery for m once it is sent
+	sentAt time.Time       // When m was sent, for Metrics
+
+	outcomeAt, settledAt time.Time // When the remote outcome arrived and m was settled
+
+	txnId  amqp.Binary              // Transaction for the transfer, empty if none
+	remote func(proton.Disposition) // Called with the remote state on settlement, may be nil
+
+	// Kept to resume the delivery after reconnect, see ResumeDeliveries().
+	bytes  []byte // Encoded message, nil for a delivery that is only settled
+	tag    string // Delivery tag
+	resume bool   // Re-send with the same tag and the resume flag
+}
+
+// outcome returns an Outcome for sm carrying its Value and delivery tag.
+func (sm *sendable) outcome(status SentStatus, err error, modified *ModifiedState) Outcome {
+	return Outcome{Status: status, Error: err, Value: sm.v, Modified: modified, tag: sm.tag,
+		outcomeAt: sm.outcomeAt, settledAt: sm.settledAt}
 }
 
 func (sm *sendable) unsent(err error) {
-	Outcome{Unsent, err, sm.v}.send(sm.ack)
+	sm.outcome(Unsent, err, nil).send(sm.ack)
 }
 
 type sender struct {
+	sendTimeout int64 // Atomic time.Duration, first field for 64-bit alignment
 	link
-	sending []*sendable
+	sending      []*sendable
+	inDoubt      []*sendable // Waiting for the re-attach to resume, see ResumeDeliveries()
+	resuming     []*sendable // Waiting for credit to resume, sent before sending
+	sendableChan chan struct{}
+	noCredit     bool // Credit was 0 at the last check
+	done         bool // sendableChan is closed
+	flushing     []chan error
+	unsettled    int // Messages waiting for an outcome in handler.sent
+}
+
+// Called in handler goroutine when the remote outcome of sm arrives before the
+// receiver settles it.
+func (s *sender) unsettledOutcome(sm *sendable, e proton.Event) {
+	sm.outcomeAt = time.Now()
+	switch {
+	case e.Link().RemoteRcvSettleMode() == proton.RcvSecond:
+		// The receiver waits for us to settle.
+		sm.settledAt = sm.outcomeAt
+		h := s.handler()
+		h.outcome(e)
+		e.Delivery().Settle()
+	case !s.waitSettled:
+		s.handler().outcome(e)
+	}
 }
 
 func newSender(ls linkSettings) *sender {
-	s := &sender{link: link{linkSettings: ls}}
+	s := &sender{
+		sendTimeout:  int64(ls.sendTimeout),
+		link:         link{linkSettings: ls},
+		sendableChan: make(chan struct{}, 1),
+		noCredit:     true,
+	}
 	s.endpoint.init(s.link.pLink.String())
 	s.handler().addLink(s.pLink, s)
+	if s.remote {
+		s.setAttachFields()
+		if s.sourceSettings.Dynamic {
+			s.pLink.Source().SetAddress(s.source)
+		}
+	}
 	s.link.pLink.Open()
 	return s
 }
 
 // Called in handler goroutine
 func (s *sender) startSend(sm *sendable) {
-	s.sending = append(s.sending, sm)
+	s.queue(sm)
 	s.trySend()
 }
 
+// Called in handler goroutine, adds sm to the messages waiting for credit.
+func (s *sender) queue(sm *sendable) {
+	if s.anonymous && sm.m.Address() == "" {
+		close(sm.sent)
+		sm.unsent(ErrMissingToAddress)
+		return
+	}
+	if sm.m.MessageId() == nil {
+		switch {
+		case s.messageID != nil:
+			sm.m.SetMessageId(s.messageID())
+		case s.requireMessageID:
+			close(sm.sent)
+			sm.unsent(ErrMissingMessageID)
+			return
+		}
+	}
+	s.sending = append(s.sending, sm)
+}
+
 // Called in handler goroutine
 func (s *sender) trySend() {
-	for s.pLink.Credit() > 0 && len(s.sending) > 0 {
+	s.creditChanged(s) // Credit granted by the peer, before we use it
+	for s.pLink.Credit() > 0 && len(s.resuming) > 0 {
+		sm := s.resuming[0]
+		s.resuming = s.resuming[1:]
+		s.resend(sm)
+	}
+	for s.pLink.Credit() > 0 && len(s.sending) > 0 && !s.unsettledFull(0) {
 		sm := s.sending[0]
 		s.sending = s.sending[1:]
 		s.send(sm)
 	}
+	if len(s.sending) == 0 && len(s.resuming) == 0 && s.pLink.IsDrain() {
+		s.pLink.Drained() // Nothing to send, return the credit
+	}
+	s.flushed()
+	s.creditChanged(s)
+	credit := s.pLink.Credit()
+	if credit > 0 && s.noCredit && !s.done {
+		select {
+		case s.sendableChan <- struct{}{}:
+		default: // Already signalled
+		}
+	}
+	s.noCredit = credit <= 0
+}
+
+func (s *sender) Sendable() <-chan struct{} { return s.sendableChan }
+
+func (s *sender) SendTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.sendTimeout))
+}
+
+func (s *sender) SetSendTimeout(d time.Duration) { atomic.StoreInt64(&s.sendTimeout, int64(d)) }
+
+func (s *sender) Unsettled() (n int) {
+	_ = s.connection().injectWait(func() error { n = s.unsettled; return nil })
+	return
+}
+
+// Called in handler goroutine, true if MaxUnsettled() stops a message being
+// sent after n messages ahead of it.
+func (s *sender) unsettledFull(n int) bool {
+	return s.maxUnsettled > 0 && s.unsettled+n >= s.maxUnsettled
+}
+
+// Called in handler goroutine when a message sent by s is no longer waiting
+// for its outcome.
+func (s *sender) settledSent() {
+	s.unsettled--
+	if s.maxUnsettled > 0 && len(s.sending) > 0 {
+		s.trySend() // Messages held back by MaxUnsettled()
+	}
+	s.flushed()
+}
+
+func (s *sender) Credit() (credit int, err error) {
+	err = s.connection().injectWait(func() error {
+		if err := s.Error(); err != nil {
+			return err
+		}
+		credit = s.pLink.Credit()
+		return nil
+	})
+	return
 }
 
 // Called in handler goroutine with credit > 0
@@ -200,38 +695,170 @@ func (s *sender) send(sm *sendable) {
 		sm.unsent(err)
 		return
 	}
-	d, err := s.pLink.SendMessageBytes(bytes)
+	// Don't start a transfer that the receiver will refuse.
+	if max := s.pLink.RemoteMaxMessageSize(); max > 0 && uint64(len(bytes)) > max {
+		sm.unsent(ErrMessageTooLarge)
+		return
+	}
+	tag := sm.tag
+	if tag == "" && s.tagGenerator != nil {
+		if tag = string(s.tagGenerator()); len(tag) > MaxDeliveryTagSize {
+			sm.unsent(ErrDeliveryTagTooLong)
+			return
+		}
+	}
+	var d proton.Delivery
+	if tag == "" {
+		d, err = s.pLink.SendMessageBytes(bytes)
+	} else {
+		d, err = s.pLink.SendMessageBytesTag(bytes, tag)
+	}
 	if err != nil {
 		sm.unsent(err)
 		return
 	}
+	sm.tag = d.Tag().String()
+	s.connection().metrics.OnTransfer(s, len(bytes))
+	if sm.txnId != "" { // The transfer carries the transactional state
+		if err := d.Local().Data().Marshal(amqp.List{sm.txnId}); err != nil {
+			panic(err) // Shouldn't happen
+		}
+		d.Update(txnState)
+	}
 	if s.SndSettle() == SndSettled || (s.SndSettle() == SndMixed && sm.ack == nil) {
-		d.Settle()                                // Pre-settled
-		Outcome{Accepted, nil, sm.v}.send(sm.ack) // Assume accepted
+		d.Settle()                                  // Pre-settled
+		sm.outcome(Accepted, nil, nil).send(sm.ack) // Assume accepted
 	} else {
 		// Register with handler to receive the remote outcome
+		sm.d, sm.sentAt = d, time.Now()
+		if s.resumes() && sm.txnId == "" {
+			sm.bytes = bytes
+		}
 		s.handler().sent[d] = sm
+		s.unsettled++
 	}
 }
 
-func (s *sender) timeoutSend(sm *sendable) {
+// Called in handler goroutine, returns true if sm was removed before it was sent.
+func (s *sender) timeoutSend(sm *sendable) bool {
 	for i, sm2 := range s.sending {
 		if sm2 == sm {
 			n := copy(s.sending[i:], s.sending[i+1:])
 			s.sending = s.sending[:i+n] // delete
 			close(sm.sent)
-			return
+			s.flushed()
+			return true
+		}
+	}
+	return false
+}
+
+// Called in handler goroutine, returns true if sm was sent but not yet
+// acknowledged. The delivery is settled so the outcome is no longer expected.
+func (s *sender) cancelSent(sm *sendable) bool {
+	h := s.handler()
+	if h.sent[sm.d] == sm {
+		delete(h.sent, sm.d)
+		sm.d.Settle()
+		s.settledSent()
+		return true
+	}
+	// Suspended by reconnect, the re-attach settles it if the receiver has it.
+	for i, sm2 := range s.inDoubt {
+		if sm2 == sm {
+			s.inDoubt = append(s.inDoubt[:i:i], s.inDoubt[i+1:]...)
+			s.flushed()
+			return true
+		}
+	}
+	for i, sm2 := range s.resuming {
+		if sm2 == sm {
+			if sm.resume { // Settle the receiver's delivery, don't re-send the message
+				s.resuming[i] = &sendable{tag: sm.tag, resume: true}
+			} else {
+				s.resuming = append(s.resuming[:i:i], s.resuming[i+1:]...)
+			}
+			s.flushed()
+			return true
+		}
+	}
+	return false
+}
+
+// Called in handler goroutine, true if any messages are waiting to be sent or
+// waiting for an outcome.
+func (s *sender) pending() bool {
+	if len(s.sending) > 0 || len(s.inDoubt) > 0 || len(s.resuming) > 0 {
+		return true
+	}
+	for d := range s.handler().sent {
+		if d.Link() == s.pLink {
+			return true
 		}
 	}
+	return false
+}
+
+// Called in handler goroutine when messages are sent or settled, wakes
+// Flush() if nothing is pending, and Shutdown() if nothing is in flight.
+func (s *sender) flushed() {
+	if len(s.flushing) > 0 && !s.pending() {
+		for _, f := range s.flushing {
+			f <- nil
+		}
+		s.flushing = nil
+	}
+	s.connection().checkShutdown()
+}
+
+func (s *sender) Flush(ctx context.Context) error {
+	f := make(chan error, 1)
+	err := s.connection().injectWait(func() error {
+		if err := s.Error(); err != nil {
+			return err
+		}
+		if s.pending() {
+			s.flushing = append(s.flushing, f)
+		} else {
+			f <- nil
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-f:
+		return err
+	case <-ctx.Done():
+		_ = s.connection().inject(func() {
+			for i, f2 := range s.flushing {
+				if f2 == f {
+					s.flushing = append(s.flushing[:i], s.flushing[i+1:]...)
+					break
+				}
+			}
+		})
+		return ctx.Err()
+	}
+}
+
+func (s *sender) CloseWithDrain(ctx context.Context) error {
+	err := s.Flush(ctx)
+	s.Close(nil)
+	return err
 }
 
 func (s *sender) SendAsyncTimeout(m amqp.Message, ack chan<- Outcome, v interface{}, t time.Duration) {
-	sm := &sendable{m, ack, v, make(chan struct{})}
-	s.engine().Inject(func() { s.startSend(sm) })
+	sm := &sendable{m: m, ack: ack, v: v, sent: make(chan struct{})}
+	if err := s.connection().inject(func() { s.startSend(sm) }); err != nil {
+		sm.unsent(err) // Connection is closed
+		return
+	}
 	select {
 	case <-sm.sent: // OK
 	case <-After(t): // Try to timeout sm
-		s.engine().Inject(func() { s.timeoutSend(sm) })
+		s.connection().inject(func() { s.timeoutSend(sm) })
 	}
 }
 
@@ -258,20 +885,247 @@ func (s *sender) SendSyncTimeout(m amqp.Message, t time.Duration) Outcome {
 		if err == Closed && s.Error() != nil {
 			err = s.Error()
 		}
-		return Outcome{Unacknowledged, err, nil}
+		return Outcome{Status: Unacknowledged, Error: err}
+	}
+}
+
+func (s *sender) SendAsyncContext(ctx context.Context, m amqp.Message, ack chan<- Outcome, v interface{}) {
+	sm := &sendable{m: m, ack: ack, v: v, sent: make(chan struct{})}
+	s.sendAsync(ctx, func() {}, sendCanceled, sm)
+}
+
+// Outcome.Error for a send abandoned by SendAsyncContext.
+func sendCanceled(status SentStatus, err error) error { return SendCanceledError{status, err} }
+
+// Outcome.Error for a send abandoned because of the SendTimeout().
+func sendTimedOut(SentStatus, error) error { return Timeout }
+
+// sendAsync sends sm and gives up if ctx is done before it is acknowledged,
+// with an Outcome.Error returned by fail. done is called when the send is
+// finished.
+func (s *sender) sendAsync(ctx context.Context, done func(), fail func(SentStatus, error) error, sm *sendable) {
+	ack := sm.ack
+	if err := ctx.Err(); err != nil {
+		sm.outcome(Unsent, fail(Unsent, err), nil).send(ack)
+		done()
+		return
+	}
+	var out chan Outcome
+	if ack != nil && ctx.Done() != nil {
+		// Intercept the outcome so we can stop waiting for it when ctx is done.
+		out = make(chan Outcome, 1)
+		sm.ack = out
+	}
+	if err := s.connection().inject(func() { s.startSend(sm) }); err != nil {
+		sm.outcome(Unsent, err, nil).send(ack) // Connection is closed
+		done()
+		return
+	}
+	select {
+	case <-sm.sent: // OK
+	case <-ctx.Done():
+		unsent := false
+		_ = s.connection().injectWait(func() error { unsent = s.timeoutSend(sm); return nil })
+		if unsent {
+			sm.outcome(Unsent, fail(Unsent, ctx.Err()), nil).send(ack)
+			done()
+			return
+		}
+	}
+	if out != nil {
+		go s.waitContext(ctx, done, fail, sm, out, ack)
+	} else {
+		done()
+	}
+}
+
+// waitContext forwards the outcome of sm from out to ack, or settles sm and
+// reports it Unacknowledged if ctx is done first. If the connection is
+// reconnecting sm is reported at once and cancelled when it is re-attached.
+func (s *sender) waitContext(ctx context.Context, done func(), fail func(SentStatus, error) error, sm *sendable, out <-chan Outcome, ack chan<- Outcome) {
+	defer done()
+	select {
+	case o := <-out:
+		o.send(ack)
+		return
+	case <-ctx.Done():
+	}
+	result := make(chan bool, 1)
+	go func() {
+		canceled := false
+		_ = s.connection().injectWait(func() error { canceled = s.cancelSent(sm); return nil })
+		result <- canceled
+	}()
+	var o Outcome
+	select {
+	case canceled := <-result:
+		if !canceled {
+			(<-out).send(ack) // Outcome arrived while we were cancelling.
+			return
+		}
+	case <-s.connection().engineDown():
+		if s.connection().whileDown(func() { o = sm.outcome(Unacknowledged, fail(Unacknowledged, ctx.Err()), nil) }) {
+			o.send(ack)
+			return
+		}
+		if !<-result { // Re-attached meanwhile
+			(<-out).send(ack)
+			return
+		}
+	}
+	sm.outcome(Unacknowledged, fail(Unacknowledged, ctx.Err()), nil).send(ack)
+}
+
+func (s *sender) SendContext(ctx context.Context, m amqp.Message) (Outcome, error) {
+	ack := make(chan Outcome, 1)
+	s.SendAsyncContext(ctx, m, ack, nil)
+	out := <-ack
+	return out, out.Error
+}
+
+func (s *sender) SendTo(ctx context.Context, to string, m amqp.Message) (Outcome, error) {
+	m = amqp.NewMessageCopy(m)
+	m.SetAddress(to)
+	return s.SendContext(ctx, m)
+}
+
+func (s *sender) SendAsyncTagged(ctx context.Context, tag []byte, m amqp.Message, ack chan<- Outcome, v interface{}) {
+	sm := &sendable{m: m, ack: ack, v: v, sent: make(chan struct{}), tag: string(tag)}
+	if len(tag) > MaxDeliveryTagSize {
+		sm.unsent(ErrDeliveryTagTooLong)
+		return
+	}
+	s.sendAsync(ctx, func() {}, sendCanceled, sm)
+}
+
+func (s *sender) SendTagged(ctx context.Context, tag []byte, m amqp.Message) (Outcome, error) {
+	ack := make(chan Outcome, 1)
+	s.SendAsyncTagged(ctx, tag, m, ack, nil)
+	out := <-ack
+	return out, out.Error
+}
+
+func (s *sender) SendBatch(ctx context.Context, msgs []amqp.Message) ([]Outcome, error) {
+	outcomes := make([]Outcome, len(msgs))
+	ack := make(chan Outcome, len(msgs)) // Never blocks the handler
+	batch := make([]*sendable, len(msgs))
+	for i, m := range msgs {
+		batch[i] = &sendable{m: m, ack: ack, v: i, sent: make(chan struct{})}
+	}
+	var err error
+	if err = ctx.Err(); err != nil {
+		err = SendCanceledError{Unsent, err}
+	} else {
+		err = s.connection().inject(func() {
+			for _, sm := range batch {
+				s.queue(sm)
+			}
+			s.trySend()
+		})
+	}
+	if err != nil {
+		for i := range outcomes {
+			outcomes[i] = Outcome{Status: Unsent, Error: err, Value: i}
+		}
+		return outcomes, err
+	}
+	done := ctx.Done()
+	for n := 0; n < len(msgs); {
+		select {
+		case o := <-ack:
+			outcomes[o.Value.(int)] = o
+			n++
+		case <-done:
+			done = nil // Outcomes for the abandoned messages follow on ack.
+			cerr := ctx.Err()
+			_ = s.connection().injectWait(func() error { s.cancelBatch(batch, cerr); return nil })
+		}
 	}
+	for _, o := range outcomes {
+		if o.Error != nil {
+			return outcomes, o.Error
+		}
+	}
+	return outcomes, nil
+}
+
+// Called in handler goroutine, abandons the messages in batch that do not yet
+// have an outcome.
+func (s *sender) cancelBatch(batch []*sendable, err error) {
+	inBatch := make(map[*sendable]bool, len(batch))
+	for _, sm := range batch {
+		inBatch[sm] = true
+	}
+	sending := s.sending[:0]
+	for _, sm := range s.sending {
+		if inBatch[sm] {
+			close(sm.sent)
+			sm.outcome(Unsent, SendCanceledError{Unsent, err}, nil).send(sm.ack)
+		} else {
+			sending = append(sending, sm)
+		}
+	}
+	s.sending = sending
+	for _, sm := range batch {
+		if s.cancelSent(sm) {
+			sm.outcome(Unacknowledged, SendCanceledError{Unacknowledged, err}, nil).send(sm.ack)
+		}
+	}
+	s.flushed()
 }
 
 func (s *sender) SendAsync(m amqp.Message, ack chan<- Outcome, v interface{}) {
-	s.SendAsyncTimeout(m, ack, v, Forever)
+	d := s.SendTimeout()
+	if d <= 0 || d == Forever {
+		s.SendAsyncTimeout(m, ack, v, Forever)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	sm := &sendable{m: m, ack: ack, v: v, sent: make(chan struct{})}
+	s.sendAsync(ctx, cancel, sendTimedOut, sm)
 }
 
+func (s *sender) TrySend(m amqp.Message) (<-chan Outcome, bool, error) {
+	if err := s.Error(); err != nil {
+		return nil, false, err
+	}
+	out := make(chan Outcome, 1)
+	sm := &sendable{m: m, ack: out, sent: make(chan struct{})}
+	result := make(chan error, 1)
+	ok, err := s.connection().tryInject(func() {
+		switch {
+		case s.Error() != nil:
+			result <- s.Error()
+		case s.pLink.Credit() <= len(s.sending) || s.unsettledFull(len(s.sending)):
+			result <- errNoCredit
+		default:
+			s.startSend(sm)
+			result <- nil
+		}
+	})
+	if ok {
+		err = <-result // Does not wait for credit, only for the check
+		if err == errNoCredit {
+			ok, err = false, nil
+		}
+	}
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// errNoCredit is used by TrySend when it can't send at once.
+var errNoCredit = fmt.Errorf("no credit")
+
 func (s *sender) SendWaitable(m amqp.Message) <-chan Outcome {
-	return s.SendWaitableTimeout(m, Forever)
+	out := make(chan Outcome, 1)
+	s.SendAsync(m, out, nil)
+	return out
 }
 
 func (s *sender) SendForget(m amqp.Message) {
-	s.SendForgetTimeout(m, Forever)
+	s.SendAsync(m, nil, nil)
 }
 
 func (s *sender) SendSync(m amqp.Message) Outcome {
@@ -280,11 +1134,37 @@ func (s *sender) SendSync(m amqp.Message) Outcome {
 
 // handler goroutine
 func (s *sender) closed(err error) error {
-	for _, sm := range s.sending {
+	sending := s.sending
+	for _, sm := range sending {
 		close(sm.sent)
 	}
 	s.sending = nil
-	return s.link.closed(err)
+	if !s.done {
+		close(s.sendableChan)
+		s.done = true
+	}
+	err = s.link.closed(err)
+	// Messages that will never be sent or acknowledged.
+	for _, sm := range sending {
+		sm.outcome(Unsent, err, nil).sendEventually(sm.ack)
+	}
+	h := s.handler()
+	for d, sm := range h.sent {
+		if d.Link() == s.pLink {
+			delete(h.sent, d)
+			sm.outcome(Unacknowledged, err, nil).sendEventually(sm.ack)
+		}
+	}
+	s.unsettled = 0
+	for _, sm := range append(s.inDoubt, s.resuming...) {
+		sm.outcome(Unacknowledged, err, nil).sendEventually(sm.ack)
+	}
+	s.inDoubt, s.resuming = nil, nil
+	for _, f := range s.flushing {
+		f <- err
+	}
+	s.flushing = nil
+	return err
 }
 
 // IncomingSender is sent on the Connection.Incoming() channel when there is
@@ -301,6 +1181,34 @@ func newIncomingSender(sn *session, pLink proton.Link) *IncomingSender {
 	}
 }
 
+// SetDynamicAddress sets the address assigned to the source node created for
+// an incoming sender with a dynamic source, see DynamicReceiver(). Call before
+// Accept()
+func (in *IncomingSender) SetDynamicAddress(addr string) { in.source = addr }
+
+// SetMaxMessageSize sets the largest message (in bytes) the incoming sender
+// will accept, call before Accept(). 0 means no limit.
+func (in *IncomingSender) SetMaxMessageSize(size uint64) { in.maxMessageSize = size }
+
+// SetProperties sets the link properties to send to the remote peer, call before Accept()
+func (in *IncomingSender) SetProperties(m map[amqp.Symbol]interface{}) { in.properties = m }
+
+// SetOfferedCapabilities sets the capabilities offered to the remote peer, call before Accept()
+func (in *IncomingSender) SetOfferedCapabilities(caps ...amqp.Symbol) {
+	in.offeredCapabilities = caps
+}
+
+// SetDesiredCapabilities sets the capabilities requested from the remote peer, call before Accept()
+func (in *IncomingSender) SetDesiredCapabilities(caps ...amqp.Symbol) {
+	in.desiredCapabilities = caps
+}
+
+// Address is the Source() address the remote peer wants to receive from.
+func (in *IncomingSender) Address() string { return in.source }
+
+// AuthenticatedUser is the SASL identity of the remote peer.
+func (in *IncomingSender) AuthenticatedUser() string { return in.session.connection.authUser }
+
 // Accept accepts an incoming sender endpoint
 func (in *IncomingSender) Accept() Endpoint {
 	return in.accept(func() Endpoint { return newSender(in.linkSettings) })
@@ -20,6 +20,10 @@ under the License.
 package electron
 
 import (
+	"context"
+	"math"
+	"sync/atomic"
+
 	"github.com/apache/qpid-proton/go/pkg/proton"
 )
 
@@ -28,10 +32,48 @@ type Session interface {
 	Endpoint
 
 	// Sender opens a new sender.
+	//
+	// The sender can be used immediately, call Sync() to wait for the remote
+	// peer to attach it. If the peer refuses the link, Sync() returns the
+	// amqp.Error sent by the peer, for example with Name amqp.NotFound.
 	Sender(...LinkOption) (Sender, error)
 
-	// Receiver opens a new Receiver.
+	// Receiver opens a new Receiver. See Sender() for error handling.
 	Receiver(...LinkOption) (Receiver, error)
+
+	// BeginTransaction declares a new transaction with the remote peer's
+	// transaction coordinator, see Txn. Each transaction uses its own
+	// coordinator link, which is closed when the transaction is discharged.
+	BeginTransaction(ctx context.Context) (Txn, error)
+
+	// Links returns a snapshot of the Senders and Receivers that are currently
+	// open on the session, in no particular order.
+	Links() []Link
+
+	// LinkCount is the number of links attached on the session. Unlike
+	// Links() it does not wait for the connection, it is safe to call from any
+	// goroutine.
+	LinkCount() int
+
+	// IncomingCapacity is the session's incoming buffer capacity in bytes, 0 if
+	// it is unlimited. See the IncomingCapacity() option.
+	IncomingCapacity() uint
+
+	// OutgoingWindow is the outgoing window in frames that we send to the peer.
+	OutgoingWindow() uint
+
+	// RemoteIncomingWindow is the number of transfer frames the remote peer will
+	// currently accept on the session. It is set when the peer begins the
+	// session, updated by the peer's flow frames and goes down as we send.
+	// Call Sync() first to be sure the peer has begun the session.
+	RemoteIncomingWindow() uint
+
+	// RemoteOutgoingWindow is the outgoing window last sent by the remote peer.
+	RemoteOutgoingWindow() uint
+
+	// HandleMax is the highest link handle allowed on the session, see the
+	// HandleMax() option.
+	HandleMax() uint32
 }
 
 type session struct {
@@ -39,6 +81,8 @@ type session struct {
 	pSession                         proton.Session
 	connection                       *connection
 	incomingCapacity, outgoingWindow uint
+	handleMax                        uint32
+	linkCount                        int32 // Atomic, see LinkCount()
 }
 
 // SessionOption can be passed when creating a Session
@@ -46,49 +90,129 @@ type SessionOption func(*session)
 
 // IncomingCapacity returns a Session Option that sets the size (in bytes) of
 // the session's incoming data buffer.
+//
+// The session's incoming window is the unused capacity divided by the local
+// max-frame-size, rounded down to whole frames. The capacity has no effect if
+// there is no max-frame-size, and it must be at least one frame: a smaller
+// capacity closes the connection with an amqp:internal-error. Messages are
+// received whole, so the capacity must also hold the largest message or its
+// transfer will stall. A capacity of many frames lets the peer keep sending
+// while earlier frames are still on the wire, which matters on high-latency
+// connections. If not set the incoming window is unlimited.
 func IncomingCapacity(bytes uint) SessionOption {
 	return func(s *session) { s.incomingCapacity = bytes }
 }
 
-// OutgoingWindow returns a Session Option that sets the outgoing window size (in frames).
+// OutgoingWindow returns a Session Option that sets the outgoing window size
+// (in frames) sent to the peer. It is advisory: transfers are limited by the
+// peer's incoming window. If not set the window is unlimited.
 func OutgoingWindow(frames uint) SessionOption {
 	return func(s *session) { s.outgoingWindow = frames }
 }
 
+// HandleMax returns a SessionOption that limits the session to n+1 links open
+// at the same time. Opening a Sender or Receiver beyond the limit returns an
+// amqp.Error with Name amqp.ResourceLimitExceeded, and incoming links beyond
+// the limit are refused with the same error. The default is no limit.
+//
+// Note the limit is enforced locally: the proton library does not send
+// handle-max in the begin frame, so the remote peer is not told about it.
+func HandleMax(n uint32) SessionOption {
+	return func(s *session) { s.handleMax = n }
+}
+
 // in proton goroutine
 func newSession(c *connection, es proton.Session, setting ...SessionOption) *session {
 	s := &session{
 		connection: c,
 		pSession:   es,
+		handleMax:  math.MaxUint32,
 	}
 	s.endpoint.init(es.String())
 	for _, set := range setting {
 		set(s)
 	}
-	c.handler.sessions[s.pSession] = s
-	s.pSession.SetIncomingCapacity(s.incomingCapacity)
-	s.pSession.SetOutgoingWindow(s.outgoingWindow)
+	c.handler.addSession(s)
+	s.setWindows()
 	s.pSession.Open()
 	return s
 }
 
+// Zero values leave the proton defaults in place.
+func (s *session) setWindows() {
+	if s.incomingCapacity != 0 {
+		s.pSession.SetIncomingCapacity(s.incomingCapacity)
+	}
+	if s.outgoingWindow != 0 {
+		s.pSession.SetOutgoingWindow(s.outgoingWindow)
+	}
+}
+
 func (s *session) Connection() Connection     { return s.connection }
 func (s *session) pEndpoint() proton.Endpoint { return s.pSession }
-func (s *session) engine() *proton.Engine     { return s.connection.engine }
 
 func (s *session) Close(err error) {
-	_ = s.engine().Inject(func() {
+	_ = s.connection.inject(func() {
 		if s.Error() == nil {
 			localClose(s.pSession, err)
 		}
 	})
 }
 
+func (s *session) Links() (links []Link) {
+	_ = s.connection.injectWait(func() error {
+		for pl, l := range s.connection.handler.links {
+			if pl.Session() == s.pSession && l.Error() == nil && pl.State().LocalActive() {
+				links = append(links, l.(Link))
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (s *session) LinkCount() int { return int(atomic.LoadInt32(&s.linkCount)) }
+
+func (s *session) IncomingCapacity() uint { return s.window(proton.Session.IncomingCapacity) }
+func (s *session) OutgoingWindow() uint   { return s.window(proton.Session.OutgoingWindow) }
+func (s *session) RemoteIncomingWindow() uint {
+	return s.window(proton.Session.RemoteIncomingWindow)
+}
+func (s *session) RemoteOutgoingWindow() uint {
+	return s.window(proton.Session.RemoteOutgoingWindow)
+}
+
+func (s *session) HandleMax() uint32 { return s.handleMax }
+
+// linksFull is true if no more links can be attached without exceeding handleMax.
+func (s *session) linksFull() bool {
+	n := 0
+	for pl := range s.connection.handler.links {
+		if pl.Session() == s.pSession {
+			n++
+		}
+	}
+	return uint64(n) > uint64(s.handleMax)
+}
+
+func (s *session) window(get func(proton.Session) uint) (n uint) {
+	_ = s.connection.injectWait(func() error {
+		if s.Error() == nil {
+			n = get(s.pSession)
+		}
+		return nil
+	})
+	return
+}
+
 func (s *session) Sender(setting ...LinkOption) (snd Sender, err error) {
-	err = s.engine().InjectWait(func() error {
+	err = s.connection.injectWait(func() error {
 		if s.Error() != nil {
 			return s.Error()
 		}
+		if s.connection.shutdown != nil {
+			return ErrShutdown
+		}
 		l, err2 := makeLocalLink(s, true, setting...)
 		if err2 == nil {
 			snd = newSender(l)
@@ -99,10 +223,13 @@ func (s *session) Sender(setting ...LinkOption) (snd Sender, err error) {
 }
 
 func (s *session) Receiver(setting ...LinkOption) (rcv Receiver, err error) {
-	err = s.engine().InjectWait(func() error {
+	err = s.connection.injectWait(func() error {
 		if s.Error() != nil {
 			return s.Error()
 		}
+		if s.connection.shutdown != nil {
+			return ErrShutdown
+		}
 		l, err2 := makeLocalLink(s, false, setting...)
 		if err2 == nil {
 			rcv = newReceiver(l)
@@ -119,10 +246,11 @@ type IncomingSession struct {
 	h                                *handler
 	pSession                         proton.Session
 	incomingCapacity, outgoingWindow uint
+	handleMax                        uint32
 }
 
 func newIncomingSession(h *handler, ps proton.Session) *IncomingSession {
-	return &IncomingSession{incoming: makeIncoming(ps), h: h, pSession: ps}
+	return &IncomingSession{incoming: makeIncoming(ps), h: h, pSession: ps, handleMax: math.MaxUint32}
 }
 
 // SetIncomingCapacity sets the session buffer capacity of an incoming session in bytes.
@@ -131,9 +259,12 @@ func (in *IncomingSession) SetIncomingCapacity(bytes uint) { in.incomingCapacity
 // SetOutgoingWindow sets the session outgoing window of an incoming session in frames.
 func (in *IncomingSession) SetOutgoingWindow(frames uint) { in.outgoingWindow = frames }
 
+// SetHandleMax limits the links on an incoming session, see HandleMax().
+func (in *IncomingSession) SetHandleMax(n uint32) { in.handleMax = n }
+
 // Accept an incoming session endpoint.
 func (in *IncomingSession) Accept() Endpoint {
 	return in.accept(func() Endpoint {
-		return newSession(in.h.connection, in.pSession, IncomingCapacity(in.incomingCapacity), OutgoingWindow(in.outgoingWindow))
+		return newSession(in.h.connection, in.pSession, IncomingCapacity(in.incomingCapacity), OutgoingWindow(in.outgoingWindow), HandleMax(in.handleMax))
 	})
 }
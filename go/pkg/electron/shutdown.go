@@ -0,0 +1,227 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/apache/qpid-proton/go/pkg/proton"
+)
+
+// ErrShutdown is returned when opening a connection, session or link after
+// Shutdown has been called.
+var ErrShutdown = fmt.Errorf("shutting down")
+
+// ShutdownError is returned by Shutdown if the context is done before in-flight
+// work completes. It reports the work that was abandoned when the connection
+// was closed.
+type ShutdownError struct {
+	// Err is the context error.
+	Err error
+	// Unsent is the number of messages that were waiting for credit.
+	Unsent int
+	// Unacknowledged is the number of messages sent with no outcome from the
+	// remote receiver.
+	Unacknowledged int
+	// Unsettled is the number of received messages the application had not
+	// settled.
+	Unsettled int
+}
+
+func (e ShutdownError) Error() string {
+	return fmt.Sprintf("shutdown abandoned %d unsent, %d unacknowledged, %d unsettled messages: %v",
+		e.Unsent, e.Unacknowledged, e.Unsettled, e.Err)
+}
+
+// shutdown is the state of a connection Shutdown, used in the handler goroutine.
+type shutdown struct {
+	quiet   chan struct{} // Closed when there is no in-flight work
+	isQuiet bool
+}
+
+func (c *connection) Shutdown(ctx context.Context) error {
+	var sd *shutdown
+	err := c.injectWait(func() error {
+		if err := c.Error(); err != nil {
+			return err
+		}
+		if c.shutdown == nil {
+			c.shutdown = &shutdown{quiet: make(chan struct{})}
+			for _, l := range c.handler.links {
+				if r, ok := l.(*receiver); ok {
+					r.releaseBuffered()
+				}
+			}
+			c.checkShutdown()
+		}
+		sd = c.shutdown
+		return nil
+	})
+	if err != nil {
+		if err == Closed {
+			err = nil
+		}
+		return err
+	}
+	select {
+	case <-sd.quiet:
+	case <-c.done:
+		if err = c.Error(); err == Closed {
+			err = nil
+		}
+		return err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	werr := c.injectWait(func() error {
+		var sdErr error
+		if err != nil {
+			e := ShutdownError{Err: err}
+			e.Unsent, e.Unacknowledged, e.Unsettled = c.handler.inFlight()
+			sdErr = e
+		}
+		// Close links, then sessions, then the connection.
+		for l := range c.handler.links {
+			localClose(l, nil)
+		}
+		for s := range c.handler.sessions {
+			localClose(s, nil)
+		}
+		return sdErr
+	})
+	if e, ok := werr.(ShutdownError); ok {
+		err = e
+	}
+	closed := make(chan struct{})
+	go func() { c.Close(nil); close(closed) }()
+	select {
+	case <-closed:
+	case <-ctx.Done(): // Don't wait for the remote peer to close
+		c.Disconnect(ShutdownError{Err: ctx.Err()})
+		<-closed
+	}
+	return err
+}
+
+// Called in handler goroutine, closes the quiet channel if Shutdown is waiting
+// and there is no in-flight work.
+func (c *connection) checkShutdown() {
+	if sd := c.shutdown; sd != nil && !sd.isQuiet {
+		if unsent, unacked, unsettled := c.handler.inFlight(); unsent+unacked+unsettled == 0 {
+			sd.isQuiet = true
+			close(sd.quiet)
+		}
+	}
+}
+
+// Called in handler goroutine, counts messages waiting for credit, sent messages
+// waiting for an outcome and received messages that are not settled.
+func (h *handler) inFlight() (unsent, unacked, unsettled int) {
+	unacked = len(h.sent)
+	for pl, l := range h.links {
+		switch l := l.(type) {
+		case *sender:
+			unsent += len(l.sending)
+			unacked += len(l.inDoubt) + len(l.resuming)
+		case *receiver:
+			unsettled += pl.Unsettled()
+		}
+	}
+	return unsent, unacked, unsettled
+}
+
+// Called in handler goroutine when Shutdown starts. Release messages the
+// application has not received, so the sender can deliver them elsewhere.
+func (r *receiver) releaseBuffered() {
+	for {
+		select {
+		case rm := <-r.buffer:
+			rm.pDelivery.SettleAs(proton.Released)
+		default:
+			return
+		}
+	}
+}
+
+// connections tracks the open connections of a container, for Shutdown.
+type connections struct {
+	lock     sync.Mutex
+	open     map[*connection]struct{}
+	shutdown bool
+}
+
+func (cs *connections) add(c *connection) error {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if cs.shutdown {
+		return ErrShutdown
+	}
+	if cs.open == nil {
+		cs.open = make(map[*connection]struct{})
+	}
+	cs.open[c] = struct{}{}
+	return nil
+}
+
+func (cs *connections) remove(c *connection) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	delete(cs.open, c)
+}
+
+func (cont *container) Shutdown(ctx context.Context) error {
+	cont.connections.lock.Lock()
+	cont.connections.shutdown = true
+	var open []*connection
+	for c := range cont.connections.open {
+		open = append(open, c)
+	}
+	cont.connections.lock.Unlock()
+
+	errs := make(chan error, len(open))
+	for _, c := range open {
+		go func(c *connection) { errs <- c.Shutdown(ctx) }(c)
+	}
+	// Add up the work abandoned by all connections, unless there is another error.
+	var err error
+	var abandoned *ShutdownError
+	for range open {
+		switch e := (<-errs).(type) {
+		case nil:
+		case ShutdownError:
+			if abandoned == nil {
+				abandoned = &ShutdownError{Err: e.Err}
+			}
+			abandoned.Unsent += e.Unsent
+			abandoned.Unacknowledged += e.Unacknowledged
+			abandoned.Unsettled += e.Unsettled
+		default:
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	if err == nil && abandoned != nil {
+		err = *abandoned
+	}
+	return err
+}
@@ -0,0 +1,206 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// shutdownAsync runs Shutdown in a goroutine, and checks it is still waiting.
+func shutdownAsync(t *testing.T, ctx context.Context, c Connection) <-chan error {
+	t.Helper()
+	result := make(chan error, 1)
+	go func() { result <- c.Shutdown(ctx) }()
+	select {
+	case err := <-result:
+		t.Fatalf("shutdown did not wait: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	return result
+}
+
+func TestShutdownWaitsForOutcomes(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+	c := p.client.Connection()
+
+	snd, rcv := p.sender()
+	acks := make(chan Outcome, 2)
+	go snd.SendAsync(amqp.NewMessageWith("a"), acks, nil)
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+
+	result := shutdownAsync(t, context.Background(), c)
+	_, err = p.client.Sender()
+	test.ErrorIf(t, test.Differ(ErrShutdown, err))
+	_, err = c.Session()
+	test.ErrorIf(t, test.Differ(ErrShutdown, err))
+
+	test.FatalIf(t, rm.Accept())
+	test.ErrorIf(t, <-result)
+	test.ErrorIf(t, test.Differ(Accepted, (<-acks).Status))
+	test.ErrorIf(t, test.Differ(Closed, c.Error()))
+	<-rcv.Done()
+	test.ErrorIf(t, test.Differ(Closed, rcv.Error()))
+}
+
+func TestShutdownWaitsForSettlement(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+	c := p.client.Connection()
+
+	rcv, snd := p.receiver(Capacity(10), Prefetch(true))
+	acks := make(chan Outcome, 2)
+	go func() {
+		snd.SendAsync(amqp.NewMessageWith("a"), acks, "a")
+		snd.SendAsync(amqp.NewMessageWith("b"), acks, "b")
+	}()
+	// Wait for the second message to be buffered without being received.
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	for _, queued := rcv.Credit(); queued < 1; _, queued = rcv.Credit() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The buffered message is released, the received one waits to be settled.
+	result := shutdownAsync(t, context.Background(), c)
+	test.ErrorIf(t, test.Differ(Outcome{Status: Released, Value: "b"}, plain(<-acks)))
+	test.FatalIf(t, rm.Accept())
+	test.ErrorIf(t, test.Differ(Outcome{Status: Accepted, Value: "a"}, plain(<-acks)))
+	test.ErrorIf(t, <-result)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+	c := p.client.Connection()
+
+	// One message sent without an outcome, one without credit, one received
+	// and not settled.
+	snd, rcv := p.sender()
+	acks := make(chan Outcome, 2)
+	go snd.SendAsync(amqp.NewMessageWith("a"), acks, nil)
+	_, err := rcv.Receive()
+	test.FatalIf(t, err)
+	sent := make(chan struct{})
+	go func() { snd.SendAsync(amqp.NewMessageWith("b"), acks, nil); close(sent) }()
+	waitQueued(snd, 1)
+	crcv, ssnd := p.receiver()
+	go func() { _ = ssnd.SendSync(amqp.NewMessageWith("c")) }()
+	_, err = crcv.Receive()
+	test.FatalIf(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	want := ShutdownError{Err: context.DeadlineExceeded, Unsent: 1, Unacknowledged: 1, Unsettled: 1}
+	test.ErrorIf(t, test.Differ(want, c.Shutdown(ctx)))
+	<-sent
+	for i := 0; i < 2; i++ {
+		if o := <-acks; o.Error == nil {
+			t.Errorf("want error outcome, got %v", o)
+		}
+	}
+	<-c.Done()
+}
+
+func TestShutdownRefusesIncoming(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+
+	snd, rcv := p.sender()
+	go func() { _ = snd.SendSync(amqp.NewMessageWith("a")) }()
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	result := shutdownAsync(t, context.Background(), p.server)
+
+	snd2, err := p.client.Sender()
+	test.FatalIf(t, err)
+	if err, ok := snd2.Sync().(amqp.Error); !ok || err.Name != amqp.ConnectionForced {
+		t.Errorf("want %v, got %v", amqp.ConnectionForced, snd2.Error())
+	}
+	test.FatalIf(t, rm.Accept())
+	test.ErrorIf(t, <-result)
+}
+
+func TestContainerShutdown(t *testing.T) {
+	cont := NewContainer("test")
+	var conns []Connection
+	for i := 0; i < 2; i++ {
+		cli, srv := net.Pipe()
+		sc, err := NewConnection(srv, Server())
+		test.FatalIf(t, err)
+		go func() {
+			for in := range sc.Incoming() {
+				in.Accept()
+			}
+		}()
+		c, err := cont.Connection(cli)
+		test.FatalIf(t, err)
+		snd, err := c.Sender() // Open a session and link
+		test.FatalIf(t, err)
+		test.FatalIf(t, snd.Sync())
+		conns = append(conns, c)
+	}
+	test.ErrorIf(t, cont.Shutdown(context.Background()))
+	for _, c := range conns {
+		test.ErrorIf(t, test.Differ(Closed, c.Error()))
+	}
+	cli, peer := net.Pipe()
+	_, err := cont.Connection(cli)
+	test.ErrorIf(t, test.Differ(ErrShutdown, err))
+	checkClosed(t, peer)
+
+	// Dial and Accept close the new net.Conn
+	l, err := net.Listen("tcp4", ":0")
+	test.FatalIf(t, err)
+	defer l.Close()
+	conn, err := net.Dial(l.Addr().Network(), l.Addr().String())
+	test.FatalIf(t, err)
+	_, err = cont.Accept(l)
+	test.ErrorIf(t, test.Differ(ErrShutdown, err))
+	checkClosed(t, conn)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	_, err = cont.Dial(l.Addr().Network(), l.Addr().String())
+	test.ErrorIf(t, test.Differ(ErrShutdown, err))
+	if conn := <-accepted; conn != nil {
+		checkClosed(t, conn)
+	}
+}
+
+// checkClosed checks that the other end of conn has been closed.
+func checkClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("want EOF got %v", err)
+	}
+}
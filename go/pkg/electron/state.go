@@ -0,0 +1,92 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ConnectionState is sent on Connection.StateChanges() when the state of the
+// connection changes.
+type ConnectionState struct {
+	// Status is the new state of the connection.
+	Status ConnectionStatus
+	// Time is when the state changed.
+	Time time.Time
+	// Err is the reason for ConnectionReconnecting or ConnectionClosed, nil
+	// for a clean close.
+	Err error
+}
+
+func (s ConnectionState) String() string {
+	if s.Err != nil {
+		return fmt.Sprintf("%v: %v", s.Status, s.Err)
+	}
+	return s.Status.String()
+}
+
+// stateChangesBuffer is the number of states buffered for StateChanges().
+const stateChangesBuffer = 8
+
+// stateChanges is the channel for StateChanges(). Sending never blocks: if
+// the buffer is full the oldest state is dropped, so the final
+// ConnectionClosed state is always delivered.
+type stateChanges struct {
+	lock   sync.Mutex
+	ch     chan ConnectionState
+	closed bool
+}
+
+func newStateChanges() stateChanges {
+	return stateChanges{ch: make(chan ConnectionState, stateChangesBuffer)}
+}
+
+func (s *stateChanges) send(state ConnectionState) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	for {
+		select {
+		case s.ch <- state:
+			if state.Status == ConnectionClosed {
+				close(s.ch)
+				s.closed = true
+			}
+			return
+		default:
+			select { // Full, drop the oldest state
+			case <-s.ch:
+			default:
+			}
+		}
+	}
+}
+
+// stateChange reports a change of state to Metrics and StateChanges().
+func (c *connection) stateChange(status ConnectionStatus, err error) {
+	c.metrics.OnConnectionStateChange(c, status, err)
+	c.states.send(ConnectionState{status, time.Now(), err})
+}
+
+func (c *connection) StateChanges() <-chan ConnectionState { return c.states.ch }
@@ -0,0 +1,110 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// nextState returns the next state from states, failing the test on timeout.
+func nextState(t *testing.T, states <-chan ConnectionState) ConnectionState {
+	t.Helper()
+	select {
+	case s, ok := <-states:
+		if !ok {
+			t.Fatal("state changes closed")
+		}
+		return s
+	case <-time.After(time.Second):
+		t.Fatal("no state change")
+	}
+	return ConnectionState{}
+}
+
+func TestStateChanges(t *testing.T) {
+	l, err := net.Listen("tcp4", ":0")
+	test.FatalIf(t, err)
+	defer l.Close()
+	servers, rcvs := make(chan Connection), make(chan Receiver)
+	go reconnectServer(l, servers, rcvs)
+
+	start := time.Now()
+	c, err := Dial(l.Addr().Network(), l.Addr().String(),
+		Reconnect(BackoffExponential(time.Millisecond, 10*time.Millisecond, 0.5)))
+	test.FatalIf(t, err)
+	states := c.StateChanges()
+	srv := <-servers
+	srvStates := srv.StateChanges()
+
+	s := nextState(t, states)
+	test.ErrorIf(t, test.Differ(ConnectionConnecting, s.Status))
+	if s.Time.Before(start) {
+		t.Errorf("bad time %v, before %v", s.Time, start)
+	}
+	test.ErrorIf(t, test.Differ(ConnectionOpen, nextState(t, states).Status))
+	test.ErrorIf(t, test.Differ(ConnectionConnecting, nextState(t, srvStates).Status))
+	test.ErrorIf(t, test.Differ(ConnectionOpen, nextState(t, srvStates).Status))
+
+	// Drop the connection, the client reconnects and the old server connection closes.
+	srv.Disconnect(fmt.Errorf("drop"))
+	if s := nextState(t, states); s.Status != ConnectionReconnecting || s.Err == nil {
+		t.Errorf("want reconnecting with error, got %v", s)
+	}
+	if s := nextState(t, srvStates); s.Status != ConnectionClosed || s.Err == nil {
+		t.Errorf("want closed with error, got %v", s)
+	}
+	if s, ok := <-srvStates; ok {
+		t.Errorf("want channel closed, got %v", s)
+	}
+	srv = <-servers
+	test.ErrorIf(t, test.Differ(ConnectionOpen, nextState(t, states).Status))
+
+	// Clean close is the final state.
+	c.Close(nil)
+	if s := nextState(t, states); s.Status != ConnectionClosed || s.Err != nil {
+		t.Errorf("want clean close, got %v", s)
+	}
+	if s, ok := <-states; ok {
+		t.Errorf("want channel closed, got %v", s)
+	}
+	srv.Close(nil)
+}
+
+// A full buffer drops the oldest states but keeps the final closed state.
+func TestStateChangesCoalesce(t *testing.T) {
+	s := newStateChanges()
+	for i := 0; i < 2*stateChangesBuffer; i++ {
+		s.send(ConnectionState{Status: ConnectionReconnecting, Err: fmt.Errorf("%v", i)})
+	}
+	s.send(ConnectionState{Status: ConnectionClosed})
+	s.send(ConnectionState{Status: ConnectionOpen}) // Ignored after close
+	var got []ConnectionState
+	for st := range s.ch {
+		got = append(got, st)
+	}
+	test.ErrorIf(t, test.Differ(stateChangesBuffer, len(got)))
+	test.ErrorIf(t, test.Differ(ConnectionClosed, got[len(got)-1].Status))
+	test.ErrorIf(t, test.Differ(fmt.Sprint(stateChangesBuffer+1), got[0].Err.Error()))
+}
@@ -0,0 +1,142 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+)
+
+// TLS returns a ConnectionOption to encrypt the connection with TLS, using
+// cfg for certificates and verification.
+//
+// For a client connection made by Dial(), DialWithDialer(), DialURL() or
+// Container.Dial(), cfg.ServerName defaults to the host being dialed. A
+// server connection must be given a cfg with a certificate.
+//
+// The TLS handshake is done before any AMQP traffic. If it fails the
+// connection closes with a TLSError.
+//
+// If SASL is enabled, a server offers the EXTERNAL mechanism to clients that
+// present a certificate, and authenticates them as the certificate subject.
+// A client configured with a certificate selects EXTERNAL if offered, use
+// SASLAllowedMechs("EXTERNAL") to require it.
+func TLS(cfg *tls.Config) ConnectionOption {
+	return func(c *connection) { c.tlsConfig = cfg }
+}
+
+// TLSError is the Connection error if the TLS handshake fails.
+type TLSError struct {
+	// Err is the error from crypto/tls, for example an x509.UnknownAuthorityError.
+	Err error
+}
+
+func (e TLSError) Error() string { return fmt.Sprintf("TLS handshake failed: %v", e.Err) }
+
+// engineConn is the net.Conn given to the proton.Engine. It allows the TLS()
+// option to wrap the net.Conn after the Engine has been created, and reports
+// the plain-text frames to the connection's FrameEventSink.
+type engineConn struct {
+	net.Conn
+	in, out frameParser
+}
+
+func newEngineConn(conn net.Conn, sink *frameSink) *engineConn {
+	return &engineConn{Conn: conn, in: frameParser{dir: Inbound, sink: sink}, out: frameParser{dir: Outbound, sink: sink}}
+}
+
+func (c *engineConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.in.parse(b[:n])
+	return
+}
+
+func (c *engineConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	c.out.parse(b[:n])
+	return
+}
+
+// wrapTLS returns conn wrapped with TLS if the TLS() option was set.
+func (c *connection) wrapTLS(conn net.Conn) net.Conn {
+	cfg := c.tlsConfig
+	switch {
+	case cfg == nil:
+		return conn
+	case c.server:
+		return tls.Server(conn, cfg)
+	default:
+		if cfg.ServerName == "" && c.tlsHost != "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = c.tlsHost
+		}
+		return tls.Client(conn, cfg)
+	}
+}
+
+// tlsSSF is the security strength factor reported to SASL for a TLS
+// connection. It only needs to be non-zero to show the connection is encrypted.
+const tlsSSF = 128
+
+// externalSecurity tells SASL about a completed TLS handshake, so a server
+// can offer the EXTERNAL mechanism using the client's certificate subject as
+// the identity. Called before the engine runs.
+func (c *connection) externalSecurity() {
+	conn := c.conn.Conn
+	if ws, ok := conn.(*webSocketConn); ok {
+		conn = ws.Conn
+	}
+	tc, ok := conn.(*tls.Conn)
+	if !ok || !c.saslEnabled {
+		return
+	}
+	state := tc.ConnectionState()
+	authid := ""
+	if len(state.PeerCertificates) > 0 {
+		authid = state.PeerCertificates[0].Subject.String()
+	}
+	sasl(c).SetExternalSecurity(tlsSSF, authid)
+	if certs := c.tlsConfig.Certificates; len(certs) > 0 && len(certs[0].Certificate) > 0 {
+		if cert, err := x509.ParseCertificate(certs[0].Certificate[0]); err == nil {
+			c.tlsSubject = cert.Subject.String()
+		}
+	}
+}
+
+// handshake does the TLS handshake, if conn uses TLS, then the WebSocket
+// handshake if conn is tunneled through a WebSocket. It does nothing if the
+// handshakes are already done.
+func handshake(conn net.Conn) error {
+	ws, isWebSocket := conn.(*webSocketConn)
+	if isWebSocket {
+		conn = ws.Conn
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			return TLSError{err}
+		}
+	}
+	if isWebSocket {
+		return ws.handshake()
+	}
+	return nil
+}
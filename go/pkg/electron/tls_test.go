@@ -0,0 +1,177 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// Make a self-signed certificate for 127.0.0.1 and a pool that trusts it.
+func testCertificate(t *testing.T, cn string) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.FatalIfN(1, t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	test.FatalIfN(1, t, err)
+	cert, err := x509.ParseCertificate(der)
+	test.FatalIfN(1, t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// Start a TLS server, return the amqps URL and channels of server connections and Receivers
+func tlsServer(t *testing.T, cfg *tls.Config, opts ...ConnectionOption) (string, net.Listener, chan Connection, chan Receiver) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	test.FatalIfN(1, t, err)
+	conns, rcvs := make(chan Connection, 1), make(chan Receiver, 1)
+	go func() {
+		for {
+			c, err := NewContainer("server").Accept(l, append([]ConnectionOption{TLS(cfg)}, opts...)...)
+			if err != nil {
+				return
+			}
+			conns <- c
+			go func() {
+				for in := range c.Incoming() {
+					ep := in.Accept()
+					if r, ok := ep.(Receiver); ok {
+						rcvs <- r
+					}
+				}
+			}()
+		}
+	}()
+	return fmt.Sprintf("amqps://%s", l.Addr()), l, conns, rcvs
+}
+
+func TestTLS(t *testing.T) {
+	cert, pool := testCertificate(t, "server")
+	url, l, _, rcvs := tlsServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer l.Close()
+
+	c, err := DialURL(url, TLS(&tls.Config{RootCAs: pool}))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	test.FatalIf(t, c.Sync())
+	s, err := c.Sender(Target("tls"))
+	test.FatalIf(t, err)
+	r := <-rcvs
+	ack := make(chan Outcome, 1)
+	go s.SendAsync(amqp.NewMessageWith("secret"), ack, nil) // Blocks until r.Receive() gives credit
+	rm, err := r.Receive()
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ("secret", rm.Message.Body()))
+	test.ErrorIf(t, rm.Accept())
+	test.ErrorIf(t, (<-ack).Error)
+}
+
+func TestTLSVerifyFail(t *testing.T) {
+	cert, _ := testCertificate(t, "server")
+	url, l, _, _ := tlsServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer l.Close()
+
+	// Default configuration does not trust the self-signed certificate.
+	c, err := DialURL(url)
+	test.FatalIf(t, err)
+	err = c.Sync()
+	if tlsErr, ok := err.(TLSError); !ok {
+		t.Errorf("want TLSError got %#v", err)
+	} else if !strings.Contains(tlsErr.Err.Error(), "unknown authority") {
+		t.Errorf("want unknown authority error got %#v", tlsErr.Err)
+	}
+}
+
+func TestTLSExternal(t *testing.T) {
+	serverCert, serverPool := testCertificate(t, "server")
+	clientCert, clientPool := testCertificate(t, "client")
+	url, l, conns, _ := tlsServer(t,
+		&tls.Config{Certificates: []tls.Certificate{serverCert}, ClientCAs: clientPool, ClientAuth: tls.RequireAndVerifyClientCert},
+		SASLAllowedMechs("EXTERNAL"))
+	defer l.Close()
+
+	c, err := DialURL(url,
+		TLS(&tls.Config{Certificates: []tls.Certificate{clientCert}, RootCAs: serverPool}),
+		SASLAllowedMechs("EXTERNAL"))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	test.FatalIf(t, c.Sync())
+	test.ErrorIf(t, test.Differ("EXTERNAL", c.SASLMechanism()))
+	test.ErrorIf(t, test.Differ("CN=client", c.AuthenticatedUser()))
+
+	s := <-conns
+	test.FatalIf(t, s.Sync())
+	test.ErrorIf(t, test.Differ("EXTERNAL", s.SASLMechanism()))
+	test.ErrorIf(t, test.Differ("CN=client", s.AuthenticatedUser()))
+}
+
+// The open frame hostname and container-id are independent of the TLS target.
+func TestTLSVirtualHost(t *testing.T) {
+	cert, pool := testCertificate(t, "server")
+	url, l, conns, _ := tlsServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer l.Close()
+
+	c, err := DialURL(url, TLS(&tls.Config{RootCAs: pool}), VirtualHost("vhost"), ContainerId("client"))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	test.FatalIf(t, c.Sync())
+	test.ErrorIf(t, test.Differ("vhost", c.VirtualHost()))
+	test.ErrorIf(t, test.Differ("client", c.ContainerId()))
+	test.ErrorIf(t, test.Differ("server", c.RemoteContainerId()))
+	s := <-conns
+	test.FatalIf(t, s.Sync())
+	test.ErrorIf(t, test.Differ("vhost", s.VirtualHost()))
+	test.ErrorIf(t, test.Differ("client", s.RemoteContainerId()))
+	test.ErrorIf(t, test.Differ("server", s.ContainerId()))
+
+	// The default virtual host is the dialed host
+	c, err = DialURL(url, TLS(&tls.Config{RootCAs: pool}))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	test.FatalIf(t, c.Sync())
+	s = <-conns
+	test.FatalIf(t, s.Sync())
+	test.ErrorIf(t, test.Differ("127.0.0.1", s.VirtualHost()))
+	test.ErrorIf(t, test.Differ(c.ContainerId(), s.RemoteContainerId()))
+}
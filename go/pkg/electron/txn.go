@@ -0,0 +1,205 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/proton"
+)
+
+// Txn is an AMQP local transaction, started by Session.BeginTransaction().
+//
+// Messages sent with SendIn() and accepted with AcceptIn() take effect
+// together when the transaction is committed, or not at all if it is
+// aborted. The transaction identifier is valid on the connection of the
+// session that began it, use it only with senders and received messages from
+// that connection.
+type Txn interface {
+	// Id is the transaction identifier assigned by the remote coordinator.
+	Id() amqp.Binary
+
+	// SendIn sends m on s as part of the transaction, and waits for the
+	// outcome like Sender.SendContext(). The outcome is provisional until the
+	// transaction is committed.
+	//
+	// Note that some brokers do not settle transactional messages until the
+	// transaction is discharged, use ctx to limit the wait.
+	SendIn(ctx context.Context, s Sender, m amqp.Message) (Outcome, error)
+
+	// AcceptIn accepts rm as part of the transaction. The message is
+	// settled, the broker removes it from its queue when the transaction is
+	// committed.
+	AcceptIn(rm *ReceivedMessage) error
+
+	// Commit discharges the transaction, making its work take effect. If the
+	// coordinator cannot commit it returns the coordinator's error, typically
+	// an amqp.Error with Name amqp.TransactionRollback.
+	Commit(ctx context.Context) error
+
+	// Abort discharges the transaction, discarding its work.
+	Abort(ctx context.Context) error
+}
+
+// ErrTxnFinished is returned for use of a Txn after Commit() or Abort().
+var ErrTxnFinished = fmt.Errorf("transaction is committed or aborted")
+
+// AMQP transaction descriptors
+const (
+	declareCode   = uint64(0x31)
+	dischargeCode = uint64(0x32)
+	declaredCode  = uint64(0x33)
+	txnState      = uint64(0x34) // transactional-state
+	errorCode     = uint64(0x1d) // error, in a rejected outcome
+)
+
+// Capability of a coordinator that supports local transactions.
+const localTransactions = amqp.Symbol("amqp:local-transactions")
+
+type txn struct {
+	id          amqp.Binary
+	coordinator *sender // Controller link for this transaction
+
+	lock     sync.Mutex
+	finished bool
+}
+
+func (s *session) BeginTransaction(ctx context.Context) (Txn, error) {
+	snd, err := s.Sender(func(l *linkSettings) { l.coordinator = true })
+	if err != nil {
+		return nil, err
+	}
+	t := &txn{coordinator: snd.(*sender)}
+	declared := func(d proton.Disposition) { // Called in handler goroutine before the outcome
+		if d.Type() == declaredCode {
+			var l amqp.List
+			if err := d.Data().Unmarshal(&l); err == nil && len(l) > 0 {
+				t.id, _ = l[0].(amqp.Binary)
+			}
+		}
+	}
+	declare := amqp.NewMessageWith(amqp.Described{Descriptor: declareCode, Value: amqp.List{}})
+	out, err := t.coordinator.sendTxn(ctx, declare, "", declared)
+	if err == nil && t.id == "" {
+		err = fmt.Errorf("transaction not declared: %v", out.Status)
+	}
+	if err != nil {
+		snd.Close(nil)
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *txn) Id() amqp.Binary { return t.id }
+
+func (t *txn) check() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.finished {
+		return ErrTxnFinished
+	}
+	return nil
+}
+
+func (t *txn) SendIn(ctx context.Context, s Sender, m amqp.Message) (Outcome, error) {
+	if err := t.check(); err != nil {
+		return Outcome{Status: Unsent, Error: err}, err
+	}
+	return s.(*sender).sendTxn(ctx, m, t.id, nil)
+}
+
+func (t *txn) AcceptIn(rm *ReceivedMessage) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	accepted := amqp.Described{Descriptor: uint64(proton.Accepted), Value: amqp.List{}}
+	return rm.settle(func(d proton.Delivery) {
+		if err := d.Local().Data().Marshal(amqp.List{t.id, accepted}); err != nil {
+			panic(err) // Shouldn't happen
+		}
+		d.Update(txnState)
+	})
+}
+
+func (t *txn) Commit(ctx context.Context) error { return t.discharge(ctx, false) }
+
+func (t *txn) Abort(ctx context.Context) error { return t.discharge(ctx, true) }
+
+func (t *txn) discharge(ctx context.Context, fail bool) error {
+	t.lock.Lock()
+	finished := t.finished
+	t.finished = true
+	t.lock.Unlock()
+	if finished {
+		return ErrTxnFinished
+	}
+	defer t.coordinator.Close(nil)
+	discharge := amqp.NewMessageWith(amqp.Described{Descriptor: dischargeCode, Value: amqp.List{t.id, fail}})
+	out, err := t.coordinator.sendTxn(ctx, discharge, "", nil)
+	if err == nil && out.Status != Accepted {
+		err = fmt.Errorf("transaction discharge %v", out.Status)
+	}
+	return err
+}
+
+// sendTxn is like SendContext for a message in a transaction, or with a
+// function to examine the remote delivery state.
+func (s *sender) sendTxn(ctx context.Context, m amqp.Message, txnId amqp.Binary, remote func(proton.Disposition)) (Outcome, error) {
+	ack := make(chan Outcome, 1)
+	sm := &sendable{m: m, ack: ack, sent: make(chan struct{}), txnId: txnId, remote: remote}
+	s.sendAsync(ctx, func() {}, sendCanceled, sm)
+	out := <-ack
+	return out, out.Error
+}
+
+// txnOutcome returns the status and error of the outcome in a
+// transactional-state disposition: a list of the transaction id and the outcome.
+func txnOutcome(d proton.Disposition) (SentStatus, error) {
+	var l amqp.List
+	if err := d.Data().Unmarshal(&l); err != nil || len(l) < 2 {
+		return Unknown, nil
+	}
+	o, ok := l[1].(amqp.Described)
+	if !ok {
+		return Unknown, nil
+	}
+	code, _ := o.Descriptor.(uint64)
+	status := sentStatus(code)
+	if status != Rejected {
+		return status, nil
+	}
+	// rejected is a list holding an optional error: a list of condition and description.
+	if v, ok := o.Value.(amqp.List); ok && len(v) > 0 {
+		if e, ok := v[0].(amqp.Described); ok && e.Descriptor == errorCode {
+			if fields, ok := e.Value.(amqp.List); ok && len(fields) > 0 {
+				var err amqp.Error
+				_ = amqp.Convert(fields[0], &err.Name)
+				if len(fields) > 1 {
+					_ = amqp.Convert(fields[1], &err.Description)
+				}
+				return status, err
+			}
+		}
+	}
+	return status, nil
+}
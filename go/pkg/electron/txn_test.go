@@ -0,0 +1,187 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+	"github.com/apache/qpid-proton/go/pkg/proton"
+)
+
+// coordinator is a test transaction coordinator serving one coordinator link.
+type coordinator struct {
+	t         *testing.T
+	id        amqp.Binary
+	rollback  bool      // Reject the discharge
+	discharge chan bool // Receives the discharge fail flag
+}
+
+func newCoordinator(t *testing.T, id string, rollback bool) *coordinator {
+	return &coordinator{t: t, id: amqp.Binary(id), rollback: rollback, discharge: make(chan bool, 1)}
+}
+
+// is true if v is the coordinator's transaction id
+func (c *coordinator) is(v interface{}) bool {
+	id, ok := v.(amqp.Binary)
+	return ok && string(id) == string(c.id)
+}
+
+func (c *coordinator) serve(r Receiver) {
+	var target proton.Terminus
+	_ = r.(*receiver).connection().injectWait(func() error {
+		target = r.(*receiver).pLink.RemoteTarget()
+		var caps []amqp.Symbol
+		_ = target.Capabilities().Unmarshal(&caps)
+		if target.Type() != proton.Coordinator || len(caps) != 1 || caps[0] != localTransactions {
+			c.t.Errorf("not a coordinator: %v %v", target.Type(), caps)
+		}
+		return nil
+	})
+	for {
+		rm, err := r.Receive()
+		if err != nil {
+			return
+		}
+		body, _ := rm.Message.Body().(amqp.Described)
+		fields, _ := body.Value.(amqp.List)
+		switch body.Descriptor {
+		case declareCode:
+			err = rm.settle(func(d proton.Delivery) {
+				_ = d.Local().Data().Marshal(amqp.List{c.id})
+				d.Update(declaredCode)
+			})
+		case dischargeCode:
+			if len(fields) != 2 || !c.is(fields[0]) {
+				c.t.Errorf("bad discharge: %v", fields)
+			}
+			fail, _ := fields[1].(bool)
+			c.discharge <- fail
+			if c.rollback {
+				err = rm.RejectWith(amqp.Errorf(amqp.TransactionRollback, "rolled back"))
+			} else {
+				err = rm.Accept()
+			}
+		default:
+			c.t.Errorf("unexpected coordinator message: %v", rm.Message)
+			err = rm.Reject()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Begin a transaction on p.client served by c
+func (c *coordinator) begin(p *pair) Txn {
+	type result struct {
+		txn Txn
+		err error
+	}
+	results := make(chan result)
+	go func() {
+		txn, err := p.client.BeginTransaction(context.Background())
+		results <- result{txn, err}
+	}()
+	go c.serve(<-p.rchan)
+	res := <-results
+	test.FatalIfN(1, c.t, res.err)
+	if !c.is(res.txn.Id()) {
+		c.t.Fatalf("want id %q, got %q", c.id, res.txn.Id())
+	}
+	return res.txn
+}
+
+func TestTransaction(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c := newCoordinator(t, "txn-1", false)
+	txn := c.begin(p)
+
+	// Transactional transfer
+	snd, rcv := p.sender(Target("q"))
+	outs := make(chan Outcome, 1)
+	go func() { out, _ := txn.SendIn(ctx, snd, amqp.NewMessageWith("x")); outs <- out }()
+	rm, err := rcv.Receive()
+	test.FatalIf(t, err)
+	var state uint64
+	var data amqp.List
+	_ = rcv.(*receiver).connection().injectWait(func() error {
+		state = rm.pDelivery.Remote().Type()
+		return rm.pDelivery.Remote().Data().Unmarshal(&data)
+	})
+	if state != txnState || len(data) != 1 || !c.is(data[0]) {
+		t.Errorf("want transactional-state [%q], got %#x %v", c.id, state, data)
+	}
+	test.FatalIf(t, rm.Accept())
+	if out := <-outs; out.Status != Accepted || out.Error != nil {
+		t.Error(out)
+	}
+
+	// Transactional acceptance
+	r, s := p.receiver(Source("q2"))
+	ack := make(chan Outcome, 1)
+	go s.SendAsync(amqp.NewMessageWith("y"), ack, "y") // Waits for credit
+	rm, err = r.Receive()
+	test.FatalIf(t, err)
+	test.FatalIf(t, txn.AcceptIn(&rm))
+	if out := <-ack; out.Status != Accepted || out.Error != nil || out.Value != "y" {
+		t.Error(out)
+	}
+
+	test.FatalIf(t, txn.Commit(ctx))
+	if fail := <-c.discharge; fail {
+		t.Error("commit discharged with fail=true")
+	}
+	test.ErrorIf(t, test.Differ(ErrTxnFinished, txn.Commit(ctx)))
+	test.ErrorIf(t, test.Differ(ErrTxnFinished, txn.Abort(ctx)))
+	if _, err := txn.SendIn(ctx, snd, amqp.NewMessage()); err != ErrTxnFinished {
+		t.Error(err)
+	}
+
+	// Abort
+	c = newCoordinator(t, "txn-2", false)
+	txn = c.begin(p)
+	test.FatalIf(t, txn.Abort(ctx))
+	if fail := <-c.discharge; !fail {
+		t.Error("abort discharged with fail=false")
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	p := newPipe(t, nil, nil)
+	defer p.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c := newCoordinator(t, "txn-1", true)
+	txn := c.begin(p)
+	err := txn.Commit(ctx)
+	<-c.discharge
+	if e, ok := err.(amqp.Error); !ok || e.Name != amqp.TransactionRollback || e.Description != "rolled back" {
+		t.Errorf("want %v error, got %#v", amqp.TransactionRollback, err)
+	}
+}
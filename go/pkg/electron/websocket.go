@@ -0,0 +1,348 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webSocketProtocol is the WebSocket subprotocol defined by the AMQP WebSocket Binding.
+const webSocketProtocol = "amqp"
+
+// webSocketGUID is combined with the client's key to make the server's accept key, see RFC 6455.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// webSocketCloseTimeout limits how long Close() waits to send a close frame to the peer.
+const webSocketCloseTimeout = time.Second
+
+// WebSocket opcodes, see RFC 6455.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// DialWebSocket connects to an AMQP server tunneled over WebSocket, as
+// required by some cloud services and firewalls that only allow HTTP traffic.
+//
+// rawURL is a "ws" or "wss" URL, for example "wss://host/$servicebus/websocket".
+// ctx limits the time to dial the TCP connection, the WebSocket handshake
+// and AMQP open are completed by the connection, check Sync() or Error() for
+// failures.
+//
+// A "wss" URL connects with TLS under the WebSocket, using the configuration
+// from the TLS() option if there is one, or a default tls.Config otherwise.
+// The Reconnect() option re-dials the same URL.
+//
+// If the WebSocket handshake fails the connection closes with a WebSocketError.
+func DialWebSocket(ctx context.Context, rawURL string, opts ...ConnectionOption) (c Connection, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	port := "80"
+	switch u.Scheme {
+	case "ws":
+	case "wss":
+		port = "443"
+		opts = append([]ConnectionOption{TLS(&tls.Config{})}, opts...)
+	default:
+		return nil, fmt.Errorf("bad WebSocket URL scheme %q, expecting ws or wss", u.Scheme)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+	return dialConnection(ctx, address, func(ctx context.Context, c *connection) (net.Conn, error) {
+		return c.dial(ctx, nil, "tcp", address)
+	}, append(opts, webSocket(u))...)
+}
+
+// webSocket is a ConnectionOption used by DialWebSocket() to tunnel the
+// connection through a WebSocket to u, after TLS if it is in use.
+func webSocket(u *url.URL) ConnectionOption {
+	return func(c *connection) { c.webSocketURL = u }
+}
+
+// WebSocketError is the Connection error if the WebSocket handshake fails.
+type WebSocketError struct {
+	// Err describes the failure, for example an unexpected HTTP status.
+	Err error
+}
+
+func (e WebSocketError) Error() string { return fmt.Sprintf("WebSocket handshake failed: %v", e.Err) }
+
+// wrapWebSocket returns conn wrapped in a client webSocketConn if the
+// connection was made by DialWebSocket().
+func (c *connection) wrapWebSocket(conn net.Conn) net.Conn {
+	if c.webSocketURL == nil {
+		return conn
+	}
+	return newWebSocketConn(conn, bufio.NewReader(conn), true, c.webSocketURL)
+}
+
+// webSocketConn carries the AMQP byte stream in binary WebSocket messages.
+// Read and Write are called concurrently by the engine, Read answers pings
+// so frames are written under lock.
+type webSocketConn struct {
+	net.Conn
+	br      *bufio.Reader // Reads from Conn, may hold data that follows the handshake.
+	client  bool          // Client frames are masked.
+	u       *url.URL      // Client request URL, nil for a server.
+	open    bool          // Handshake is done, a server is created open.
+	payload int64         // Unread payload bytes in the current frame.
+	mask    []byte        // Mask for the current frame, nil if not masked.
+	maskPos int
+
+	lock   sync.Mutex // Serializes frames written by Write() and by Read() for control frames.
+	closed bool       // Close frame has been sent, guarded by lock.
+}
+
+func newWebSocketConn(conn net.Conn, br *bufio.Reader, client bool, u *url.URL) *webSocketConn {
+	return &webSocketConn{Conn: conn, br: br, client: client, u: u, open: !client}
+}
+
+// handshake sends the client's HTTP upgrade request and checks the response,
+// if not already done.
+func (ws *webSocketConn) handshake() error {
+	if ws.open {
+		return nil
+	}
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return WebSocketError{err}
+	}
+	nonce := base64.StdEncoding.EncodeToString(key)
+	req := &http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Path: ws.u.Path, RawPath: ws.u.RawPath, RawQuery: ws.u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":                {"websocket"},
+			"Connection":             {"Upgrade"},
+			"Sec-WebSocket-Key":      {nonce},
+			"Sec-WebSocket-Version":  {"13"},
+			"Sec-WebSocket-Protocol": {webSocketProtocol},
+		},
+		Host: ws.u.Host,
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if err := req.Write(ws.Conn); err != nil {
+		return WebSocketError{err}
+	}
+	resp, err := http.ReadResponse(ws.br, req)
+	if err != nil {
+		return WebSocketError{err}
+	}
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode != http.StatusSwitchingProtocols:
+		err = fmt.Errorf("unexpected HTTP status %q", resp.Status)
+	case !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket"):
+		err = fmt.Errorf("bad Upgrade header %q", resp.Header.Get("Upgrade"))
+	case resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(nonce):
+		err = fmt.Errorf("bad Sec-WebSocket-Accept header %q", resp.Header.Get("Sec-WebSocket-Accept"))
+	case resp.Header.Get("Sec-WebSocket-Protocol") != webSocketProtocol:
+		err = fmt.Errorf("server did not accept the %q subprotocol", webSocketProtocol)
+	default:
+		ws.open = true
+		return nil
+	}
+	return WebSocketError{err}
+}
+
+// webSocketAccept returns the Sec-WebSocket-Accept value for a Sec-WebSocket-Key.
+func webSocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Read returns payload bytes from binary messages, answering pings and
+// returning io.EOF when the peer closes the WebSocket.
+func (ws *webSocketConn) Read(b []byte) (int, error) {
+	for ws.payload == 0 {
+		if err := ws.readHeader(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(b)) > ws.payload {
+		b = b[:ws.payload]
+	}
+	n, err := ws.br.Read(b)
+	ws.unmask(b[:n])
+	ws.payload -= int64(n)
+	return n, err
+}
+
+// readHeader reads frame headers and handles control frames until there is
+// data frame payload to read.
+func (ws *webSocketConn) readHeader() error {
+	var h [2]byte
+	if _, err := io.ReadFull(ws.br, h[:]); err != nil {
+		return err
+	}
+	opcode := h[0] & 0x0F
+	size := int64(h[1] & 0x7F)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return err
+		}
+		size = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return err
+		}
+		size = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+	}
+	ws.mask, ws.maskPos = nil, 0
+	if h[1]&0x80 != 0 {
+		ws.mask = make([]byte, 4)
+		if _, err := io.ReadFull(ws.br, ws.mask); err != nil {
+			return err
+		}
+	}
+	switch opcode {
+	case wsBinary, wsContinuation:
+		ws.payload = size
+		return nil
+	case wsClose, wsPing, wsPong:
+		if size > 125 {
+			return fmt.Errorf("WebSocket control frame too large: %d bytes", size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(ws.br, data); err != nil {
+			return err
+		}
+		ws.unmask(data)
+		switch opcode {
+		case wsClose:
+			_ = ws.writeClose()
+			return io.EOF
+		case wsPing:
+			return ws.writeFrame(wsPong, data)
+		}
+		return nil
+	default: // Including wsText, the AMQP binding only allows binary messages.
+		return fmt.Errorf("unexpected WebSocket opcode %#x", opcode)
+	}
+}
+
+func (ws *webSocketConn) unmask(b []byte) {
+	if ws.mask != nil {
+		for i := range b {
+			b[i] ^= ws.mask[ws.maskPos%4]
+			ws.maskPos++
+		}
+	}
+}
+
+// Write sends b as a single binary message.
+func (ws *webSocketConn) Write(b []byte) (int, error) {
+	if err := ws.writeFrame(wsBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeFrame writes a single final frame with opcode and payload.
+func (ws *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	if ws.closed {
+		return fmt.Errorf("WebSocket is closed")
+	}
+	if opcode == wsClose {
+		ws.closed = true
+	}
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if ws.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(n))
+	}
+	if ws.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := ws.Conn.Write(frame)
+	return err
+}
+
+// writeClose sends a close frame if one has not been sent already.
+func (ws *webSocketConn) writeClose() error {
+	ws.lock.Lock()
+	closed := ws.closed
+	ws.lock.Unlock()
+	if closed {
+		return nil
+	}
+	_ = ws.Conn.SetWriteDeadline(time.Now().Add(webSocketCloseTimeout))
+	return ws.writeFrame(wsClose, nil)
+}
+
+// Close sends a close frame to the peer, then closes the underlying connection.
+func (ws *webSocketConn) Close() error {
+	_ = ws.writeClose()
+	return ws.Conn.Close()
+}
@@ -0,0 +1,237 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package electron
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/qpid-proton/go/pkg/amqp"
+	"github.com/apache/qpid-proton/go/pkg/internal/test"
+)
+
+// Do the server side of the WebSocket handshake on conn, accepting protocol.
+func acceptWebSocket(conn net.Conn, protocol string) (*webSocketConn, *http.Request, error) {
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"
+	resp += fmt.Sprintf("Sec-WebSocket-Accept: %s\r\n", webSocketAccept(req.Header.Get("Sec-WebSocket-Key")))
+	if protocol != "" {
+		resp += fmt.Sprintf("Sec-WebSocket-Protocol: %s\r\n", protocol)
+	}
+	if _, err = io.WriteString(conn, resp+"\r\n"); err != nil {
+		return nil, nil, err
+	}
+	return newWebSocketConn(conn, br, false, nil), req, nil
+}
+
+// Start a WebSocket server, with TLS if cfg is not nil. Return the listener
+// and channels of upgrade requests, server connections and Receivers.
+func webSocketServer(t *testing.T, cfg *tls.Config, protocol string) (net.Listener, chan *http.Request, chan Connection, chan Receiver) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	test.FatalIfN(1, t, err)
+	if cfg != nil {
+		l = tls.NewListener(l, cfg)
+	}
+	reqs, conns, rcvs := make(chan *http.Request, 10), make(chan Connection, 10), make(chan Receiver, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			ws, req, err := acceptWebSocket(conn, protocol)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			reqs <- req
+			c, err := NewContainer("server").Connection(ws, Server(), AllowIncoming())
+			if err != nil {
+				continue
+			}
+			conns <- c
+			go func() {
+				for in := range c.Incoming() {
+					ep := in.Accept()
+					if r, ok := ep.(Receiver); ok {
+						rcvs <- r
+					}
+				}
+			}()
+		}
+	}()
+	return l, reqs, conns, rcvs
+}
+
+// Send body on s and check it arrives at r.
+func webSocketSend(t *testing.T, s Sender, r Receiver, body string) {
+	ack := make(chan Outcome, 1)
+	go s.SendAsync(amqp.NewMessageWith(body), ack, nil)
+	rm, err := r.Receive()
+	test.FatalIfN(1, t, err)
+	test.ErrorIfN(1, t, test.Differ(body, rm.Message.Body()))
+	test.ErrorIfN(1, t, rm.Accept())
+	test.ErrorIfN(1, t, (<-ack).Error)
+}
+
+func TestWebSocket(t *testing.T) {
+	l, reqs, _, rcvs := webSocketServer(t, nil, "amqp")
+	defer l.Close()
+
+	c, err := DialWebSocket(context.Background(), fmt.Sprintf("ws://%s/$servicebus/websocket", l.Addr()))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	test.FatalIf(t, c.Sync())
+	req := <-reqs
+	test.ErrorIf(t, test.Differ("/$servicebus/websocket", req.URL.Path))
+	test.ErrorIf(t, test.Differ("amqp", req.Header.Get("Sec-WebSocket-Protocol")))
+	test.ErrorIf(t, test.Differ(l.Addr().String(), req.Host))
+	s, err := c.Sender(Target("ws"))
+	test.FatalIf(t, err)
+	r := <-rcvs
+	webSocketSend(t, s, r, "hello")
+	// Large enough to need a 64-bit WebSocket frame length.
+	webSocketSend(t, s, r, strings.Repeat("x", 100000))
+}
+
+func TestWebSocketTLS(t *testing.T) {
+	cert, pool := testCertificate(t, "server")
+	l, _, _, rcvs := webSocketServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, "amqp")
+	defer l.Close()
+
+	c, err := DialWebSocket(context.Background(), fmt.Sprintf("wss://%s/", l.Addr()), TLS(&tls.Config{RootCAs: pool}))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	test.FatalIf(t, c.Sync())
+	s, err := c.Sender(Target("ws"))
+	test.FatalIf(t, err)
+	webSocketSend(t, s, <-rcvs, "secret")
+
+	// Default configuration does not trust the self-signed certificate.
+	c, err = DialWebSocket(context.Background(), fmt.Sprintf("wss://%s/", l.Addr()))
+	test.FatalIf(t, err)
+	if _, ok := c.Sync().(TLSError); !ok {
+		t.Errorf("want TLSError got %#v", c.Error())
+	}
+}
+
+func TestWebSocketHandshakeFail(t *testing.T) {
+	l, _, _, _ := webSocketServer(t, nil, "") // Does not accept the amqp subprotocol
+	defer l.Close()
+
+	c, err := DialWebSocket(context.Background(), fmt.Sprintf("ws://%s", l.Addr()))
+	test.FatalIf(t, err)
+	err = c.Sync()
+	if wsErr, ok := err.(WebSocketError); !ok {
+		t.Errorf("want WebSocketError got %#v", err)
+	} else if !strings.Contains(wsErr.Err.Error(), "subprotocol") {
+		t.Errorf("want subprotocol error got %#v", wsErr.Err)
+	}
+
+	_, err = DialWebSocket(context.Background(), "http://localhost")
+	if err == nil || !strings.Contains(err.Error(), "scheme") {
+		t.Errorf("want scheme error got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = DialWebSocket(ctx, fmt.Sprintf("ws://%s", l.Addr())); err == nil {
+		t.Error("want error dialing with a cancelled context")
+	}
+}
+
+func TestWebSocketReconnect(t *testing.T) {
+	l, reqs, conns, rcvs := webSocketServer(t, nil, "amqp")
+	defer l.Close()
+
+	events := make(chan ReconnectEvent, 10)
+	c, err := DialWebSocket(context.Background(), fmt.Sprintf("ws://%s/amqp", l.Addr()),
+		Reconnect(BackoffExponential(time.Millisecond, 10*time.Millisecond, 0.5), ReconnectEvents(events)))
+	test.FatalIf(t, err)
+	defer c.Close(nil)
+	s, err := c.Sender(Target("ws"))
+	test.FatalIf(t, err)
+	webSocketSend(t, s, <-rcvs, "before")
+	<-reqs
+	srv := <-conns
+	srv.Disconnect(fmt.Errorf("drop"))
+
+	if e := <-events; e.Attempt != 0 || e.Err == nil {
+		t.Errorf("want disconnect event got %v", e)
+	}
+	if e := <-events; !e.Reconnected || e.Attempt != 1 {
+		t.Errorf("want reconnected event got %v", e)
+	}
+	// The re-dialed connection does a new WebSocket handshake.
+	test.ErrorIf(t, test.Differ("/amqp", (<-reqs).URL.Path))
+	<-conns
+	// The sender is re-attached and can still be used.
+	webSocketSend(t, s, <-rcvs, "after")
+}
+
+func TestWebSocketConnControl(t *testing.T) {
+	cli, srv := net.Pipe()
+	client := newWebSocketConn(cli, bufio.NewReader(cli), true, nil)
+	server := newWebSocketConn(srv, bufio.NewReader(srv), false, nil)
+	defer client.Close()
+
+	// The client answers a ping while reading, then reads the message.
+	go func() {
+		_ = server.writeFrame(wsPing, []byte("ping"))
+		_, _ = server.Write([]byte("hello"))
+	}()
+	pongs := make(chan string, 1)
+	go func() {
+		h := make([]byte, 6) // Header and mask
+		if _, err := io.ReadFull(server.br, h); err == nil && h[0] == 0x80|wsPong {
+			body := make([]byte, h[1]&0x7F)
+			_, _ = io.ReadFull(server.br, body)
+			for i := range body {
+				body[i] ^= h[2+i%4]
+			}
+			pongs <- string(body)
+		}
+		close(pongs)
+	}()
+	b := make([]byte, 10)
+	n, err := client.Read(b)
+	test.FatalIf(t, err)
+	test.ErrorIf(t, test.Differ("hello", string(b[:n])))
+	test.ErrorIf(t, test.Differ("ping", <-pongs))
+
+	// A close frame is answered and reported as EOF.
+	go func() { _ = server.writeFrame(wsClose, nil) }()
+	go func() { _, _ = io.Copy(ioutil.Discard, srv) }()
+	_, err = client.Read(b)
+	test.ErrorIf(t, test.Differ(io.EOF, err))
+}
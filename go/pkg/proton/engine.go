@@ -113,10 +113,38 @@ type Engine struct {
 	timer            *time.Timer
 	traceEvent       bool
 	reading, writing bool
+	header           [8]byte // Protocol header received from the peer
+	headerLen        int
 }
 
 const bufferSize = 4096
 
+// ProtocolVersionError is returned by Engine.Run() if the peer's protocol
+// header is not for AMQP 1.0.
+type ProtocolVersionError struct {
+	// Received is the header sent by the peer.
+	Received [8]byte
+}
+
+func (e ProtocolVersionError) Error() string {
+	return fmt.Sprintf("unsupported protocol header %q, expected AMQP 1.0", string(e.Received[:]))
+}
+
+// checkProtocolHeader checks for an AMQP 1.0 protocol header: "AMQP", a
+// protocol id, then major=1, minor=0, revision=0.
+//
+// The protocol id is 0 for AMQP. The SASL (3) and TLS (2) layers that may
+// precede the AMQP header use the same format, so their ids are also accepted.
+func checkProtocolHeader(header [8]byte) error {
+	if string(header[0:4]) == "AMQP" && header[5] == 1 && header[6] == 0 && header[7] == 0 {
+		switch header[4] {
+		case 0, 2, 3:
+			return nil
+		}
+	}
+	return ProtocolVersionError{header}
+}
+
 func envBool(name string) bool {
 	v := strings.ToLower(os.Getenv(name))
 	return v == "true" || v == "1" || v == "yes" || v == "on"
@@ -200,6 +228,19 @@ func (eng *Engine) Inject(f func()) error {
 	}
 }
 
+// TryInject is like Inject but does not block: it returns false without
+// injecting f if the engine is busy and not ready to take it at once.
+func (eng *Engine) TryInject(f func()) (bool, error) {
+	select {
+	case eng.inject <- f:
+		return true, nil
+	case <-eng.running:
+		return false, eng.Error()
+	default:
+		return false, nil
+	}
+}
+
 // InjectWait is like Inject but does not return till f() has completed or the
 // engine is closed, and returns an error value from f()
 func (eng *Engine) InjectWait(f func() error) error {
@@ -297,6 +338,11 @@ func (eng *Engine) dispatch() bool {
 	return !eng.transport.Closed() || C.pn_collector_peek(eng.collector) != nil
 }
 
+// write starts a Write of the pending transport output in a separate goroutine.
+// There is never more than one Write in progress. The output is a single
+// ordered byte stream for the whole connection (encrypted and framed by the TLS
+// and SASL layers when they are in use) so it cannot be divided by channel
+// among concurrent writers.
 func (eng *Engine) write() {
 	if !eng.writing {
 		size := eng.Transport().Pending() // Evaluate before Head(), may change buffer.
@@ -307,13 +353,18 @@ func (eng *Engine) write() {
 				n, err := eng.conn.Write(cByteSlice(start, size))
 				eng.Inject(func() { // Inject results of Write back to engine goroutine
 					eng.writing = false
-					if n > 0 {
+					// CloseHead() discards pending output, don't pop it twice.
+					if n > 0 && !C.pn_transport_head_closed(eng.transport.pn) {
 						eng.transport.Pop(uint(n))
 					}
 					if err != nil {
 						eng.Transport().Condition().SetError(err)
 						eng.Transport().CloseHead()
 					}
+					// Pop() may produce final output, e.g. a SASL outcome, before
+					// closing the tail. Start writing it before dispatching
+					// events that may close the head.
+					eng.write()
 				})
 			}()
 		}
@@ -330,6 +381,16 @@ func (eng *Engine) read() {
 				n, err := eng.conn.Read(cByteSlice(start, size))
 				eng.Inject(func() {
 					eng.reading = false
+					if n > 0 && eng.headerLen < len(eng.header) {
+						eng.headerLen += copy(eng.header[eng.headerLen:], cByteSlice(start, n))
+						if eng.headerLen == len(eng.header) {
+							if err := checkProtocolHeader(eng.header); err != nil {
+								eng.err.Set(err) // Keep the error type for Run()
+								eng.disconnect(err)
+								return
+							}
+						}
+					}
 					if n > 0 {
 						eng.Transport().Process(uint(n))
 					}
@@ -343,6 +404,10 @@ func (eng *Engine) read() {
 	}
 }
 
+// Free the resources of an Engine that will not be started. Run() frees the
+// Engine when it exits.
+func (eng *Engine) Free() { eng.free() }
+
 func (eng *Engine) free() {
 	if !eng.transport.IsNil() {
 		eng.transport.Unbind()
@@ -360,6 +425,10 @@ func (eng *Engine) free() {
 	}
 }
 
+// finalWriteTimeout limits how long Run waits for a Write in progress after
+// the transport has closed.
+const finalWriteTimeout = time.Second
+
 // Run the engine. Engine.Run() will exit when the engine is closed or
 // disconnected.  You can check for errors after exit with Engine.Error().
 //
@@ -367,15 +436,36 @@ func (eng *Engine) Run() error {
 	defer eng.free()
 	eng.transport.Bind(eng.connection)
 	eng.tick() // Start ticking if needed
-	for eng.dispatch() {
+	// Give a Write in progress when the transport closes up to
+	// finalWriteTimeout to finish before closing conn, it may carry the final
+	// frames, e.g. a SASL outcome. Don't wait longer, the peer may have
+	// stopped reading.
+	var finalWrite <-chan time.Time
+loop:
+	for {
+		if !eng.dispatch() {
+			if !eng.writing {
+				break
+			}
+			if finalWrite == nil {
+				finalWrite = time.After(finalWriteTimeout)
+			}
+		}
 		// Initiate read/write if needed
 		eng.read()
 		eng.write()
+		if !eng.writing && C.pn_collector_peek(eng.collector) != nil {
+			// No Write will wake us, dispatch events raised by Pending(),
+			// e.g. the transport closing after a SASL failure.
+			continue
+		}
 		select {
 		case f := <-eng.inject: // User or IO action
 			f()
 		case <-eng.timer.C:
 			eng.tick()
+		case <-finalWrite:
+			break loop
 		}
 	}
 	eng.err.Set(EndpointError(eng.Connection()))
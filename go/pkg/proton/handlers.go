@@ -113,6 +113,9 @@ const (
 	MMessage
 	// A network connection was disconnected.
 	MDisconnected
+	// A receiver link in drain mode has no credit left: the sender used or
+	// returned it all. See Link.Drain().
+	MDrained
 )
 
 func (t MessagingEvent) String() string {
@@ -163,6 +166,8 @@ func (t MessagingEvent) String() string {
 		return "Settled"
 	case MMessage:
 		return "Message"
+	case MDrained:
+		return "Drained"
 	default:
 		return "Unknown"
 	}
@@ -337,9 +342,30 @@ func (d *MessagingAdapter) HandleEvent(e Event) {
 	case ELinkRemoteClose, ELinkLocalOpen, ELinkLocalClose:
 		d.link.HandleEvent(e)
 
+	case ELinkRemoteDetach:
+		// The peer detached without closing, for example to keep a durable
+		// subscription. Reply with a detach rather than a close; the link is
+		// finished locally either way.
+		l := e.Link()
+		if l.State().LocalActive() {
+			l.Detach()
+		}
+		if l.RemoteCondition().IsSet() {
+			d.mhandler.HandleMessagingEvent(MLinkError, e)
+		} else {
+			d.mhandler.HandleMessagingEvent(MLinkClosing, e)
+		}
+		if l.State().LocalActive() {
+			l.Close()
+		}
+		d.mhandler.HandleMessagingEvent(MLinkClosed, e)
+
 	case ELinkFlow:
-		if e.Link().IsSender() && e.Link().Credit() > 0 {
+		l := e.Link()
+		if l.IsSender() && l.Credit() > 0 {
 			d.mhandler.HandleMessagingEvent(MSendable, e)
+		} else if l.IsReceiver() && l.IsDrain() && !l.Draining() {
+			d.mhandler.HandleMessagingEvent(MDrained, e)
 		}
 
 	case EDelivery:
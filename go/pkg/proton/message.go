@@ -95,10 +95,16 @@ func (link Link) Send(m amqp.Message) (Delivery, error) {
 // SendMessageBytes sends encoded bytes of an amqp.Message over a Link.
 // Returns a Delivery that can be use to determine the outcome of the message.
 func (link Link) SendMessageBytes(bytes []byte) (Delivery, error) {
+	return link.SendMessageBytesTag(bytes, nextTag())
+}
+
+// SendMessageBytesTag is like SendMessageBytes but uses tag as the delivery
+// tag. Tags must be unique among the unsettled deliveries on the link.
+func (link Link) SendMessageBytesTag(bytes []byte, tag string) (Delivery, error) {
 	if !link.IsSender() {
 		return Delivery{}, fmt.Errorf("attempt to send message on receiving link")
 	}
-	delivery := link.Delivery(nextTag())
+	delivery := link.Delivery(tag)
 	result := link.SendBytes(bytes)
 	link.Advance()
 	if result != len(bytes) {
@@ -20,11 +20,14 @@ under the License.
 package proton
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/apache/qpid-proton/go/pkg/amqp"
 	"github.com/apache/qpid-proton/go/pkg/internal/test"
 )
 
@@ -74,3 +77,98 @@ func Test(t *testing.T) {
 	test.FatalIf(t, client.expect(events{EConnectionLocalOpen}))
 	test.FatalIf(t, server.expect(events{EConnectionRemoteOpen}))
 }
+
+func TestCheckProtocolHeader(t *testing.T) {
+	for _, h := range []string{"AMQP\x00\x01\x00\x00", "AMQP\x03\x01\x00\x00", "AMQP\x02\x01\x00\x00"} {
+		var header [8]byte
+		copy(header[:], h)
+		test.ErrorIf(t, checkProtocolHeader(header))
+	}
+	for _, h := range []string{"AMQP\x00\x00\x09\x01", "AMQP\x01\x01\x00\x0a", "AMQP\x00\x02\x00\x00", "HTTP/1.1"} {
+		var header [8]byte
+		copy(header[:], h)
+		test.ErrorIf(t, test.Differ(ProtocolVersionError{header}, checkProtocolHeader(header)))
+	}
+}
+
+func TestProtocolVersionMismatch(t *testing.T) {
+	cConn, sConn := net.Pipe()
+	server, err := newTestEngine(sConn)
+	test.FatalIf(t, err)
+	server.Server()
+	done := make(chan error)
+	go func() { done <- server.Run() }()
+	go cConn.Write([]byte("AMQP\x00\x00\x09\x01")) // AMQP 0-9-1
+	defer cConn.Close()
+
+	err = <-done
+	want := ProtocolVersionError{[8]byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}}
+	test.ErrorIf(t, test.Differ(want, err))
+	test.ErrorIf(t, test.Differ(want, server.Error()))
+	test.ErrorIf(t, test.Differ(`unsupported protocol header "AMQP\x00\x00\t\x01", expected AMQP 1.0`, err.Error()))
+}
+
+// The engine writes output produced as the transport closes before it closes
+// conn, so the peer sees why it was refused rather than a bare EOF.
+func TestRunFinalOutput(t *testing.T) {
+	cConn, sConn := net.Pipe()
+	server, err := newTestEngine(sConn)
+	test.FatalIf(t, err)
+	server.Server()
+	server.Transport().SASL().AllowedMechs("ANONYMOUS")
+	done := make(chan error)
+	go func() { done <- server.Run() }()
+	defer cConn.Close()
+
+	// SASL header and a sasl-init for a mechanism the server does not offer.
+	body, err := amqp.Marshal(amqp.Described{Descriptor: uint64(0x41), Value: []interface{}{amqp.Symbol("PLAIN")}}, nil)
+	test.FatalIf(t, err)
+	frame := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(8+len(body)))
+	frame[4], frame[5] = 2, 1 // doff, SASL frame type
+	go cConn.Write(append([]byte("AMQP\x03\x01\x00\x00"), append(frame, body...)...))
+
+	var got []byte
+	buf := make([]byte, 1024)
+	for {
+		n, err := cConn.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	<-done
+	if !strings.Contains(string(got), "S\x44") { // sasl-outcome descriptor
+		t.Errorf("sasl-outcome not sent: %q", got)
+	}
+}
+
+func countAuthenticators() int {
+	authenticators.Lock()
+	defer authenticators.Unlock()
+	return len(authenticators.m)
+}
+
+// Test that setting the SASL authenticator again replaces it without leaking
+// the first one, and that freeing the transport forgets it.
+func TestSetSASLAuthenticatorTwice(t *testing.T) {
+	c, s := net.Pipe()
+	defer func() { c.Close(); s.Close() }()
+	eng, err := NewEngine(s)
+	test.FatalIf(t, err)
+	n := countAuthenticators()
+	var called string
+	tr := eng.Transport()
+	test.FatalIf(t, tr.SetSASLAuthenticator(func(string, string, []byte) error { called = "first"; return nil }))
+	test.FatalIf(t, tr.SetSASLAuthenticator(func(string, string, []byte) error { called = "second"; return nil }))
+	test.ErrorIf(t, test.Differ(n+1, countAuthenticators()))
+
+	authenticators.Lock()
+	a := authenticators.m[authenticators.ids[tr.pn]]
+	authenticators.Unlock()
+	test.FatalIf(t, a.auth("PLAIN", "fred", nil))
+	test.ErrorIf(t, test.Differ("second", called))
+
+	eng.Free()
+	test.ErrorIf(t, test.Differ(n, countAuthenticators()))
+}
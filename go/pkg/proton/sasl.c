@@ -0,0 +1,151 @@
+/*
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+/* A SASL server implementation that checks credentials by calling back into
+   Go, see Transport.SetSASLAuthenticator() */
+
+#include <proton/sasl.h>
+#include <proton/sasl-plugin.h>
+
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include "_cgo_export.h"
+
+typedef struct {
+  uintptr_t id;                 /* Go authenticator id */
+  char *user;                   /* Authenticated user, owned by the context */
+} pn_go_sasl_t;
+
+static const char PLAIN[] = "PLAIN";
+static const char EXTERNAL[] = "EXTERNAL";
+
+static char *copy_bytes(const char *start, size_t size) {
+  char *s = (char*)malloc(size + 1);
+  if (s) {
+    memcpy(s, start, size);
+    s[size] = 0;
+  }
+  return s;
+}
+
+static void go_sasl_free(pn_transport_t *transport) {
+  pn_go_sasl_t *ctx = (pn_go_sasl_t*)pnx_sasl_get_context(transport);
+  if (ctx) {
+    goSASLForget(ctx->id);
+    free(ctx->user);
+    free(ctx);
+    pnx_sasl_set_context(transport, NULL);
+  }
+}
+
+/* PLAIN discloses the password so is only offered if the transport is
+   encrypted or insecure mechanisms are allowed. */
+static const char *go_sasl_list_mechs(pn_transport_t *transport) {
+  bool plain = pnx_sasl_is_transport_encrypted(transport) || pnx_sasl_get_allow_insecure_mechs(transport);
+  if (pnx_sasl_get_external_username(transport)) {
+    return plain ? "EXTERNAL PLAIN" : "EXTERNAL";
+  }
+  return plain ? PLAIN : "";
+}
+
+static bool go_sasl_init_server(pn_transport_t *transport) {
+  pnx_sasl_set_desired_state(transport, SASL_POSTED_MECHANISMS);
+  return true;
+}
+
+static bool go_sasl_init_client(pn_transport_t *transport) { return false; }
+
+static void go_sasl_prepare(pn_transport_t *transport) {}
+
+/* The PLAIN initial response is: authzid NUL authcid NUL password */
+static void go_sasl_process_init(pn_transport_t *transport, const char *mechanism, const pn_bytes_t *recv) {
+  pn_go_sasl_t *ctx = (pn_go_sasl_t*)pnx_sasl_get_context(transport);
+  char *user = NULL, *password = NULL;
+  if (strcmp(mechanism, PLAIN) == 0 && recv->start) {
+    const char *end = recv->start + recv->size;
+    const char *u = (const char*)memchr(recv->start, 0, recv->size);
+    const char *p = u ? (const char*)memchr(u + 1, 0, end - (u + 1)) : NULL;
+    if (p) {
+      user = copy_bytes(u + 1, p - (u + 1));
+      password = copy_bytes(p + 1, end - (p + 1));
+    }
+  } else if (strcmp(mechanism, EXTERNAL) == 0 && pnx_sasl_get_external_username(transport)) {
+    const char *ext = pnx_sasl_get_external_username(transport);
+    user = copy_bytes(ext, strlen(ext));
+    password = copy_bytes("", 0);
+  }
+  if (ctx && user && password && goSASLVerify(ctx->id, (char*)mechanism, user, password) == 0) {
+    free(ctx->user);
+    ctx->user = user;           /* The transport keeps a pointer to the user name */
+    pnx_sasl_succeed_authentication(transport, user);
+  } else {
+    free(user);
+    pnx_sasl_fail_authentication(transport);
+  }
+  if (password) {
+    memset(password, 0, strlen(password));
+    free(password);
+  }
+  pnx_sasl_set_desired_state(transport, SASL_POSTED_OUTCOME);
+}
+
+static void go_sasl_process_response(pn_transport_t *transport, const pn_bytes_t *recv) {}
+static bool go_sasl_process_mechanisms(pn_transport_t *transport, const char *mechs) { return false; }
+static void go_sasl_process_challenge(pn_transport_t *transport, const pn_bytes_t *recv) {}
+static void go_sasl_process_outcome(pn_transport_t *transport) {}
+static bool go_sasl_can_encrypt(pn_transport_t *transport) { return false; }
+static ssize_t go_sasl_max_encrypt_size(pn_transport_t *transport) { return 0; }
+static ssize_t go_sasl_encode(pn_transport_t *transport, pn_bytes_t in, pn_bytes_t *out) { return 0; }
+static ssize_t go_sasl_decode(pn_transport_t *transport, pn_bytes_t in, pn_bytes_t *out) { return 0; }
+
+static const pnx_sasl_implementation go_sasl_impl = {
+  go_sasl_free,
+  go_sasl_list_mechs,
+
+  go_sasl_init_server,
+  go_sasl_init_client,
+
+  go_sasl_prepare,
+
+  go_sasl_process_init,
+  go_sasl_process_response,
+
+  go_sasl_process_mechanisms,
+  go_sasl_process_challenge,
+  go_sasl_process_outcome,
+
+  go_sasl_can_encrypt,
+  go_sasl_max_encrypt_size,
+  go_sasl_encode,
+  go_sasl_decode
+};
+
+/* Install the Go authenticator id on a server transport, returns false if out of memory. */
+bool pn_go_sasl_set_authenticator(pn_transport_t *transport, uintptr_t id) {
+  pn_go_sasl_t *ctx = (pn_go_sasl_t*)calloc(1, sizeof(pn_go_sasl_t));
+  if (!ctx) return false;
+  ctx->id = id;
+  pn_sasl(transport);           /* Make sure the SASL layer exists */
+  pnx_sasl_set_implementation(transport, &go_sasl_impl, ctx);
+  return true;
+}
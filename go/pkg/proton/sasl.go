@@ -0,0 +1,119 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package proton
+
+/*
+#include <proton/transport.h>
+#include <stdbool.h>
+#include <stdint.h>
+#include <string.h>
+
+bool pn_go_sasl_set_authenticator(pn_transport_t *transport, uintptr_t id);
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// SASLAuthenticator checks the credentials of a client authenticating with a
+// SASL mechanism. For PLAIN it is given the user name and password, for
+// EXTERNAL the identity set by SASL.SetExternalSecurity() and an empty
+// password. It returns nil to accept the client, an error to refuse it.
+//
+// The password is erased when the SASLAuthenticator returns, it must not be
+// retained.
+type SASLAuthenticator func(mech, user string, password []byte) error
+
+type saslAuth struct {
+	auth SASLAuthenticator
+	pn   *C.pn_transport_t
+}
+
+// authenticators holds the SASLAuthenticator for each transport, by id, and
+// the id installed on each transport.
+var authenticators struct {
+	sync.Mutex
+	m    map[uintptr]saslAuth
+	ids  map[*C.pn_transport_t]uintptr
+	next uintptr
+}
+
+// SetSASLAuthenticator makes a server transport authenticate clients by calling
+// auth, instead of using the SASL library and its configuration. The transport
+// offers the PLAIN mechanism if the connection is encrypted or insecure
+// mechanisms are allowed (see SASL.SetAllowInsecureMechs()), and EXTERNAL if
+// an external identity has been set.
+//
+// auth is called in the engine goroutine while the SASL frames are processed,
+// it must not block. The user name is the authenticated user when it returns nil.
+// Calling SetSASLAuthenticator again replaces the previous auth.
+func (t Transport) SetSASLAuthenticator(auth SASLAuthenticator) error {
+	authenticators.Lock()
+	defer authenticators.Unlock()
+	if authenticators.m == nil {
+		authenticators.m = make(map[uintptr]saslAuth)
+		authenticators.ids = make(map[*C.pn_transport_t]uintptr)
+	}
+	if id, ok := authenticators.ids[t.pn]; ok {
+		authenticators.m[id] = saslAuth{auth, t.pn}
+		return nil
+	}
+	authenticators.next++
+	id := authenticators.next
+	if !C.pn_go_sasl_set_authenticator(t.pn, C.uintptr_t(id)) {
+		return fmt.Errorf("cannot set SASL authenticator: out of memory")
+	}
+	authenticators.m[id] = saslAuth{auth, t.pn}
+	authenticators.ids[t.pn] = id
+	return nil
+}
+
+//export goSASLVerify
+func goSASLVerify(id C.uintptr_t, mech, user, password *C.char) C.int {
+	authenticators.Lock()
+	a := authenticators.m[uintptr(id)]
+	authenticators.Unlock()
+	if a.auth == nil {
+		return 1
+	}
+	p := C.GoBytes(unsafe.Pointer(password), C.int(C.strlen(password)))
+	defer func() {
+		for i := range p {
+			p[i] = 0
+		}
+	}()
+	if a.auth(C.GoString(mech), C.GoString(user), p) != nil {
+		return 1
+	}
+	return 0
+}
+
+//export goSASLForget
+func goSASLForget(id C.uintptr_t) {
+	authenticators.Lock()
+	if a, ok := authenticators.m[uintptr(id)]; ok {
+		delete(authenticators.ids, a.pn)
+		delete(authenticators.m, uintptr(id))
+	}
+	authenticators.Unlock()
+}
@@ -117,6 +117,7 @@ func (e Event) Injecter() Injecter { return e.injecter }
 // Data is an intermediate form of decoded AMQP data.
 type Data struct{ pn *C.pn_data_t }
 
+func (d Data) IsNil() bool          { return d.pn == nil }
 func (d Data) Free()                { C.pn_data_free(d.pn) }
 func (d Data) CPtr() unsafe.Pointer { return unsafe.Pointer(d.pn) }
 func (d Data) Clear()               { C.pn_data_clear(d.pn) }
@@ -231,6 +232,16 @@ func (d Delivery) Accept() { d.SettleAs(Accepted) }
 // Reject rejects and settles a delivery
 func (d Delivery) Reject() { d.SettleAs(Rejected) }
 
+// RejectWith rejects and settles a delivery, setting the local error condition
+// from err using amqp.MakeCondition. Pass an amqp.Error or *amqp.Error to
+// control the condition name, description and info sent to the sender.
+func (d Delivery) RejectWith(err error) {
+	if err != nil {
+		d.Local().Condition().SetError(amqp.MakeCondition(err))
+	}
+	d.Reject()
+}
+
 // Release releases and settles a delivery
 // If delivered is true the delivery count for the message will be increased.
 func (d Delivery) Release(delivered bool) {
@@ -241,6 +252,13 @@ func (d Delivery) Release(delivered bool) {
 	}
 }
 
+// SetResume marks an outgoing delivery as resuming the unsettled delivery with
+// the same tag from before the link was re-attached. Call before sending data.
+func (d Delivery) SetResume(resume bool) { C.pn_delivery_set_resume(d.pn, C.bool(resume)) }
+
+// Resumed is true if the delivery resumes an earlier delivery with the same tag.
+func (d Delivery) Resumed() bool { return bool(C.pn_delivery_resumed(d.pn)) }
+
 type DeliveryTag struct{ pn C.pn_delivery_tag_t }
 
 func (t DeliveryTag) String() string { return C.GoStringN(t.pn.start, C.int(t.pn.size)) }
@@ -292,6 +310,47 @@ func (l Link) IsDrain() bool {
 	return bool(C.pn_link_get_drain(l.pn))
 }
 
+// DeliveryCount calls pn_link_delivery_count(), pn_sequence_t is not handled by
+// the wrapper generator.
+func (l Link) DeliveryCount() uint32 {
+	return uint32(C.pn_link_delivery_count(l.pn))
+}
+
+// Link properties and capabilities are not in the generated wrappers, they were
+// added to proton-c after the last wrapper generation.
+
+func (l Link) Properties() Data {
+	return Data{C.pn_link_properties(l.pn)}
+}
+
+// RemoteProperties is a nil Data if the remote peer did not send any properties.
+func (l Link) RemoteProperties() Data {
+	return Data{C.pn_link_remote_properties(l.pn)}
+}
+func (l Link) OfferedCapabilities() Data {
+	return Data{C.pn_link_offered_capabilities(l.pn)}
+}
+func (l Link) DesiredCapabilities() Data {
+	return Data{C.pn_link_desired_capabilities(l.pn)}
+}
+func (l Link) RemoteOfferedCapabilities() Data {
+	return Data{C.pn_link_remote_offered_capabilities(l.pn)}
+}
+func (l Link) RemoteDesiredCapabilities() Data {
+	return Data{C.pn_link_remote_desired_capabilities(l.pn)}
+}
+
+// UnsettledMap is the map of delivery tags to delivery states sent when the
+// link is attached, so the remote peer can resume unsettled deliveries.
+func (l Link) UnsettledMap() Data {
+	return Data{C.pn_link_unsettled_map(l.pn)}
+}
+
+// RemoteUnsettledMap is empty if the remote peer did not send an unsettled map.
+func (l Link) RemoteUnsettledMap() Data {
+	return Data{C.pn_link_remote_unsettled_map(l.pn)}
+}
+
 func cPtr(b []byte) *C.char {
 	if len(b) == 0 {
 		return nil
@@ -360,11 +419,30 @@ func (c Connection) Sessions(state State) (sessions []Session) {
 // The password must not contain embedded nul characters, a trailing nul is ignored.
 func (c Connection) SetPassword(password []byte) {
 	if len(password) == 0 || password[len(password)-1] != 0 {
-		password = append(password, 0) // Proton requires a terminating null.
+		// Proton requires a terminating null, erase our copy after use.
+		p := make([]byte, len(password)+1)
+		copy(p, password)
+		defer func() {
+			for i := range p {
+				p[i] = 0
+			}
+		}()
+		password = p
 	}
 	C.pn_connection_set_password(c.pn, (*C.char)(unsafe.Pointer(&password[0])))
 }
 
+// RemoteIncomingWindow is the number of transfer frames the remote peer will
+// currently accept on the session, 0 before the remote begin.
+func (s Session) RemoteIncomingWindow() uint {
+	return uint(C.pn_session_remote_incoming_window(s.pn))
+}
+
+// RemoteOutgoingWindow is the outgoing window last sent by the remote peer.
+func (s Session) RemoteOutgoingWindow() uint {
+	return uint(C.pn_session_remote_outgoing_window(s.pn))
+}
+
 func (s Session) String() string {
 	return fmt.Sprintf("(Session)(%p)", s.pn) // TODO aconway 2016-09-12: should print channel number.
 }
@@ -376,7 +454,14 @@ func (c Condition) Error() error {
 	if c.IsNil() || !c.IsSet() {
 		return nil
 	}
-	return amqp.Error{Name: c.Name(), Description: c.Description()}
+	err := amqp.Error{Name: c.Name(), Description: c.Description()}
+	if info := c.Info(); !info.Empty() {
+		var m map[amqp.Symbol]interface{}
+		if info.Unmarshal(&m) == nil { // Ignore info that is not a symbol-keyed map
+			err.Info = &m
+		}
+	}
+	return err
 }
 
 // Set a Go error into a condition, converting to an amqp.Error using amqp.MakeError
@@ -385,6 +470,11 @@ func (c Condition) SetError(err error) {
 		cond := amqp.MakeError(err)
 		c.SetName(cond.Name)
 		c.SetDescription(cond.Description)
+		if cond.Info != nil {
+			_ = c.Info().Marshal(*cond.Info)
+		} else {
+			c.Info().Clear()
+		}
 	}
 }
 
@@ -444,6 +534,19 @@ func (t Transport) SASL() SASL {
 	return SASL{C.pn_sasl(t.pn)}
 }
 
+// SetExternalSecurity tells the SASL layer about security provided outside of
+// proton, for example by crypto/tls. ssf > 0 means the connection is encrypted.
+// If authid is not empty a server will offer the EXTERNAL mechanism and
+// authenticate the client as authid.
+func (s SASL) SetExternalSecurity(ssf int, authid string) {
+	var authidC *C.char
+	if authid != "" {
+		authidC = C.CString(authid)
+		defer C.free(unsafe.Pointer(authidC))
+	}
+	C.pn_sasl_set_external_security(s.pn, C.int(ssf), authidC)
+}
+
 // Do we support extended SASL negotiation?
 // All implementations of Proton support ANONYMOUS and EXTERNAL on both
 // client and server sides and PLAIN on the client side.